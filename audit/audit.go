@@ -0,0 +1,162 @@
+// Package audit records who changed what. Entries are buffered and written
+// to the audit_log collection in the background so that auditing never
+// slows down or fails a request.
+package audit
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// Collection is where audit entries are stored
+const Collection = "audit_log"
+
+// maxBatch caps how many entries are written at once
+const maxBatch = 100
+
+// redacted replaces the values of secret fields in diffs
+const redacted = "[redacted]"
+
+// secretFields are never written to the audit log
+var secretFields = map[string]bool{"password": true, "secret": true}
+
+// ignoredFields change on every write and are left out of diffs
+var ignoredFields = map[string]bool{"updated_at": true}
+
+// Logger writes audit entries asynchronously through a bounded buffer
+type Logger struct {
+	collection *mongo.Collection
+	entries    chan models.AuditEntry
+}
+
+// NewLogger creates a logger buffering up to bufferSize entries. Entries
+// recorded while the buffer is full are dropped and logged.
+func NewLogger(db *mongo.Database, bufferSize int) *Logger {
+	return &Logger{
+		collection: db.Collection(Collection),
+		entries:    make(chan models.AuditEntry, bufferSize),
+	}
+}
+
+// Start writes buffered entries in the background
+func (l *Logger) Start() {
+	go func() {
+		for entry := range l.entries {
+			batch := []interface{}{entry}
+			for len(batch) < maxBatch && len(l.entries) > 0 {
+				batch = append(batch, <-l.entries)
+			}
+			l.write(batch)
+		}
+	}()
+}
+
+// write stores a batch of entries
+func (l *Logger) write(batch []interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := l.collection.InsertMany(ctx, batch, options.InsertMany().SetOrdered(false)); err != nil {
+		log.Printf("Failed to write %d audit entries: %v", len(batch), err)
+	}
+}
+
+// Record queues an entry without blocking, filling in its ID and time
+func (l *Logger) Record(entry models.AuditEntry) {
+	entry.ID = primitive.NewObjectID()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	select {
+	case l.entries <- entry:
+	default:
+		log.Printf("Audit buffer full, dropped %s of %s %s by %q",
+			entry.Action, entry.ResourceType, entry.ResourceID, entry.Actor)
+	}
+}
+
+// Diff returns the top-level fields that differ between before and after,
+// either of which may be nil for a creation or deletion. Values are compared
+// in their stored form and secrets are redacted.
+func Diff(before, after interface{}) map[string]models.AuditChange {
+	prev, next := toDocument(before), toDocument(after)
+
+	changes := map[string]models.AuditChange{}
+	for key, value := range prev {
+		if ignoredFields[key] {
+			continue
+		}
+		if other, ok := next[key]; !ok || !reflect.DeepEqual(value, other) {
+			changes[key] = redact(key, models.AuditChange{Before: value, After: other})
+		}
+	}
+	for key, value := range next {
+		if _, ok := prev[key]; !ok && !ignoredFields[key] {
+			changes[key] = redact(key, models.AuditChange{After: value})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// toDocument converts a value to its stored form
+func toDocument(v interface{}) bson.M {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal audited value: %v", err)
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		log.Printf("Failed to unmarshal audited value: %v", err)
+		return nil
+	}
+	return doc
+}
+
+// redact hides the values of secret fields, keeping the fact that they
+// changed
+func redact(key string, change models.AuditChange) models.AuditChange {
+	if !secretFields[key] {
+		return change
+	}
+	if change.Before != nil {
+		change.Before = redacted
+	}
+	if change.After != nil {
+		change.After = redacted
+	}
+	return change
+}
+
+// EnsureRetention makes audit entries expire retention after they were
+// recorded, updating the expiry of an existing TTL index
+func EnsureRetention(ctx context.Context, db *mongo.Database, retention time.Duration) error {
+	seconds := int32(retention / time.Second)
+	_, err := db.Collection(Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("audit_ttl").SetExpireAfterSeconds(seconds),
+	})
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "IndexOptionsConflict" {
+		return db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: Collection},
+			{Key: "index", Value: bson.M{"name": "audit_ttl", "expireAfterSeconds": seconds}},
+		}).Err()
+	}
+	return err
+}
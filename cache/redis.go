@@ -55,9 +55,13 @@ func GetCache(ctx context.Context, key string, dest interface{}) error {
 	return json.Unmarshal([]byte(val), dest)
 }
 
-// DeleteCache removes data from Redis cache
-func DeleteCache(ctx context.Context, key string) error {
-	return redisClient.Del(ctx, key).Err()
+// DeleteCache removes data from Redis cache, several keys are removed in a
+// single call
+func DeleteCache(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return redisClient.Del(ctx, keys...).Err()
 }
 
 // DeleteByPattern deletes all keys matching a pattern
@@ -77,4 +81,9 @@ const (
 	UserDetailPattern    = "user:%s"
 	ProductListPattern   = "products:*"
 	ProductDetailPattern = "product:%s"
+	CategoryListPattern  = "categories:*"
+
+	// Snapshot keys holding every document, refreshed by the Redis update job
+	UserSnapshotKey    = "users:all"
+	ProductSnapshotKey = "products:all"
 )
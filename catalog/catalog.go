@@ -0,0 +1,224 @@
+// Package catalog moves products in and out of the store in bulk, as CSV,
+// JSON Lines or XLSX files. Imports run in the background from an uploaded
+// file, exports are written row by row as products are read.
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-tutorial/models"
+)
+
+// File formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+// Product fields a column can be mapped to. Prices lists fixed prices in
+// other currencies as "EUR=9.99,GBP=8.50".
+const (
+	FieldID          = "id"
+	FieldSKU         = "sku"
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldCategory    = "category"
+	FieldPrice       = "price"
+	FieldPrices      = "prices"
+	FieldStock       = "stock"
+)
+
+// Columns are the columns of an export, in order. An export imports back
+// unchanged, the id column is ignored.
+var Columns = []string{FieldID, FieldSKU, FieldName, FieldDescription, FieldCategory, FieldPrice, FieldPrices, FieldStock}
+
+// ErrUnknownFormat is returned for a format other than csv, ndjson and xlsx
+var ErrUnknownFormat = errors.New("format must be one of: csv, ndjson, xlsx")
+
+// ParseFormat returns the format of a file from an explicit format name or,
+// when that is empty, from the extension of its filename
+func ParseFormat(format, filename string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(path.Ext(filename), ".")
+	}
+	switch strings.ToLower(format) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON, "jsonl":
+		return FormatNDJSON, nil
+	case FormatXLSX:
+		return FormatXLSX, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Mapping maps the columns of a file to product fields. Columns that are not
+// mapped are ignored. A nil mapping maps every column named after a field,
+// ignoring case, to that field.
+type Mapping map[string]string
+
+// Validate checks that every column maps to a distinct importable field
+func (m Mapping) Validate() error {
+	seen := make(map[string]string)
+	for column, field := range m {
+		if field == FieldID || !isField(field) {
+			return fmt.Errorf("column %q maps to unknown field %q", column, field)
+		}
+		if other, ok := seen[field]; ok {
+			return fmt.Errorf("columns %q and %q both map to %s", other, column, field)
+		}
+		seen[field] = column
+	}
+	return nil
+}
+
+// field returns the field a column maps to, empty when it is ignored
+func (m Mapping) field(column string) string {
+	if m != nil {
+		return m[column]
+	}
+	field := strings.ToLower(strings.TrimSpace(column))
+	if field == FieldID || !isField(field) {
+		return ""
+	}
+	return field
+}
+
+// isField reports whether name is one of the product fields
+func isField(name string) bool {
+	for _, field := range Columns {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Item is a product read from one row. Fields left empty in the row are
+// left unset, an import only changes the fields a row gives.
+type Item struct {
+	Row         int
+	SKU         string
+	Name        string
+	Description string
+	Category    string
+	Price       *float64
+	Prices      map[string]float64
+	Stock       *int
+}
+
+// Item reads the product of a row. Values that don't parse are reported
+// per field.
+func (m Mapping) Item(number int, row map[string]string) (Item, []models.ImportRowError) {
+	item := Item{Row: number}
+	var errs []models.ImportRowError
+	fail := func(field, message string) {
+		errs = append(errs, models.ImportRowError{Row: number, Field: field, Message: message})
+	}
+
+	for column, raw := range row {
+		field := m.field(column)
+		value := strings.TrimSpace(raw)
+		if field == "" || value == "" {
+			continue
+		}
+		switch field {
+		case FieldSKU:
+			item.SKU = value
+		case FieldName:
+			item.Name = value
+		case FieldDescription:
+			item.Description = value
+		case FieldCategory:
+			item.Category = value
+		case FieldPrice:
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				fail(field, "Must be a number")
+				continue
+			}
+			item.Price = &price
+		case FieldPrices:
+			prices, err := ParsePrices(value)
+			if err != nil {
+				fail(field, err.Error())
+				continue
+			}
+			item.Prices = prices
+		case FieldStock:
+			stock, err := strconv.Atoi(value)
+			if err != nil {
+				fail(field, "Must be a whole number")
+				continue
+			}
+			item.Stock = &stock
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return item, errs
+}
+
+// ParsePrices reads fixed prices written as "EUR=9.99,GBP=8.50". Semicolons
+// separate prices too.
+func ParsePrices(value string) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+		code, amount, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, errors.New("Must be prices like EUR=9.99,GBP=8.50")
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Price for %s must be a number", strings.TrimSpace(code))
+		}
+		prices[strings.ToUpper(strings.TrimSpace(code))] = price
+	}
+	return prices, nil
+}
+
+// Prices are fixed prices by currency code. They are written as an object in
+// JSON and as "EUR=9.99,GBP=8.50" in the other formats.
+type Prices map[string]float64
+
+// String writes the prices sorted by currency code
+func (p Prices) String() string {
+	codes := make([]string, 0, len(p))
+	for code := range p {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = code + "=" + strconv.FormatFloat(p[code], 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Row returns the values of a product in the order of Columns
+func Row(p models.Product) []interface{} {
+	var prices Prices
+	if len(p.Prices) > 0 {
+		prices = make(Prices, len(p.Prices))
+		for _, price := range p.Prices {
+			prices[price.Currency] = price.Major()
+		}
+	}
+	return []interface{}{p.ID.Hex(), p.SKU, p.Name, p.Description, p.Category, p.Price.Major(), prices, p.Stock}
+}
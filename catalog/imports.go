@@ -0,0 +1,386 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/jobs"
+	"go-tutorial/media"
+	"go-tutorial/models"
+)
+
+// Collection is where import jobs are stored
+const Collection = "product_imports"
+
+// ImportJob is the type of the background job running an import
+const ImportJob = "products.import"
+
+const (
+	// maxErrors caps the row errors kept with an import
+	maxErrors = 1000
+	// progressEvery is how many rows are imported between progress saves
+	progressEvery = 100
+)
+
+// ErrNotFound is returned when there is no import with that ID
+var ErrNotFound = errors.New("import not found")
+
+// Target saves the products read by an import
+type Target interface {
+	// ImportProduct creates or updates the product of a row, or only
+	// validates it for a dry run. It returns whether the row creates a
+	// product and the problems that keep it from being imported; an error
+	// is a failure to import it.
+	ImportProduct(ctx context.Context, job *models.ProductImport, item Item) (bool, []models.ImportRowError, error)
+	// FinishImport is called once all rows have been imported
+	FinishImport(ctx context.Context, job *models.ProductImport)
+}
+
+// ImportPayload is the payload of an ImportJob
+type ImportPayload struct {
+	ImportID primitive.ObjectID `json:"import_id"`
+}
+
+// ImportResult is the result of an ImportJob
+type ImportResult struct {
+	ImportID primitive.ObjectID `json:"import_id"`
+	Status   string             `json:"status"`
+	Total    int                `json:"total"`
+	Created  int                `json:"created"`
+	Updated  int                `json:"updated"`
+	Failed   int                `json:"failed"`
+}
+
+// Imports stores import jobs and the files they import
+type Imports struct {
+	imports *mongo.Collection
+	store   media.BlobStore
+	queue   *jobs.Queue
+	maxSize int64
+}
+
+// NewImports creates an import store over db keeping files of up to
+// maxSize bytes in store and running imports through queue
+func NewImports(db *mongo.Database, store media.BlobStore, queue *jobs.Queue, maxSize int64) *Imports {
+	return &Imports{imports: db.Collection(Collection), store: store, queue: queue, maxSize: maxSize}
+}
+
+// MaxSize returns the largest file accepted for import, in bytes
+func (s *Imports) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Create stores the file of a new import and queues the job running it
+func (s *Imports) Create(ctx context.Context, job *models.ProductImport, data []byte) error {
+	job.ID = primitive.NewObjectID()
+	job.Status = models.ImportPending
+	job.BlobKey = fmt.Sprintf("imports/%s.%s", job.ID.Hex(), job.Format)
+	job.Errors = []models.ImportRowError{}
+	job.CreatedAt = time.Now()
+
+	if err := s.store.Put(ctx, job.BlobKey, data, ContentType(job.Format)); err != nil {
+		return err
+	}
+	if _, err := s.imports.InsertOne(ctx, job); err != nil {
+		s.store.Delete(ctx, job.BlobKey)
+		return err
+	}
+
+	queued, err := s.queue.Enqueue(ctx, ImportJob, ImportPayload{ImportID: job.ID}, jobs.Options{CreatedBy: job.CreatedBy})
+	if err == nil {
+		job.JobID = &queued.ID
+		_, err = s.imports.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{"job_id": queued.ID}})
+	}
+	if err != nil {
+		s.imports.DeleteOne(ctx, bson.M{"_id": job.ID})
+		s.store.Delete(ctx, job.BlobKey)
+		return err
+	}
+	return nil
+}
+
+// Get returns an import
+func (s *Imports) Get(ctx context.Context, id primitive.ObjectID) (*models.ProductImport, error) {
+	var job models.ProductImport
+	err := s.imports.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Cancel stops an import. A queued import is cancelled at once, a running
+// one stops before its next row once its worker notices. It returns
+// jobs.ErrFinished for an import that already finished.
+func (s *Imports) Cancel(ctx context.Context, id primitive.ObjectID) (*models.ProductImport, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.FinishedAt != nil || job.JobID == nil {
+		return nil, jobs.ErrFinished
+	}
+
+	queued, err := s.queue.Cancel(ctx, *job.JobID)
+	if err != nil {
+		return nil, err
+	}
+	if queued.Status != models.JobCancelled {
+		return job, nil
+	}
+
+	// The job never ran, the import is finished here
+	now := time.Now()
+	_, err = s.imports.UpdateOne(ctx,
+		bson.M{"_id": job.ID, "finished_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.ImportCancelled, "finished_at": now}})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Delete(ctx, job.BlobKey); err != nil {
+		log.Printf("Error deleting file of product import %s: %v", job.ID.Hex(), err)
+	}
+	job.Status = models.ImportCancelled
+	job.FinishedAt = &now
+	return job, nil
+}
+
+// Importer runs imports as ImportJob jobs. An import whose worker stopped
+// is started again from the first row by the worker taking the job over;
+// rows already imported then update their products.
+type Importer struct {
+	imports *Imports
+	target  Target
+}
+
+func NewImporter(imports *Imports, target Target) *Importer {
+	return &Importer{imports: imports, target: target}
+}
+
+// Run imports every row of the import of a job. A file that can't be read
+// fails the job without retrying it, rows that fail are only counted.
+func (i *Importer) Run(ctx context.Context, task *jobs.Task, payload ImportPayload) (interface{}, error) {
+	job, err := i.start(ctx, payload.ImportID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, jobs.Permanent(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.FinishedAt != nil {
+		// The last worker finished the import but stopped before its job
+		return result(job), nil
+	}
+
+	// Rows are counted first, so progress can be shown against the total
+	total, err := i.count(ctx, job)
+	if err != nil {
+		return i.finish(ctx, job, err)
+	}
+	job.Total = total
+	i.save(ctx, task, job)
+
+	err = i.each(ctx, job, func(number int, row map[string]string) {
+		i.importRow(ctx, job, number, row)
+		if job.Processed%progressEvery == 0 {
+			i.save(ctx, task, job)
+		}
+	})
+	return i.finish(ctx, job, err)
+}
+
+// start marks an import as running, from the first row. An import that
+// already finished is returned as it is.
+func (i *Importer) start(ctx context.Context, id primitive.ObjectID) (*models.ProductImport, error) {
+	var job models.ProductImport
+	err := i.imports.imports.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "finished_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{
+			"status":     models.ImportRunning,
+			"started_at": time.Now(),
+			"total":      0,
+			"processed":  0,
+			"created":    0,
+			"updated":    0,
+			"failed":     0,
+			"errors":     []models.ImportRowError{},
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return i.imports.Get(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// importRow imports a single row and counts its outcome
+func (i *Importer) importRow(ctx context.Context, job *models.ProductImport, number int, row map[string]string) {
+	job.Processed++
+	item, errs := Mapping(job.Mapping).Item(number, row)
+	if len(errs) == 0 {
+		var created bool
+		var err error
+		created, errs, err = i.target.ImportProduct(ctx, job, item)
+		if err != nil {
+			log.Printf("Error importing row %d of product import %s: %v", number, job.ID.Hex(), err)
+			errs = []models.ImportRowError{{Row: number, Message: "The row could not be saved"}}
+		}
+		if len(errs) == 0 && created {
+			job.Created++
+			return
+		}
+		if len(errs) == 0 {
+			job.Updated++
+			return
+		}
+	}
+
+	job.Failed++
+	for _, rowErr := range errs {
+		if len(job.Errors) < maxErrors {
+			job.Errors = append(job.Errors, rowErr)
+		}
+	}
+}
+
+// count returns the number of rows of an import's file
+func (i *Importer) count(ctx context.Context, job *models.ProductImport) (int, error) {
+	total := 0
+	err := i.each(ctx, job, func(int, map[string]string) {
+		total++
+	})
+	return total, err
+}
+
+// each calls fn with every row of an import's file, numbered from 1
+func (i *Importer) each(ctx context.Context, job *models.ProductImport, fn func(int, map[string]string)) error {
+	blob, _, err := i.imports.store.Get(ctx, job.BlobKey)
+	if err != nil {
+		return fmt.Errorf("reading the file: %w", err)
+	}
+	defer blob.Close()
+
+	// XLSX files need random access, the others are read as they arrive
+	var src io.Reader = blob
+	if job.Format == FormatXLSX {
+		data, err := io.ReadAll(blob)
+		if err != nil {
+			return fmt.Errorf("reading the file: %w", err)
+		}
+		src = bytes.NewReader(data)
+	}
+
+	reader, err := NewReader(job.Format, src)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for number := 1; ; number++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		row, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("row %d: %w", number, err)
+		}
+		fn(number, row)
+	}
+}
+
+// save records the progress of an import with the import and its job
+func (i *Importer) save(ctx context.Context, task *jobs.Task, job *models.ProductImport) {
+	_, err := i.imports.imports.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"total":     job.Total,
+		"processed": job.Processed,
+		"created":   job.Created,
+		"updated":   job.Updated,
+		"failed":    job.Failed,
+		"errors":    job.Errors,
+	}})
+	if err == nil {
+		err = task.Progress(ctx, job.Processed, job.Total, "")
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Error saving progress of product import %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// finish records the outcome of an import and removes its file. err is a
+// failure to read the file, which fails the whole import, or the job being
+// stopped. An import whose job was taken over by another worker is left to
+// that worker.
+func (i *Importer) finish(ctx context.Context, job *models.ProductImport, err error) (interface{}, error) {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, jobs.ErrLeaseLost) {
+		return nil, cause
+	}
+	// The outcome is recorded even when the job was cancelled
+	ctx = context.WithoutCancel(ctx)
+
+	now := time.Now()
+	set := bson.M{
+		"status":      models.ImportCompleted,
+		"total":       job.Total,
+		"processed":   job.Processed,
+		"created":     job.Created,
+		"updated":     job.Updated,
+		"failed":      job.Failed,
+		"errors":      job.Errors,
+		"finished_at": now,
+	}
+	switch {
+	case errors.Is(cause, jobs.ErrCancelled):
+		set["status"] = models.ImportCancelled
+		err = cause
+	case err != nil:
+		set["status"] = models.ImportFailed
+		set["error"] = err.Error()
+		err = jobs.Permanent(err)
+	}
+	if _, updateErr := i.imports.imports.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": set}); updateErr != nil {
+		return nil, fmt.Errorf("finishing product import: %w", updateErr)
+	}
+
+	job.Status = set["status"].(string)
+	job.FinishedAt = &now
+	i.target.FinishImport(ctx, job)
+	if err := i.imports.store.Delete(ctx, job.BlobKey); err != nil {
+		log.Printf("Error deleting file of product import %s: %v", job.ID.Hex(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result(job), nil
+}
+
+// result sums up a finished import
+func result(job *models.ProductImport) ImportResult {
+	return ImportResult{
+		ImportID: job.ID,
+		Status:   job.Status,
+		Total:    job.Total,
+		Created:  job.Created,
+		Updated:  job.Updated,
+		Failed:   job.Failed,
+	}
+}
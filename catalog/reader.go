@@ -0,0 +1,229 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Reader reads the rows of a file keyed by column name. CSV and XLSX files
+// name their columns in the first row, each JSON line is an object.
+type Reader interface {
+	// Next returns the next row, io.EOF after the last one
+	Next() (map[string]string, error)
+	Close() error
+}
+
+// NewReader reads a file of the given format
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatNDJSON:
+		return newNDJSONReader(r), nil
+	case FormatXLSX:
+		return newXLSXReader(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// headerRow keys the values of a row by the header. Missing values are
+// empty, values without a header are dropped.
+func headerRow(header, values []string) map[string]string {
+	row := make(map[string]string, len(header))
+	for i, column := range header {
+		if i < len(values) {
+			row[column] = values[i]
+		} else {
+			row[column] = ""
+		}
+	}
+	return row
+}
+
+// blank reports whether a row has no values, such as the trailing rows of
+// a spreadsheet
+func blank(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The header is kept, the next read reuses its slice
+	header = append([]string(nil), header...)
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // Spreadsheets often write a BOM
+	return &csvReader{r: cr, header: header}, nil
+}
+
+func (c *csvReader) Next() (map[string]string, error) {
+	for {
+		values, err := c.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		if !blank(values) {
+			return headerRow(c.header, values), nil
+		}
+	}
+}
+
+func (c *csvReader) Close() error { return nil }
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (n *ndjsonReader) Next() (map[string]string, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("line %d is not a JSON object: %w", n.line, err)
+		}
+		row := make(map[string]string, len(object))
+		for key, value := range object {
+			row[key] = jsonString(value)
+		}
+		return row, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (n *ndjsonReader) Close() error { return nil }
+
+// jsonString writes a JSON value the way it would appear in a CSV cell.
+// Objects become "key=value" lists, so prices can be given as an object.
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			parts[i] = key + "=" + jsonString(v[key])
+		}
+		return strings.Join(parts, ",")
+	}
+	raw, _ := json.Marshal(value)
+	return string(raw)
+}
+
+// xlsxReader reads the first sheet of a workbook. The workbook is opened in
+// memory, its rows are read one at a time.
+type xlsxReader struct {
+	file   *excelize.File
+	rows   *excelize.Rows
+	header []string
+}
+
+func newXLSXReader(r io.Reader) (*xlsxReader, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("the file is not an XLSX workbook: %w", err)
+	}
+	sheets := file.GetSheetList()
+	if len(sheets) == 0 {
+		file.Close()
+		return nil, errors.New("the workbook has no sheets")
+	}
+	rows, err := file.Rows(sheets[0])
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	x := &xlsxReader{file: file, rows: rows}
+	for x.header == nil {
+		if !rows.Next() {
+			x.Close()
+			if err := rows.Error(); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("the file is empty")
+		}
+		values, err := rows.Columns()
+		if err != nil {
+			x.Close()
+			return nil, err
+		}
+		if !blank(values) {
+			x.header = values
+		}
+	}
+	return x, nil
+}
+
+func (x *xlsxReader) Next() (map[string]string, error) {
+	for x.rows.Next() {
+		values, err := x.rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		if !blank(values) {
+			return headerRow(x.header, values), nil
+		}
+	}
+	if err := x.rows.Error(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (x *xlsxReader) Close() error {
+	x.rows.Close()
+	return x.file.Close()
+}
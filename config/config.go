@@ -1,15 +1,107 @@
 package config
 
+import "time"
+
+// APIKey lets an internal service call the API without a user token, acting
+// with the permissions of Role. Service is recorded as the actor of its
+// changes.
+type APIKey struct {
+	Service string
+	Role    string
+}
+
 type Config struct {
-	MongoURI string
-	Database string
-	Port     string
+	MongoURI             string
+	Database             string
+	Port                 string
+	Currency             string
+	ExchangeRatesFile    string
+	PaymentWebhookSecret string
+	MediaStore           string // "local" or "s3"
+	MediaDir             string
+	MediaURLSecret       string
+	MediaURLTTL          time.Duration
+	MaxImageSize         int64
+	ThumbnailSizes       []int
+	S3Endpoint           string
+	S3Region             string
+	S3Bucket             string
+	S3AccessKey          string
+	S3SecretKey          string
+	TrashRetention       time.Duration
+	TrashPurgeInterval   time.Duration
+	AuditRetention       time.Duration
+	AuditBufferSize      int
+	RevisionsKeep        int           // Revisions kept per product, 0 keeps all
+	RevisionsMaxAge      time.Duration // 0 keeps revisions forever
+	EventPublisher       string        // "log", "memory" or "redis"
+	EventStream          string        // Redis stream events are added to
+	EventStreamMaxLen    int64         // Approximate stream length kept, 0 keeps all
+	EventRelayInterval   time.Duration
+	EventMaxAttempts     int    // Attempts before an event is marked failed, 0 retries forever
+	LiveChannel          string // Redis channel broadcasting events to every instance
+	LiveReplaySize       int    // Events kept for clients resuming a stream
+	WebhookInterval      time.Duration
+	WebhookTimeout       time.Duration
+	WebhookMaxAttempts   int    // Attempts before a delivery is dead
+	WebhookDisableAfter  int    // Failed attempts in a row before a webhook is disabled, 0 never disables
+	NotificationBroker   string // "memory" for a single instance or "redis"
+	NotificationChannel  string // Redis channel carrying notifications to every instance
+	ImportMaxSize        int64  // Largest product import file in bytes
+	Mode                 string // "api" serves HTTP, "worker" runs the background work, "all" does both
+	JobConcurrency       int    // Jobs a worker process runs at once
+	JobPollInterval      time.Duration
+	JobVisibilityTimeout time.Duration     // How long a job of a stopped worker waits before another worker takes it over
+	GraphiQL             bool              // Serves the GraphiQL IDE at /graphiql, only turned on for development
+	GRPCPort             string            // Port of the gRPC server running alongside the HTTP server
+	APIKeys              map[string]APIKey // By key, accepted in the X-API-Key header and gRPC metadata
 }
 
 func LoadConfig() *Config {
 	return &Config{
-		MongoURI: "mongodb://localhost:27017/",
-		Database: "test-db",
-		Port:     ":80",
+		MongoURI:             "mongodb://localhost:27017/?replicaSet=rs0",
+		Database:             "test-db",
+		Port:                 ":80",
+		Currency:             "USD",
+		ExchangeRatesFile:    "exchange_rates.json",
+		PaymentWebhookSecret: "your-webhook-secret",
+		MediaStore:           "local",
+		MediaDir:             "uploads",
+		MediaURLSecret:       "your-media-secret",
+		MediaURLTTL:          24 * time.Hour,
+		MaxImageSize:         10 << 20,
+		ThumbnailSizes:       []int{160, 480, 1024},
+		S3Endpoint:           "http://localhost:9000",
+		S3Region:             "us-east-1",
+		S3Bucket:             "product-images",
+		S3AccessKey:          "minioadmin",
+		S3SecretKey:          "minioadmin",
+		TrashRetention:       30 * 24 * time.Hour,
+		TrashPurgeInterval:   time.Hour,
+		AuditRetention:       365 * 24 * time.Hour,
+		AuditBufferSize:      1000,
+		RevisionsKeep:        100,
+		RevisionsMaxAge:      0,
+		EventPublisher:       "log",
+		EventStream:          "events",
+		EventStreamMaxLen:    100000,
+		EventRelayInterval:   time.Second,
+		EventMaxAttempts:     10,
+		LiveChannel:          "events:live",
+		LiveReplaySize:       1000,
+		WebhookInterval:      5 * time.Second,
+		WebhookTimeout:       10 * time.Second,
+		WebhookMaxAttempts:   10,
+		WebhookDisableAfter:  50,
+		NotificationBroker:   "redis",
+		NotificationChannel:  "notifications",
+		ImportMaxSize:        50 << 20,
+		Mode:                 "all",
+		JobConcurrency:       4,
+		JobPollInterval:      time.Second,
+		JobVisibilityTimeout: time.Minute,
+		GraphiQL:             false,
+		GRPCPort:             ":9090",
+		APIKeys:              map[string]APIKey{},
 	}
 }
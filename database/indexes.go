@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes lists the indexes each collection needs
+var collectionIndexes = map[string][]mongo.IndexModel{
+	"products": {
+		{
+			// Full-text index used by product search, a match in the name
+			// ranks above a match in the description
+			Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().
+				SetName("product_text").
+				SetWeights(bson.M{"name": 10, "description": 2}),
+		},
+		{Keys: bson.D{{Key: "category", Value: 1}}},
+		{Keys: bson.D{{Key: "rating.average", Value: -1}}},
+		{
+			// SKUs are unique across live products, products without
+			// variants are left out of the index. Trashed products differ
+			// in deleted_at, so their SKUs can be used again.
+			Keys: bson.D{{Key: "variants.sku", Value: 1}, {Key: "deleted_at", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"variants.sku": bson.M{"$exists": true}}),
+		},
+		{
+			// Product SKUs are unique too, used to match imported rows
+			Keys: bson.D{{Key: "sku", Value: 1}, {Key: "deleted_at", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"sku": bson.M{"$exists": true}}),
+		},
+	},
+	"users": {
+		{
+			// Emails are unique among live users. Trashed users keep their
+			// email and differ in deleted_at, so it can be registered again.
+			Keys:    bson.D{{Key: "email", Value: 1}, {Key: "deleted_at", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"stock_movements": {
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"reservations": {
+		// Used by the sweeper to find expired reservations
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	},
+	"carts": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"orders": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"payments": {
+		{
+			// An order has at most one payment that has not failed
+			Keys: bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"open": true}),
+		},
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "provider_ref", Value: 1}}},
+	},
+	"reviews": {
+		{
+			// Each user reviews a product at most once
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"price_rules": {
+		{
+			// Coupon codes are unique, automatic discounts have no code
+			Keys: bson.D{{Key: "code", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"code": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "active", Value: 1}, {Key: "priority", Value: -1}}},
+	},
+	"product_revisions": {
+		// Numbers are unique per product, concurrent changes retry on a clash
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "number", Value: -1}},
+			Options: options.Index().SetUnique(true),
+		},
+	},
+	"audit_log": {
+		// Retention is a TTL index created by audit.EnsureRetention
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "resource_type", Value: 1}, {Key: "resource_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"outbox": {
+		// The relay claims due pending events, oldest first
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		// Published events are kept a week for inspection, failed ones until
+		// someone looks at them
+		{
+			Keys:    bson.D{{Key: "published_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60),
+		},
+	},
+	"webhook_subscriptions": {
+		// The dispatcher looks up active subscriptions by event type
+		{Keys: bson.D{{Key: "active", Value: 1}, {Key: "events", Value: 1}}},
+	},
+	"webhook_deliveries": {
+		// An event is delivered once per subscription, even when republished
+		{
+			Keys:    bson.D{{Key: "subscription_id", Value: 1}, {Key: "event_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		// Delivery history is kept for 30 days
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	},
+	"notifications": {
+		// Listing a user's notifications, all or unread only, newest first
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "read_at", Value: 1}, {Key: "created_at", Value: -1}}},
+	},
+	"product_imports": {
+		// Finished imports are kept for 30 days
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	},
+	"jobs": {
+		// Used by workers to claim due jobs and take over abandoned ones
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "type", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		// Finished jobs are kept for 30 days
+		{
+			Keys:    bson.D{{Key: "finished_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 60 * 60),
+		},
+	},
+	"categories": {
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "path", Value: 1}}},
+	},
+}
+
+// EnsureIndexes creates any missing indexes. Creating an index that already
+// exists with the same definition is a no-op.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	for collection, indexes := range collectionIndexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,12 +2,19 @@ package database
 
 import (
 	"context"
+	"errors"
 	"go-tutorial/config"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrNoTransactions is returned by Connect when the server is a standalone
+// mongod. Writes run in transactions, which need a replica set member or a
+// mongos router.
+var ErrNoTransactions = errors.New("MongoDB does not support transactions: run it as a replica set (mongod --replSet rs0, then rs.initiate()) and connect with ?replicaSet=rs0")
+
 func Connect(cfg *config.Config) (*mongo.Client, error) {
 	// Initialize MongoDB client
 	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
@@ -21,6 +28,28 @@ func Connect(cfg *config.Config) (*mongo.Client, error) {
 	if err = client.Ping(context.TODO(), nil); err != nil {
 		return nil, err
 	}
+	// Fail at startup rather than on the first write
+	if err = checkTransactions(context.TODO(), client); err != nil {
+		client.Disconnect(context.TODO())
+		return nil, err
+	}
 
 	return client, nil
 }
+
+// checkTransactions asks the server what it is. Replica set members report
+// their set's name and mongos reports "isdbgrid", a standalone neither.
+func checkTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrNoTransactions
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package discounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+var (
+	// ErrCouponNotFound is returned for codes no active rule has
+	ErrCouponNotFound = errors.New("coupon not found")
+	// ErrCouponNotStarted is returned for coupons scheduled to start later
+	ErrCouponNotStarted = errors.New("coupon is not valid yet")
+	// ErrCouponExpired is returned for coupons past their end time
+	ErrCouponExpired = errors.New("coupon has expired")
+	// ErrCouponExhausted is returned when a coupon reached its global usage limit
+	ErrCouponExhausted = errors.New("coupon has been used up")
+	// ErrCouponLimitReached is returned when a user used a coupon as often as
+	// allowed
+	ErrCouponLimitReached = errors.New("coupon usage limit reached")
+)
+
+// Service stores price rules and tracks coupon usage
+type Service struct {
+	rules  *mongo.Collection
+	usages *mongo.Collection
+}
+
+// NewService creates a discount service over db
+func NewService(db *mongo.Database) *Service {
+	return &Service{
+		rules:  db.Collection("price_rules"),
+		usages: db.Collection("coupon_usages"),
+	}
+}
+
+// NormalizeCode returns the stored form of a coupon code
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Rules returns the rules active at a time, the automatic ones plus the
+// coupons among codes
+func (s *Service) Rules(ctx context.Context, at time.Time, codes []string) ([]models.PriceRule, error) {
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		normalized = append(normalized, NormalizeCode(code))
+	}
+
+	filter := bson.M{
+		"active": true,
+		"$and": []bson.M{
+			{"$or": []bson.M{{"code": bson.M{"$exists": false}}, {"code": bson.M{"$in": normalized}}}},
+			{"$or": []bson.M{{"starts_at": bson.M{"$exists": false}}, {"starts_at": bson.M{"$lte": at}}}},
+			{"$or": []bson.M{{"ends_at": bson.M{"$exists": false}}, {"ends_at": bson.M{"$gt": at}}}},
+		},
+	}
+	cursor, err := s.rules.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var rules []models.PriceRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Coupon returns the rule of a coupon code if userID may use it at a time
+func (s *Service) Coupon(ctx context.Context, code, userID string, at time.Time) (*models.PriceRule, error) {
+	var rule models.PriceRule
+	err := s.rules.FindOne(ctx, bson.M{"code": NormalizeCode(code), "active": true}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case rule.StartsAt != nil && at.Before(*rule.StartsAt):
+		return nil, ErrCouponNotStarted
+	case rule.EndsAt != nil && !at.Before(*rule.EndsAt):
+		return nil, ErrCouponExpired
+	case rule.UsageLimit > 0 && rule.UsedCount >= rule.UsageLimit:
+		return nil, ErrCouponExhausted
+	}
+
+	if rule.PerUserLimit > 0 {
+		var usage struct {
+			Count int `bson:"count"`
+		}
+		err := s.usages.FindOne(ctx, bson.M{"_id": usageID(rule.ID, userID)}).Decode(&usage)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if usage.Count >= rule.PerUserLimit {
+			return nil, ErrCouponLimitReached
+		}
+	}
+	return &rule, nil
+}
+
+// usageID keys the usage count of a coupon by a user
+func usageID(ruleID primitive.ObjectID, userID string) string {
+	return ruleID.Hex() + ":" + userID
+}
+
+// Redeem counts one use of a coupon by a user. Both limits are enforced by
+// conditional updates, so concurrent checkouts cannot exceed them. Pass a
+// session context to make the redemption part of a transaction.
+func (s *Service) Redeem(ctx context.Context, rule models.PriceRule, userID string) error {
+	filter := bson.M{"_id": rule.ID}
+	if rule.UsageLimit > 0 {
+		filter["$expr"] = bson.M{"$lt": bson.A{"$used_count", "$usage_limit"}}
+	}
+	result, err := s.rules.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"used_count": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCouponExhausted
+	}
+
+	// A user at the limit fails the count filter, and the upsert then
+	// collides with their existing usage document
+	usageFilter := bson.M{"_id": usageID(rule.ID, userID)}
+	if rule.PerUserLimit > 0 {
+		usageFilter["count"] = bson.M{"$lt": rule.PerUserLimit}
+	}
+	_, err = s.usages.UpdateOne(ctx, usageFilter,
+		bson.M{
+			"$inc":         bson.M{"count": 1},
+			"$setOnInsert": bson.M{"rule_id": rule.ID, "user_id": userID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCouponLimitReached
+	}
+	return err
+}
+
+// Release gives back a use of a coupon, when the order it was used on is
+// cancelled
+func (s *Service) Release(ctx context.Context, ruleID primitive.ObjectID, userID string) error {
+	_, err := s.rules.UpdateOne(ctx,
+		bson.M{"_id": ruleID, "used_count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"used_count": -1}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.usages.UpdateOne(ctx,
+		bson.M{"_id": usageID(ruleID, userID), "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
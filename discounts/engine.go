@@ -0,0 +1,161 @@
+package discounts
+
+import (
+	"math"
+	"slices"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+)
+
+// Converter converts amounts between currencies, *money.RateStore is one
+type Converter interface {
+	Convert(m money.Money, to string) (money.Money, error)
+}
+
+// Item is a product, or a variant of it, to quote. Categories holds the
+// product's category and all of its ancestors.
+type Item struct {
+	ProductID  primitive.ObjectID
+	VariantID  *primitive.ObjectID
+	Name       string
+	SKU        string
+	Quantity   int
+	UnitPrice  money.Money
+	Categories []string
+}
+
+// Quote prices items in a currency with the rules that apply to each. Every
+// rule given is assumed active, see Service.Rules.
+func Quote(rules []models.PriceRule, items []Item, currency string, rates Converter) models.Quote {
+	rules = slices.Clone(rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		return rules[i].ID.Hex() < rules[j].ID.Hex()
+	})
+
+	quote := models.Quote{
+		Currency: currency,
+		Lines:    make([]models.QuoteLine, 0, len(items)),
+		Subtotal: money.Zero(currency),
+		Discount: money.Zero(currency),
+		Total:    money.Zero(currency),
+	}
+	coupons := make(map[string]bool)
+	for _, item := range items {
+		discounts := bestDiscounts(rules, item, rates)
+		line := models.QuoteLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Name:      item.Name,
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+			Discounts: discounts,
+			UnitTotal: item.UnitPrice,
+		}
+		for _, d := range discounts {
+			line.UnitTotal.Amount -= d.Amount.Amount
+			if d.Code != "" {
+				coupons[d.Code] = true
+			}
+		}
+		line.LineTotal = line.UnitTotal.Mul(item.Quantity)
+
+		quote.Lines = append(quote.Lines, line)
+		quote.Subtotal.Amount += item.UnitPrice.Mul(item.Quantity).Amount
+		quote.Total.Amount += line.LineTotal.Amount
+	}
+	quote.Discount.Amount = quote.Subtotal.Amount - quote.Total.Amount
+
+	for code := range coupons {
+		quote.Coupons = append(quote.Coupons, code)
+	}
+	sort.Strings(quote.Coupons)
+	return quote
+}
+
+// bestDiscounts returns the discounts of the best exclusive rule or of all
+// stackable rules, whichever takes more off the unit price
+func bestDiscounts(rules []models.PriceRule, item Item, rates Converter) []models.AppliedDiscount {
+	stacked := []models.AppliedDiscount{}
+	var exclusive *models.AppliedDiscount
+	price := item.UnitPrice
+	for _, rule := range rules {
+		if !Applies(rule, item) {
+			continue
+		}
+
+		if rule.Stackable {
+			// Stackable rules apply one after another to what is left
+			d, ok := discount(rule, price, rates)
+			if ok {
+				stacked = append(stacked, d)
+				price.Amount -= d.Amount.Amount
+			}
+			continue
+		}
+		d, ok := discount(rule, item.UnitPrice, rates)
+		if ok && (exclusive == nil || d.Amount.Amount > exclusive.Amount.Amount) {
+			exclusive = &d
+		}
+	}
+
+	if exclusive != nil && exclusive.Amount.Amount >= item.UnitPrice.Amount-price.Amount {
+		return []models.AppliedDiscount{*exclusive}
+	}
+	return stacked
+}
+
+// Applies reports whether an item is in the scope of a rule
+func Applies(rule models.PriceRule, item Item) bool {
+	switch rule.Scope {
+	case models.ScopeCatalog:
+		return true
+	case models.ScopeProduct:
+		return slices.Contains(rule.ProductIDs, item.ProductID)
+	case models.ScopeCategory:
+		for _, category := range rule.Categories {
+			if slices.Contains(item.Categories, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discount works out what a rule takes off a unit price, never more than the
+// price itself. Fixed amounts that cannot be converted to the price's
+// currency do not apply.
+func discount(rule models.PriceRule, price money.Money, rates Converter) (models.AppliedDiscount, bool) {
+	off := money.Zero(price.Currency)
+	switch rule.Type {
+	case models.DiscountPercentage:
+		off.Amount = int64(math.Round(float64(price.Amount) * rule.Percent / 100))
+	case models.DiscountFixed:
+		if rule.Amount == nil {
+			return models.AppliedDiscount{}, false
+		}
+		converted, err := rates.Convert(*rule.Amount, price.Currency)
+		if err != nil {
+			return models.AppliedDiscount{}, false
+		}
+		off = converted
+	}
+	off.Amount = min(off.Amount, price.Amount)
+	if off.Amount <= 0 {
+		return models.AppliedDiscount{}, false
+	}
+
+	return models.AppliedDiscount{
+		RuleID: rule.ID,
+		Name:   rule.Name,
+		Code:   rule.Code,
+		Amount: off,
+	}, true
+}
@@ -0,0 +1,138 @@
+// Package events carries domain events to downstream services. Handlers add
+// events to the outbox in the transaction that makes the change, and the
+// relay publishes them afterwards, so an event is published if and only if
+// its change was committed. Delivery is at least once, consumers should
+// ignore event IDs they have already seen.
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+)
+
+// Event types
+const (
+	ProductCreated  = "product.created"
+	ProductUpdated  = "product.updated"
+	ProductDeleted  = "product.deleted"
+	ProductRestored = "product.restored"
+	StockChanged    = "product.stock_changed"
+	UserRegistered  = "user.registered"
+	UserUpdated     = "user.updated"
+	UserDeleted     = "user.deleted"
+	UserRestored    = "user.restored"
+	RoleAssigned    = "user.role_assigned"
+)
+
+// Types lists every event type, e.g. for consumers to subscribe to
+var Types = []string{
+	ProductCreated, ProductUpdated, ProductDeleted, ProductRestored, StockChanged,
+	UserRegistered, UserUpdated, UserDeleted, UserRestored, RoleAssigned,
+}
+
+// Aggregate types, the kind of resource an event is about
+const (
+	AggregateProduct = "product"
+	AggregateUser    = "user"
+)
+
+// Event is a domain event. Data is the JSON payload of its type.
+type Event struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Type          string             `json:"type" bson:"type"`
+	AggregateType string             `json:"aggregate_type" bson:"aggregate_type"`
+	AggregateID   string             `json:"aggregate_id" bson:"aggregate_id"`
+	Actor         string             `json:"actor,omitempty" bson:"actor,omitempty"`
+	Data          json.RawMessage    `json:"data" bson:"data"`
+	OccurredAt    time.Time          `json:"occurred_at" bson:"occurred_at"`
+}
+
+// ProductData is the payload of product events. Changed lists the fields
+// an update changed.
+type ProductData struct {
+	Product models.Product `json:"product"`
+	Changed []string       `json:"changed,omitempty"`
+}
+
+// StockChangedData is the payload of StockChanged
+type StockChangedData struct {
+	ProductID string `json:"product_id"`
+	VariantID string `json:"variant_id,omitempty"`
+	Delta     int    `json:"delta"`
+	Quantity  int    `json:"quantity"`
+	Reason    string `json:"reason"`
+}
+
+// UserData is the payload of user events. It never carries the password.
+type UserData struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// RoleAssignedData is the payload of RoleAssigned
+type RoleAssignedData struct {
+	UserID string `json:"user_id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// newEvent builds an event. Payloads are plain structs, which always
+// marshal.
+func newEvent(eventType, aggregateType, aggregateID, actor string, data interface{}) Event {
+	raw, _ := json.Marshal(data)
+	return Event{
+		ID:            primitive.NewObjectID(),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Actor:         actor,
+		Data:          raw,
+		OccurredAt:    time.Now(),
+	}
+}
+
+// NewProductEvent builds a ProductCreated, ProductUpdated, ProductDeleted or
+// ProductRestored event
+func NewProductEvent(eventType string, product models.Product, changed []string, actor string) Event {
+	return newEvent(eventType, AggregateProduct, product.ID.Hex(), actor, ProductData{Product: product, Changed: changed})
+}
+
+// NewStockChanged builds the event of a stock ledger movement
+func NewStockChanged(movement models.StockMovement) Event {
+	data := StockChangedData{
+		ProductID: movement.ProductID.Hex(),
+		Delta:     movement.Delta,
+		Quantity:  movement.Quantity,
+		Reason:    movement.Reason,
+	}
+	if movement.VariantID != nil {
+		data.VariantID = movement.VariantID.Hex()
+	}
+	return newEvent(StockChanged, AggregateProduct, data.ProductID, movement.Actor, data)
+}
+
+// NewUserEvent builds a UserRegistered, UserUpdated, UserDeleted or
+// UserRestored event
+func NewUserEvent(eventType string, user models.User, actor string) Event {
+	return newEvent(eventType, AggregateUser, user.ID.Hex(), actor, UserData{
+		ID:    user.ID.Hex(),
+		Name:  user.Name,
+		Email: user.Email,
+		Role:  user.Role,
+	})
+}
+
+// NewRoleAssigned builds the event of a user's role changing
+func NewRoleAssigned(userID primitive.ObjectID, from, to, actor string) Event {
+	return newEvent(RoleAssigned, AggregateUser, userID.Hex(), actor, RoleAssignedData{
+		UserID: userID.Hex(),
+		From:   from,
+		To:     to,
+	})
+}
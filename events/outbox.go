@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is where events wait to be published
+const Collection = "outbox"
+
+// Outbox entry statuses
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+	StatusFailed    = "failed"
+)
+
+// entry is an event in the outbox with its delivery state. A pending entry
+// is due once NextAttemptAt has passed, a relay that claims it pushes
+// NextAttemptAt forward so others leave it alone while it is published.
+type entry struct {
+	Event         `bson:",inline"`
+	Status        string     `bson:"status"`
+	Attempts      int        `bson:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at"`
+	LastError     string     `bson:"last_error,omitempty"`
+	PublishedAt   *time.Time `bson:"published_at,omitempty"`
+}
+
+// Outbox stores events next to the changes they describe
+type Outbox struct {
+	collection *mongo.Collection
+}
+
+// NewOutbox creates an outbox over db
+func NewOutbox(db *mongo.Database) *Outbox {
+	return &Outbox{collection: db.Collection(Collection)}
+}
+
+// Add stores events for publishing. Pass the session context of the
+// transaction making the change, so the events are committed or rolled back
+// with it.
+func (o *Outbox) Add(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		docs[i] = entry{
+			Event:         event,
+			Status:        StatusPending,
+			NextAttemptAt: event.OccurredAt,
+		}
+	}
+	_, err := o.collection.InsertMany(ctx, docs)
+	return err
+}
+
+// claim takes the oldest due event for lease, returning nil when none is due
+func (o *Outbox) claim(ctx context.Context, lease time.Duration) (*entry, error) {
+	now := time.Now()
+	var claimed entry
+	err := o.collection.FindOneAndUpdate(ctx,
+		bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{
+			"$set": bson.M{"next_attempt_at": now.Add(lease)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&claimed)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &claimed, nil
+}
+
+// markPublished records that a claimed event was delivered
+func (o *Outbox) markPublished(ctx context.Context, e *entry) error {
+	now := time.Now()
+	_, err := o.collection.UpdateOne(ctx, bson.M{"_id": e.ID}, bson.M{
+		"$set":   bson.M{"status": StatusPublished, "published_at": now},
+		"$unset": bson.M{"last_error": ""},
+	})
+	return err
+}
+
+// markFailed records a failed delivery, scheduling a retry at next or giving
+// up when next is zero
+func (o *Outbox) markFailed(ctx context.Context, e *entry, cause error, next time.Time) error {
+	set := bson.M{"last_error": cause.Error()}
+	if next.IsZero() {
+		set["status"] = StatusFailed
+	} else {
+		set["next_attempt_at"] = next
+	}
+	_, err := o.collection.UpdateOne(ctx, bson.M{"_id": e.ID}, bson.M{"$set": set})
+	return err
+}
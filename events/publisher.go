@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Publisher delivers events to their consumers. An error makes the relay
+// retry the event later.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Fanout publishes events to each of its publishers. When one fails the
+// event is retried for all of them, so each must tolerate duplicates.
+type Fanout []Publisher
+
+// Publish hands event to every publisher
+func (f Fanout) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, publisher := range f {
+		if err := publisher.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogPublisher writes events to the log, for development and for running
+// without consumers
+type LogPublisher struct{}
+
+// Publish logs event
+func (LogPublisher) Publish(ctx context.Context, event Event) error {
+	log.Printf("Event %s %s %s/%s: %s", event.ID.Hex(), event.Type, event.AggregateType, event.AggregateID, event.Data)
+	return nil
+}
+
+// Handler consumes an event from the bus
+type Handler func(ctx context.Context, event Event) error
+
+// Bus delivers events to in-process subscribers
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// NewBus creates an in-memory bus without subscribers
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe registers handler for events of eventType, "*" subscribes to
+// every event
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish calls the subscribers of event in turn. A failing subscriber does
+// not stop the others, but the event is retried for all of them.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[event.Type]...), b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RedisStream appends events to a Redis stream, where consumer groups can
+// read them
+type RedisStream struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisStream creates a publisher appending to stream, trimmed to about
+// maxLen entries, 0 keeps every entry
+func NewRedisStream(client *redis.Client, stream string, maxLen int64) *RedisStream {
+	return &RedisStream{client: client, stream: stream, maxLen: maxLen}
+}
+
+// Publish appends event to the stream
+func (s *RedisStream) Publish(ctx context.Context, event Event) error {
+	args := &redis.XAddArgs{
+		Stream: s.stream,
+		Values: map[string]interface{}{
+			"id":             event.ID.Hex(),
+			"type":           event.Type,
+			"aggregate_type": event.AggregateType,
+			"aggregate_id":   event.AggregateID,
+			"actor":          event.Actor,
+			"data":           string(event.Data),
+			"occurred_at":    event.OccurredAt.Format(time.RFC3339Nano),
+		},
+	}
+	if s.maxLen > 0 {
+		args.MaxLen = s.maxLen
+		args.Approx = true
+	}
+	if err := s.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("adding to stream %s: %w", s.stream, err)
+	}
+	return nil
+}
+
+// RedisPubSub broadcasts events on a Redis channel to every running
+// instance. Subscribers that are not connected miss them, use RedisStream
+// for durable consumers.
+type RedisPubSub struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisPubSub creates a publisher broadcasting on channel
+func NewRedisPubSub(client *redis.Client, channel string) *RedisPubSub {
+	return &RedisPubSub{client: client, channel: channel}
+}
+
+// Publish broadcasts event as JSON
+func (p *RedisPubSub) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := p.client.Publish(ctx, p.channel, payload).Err(); err != nil {
+		return fmt.Errorf("publishing to channel %s: %w", p.channel, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// relayBatchSize bounds the events published per tick
+	relayBatchSize = 100
+	// leaseDuration is how long a claimed event is left to its relay before
+	// another relay may take it over, e.g. after a crash mid-publish
+	leaseDuration = time.Minute
+	// maxBackoff caps the wait between retries of an event
+	maxBackoff = time.Hour
+)
+
+// Relay periodically publishes the due events of the outbox. Events are
+// published oldest first, an event that fails is retried with exponential
+// backoff and may then overtake newer ones. Several relays can run at once,
+// each event is claimed by one of them at a time.
+type Relay struct {
+	outbox      *Outbox
+	publisher   Publisher
+	interval    time.Duration
+	maxAttempts int
+}
+
+func NewRelay(outbox *Outbox, publisher Publisher, interval time.Duration, maxAttempts int) *Relay {
+	return &Relay{
+		outbox:      outbox,
+		publisher:   publisher,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (r *Relay) Start() {
+	ticker := time.NewTicker(r.interval)
+	go func() {
+		for range ticker.C {
+			r.relay()
+		}
+	}()
+}
+
+func (r *Relay) relay() {
+	ctx := context.Background()
+	for i := 0; i < relayBatchSize; i++ {
+		e, err := r.outbox.claim(ctx, leaseDuration)
+		if err != nil {
+			log.Printf("Error claiming outbox event: %v", err)
+			return
+		}
+		if e == nil {
+			return
+		}
+		r.deliver(ctx, e)
+	}
+}
+
+// deliver publishes a claimed event and records the outcome. An event whose
+// outcome can't be recorded is published again once its lease runs out.
+func (r *Relay) deliver(ctx context.Context, e *entry) {
+	publishCtx, cancel := context.WithTimeout(ctx, leaseDuration/2)
+	err := r.publisher.Publish(publishCtx, e.Event)
+	cancel()
+	if err == nil {
+		if err := r.outbox.markPublished(ctx, e); err != nil {
+			log.Printf("Error marking event %s published: %v", e.ID.Hex(), err)
+		}
+		return
+	}
+
+	var next time.Time
+	if r.maxAttempts <= 0 || e.Attempts < r.maxAttempts {
+		next = time.Now().Add(backoff(e.Attempts))
+		log.Printf("Error publishing event %s, attempt %d: %v", e.ID.Hex(), e.Attempts, err)
+	} else {
+		log.Printf("Giving up on event %s after %d attempts: %v", e.ID.Hex(), e.Attempts, err)
+	}
+	if err := r.outbox.markFailed(ctx, e, err, next); err != nil {
+		log.Printf("Error recording failure of event %s: %v", e.ID.Hex(), err)
+	}
+}
+
+// backoff returns the wait before the retry following attempt: 2s, 4s, 8s
+// and so on up to maxBackoff
+func backoff(attempt int) time.Duration {
+	if attempt > 20 {
+		return maxBackoff
+	}
+	wait := time.Second << attempt
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
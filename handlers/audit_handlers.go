@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/audit"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+)
+
+// clientIP returns the address the request came from. Proxy headers are
+// not trusted, they can be set by anyone.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestID returns the ID the RequestID middleware gave the request
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value("request_id").(string)
+	return id
+}
+
+// audit records a change made by the authenticated user. before and after
+// are the resource around the change, nil for a creation or deletion.
+func (h *Handler) audit(r *http.Request, action, resourceType, resourceID string, before, after interface{}) {
+	h.auditAs(r, currentUser(r), action, resourceType, resourceID, before, after)
+}
+
+// auditAs records a change made by actor, for requests made before the
+// actor is authenticated
+func (h *Handler) auditAs(r *http.Request, actor, action, resourceType, resourceID string, before, after interface{}) {
+	h.Audit.Record(models.AuditEntry{
+		Actor:        actor,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      audit.Diff(before, after),
+		RequestID:    requestID(r),
+		IP:           clientIP(r),
+	})
+}
+
+// GetAuditLog handles querying the audit log, newest entries first. It
+// filters on actor, action, resource_type, resource_id and a from/to time
+// range given in RFC 3339.
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "The audit log supports page and limit pagination only")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := bson.M{}
+	for param, field := range map[string]string{
+		"actor":         "actor",
+		"action":        "action",
+		"resource_type": "resource_type",
+		"resource_id":   "resource_id",
+	} {
+		if value := query.Get(param); value != "" {
+			filter[field] = value
+		}
+	}
+
+	createdAt := bson.M{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lt"} {
+		raw := query.Get(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+				{Field: param, Message: "Must be a time in RFC 3339 format"},
+			})
+			return
+		}
+		createdAt[operator] = t
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	// Decode the values in diffs as objects rather than key/value lists
+	auditLog := h.DB.Database(h.Database).Collection(audit.Collection,
+		options.Collection().SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true}))
+	total, err := auditLog.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting audit entries")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := auditLog.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching audit entries")
+		return
+	}
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing audit entries")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Audit log fetched successfully", entries, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
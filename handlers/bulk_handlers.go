@@ -0,0 +1,910 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+
+	"go-tutorial/cache"
+	"go-tutorial/events"
+	"go-tutorial/inventory"
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// maxBulkOperations caps the operations of one bulk write
+const maxBulkOperations = 500
+
+// bulkItem is a validated operation of a bulk write on documents of type T
+type bulkItem[T any] struct {
+	result *models.BulkItemResult
+	status string // The status the item gets once committed
+	id     primitive.ObjectID
+	// model is the write of the item in the bulk write, nil when the item
+	// only writes in commit
+	model mongo.WriteModel
+	// previous is the document before an update or delete, read in the
+	// transaction
+	previous *T
+	// unique holds the values of unique fields the item writes, checked
+	// against the batch and the live documents before the transaction
+	unique bson.M
+	// check tells whether an update or delete can apply to the current
+	// document, before the transaction
+	check func(current *T) error
+	// commit runs in the transaction after the bulk write and returns the
+	// events of the change
+	commit func(sc mongo.SessionContext) ([]events.Event, error)
+	// done runs once the change is committed
+	done func(ctx context.Context)
+}
+
+// errBulkDuplicate is the failure of an item writing a unique value that
+// another document or an earlier item of the batch already has
+var errBulkDuplicate = errors.New("duplicate value of a unique field")
+
+// bulkFailure is the failure of one item that aborted a bulk write
+type bulkFailure struct {
+	index int // Position among the items written
+	err   error
+}
+
+func (e *bulkFailure) Error() string { return e.err.Error() }
+func (e *bulkFailure) Unwrap() error { return e.err }
+
+// bulkError builds the error of a failed item
+func bulkError(code int, message string) *models.BulkItemError {
+	return &models.BulkItemError{Code: code, Message: message}
+}
+
+// bulkValidationError builds the error of an item whose data failed
+// validation
+func bulkValidationError(err error) *models.BulkItemError {
+	itemErr := bulkError(http.StatusBadRequest, "Validation failed")
+	for _, err := range err.(validator.ValidationErrors) {
+		itemErr.Fields = append(itemErr.Fields, models.BulkFieldError{
+			Field:   err.Field(),
+			Message: utils.FormatValidationError(err),
+		})
+	}
+	return itemErr
+}
+
+// decodeBulkData decodes the data of an operation, rejecting unknown fields
+// so a misspelt field isn't silently ignored across a whole batch
+func decodeBulkData(data json.RawMessage, v interface{}) *models.BulkItemError {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid operation data")
+	}
+	return nil
+}
+
+// readBulkRequest decodes a bulk write request, writing the error response
+// when it is invalid as a whole
+func (h *Handler) readBulkRequest(w http.ResponseWriter, r *http.Request) (*models.BulkRequest, bool) {
+	var req models.BulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return nil, false
+	}
+	if len(req.Operations) == 0 {
+		h.ErrorHdlr.HandleBadRequest(w, "No operations given")
+		return nil, false
+	}
+	if len(req.Operations) > maxBulkOperations {
+		h.ErrorHdlr.HandleBadRequest(w, fmt.Sprintf("A batch can have at most %d operations", maxBulkOperations))
+		return nil, false
+	}
+	return &req, true
+}
+
+// prepareBulk checks the permission of every operation and prepares the
+// ones allowed. prepare fills in an item, returning why the operation
+// can't be applied or an error that fails the whole request.
+func prepareBulk[T any](r *http.Request, req *models.BulkRequest, permissions map[string]middleware.Permission,
+	prepare func(op models.BulkOperation, item *bulkItem[T]) (*models.BulkItemError, error)) ([]*bulkItem[T], *models.BulkResult, error) {
+	result := &models.BulkResult{Atomic: req.Atomic, Items: make([]models.BulkItemResult, len(req.Operations))}
+	var items []*bulkItem[T]
+	seen := make(map[string]bool)
+	for i, op := range req.Operations {
+		result.Items[i] = models.BulkItemResult{Index: i, Op: op.Op, ID: op.ID}
+		item := &bulkItem[T]{result: &result.Items[i]}
+
+		permission, ok := permissions[op.Op]
+		var itemErr *models.BulkItemError
+		switch {
+		case !ok:
+			itemErr = bulkError(http.StatusBadRequest, "op must be create, update or delete")
+		case !middleware.HasPermission(currentRole(r), permission):
+			itemErr = bulkError(http.StatusForbidden, "Insufficient permissions")
+		case op.Op != models.BulkCreate && seen[op.ID]:
+			// Later operations would not see the earlier ones' changes
+			itemErr = bulkError(http.StatusBadRequest, "The batch already has an operation on this ID")
+		default:
+			seen[op.ID] = true
+			var err error
+			if itemErr, err = prepare(op, item); err != nil {
+				return nil, nil, err
+			}
+		}
+		if itemErr != nil {
+			failBulkItem(result, item.result, itemErr)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, result, nil
+}
+
+// failBulkItem records the failure of an item
+func failBulkItem(result *models.BulkResult, item *models.BulkItemResult, itemErr *models.BulkItemError) {
+	item.Status = models.BulkFailed
+	item.Error = itemErr
+	item.Data = nil
+	result.Failed++
+}
+
+// runBulk writes the prepared items of a batch in one transaction with a
+// single ordered bulk write, their events added to the outbox with them.
+// Items that can't apply are found by precheckBulk beforehand: an atomic
+// batch then fails as a whole, otherwise they are dropped. An item failing
+// in the transaction anyway, e.g. after a concurrent write, aborts it and
+// is handled the same way, the rest being written again. describe turns
+// the error of a failed item into its result. Once committed the items are
+// done and the detail caches of their documents and the cached lists are
+// invalidated once.
+func runBulk[T any](ctx context.Context, h *Handler, collection *mongo.Collection, result *models.BulkResult,
+	items []*bulkItem[T], describe func(error) *models.BulkItemError, detailPattern, listPattern string) error {
+	skipRest := func(items []*bulkItem[T]) {
+		for _, item := range items {
+			if item.result.Status != models.BulkFailed {
+				item.result.Status = models.BulkSkipped
+				item.result.Data = nil
+			}
+		}
+	}
+	if result.Atomic && result.Failed > 0 {
+		skipRest(items)
+		return nil
+	}
+
+	failures, err := precheckBulk(ctx, collection, items)
+	if err != nil {
+		return err
+	}
+	var pending []*bulkItem[T]
+	for i, item := range items {
+		if failures[i] != nil {
+			failBulkItem(result, item.result, describe(failures[i]))
+			continue
+		}
+		pending = append(pending, item)
+	}
+	if result.Atomic && result.Failed > 0 {
+		skipRest(items)
+		return nil
+	}
+
+	for len(pending) > 0 {
+		err := h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+			return writeBulk(sc, collection, pending)
+		})
+		if err == nil {
+			break
+		}
+
+		var failure *bulkFailure
+		if !errors.As(err, &failure) {
+			return err
+		}
+		failBulkItem(result, pending[failure.index].result, describe(failure.err))
+		if result.Atomic {
+			skipRest(pending)
+			return nil
+		}
+		pending = append(pending[:failure.index:failure.index], pending[failure.index+1:]...)
+	}
+
+	keys := make([]string, 0, len(pending))
+	for _, item := range pending {
+		item.result.Status = item.status
+		item.result.ID = item.id.Hex()
+		result.Succeeded++
+		keys = append(keys, fmt.Sprintf(detailPattern, item.id.Hex()))
+		item.done(ctx)
+	}
+	if len(pending) > 0 {
+		if err := cache.DeleteCache(ctx, keys...); err != nil {
+			log.Printf("Failed to invalidate detail caches: %v", err)
+		}
+		if err := cache.DeleteByPattern(ctx, listPattern); err != nil {
+			log.Printf("Failed to invalidate list caches: %v", err)
+		}
+	}
+	return nil
+}
+
+// findLive reads the live documents updated or deleted by items, by ID
+func findLive[T any](ctx context.Context, collection *mongo.Collection, items []*bulkItem[T]) (map[primitive.ObjectID]*T, error) {
+	var ids []primitive.ObjectID
+	for _, item := range items {
+		if item.status != models.BulkCreated {
+			ids = append(ids, item.id)
+		}
+	}
+	found := make(map[primitive.ObjectID]*T, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	cursor, err := collection.Find(ctx, trash.NotDeleted(bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		found[cursor.Current.Lookup("_id").ObjectID()] = &doc
+	}
+	return found, cursor.Err()
+}
+
+// precheckBulk finds the items that would fail, returning their errors by
+// position: updates and deletes of documents that are gone or that their
+// check rejects, and unique values already taken. A batch is read with a
+// query per unique field, so bad items cost no transaction.
+func precheckBulk[T any](ctx context.Context, collection *mongo.Collection, items []*bulkItem[T]) ([]error, error) {
+	failures := make([]error, len(items))
+	current, err := findLive(ctx, collection, items)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		if item.status == models.BulkCreated {
+			continue
+		}
+		doc := current[item.id]
+		switch {
+		case doc == nil:
+			failures[i] = mongo.ErrNoDocuments
+		case item.check != nil:
+			failures[i] = item.check(doc)
+		}
+	}
+
+	// The first item writing a value claims it within the batch
+	values := make(map[string][]interface{})
+	claimed := make(map[string]map[interface{}]primitive.ObjectID)
+	for i, item := range items {
+		if failures[i] != nil {
+			continue
+		}
+		for field, value := range item.unique {
+			if claimed[field] == nil {
+				claimed[field] = make(map[interface{}]primitive.ObjectID)
+			}
+			if id, ok := claimed[field][value]; ok && id != item.id {
+				failures[i] = errBulkDuplicate
+				break
+			}
+			claimed[field][value] = item.id
+			values[field] = append(values[field], value)
+		}
+	}
+
+	for field, fieldValues := range values {
+		cursor, err := collection.Find(ctx, trash.NotDeleted(bson.M{field: bson.M{"$in": fieldValues}}),
+			options.Find().SetProjection(bson.M{field: 1}))
+		if err != nil {
+			return nil, err
+		}
+		taken := make(map[interface{}]primitive.ObjectID)
+		for cursor.Next(ctx) {
+			if value, ok := cursor.Current.Lookup(field).StringValueOK(); ok {
+				taken[value] = cursor.Current.Lookup("_id").ObjectID()
+			}
+		}
+		cursor.Close(ctx)
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		for i, item := range items {
+			value, ok := item.unique[field]
+			if !ok || failures[i] != nil {
+				continue
+			}
+			if id, ok := taken[value]; ok && id != item.id {
+				failures[i] = errBulkDuplicate
+			}
+		}
+	}
+	return failures, nil
+}
+
+// writeBulk writes items within a transaction. The documents updated and
+// deleted are read first, a write conflict on them retries the transaction.
+func writeBulk[T any](sc mongo.SessionContext, collection *mongo.Collection, items []*bulkItem[T]) ([]events.Event, error) {
+	found, err := findLive(sc, collection, items)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range items {
+		item.previous = nil
+		if item.status == models.BulkCreated {
+			continue
+		}
+		if item.previous = found[item.id]; item.previous == nil {
+			return nil, &bulkFailure{index: i, err: mongo.ErrNoDocuments}
+		}
+	}
+
+	// Items without a write of their own are left out of the bulk write
+	var writes []mongo.WriteModel
+	var positions []int
+	for i, item := range items {
+		if item.model != nil {
+			writes = append(writes, item.model)
+			positions = append(positions, i)
+		}
+	}
+	if len(writes) > 0 {
+		_, err := collection.BulkWrite(sc, writes, options.BulkWrite().SetOrdered(true))
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+			return nil, &bulkFailure{index: positions[bulkErr.WriteErrors[0].Index], err: err}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var changeEvents []events.Event
+	for i, item := range items {
+		itemEvents, err := item.commit(sc)
+		if err != nil {
+			return nil, &bulkFailure{index: i, err: err}
+		}
+		changeEvents = append(changeEvents, itemEvents...)
+	}
+	return changeEvents, nil
+}
+
+// writeBulkResult answers a bulk write. A failed atomic batch changed
+// nothing and is answered with 422.
+func (h *Handler) writeBulkResult(w http.ResponseWriter, result *models.BulkResult) {
+	if result.Atomic && result.Failed > 0 {
+		h.ResponseHdlr.JSON(w, http.StatusUnprocessableEntity, utils.Response{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "No operations were applied",
+			Data:    result,
+		})
+		return
+	}
+	h.ResponseHdlr.Success(w, fmt.Sprintf("%d operations applied, %d failed", result.Succeeded, result.Failed), result)
+}
+
+// applyOne applies a single operation as an atomic batch, for the APIs that
+// write one document per call. The result's Error tells why the operation
+// failed, an error fails the whole request.
+func applyOne[T any](r *http.Request, h *Handler, op models.BulkOperation, permissions map[string]middleware.Permission,
+	prepare func(r *http.Request, op models.BulkOperation, item *bulkItem[T]) (*models.BulkItemError, error),
+	collection string, describe func(error) *models.BulkItemError, detailPattern, listPattern string) (*models.BulkItemResult, error) {
+	req := &models.BulkRequest{Atomic: true, Operations: []models.BulkOperation{op}}
+	items, result, err := prepareBulk(r, req, permissions,
+		func(op models.BulkOperation, item *bulkItem[T]) (*models.BulkItemError, error) {
+			return prepare(r, op, item)
+		})
+	if err != nil {
+		return nil, err
+	}
+	err = runBulk(r.Context(), h, h.DB.Database(h.Database).Collection(collection), result, items,
+		describe, detailPattern, listPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Items[0], nil
+}
+
+// productBulkPermissions are the permissions of product operations
+var productBulkPermissions = map[string]middleware.Permission{
+	models.BulkCreate: middleware.PermissionCreateProduct,
+	models.BulkUpdate: middleware.PermissionUpdateProduct,
+	models.BulkDelete: middleware.PermissionDeleteProduct,
+}
+
+// BulkProducts handles creating, updating and deleting many products at
+// once. Each operation takes the body of the matching single-product
+// endpoint as its data and needs that endpoint's permission. With atomic
+// set, every operation is applied or none is.
+func (h *Handler) BulkProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := h.readBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	items, result, err := prepareBulk(r, req, productBulkPermissions,
+		func(op models.BulkOperation, item *bulkItem[models.Product]) (*models.BulkItemError, error) {
+			switch op.Op {
+			case models.BulkCreate:
+				return h.prepareProductCreate(r, op, item)
+			case models.BulkUpdate:
+				return h.prepareProductUpdate(r, op, item)
+			}
+			return h.prepareProductDelete(r, op, item)
+		})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error preparing operations")
+		return
+	}
+
+	err = runBulk(ctx, h, h.DB.Database(h.Database).Collection("products"), result, items,
+		productBulkError, cache.ProductDetailPattern, cache.ProductListPattern)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error applying operations")
+		return
+	}
+
+	h.writeBulkResult(w, result)
+}
+
+// applyProductOp applies one product operation
+func (h *Handler) applyProductOp(r *http.Request, op models.BulkOperation) (*models.BulkItemResult, error) {
+	prepare := map[string]func(*http.Request, models.BulkOperation, *bulkItem[models.Product]) (*models.BulkItemError, error){
+		models.BulkCreate: h.prepareProductCreate,
+		models.BulkUpdate: h.prepareProductUpdate,
+		models.BulkDelete: h.prepareProductDelete,
+	}[op.Op]
+	return applyOne(r, h, op, productBulkPermissions, prepare,
+		"products", productBulkError, cache.ProductDetailPattern, cache.ProductListPattern)
+}
+
+// productBulkError describes why a product operation failed
+func productBulkError(err error) *models.BulkItemError {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return bulkError(http.StatusNotFound, "Product not found")
+	case mongo.IsDuplicateKeyError(err), errors.Is(err, errBulkDuplicate):
+		return bulkError(http.StatusConflict, "A product with this SKU already exists")
+	case errors.Is(err, inventory.ErrVariantRequired):
+		return bulkError(http.StatusBadRequest, "Stock of a product with variants is set per variant")
+	case errors.Is(err, inventory.ErrNotFound):
+		return bulkError(http.StatusNotFound, "Product not found")
+	}
+	log.Printf("Error applying product operation: %v", err)
+	return bulkError(http.StatusInternalServerError, "Error saving product")
+}
+
+// productCategory resolves the category of a product operation
+func (h *Handler) productCategory(ctx context.Context, name string) (string, *models.BulkItemError, error) {
+	slug := utils.Slugify(name)
+	if _, err := h.findCategory(ctx, slug); err == mongo.ErrNoDocuments {
+		itemErr := bulkError(http.StatusBadRequest, "Validation failed")
+		itemErr.Fields = []models.BulkFieldError{{Field: "category", Message: "Category does not exist"}}
+		return "", itemErr, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	return slug, nil, nil
+}
+
+func (h *Handler) prepareProductCreate(r *http.Request, op models.BulkOperation, item *bulkItem[models.Product]) (*models.BulkItemError, error) {
+	var req models.CreateProductRequest
+	if itemErr := decodeBulkData(op.Data, &req); itemErr != nil {
+		return itemErr, nil
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return bulkValidationError(err), nil
+	}
+	category, itemErr, err := h.productCategory(r.Context(), req.Category)
+	if itemErr != nil || err != nil {
+		return itemErr, err
+	}
+	price, err := basePrice(req.Price)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid price: "+err.Error()), nil
+	}
+	prices, err := priceList(req.Prices)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid prices: "+err.Error()), nil
+	}
+
+	actor := currentUser(r)
+	product := models.Product{
+		ID:          primitive.NewObjectID(),
+		SKU:         normalizeSKU(req.SKU),
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       price,
+		Prices:      prices,
+		Category:    category,
+		Stock:       req.Stock,
+	}
+	item.status = models.BulkCreated
+	item.id = product.ID
+	item.model = mongo.NewInsertOneModel().SetDocument(product)
+	if product.SKU != "" {
+		item.unique = bson.M{"sku": product.SKU}
+	}
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		if product.Stock > 0 {
+			_, err := h.Inventory.Record(sc, product.ID, nil, product.Stock, product.Stock, inventory.Change{
+				Reason: models.MovementInitial,
+				Actor:  actor,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		item.result.Data = product
+		return []events.Event{events.NewProductEvent(events.ProductCreated, product, nil, actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		if err := h.Search.Index(ctx, product); err != nil {
+			log.Printf("Failed to index product %s: %v", product.ID.Hex(), err)
+		}
+		h.recordRevision(r, product, models.RevisionCreate, 0)
+		h.audit(r, models.AuditCreate, models.AuditResourceProduct, product.ID.Hex(), nil, product)
+	}
+	return nil, nil
+}
+
+func (h *Handler) prepareProductUpdate(r *http.Request, op models.BulkOperation, item *bulkItem[models.Product]) (*models.BulkItemError, error) {
+	objID, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid product ID"), nil
+	}
+	var req models.UpdateProductRequest
+	if itemErr := decodeBulkData(op.Data, &req); itemErr != nil {
+		return itemErr, nil
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return bulkValidationError(err), nil
+	}
+
+	update := bson.M{}
+	if req.SKU != "" {
+		update["sku"] = normalizeSKU(req.SKU)
+	}
+	if req.Name != "" {
+		update["name"] = req.Name
+	}
+	if req.Description != "" {
+		update["description"] = req.Description
+	}
+	if req.Price > 0 {
+		price, err := basePrice(req.Price)
+		if err != nil {
+			return bulkError(http.StatusBadRequest, "Invalid price: "+err.Error()), nil
+		}
+		update["price"] = price
+	}
+	if req.Prices != nil {
+		prices, err := priceList(req.Prices)
+		if err != nil {
+			return bulkError(http.StatusBadRequest, "Invalid prices: "+err.Error()), nil
+		}
+		update["prices"] = prices
+	}
+	if req.Category != "" {
+		category, itemErr, err := h.productCategory(r.Context(), req.Category)
+		if itemErr != nil || err != nil {
+			return itemErr, err
+		}
+		update["category"] = category
+	}
+	if len(update) == 0 && req.Stock == nil {
+		return bulkError(http.StatusBadRequest, "No fields to update"), nil
+	}
+
+	actor := currentUser(r)
+	var updated models.Product
+	item.status = models.BulkUpdated
+	item.id = objID
+	if len(update) > 0 {
+		item.model = mongo.NewUpdateOneModel().
+			SetFilter(trash.NotDeleted(bson.M{"_id": objID})).
+			SetUpdate(bson.M{"$set": update})
+	}
+	if sku, ok := update["sku"]; ok {
+		item.unique = bson.M{"sku": sku}
+	}
+	if req.Stock != nil {
+		item.check = func(current *models.Product) error {
+			if len(current.Variants) > 0 {
+				return inventory.ErrVariantRequired
+			}
+			return nil
+		}
+	}
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		if req.Stock != nil {
+			_, err := h.Inventory.Set(sc, objID, nil, *req.Stock, inventory.Change{
+				Reason: models.MovementManualSet,
+				Actor:  actor,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		// The variant price range depends on the base price
+		if _, ok := update["price"]; ok {
+			if err := h.refreshVariantSummary(sc, objID); err != nil {
+				return nil, err
+			}
+		}
+		err := h.DB.Database(h.Database).Collection("products").
+			FindOne(sc, bson.M{"_id": objID}).
+			Decode(&updated)
+		if err != nil {
+			return nil, err
+		}
+		item.result.Data = updated
+		return []events.Event{events.NewProductEvent(events.ProductUpdated, updated,
+			changedFields(*item.previous, updated), actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		previous := *item.previous
+		if err := h.Search.Index(ctx, updated); err != nil {
+			log.Printf("Failed to index product %s: %v", objID.Hex(), err)
+		}
+		if err := h.Revisions.EnsureBaseline(ctx, previous, actor); err != nil {
+			log.Printf("Failed to record baseline revision of product %s: %v", objID.Hex(), err)
+		}
+		h.recordRevision(r, updated, models.RevisionUpdate, 0)
+		h.audit(r, models.AuditUpdate, models.AuditResourceProduct, objID.Hex(), previous, updated)
+	}
+	return nil, nil
+}
+
+func (h *Handler) prepareProductDelete(r *http.Request, op models.BulkOperation, item *bulkItem[models.Product]) (*models.BulkItemError, error) {
+	objID, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid product ID"), nil
+	}
+
+	actor := currentUser(r)
+	item.status = models.BulkDeleted
+	item.id = objID
+	item.model = mongo.NewUpdateOneModel().
+		SetFilter(trash.NotDeleted(bson.M{"_id": objID})).
+		SetUpdate(bson.M{"$set": bson.M{"deleted_at": time.Now(), "deleted_by": actor}})
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		var deleted models.Product
+		err := h.DB.Database(h.Database).Collection("products").
+			FindOne(sc, bson.M{"_id": objID}).
+			Decode(&deleted)
+		if err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewProductEvent(events.ProductDeleted, deleted, nil, actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		if err := h.Search.Remove(ctx, objID.Hex()); err != nil {
+			log.Printf("Failed to remove product %s from search index: %v", objID.Hex(), err)
+		}
+		h.audit(r, models.AuditDelete, models.AuditResourceProduct, objID.Hex(), nil, nil)
+	}
+	return nil, nil
+}
+
+// userBulkPermissions are the permissions of user operations
+var userBulkPermissions = map[string]middleware.Permission{
+	models.BulkCreate: middleware.PermissionCreateUser,
+	models.BulkUpdate: middleware.PermissionUpdateUser,
+	models.BulkDelete: middleware.PermissionDeleteUser,
+}
+
+// BulkUsers handles creating, updating and deleting many users at once.
+// Created users default to the user role, other roles need the permission
+// to assign roles. Otherwise it works like BulkProducts.
+func (h *Handler) BulkUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := h.readBulkRequest(w, r)
+	if !ok {
+		return
+	}
+
+	items, result, err := prepareBulk(r, req, userBulkPermissions,
+		func(op models.BulkOperation, item *bulkItem[models.UserDetails]) (*models.BulkItemError, error) {
+			switch op.Op {
+			case models.BulkCreate:
+				return h.prepareUserCreate(r, op, item)
+			case models.BulkUpdate:
+				return h.prepareUserUpdate(r, op, item)
+			}
+			return h.prepareUserDelete(r, op, item)
+		})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error preparing operations")
+		return
+	}
+
+	err = runBulk(ctx, h, h.DB.Database(h.Database).Collection("users"), result, items,
+		userBulkError, cache.UserDetailPattern, cache.UserListPattern)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error applying operations")
+		return
+	}
+
+	h.writeBulkResult(w, result)
+}
+
+// applyUserOp applies one user operation
+func (h *Handler) applyUserOp(r *http.Request, op models.BulkOperation) (*models.BulkItemResult, error) {
+	prepare := map[string]func(*http.Request, models.BulkOperation, *bulkItem[models.UserDetails]) (*models.BulkItemError, error){
+		models.BulkCreate: h.prepareUserCreate,
+		models.BulkUpdate: h.prepareUserUpdate,
+		models.BulkDelete: h.prepareUserDelete,
+	}[op.Op]
+	return applyOne(r, h, op, userBulkPermissions, prepare,
+		"users", userBulkError, cache.UserDetailPattern, cache.UserListPattern)
+}
+
+// userBulkError describes why a user operation failed
+func userBulkError(err error) *models.BulkItemError {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return bulkError(http.StatusNotFound, "User not found")
+	case mongo.IsDuplicateKeyError(err), errors.Is(err, errBulkDuplicate):
+		return bulkError(http.StatusConflict, "User with this email already exists")
+	}
+	log.Printf("Error applying user operation: %v", err)
+	return bulkError(http.StatusInternalServerError, "Error saving user")
+}
+
+func (h *Handler) prepareUserCreate(r *http.Request, op models.BulkOperation, item *bulkItem[models.UserDetails]) (*models.BulkItemError, error) {
+	var req models.CreateUserRequest
+	if itemErr := decodeBulkData(op.Data, &req); itemErr != nil {
+		return itemErr, nil
+	}
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return bulkValidationError(err), nil
+	}
+	if req.Role != "user" && !middleware.HasPermission(currentRole(r), middleware.PermissionAssignRole) {
+		return bulkError(http.StatusForbidden, "Insufficient permissions to create users with this role"), nil
+	}
+
+	var dateOfBirth *time.Time
+	if req.DateOfBirth != "" {
+		dob, err := parseDateOfBirth(req.DateOfBirth)
+		if err != nil {
+			return bulkError(http.StatusBadRequest, err.Error()), nil
+		}
+		dateOfBirth = &dob
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	actor := currentUser(r)
+	user := models.UserDetails{
+		User: models.User{
+			ID:       primitive.NewObjectID(),
+			Name:     req.Name,
+			Email:    req.Email,
+			Password: string(hashedPassword),
+			Role:     req.Role,
+		},
+		Gender:      req.Gender,
+		DateOfBirth: dateOfBirth,
+		Phone:       req.Phone,
+		Preferences: models.DefaultPreferences(),
+	}
+	item.status = models.BulkCreated
+	item.id = user.ID
+	item.model = mongo.NewInsertOneModel().SetDocument(user)
+	item.unique = bson.M{"email": user.Email}
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		item.result.Data = user
+		return []events.Event{events.NewUserEvent(events.UserRegistered, user.User, actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		h.audit(r, models.AuditCreate, models.AuditResourceUser, user.ID.Hex(), nil, user)
+	}
+	return nil, nil
+}
+
+func (h *Handler) prepareUserUpdate(r *http.Request, op models.BulkOperation, item *bulkItem[models.UserDetails]) (*models.BulkItemError, error) {
+	objID, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid user ID format"), nil
+	}
+	var req models.UpdateUserRequest
+	if itemErr := decodeBulkData(op.Data, &req); itemErr != nil {
+		return itemErr, nil
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return bulkValidationError(err), nil
+	}
+	update, err := userUpdate(req)
+	var dateErr *dateOfBirthError
+	if errors.As(err, &dateErr) {
+		return bulkError(http.StatusBadRequest, err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(update) == 0 {
+		return bulkError(http.StatusBadRequest, "No fields to update"), nil
+	}
+
+	actor := currentUser(r)
+	var updated models.UserDetails
+	item.status = models.BulkUpdated
+	item.id = objID
+	item.model = mongo.NewUpdateOneModel().
+		SetFilter(trash.NotDeleted(bson.M{"_id": objID})).
+		SetUpdate(bson.M{"$set": update})
+	if email, ok := update["email"]; ok {
+		item.unique = bson.M{"email": email}
+	}
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		err := h.DB.Database(h.Database).Collection("users").
+			FindOne(sc, bson.M{"_id": objID}).
+			Decode(&updated)
+		if err != nil {
+			return nil, err
+		}
+		item.result.Data = updated
+		return []events.Event{events.NewUserEvent(events.UserUpdated, updated.User, actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		h.audit(r, models.AuditUpdate, models.AuditResourceUser, objID.Hex(), *item.previous, updated)
+		h.Media.SignAvatar(updated.Avatar)
+	}
+	return nil, nil
+}
+
+func (h *Handler) prepareUserDelete(r *http.Request, op models.BulkOperation, item *bulkItem[models.UserDetails]) (*models.BulkItemError, error) {
+	objID, err := primitive.ObjectIDFromHex(op.ID)
+	if err != nil {
+		return bulkError(http.StatusBadRequest, "Invalid user ID"), nil
+	}
+
+	actor := currentUser(r)
+	item.status = models.BulkDeleted
+	item.id = objID
+	item.model = mongo.NewUpdateOneModel().
+		SetFilter(trash.NotDeleted(bson.M{"_id": objID})).
+		SetUpdate(bson.M{"$set": bson.M{"deleted_at": time.Now(), "deleted_by": actor}})
+	item.commit = func(sc mongo.SessionContext) ([]events.Event, error) {
+		var deleted models.User
+		err := h.DB.Database(h.Database).Collection("users").
+			FindOne(sc, bson.M{"_id": objID}).
+			Decode(&deleted)
+		if err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserDeleted, deleted, actor)}, nil
+	}
+	item.done = func(ctx context.Context) {
+		h.audit(r, models.AuditDelete, models.AuditResourceUser, objID.Hex(), nil, nil)
+	}
+	return nil, nil
+}
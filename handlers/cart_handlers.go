@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// maxCartItems caps the number of distinct items in a cart
+const maxCartItems = 100
+
+// errCartChanged is returned when a cart was modified by another request
+// between being read and written
+var errCartChanged = errors.New("cart was modified concurrently")
+
+// loadCart returns the cart of a user, an empty unsaved cart in the base
+// currency when the user has none
+func (h *Handler) loadCart(ctx context.Context, userID string) (*models.Cart, error) {
+	var cart models.Cart
+	err := h.DB.Database(h.Database).Collection("carts").
+		FindOne(ctx, bson.M{"user_id": userID}).
+		Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		return &models.Cart{UserID: userID, Currency: money.Base(), Items: []models.CartItem{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cart.Items == nil {
+		cart.Items = []models.CartItem{}
+	}
+	return &cart, nil
+}
+
+// saveCart writes a cart loaded with loadCart. The write only succeeds if the
+// cart was not changed since it was loaded, otherwise errCartChanged is
+// returned.
+func (h *Handler) saveCart(ctx context.Context, cart *models.Cart) error {
+	carts := h.DB.Database(h.Database).Collection("carts")
+	previous := cart.UpdatedAt
+	cart.UpdatedAt = time.Now()
+
+	if cart.ID.IsZero() {
+		cart.ID = primitive.NewObjectID()
+		_, err := carts.InsertOne(ctx, cart)
+		if mongo.IsDuplicateKeyError(err) {
+			return errCartChanged
+		}
+		return err
+	}
+
+	result, err := carts.UpdateOne(ctx,
+		bson.M{"_id": cart.ID, "updated_at": previous},
+		bson.M{"$set": bson.M{
+			"items":      cart.Items,
+			"currency":   cart.Currency,
+			"coupons":    cart.Coupons,
+			"updated_at": cart.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errCartChanged
+	}
+	return nil
+}
+
+// writeCartError writes the response for a cart that could not be saved
+func (h *Handler) writeCartError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCartChanged) {
+		h.ErrorHdlr.HandleConflict(w, "Cart was changed by another request, please retry")
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error saving cart")
+}
+
+// cartResponse writes a cart with its subtotal. Items are always priced in
+// the cart currency.
+func (h *Handler) cartResponse(w http.ResponseWriter, message string, cart *models.Cart) {
+	cart.Subtotal = money.Zero(cart.Currency)
+	for _, item := range cart.Items {
+		cart.Subtotal.Amount += item.UnitPrice.Mul(item.Quantity).Amount
+	}
+	h.ResponseHdlr.Success(w, message, cart)
+}
+
+// sellableItem returns the name, SKU and unit price in a currency of a
+// product, or of one of its variants, or a message explaining why it cannot
+// be sold
+func (h *Handler) sellableItem(product *models.Product, variantID *primitive.ObjectID, currency string) (models.CartItem, string) {
+	item := models.CartItem{
+		ProductID: product.ID,
+		VariantID: variantID,
+		Name:      product.Name,
+	}
+	var variant *models.Variant
+	if variantID == nil {
+		if len(product.Variants) > 0 {
+			return item, "A variant must be chosen for this product"
+		}
+	} else {
+		idx := slices.IndexFunc(product.Variants, func(v models.Variant) bool { return v.ID == *variantID })
+		if idx < 0 {
+			return item, "Variant not found"
+		}
+		variant = &product.Variants[idx]
+		item.SKU = variant.SKU
+	}
+
+	price, err := h.priceIn(*product, variant, currency)
+	if err != nil {
+		return item, "Product cannot be priced in " + currency
+	}
+	item.UnitPrice = price
+	return item, ""
+}
+
+// sameCartItem reports whether a cart item is the given product and variant
+func sameCartItem(item models.CartItem, productID primitive.ObjectID, variantID *primitive.ObjectID) bool {
+	if item.ProductID != productID {
+		return false
+	}
+	if item.VariantID == nil || variantID == nil {
+		return item.VariantID == nil && variantID == nil
+	}
+	return *item.VariantID == *variantID
+}
+
+// GetCart handles retrieving the current user's cart
+func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
+	cart, err := h.loadCart(r.Context(), currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	h.cartResponse(w, "Cart fetched successfully", cart)
+}
+
+// AddCartItem handles putting a product in the current user's cart. Adding a
+// product already in the cart increases its quantity.
+func (h *Handler) AddCartItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.AddCartItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	productID, _ := primitive.ObjectIDFromHex(req.ProductID)
+	variantID, err := optionalObjectID(req.VariantID)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	var product models.Product
+	err = h.DB.Database(h.Database).Collection("products").
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": productID})).
+		Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Product not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching product")
+		return
+	}
+
+	cart, err := h.loadCart(ctx, currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	// The first item decides the currency the cart is priced in
+	if req.Currency != "" {
+		currency, err := money.Normalize(req.Currency)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+			return
+		}
+		if currency != cart.Currency && len(cart.Items) > 0 {
+			h.ErrorHdlr.HandleConflict(w, "Cart is priced in "+cart.Currency+", clear it to change currency")
+			return
+		}
+		cart.Currency = currency
+	}
+
+	item, msg := h.sellableItem(&product, variantID, cart.Currency)
+	if msg != "" {
+		h.ErrorHdlr.HandleBadRequest(w, msg)
+		return
+	}
+
+	idx := slices.IndexFunc(cart.Items, func(i models.CartItem) bool {
+		return sameCartItem(i, productID, variantID)
+	})
+	if idx >= 0 {
+		// Refresh the snapshot, the cart shows what checkout will charge
+		item.Quantity = cart.Items[idx].Quantity + req.Quantity
+		cart.Items[idx] = item
+	} else {
+		if len(cart.Items) >= maxCartItems {
+			h.ErrorHdlr.HandleBadRequest(w, "Cart is full")
+			return
+		}
+		item.Quantity = req.Quantity
+		cart.Items = append(cart.Items, item)
+	}
+	if item.Quantity > 1000 {
+		h.ErrorHdlr.HandleBadRequest(w, "Quantity cannot exceed 1000")
+		return
+	}
+
+	if err := h.saveCart(ctx, cart); err != nil {
+		h.writeCartError(w, err)
+		return
+	}
+
+	h.cartResponse(w, "Item added to cart successfully", cart)
+}
+
+// cartItemIndex finds the cart item named by the productId route variable
+// and the optional variant_id query parameter
+func cartItemIndex(r *http.Request, cart *models.Cart) (int, error) {
+	productID, err := primitive.ObjectIDFromHex(mux.Vars(r)["productId"])
+	if err != nil {
+		return -1, err
+	}
+	variantID, err := optionalObjectID(r.URL.Query().Get("variant_id"))
+	if err != nil {
+		return -1, err
+	}
+	return slices.IndexFunc(cart.Items, func(i models.CartItem) bool {
+		return sameCartItem(i, productID, variantID)
+	}), nil
+}
+
+// UpdateCartItem handles changing the quantity of an item in the current
+// user's cart, a quantity of zero removes the item
+func (h *Handler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.UpdateCartItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	cart, err := h.loadCart(ctx, currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	idx, err := cartItemIndex(r, cart)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product or variant ID")
+		return
+	}
+	if idx < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Item not found in cart")
+		return
+	}
+
+	if *req.Quantity == 0 {
+		cart.Items = slices.Delete(cart.Items, idx, idx+1)
+	} else {
+		cart.Items[idx].Quantity = *req.Quantity
+	}
+
+	if err := h.saveCart(ctx, cart); err != nil {
+		h.writeCartError(w, err)
+		return
+	}
+
+	h.cartResponse(w, "Cart updated successfully", cart)
+}
+
+// RemoveCartItem handles removing an item from the current user's cart
+func (h *Handler) RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	cart, err := h.loadCart(ctx, currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	idx, err := cartItemIndex(r, cart)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product or variant ID")
+		return
+	}
+	if idx < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Item not found in cart")
+		return
+	}
+
+	cart.Items = slices.Delete(cart.Items, idx, idx+1)
+	if err := h.saveCart(ctx, cart); err != nil {
+		h.writeCartError(w, err)
+		return
+	}
+
+	h.cartResponse(w, "Item removed from cart successfully", cart)
+}
+
+// ClearCart handles removing every item from the current user's cart
+func (h *Handler) ClearCart(w http.ResponseWriter, r *http.Request) {
+	_, err := h.DB.Database(h.Database).Collection("carts").
+		DeleteOne(r.Context(), bson.M{"user_id": currentUser(r)})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error clearing cart")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Cart cleared successfully", nil)
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/cache"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+)
+
+// categoryNode is a category with its children, used for tree responses
+type categoryNode struct {
+	models.Category
+	Children []*categoryNode `json:"children"`
+}
+
+// findCategory looks up a category by slug
+func (h *Handler) findCategory(ctx context.Context, slug string) (*models.Category, error) {
+	var category models.Category
+	err := h.DB.Database(h.Database).Collection("categories").
+		FindOne(ctx, bson.M{"slug": slug}).
+		Decode(&category)
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// subtreeFilter selects a category and all of its descendants by path
+func subtreeFilter(path string) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"path": path},
+		{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(path+"/")}},
+	}}
+}
+
+// categorySubtree returns the slugs of a category and all of its descendants
+func (h *Handler) categorySubtree(ctx context.Context, slug string) ([]string, error) {
+	category, err := h.findCategory(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetProjection(bson.M{"slug": 1})
+	cursor, err := h.DB.Database(h.Database).Collection("categories").
+		Find(ctx, subtreeFilter(category.Path), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, len(categories))
+	for i, c := range categories {
+		slugs[i] = c.Slug
+	}
+	return slugs, nil
+}
+
+// resolveProductCategory maps the category given in a product request to the
+// slug of an existing category, writing a validation error if there is none
+func (h *Handler) resolveProductCategory(w http.ResponseWriter, ctx context.Context, name string) (string, bool) {
+	slug := utils.Slugify(name)
+	if _, err := h.findCategory(ctx, slug); err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+				{Field: "category", Message: "Category does not exist"},
+			})
+		} else {
+			h.ErrorHdlr.HandleInternalError(w, "Error checking category")
+		}
+		return "", false
+	}
+	return slug, true
+}
+
+// invalidateCategoryCaches clears category caches and product lists, which
+// depend on the category tree when filtering by a subtree
+func invalidateCategoryCaches(ctx context.Context) {
+	if err := cache.DeleteByPattern(ctx, cache.CategoryListPattern); err != nil {
+		log.Printf("Failed to invalidate category list cache: %v", err)
+	}
+	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
+		log.Printf("Failed to invalidate product list cache: %v", err)
+	}
+}
+
+// ListCategories handles listing all categories, as a flat list ordered by
+// path or as a tree when tree=true
+func (h *Handler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	asTree := r.URL.Query().Get("tree") == "true"
+
+	var categories []models.Category
+	cacheKey := "categories:all"
+	err := cache.GetCache(ctx, cacheKey, &categories)
+	if err == nil {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+
+		opts := options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+		cursor, err := h.DB.Database(h.Database).Collection("categories").Find(ctx, bson.M{}, opts)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error fetching categories")
+			return
+		}
+		defer cursor.Close(ctx)
+
+		categories = []models.Category{}
+		if err := cursor.All(ctx, &categories); err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error processing categories data")
+			return
+		}
+
+		if err := cache.SetCache(ctx, cacheKey, categories, 30*time.Minute); err != nil {
+			log.Printf("Failed to cache categories: %v", err)
+		}
+	}
+
+	if !asTree {
+		h.ResponseHdlr.Success(w, "Categories fetched successfully", categories)
+		return
+	}
+
+	// Categories are sorted by path, so parents come before their children
+	roots := []*categoryNode{}
+	nodes := make(map[primitive.ObjectID]*categoryNode)
+	for _, c := range categories {
+		node := &categoryNode{Category: c, Children: []*categoryNode{}}
+		nodes[c.ID] = node
+		if c.ParentID != nil {
+			if parent, ok := nodes[*c.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	h.ResponseHdlr.Success(w, "Categories fetched successfully", roots)
+}
+
+// GetCategory handles retrieving a single category by slug
+func (h *Handler) GetCategory(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	category, err := h.findCategory(r.Context(), slug)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Category not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching category")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Category fetched successfully", category)
+}
+
+// CreateCategory handles creating a category, optionally under a parent
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.CreateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	slug := req.Slug
+	if slug == "" {
+		slug = req.Name
+	}
+	slug = utils.Slugify(slug)
+	if slug == "" {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+			{Field: "slug", Message: "Slug must contain letters or digits"},
+		})
+		return
+	}
+
+	newCategory := models.Category{
+		ID:   primitive.NewObjectID(),
+		Name: req.Name,
+		Slug: slug,
+		Path: slug,
+	}
+
+	// Place the category under its parent
+	if req.ParentID != "" {
+		parentID, _ := primitive.ObjectIDFromHex(req.ParentID)
+		var parent models.Category
+		err := h.DB.Database(h.Database).Collection("categories").
+			FindOne(ctx, bson.M{"_id": parentID}).
+			Decode(&parent)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				h.ErrorHdlr.HandleNotFound(w, "Parent category not found")
+				return
+			}
+			h.ErrorHdlr.HandleInternalError(w, "Error fetching parent category")
+			return
+		}
+		newCategory.ParentID = &parent.ID
+		newCategory.Path = parent.Path + "/" + slug
+		newCategory.Depth = parent.Depth + 1
+	}
+
+	_, err := h.DB.Database(h.Database).Collection("categories").InsertOne(ctx, newCategory)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			h.ErrorHdlr.HandleConflict(w, "Category with this slug already exists")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error creating category")
+		return
+	}
+
+	invalidateCategoryCaches(ctx)
+
+	h.audit(r, models.AuditCreate, models.AuditResourceCategory, newCategory.ID.Hex(), nil, newCategory)
+
+	h.ResponseHdlr.Created(w, "Category created successfully", newCategory)
+}
+
+// UpdateCategory handles renaming a category and moving it to another parent.
+// Moving a category also moves its whole subtree.
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slug := mux.Vars(r)["slug"]
+
+	var req models.UpdateCategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	if req.Name == "" && req.ParentID == nil {
+		h.ErrorHdlr.HandleBadRequest(w, "No fields to update")
+		return
+	}
+
+	categories := h.DB.Database(h.Database).Collection("categories")
+	category, err := h.findCategory(ctx, slug)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Category not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching category")
+		return
+	}
+	previous := *category
+
+	update := bson.M{}
+	if req.Name != "" {
+		update["name"] = req.Name
+	}
+
+	// Work out the new position in the tree
+	oldPath, oldDepth := category.Path, category.Depth
+	if req.ParentID != nil {
+		category.ParentID = nil
+		category.Path = category.Slug
+		category.Depth = 0
+
+		if *req.ParentID != "" {
+			parentID, _ := primitive.ObjectIDFromHex(*req.ParentID)
+			var parent models.Category
+			err := categories.FindOne(ctx, bson.M{"_id": parentID}).Decode(&parent)
+			if err != nil {
+				if err == mongo.ErrNoDocuments {
+					h.ErrorHdlr.HandleNotFound(w, "Parent category not found")
+					return
+				}
+				h.ErrorHdlr.HandleInternalError(w, "Error fetching parent category")
+				return
+			}
+			if parent.Path == oldPath || strings.HasPrefix(parent.Path, oldPath+"/") {
+				h.ErrorHdlr.HandleBadRequest(w, "A category cannot be moved under itself or its descendants")
+				return
+			}
+			category.ParentID = &parent.ID
+			category.Path = parent.Path + "/" + category.Slug
+			category.Depth = parent.Depth + 1
+		}
+
+		update["parent_id"] = category.ParentID
+		update["path"] = category.Path
+		update["depth"] = category.Depth
+	}
+
+	_, err = categories.UpdateOne(ctx, bson.M{"_id": category.ID}, bson.M{"$set": update})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating category")
+		return
+	}
+
+	// Rewrite the path prefix and depth of every descendant
+	if category.Path != oldPath {
+		_, err = categories.UpdateMany(ctx,
+			bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(oldPath+"/")}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"path": bson.M{"$concat": bson.A{
+					category.Path,
+					bson.M{"$substrCP": bson.A{"$path", len(oldPath), bson.M{"$strLenCP": "$path"}}},
+				}},
+				"depth": bson.M{"$add": bson.A{"$depth", category.Depth - oldDepth}},
+			}}}},
+		)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error moving category subtree")
+			return
+		}
+	}
+
+	invalidateCategoryCaches(ctx)
+
+	if req.Name != "" {
+		category.Name = req.Name
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceCategory, category.ID.Hex(), previous, category)
+
+	h.ResponseHdlr.Success(w, "Category updated successfully", category)
+}
+
+// DeleteCategory handles deleting a category that has no subcategories and
+// no products
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	slug := mux.Vars(r)["slug"]
+
+	categories := h.DB.Database(h.Database).Collection("categories")
+	category, err := h.findCategory(ctx, slug)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Category not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching category")
+		return
+	}
+
+	children, err := categories.CountDocuments(ctx, bson.M{"parent_id": category.ID})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking subcategories")
+		return
+	}
+	if children > 0 {
+		h.ErrorHdlr.HandleConflict(w, "Category has subcategories")
+		return
+	}
+
+	products, err := h.DB.Database(h.Database).Collection("products").
+		CountDocuments(ctx, bson.M{"category": category.Slug})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking category products")
+		return
+	}
+	if products > 0 {
+		h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("Category is used by %d products", products))
+		return
+	}
+
+	if _, err := categories.DeleteOne(ctx, bson.M{"_id": category.ID}); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting category")
+		return
+	}
+
+	invalidateCategoryCaches(ctx)
+
+	h.audit(r, models.AuditDelete, models.AuditResourceCategory, category.ID.Hex(), category, nil)
+
+	h.ResponseHdlr.Success(w, "Category successfully deleted", nil)
+}
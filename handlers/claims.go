@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// currentUser returns the ID of the authenticated user, recorded as the actor
+// of changes
+func currentUser(r *http.Request) string {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	userID, _ := claims["user_id"].(string)
+	return userID
+}
+
+// currentRole returns the role of the authenticated user
+func currentRole(r *http.Request) string {
+	claims, _ := r.Context().Value("claims").(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	return role
+}
@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/discounts"
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/utils"
+)
+
+// maxCartCoupons caps the number of coupons applied to a cart
+const maxCartCoupons = 5
+
+// priceRuleFromRequest builds a price rule from a create or replace request,
+// or returns the problems with it
+func (h *Handler) priceRuleFromRequest(ctx context.Context, req models.PriceRuleRequest) (models.PriceRule, []utils.ErrorDetail) {
+	rule := models.PriceRule{
+		Name:         req.Name,
+		Type:         req.Type,
+		Scope:        req.Scope,
+		Code:         discounts.NormalizeCode(req.Code),
+		StartsAt:     req.StartsAt,
+		EndsAt:       req.EndsAt,
+		Priority:     req.Priority,
+		Stackable:    req.Stackable,
+		Active:       req.Active == nil || *req.Active,
+		UsageLimit:   req.UsageLimit,
+		PerUserLimit: req.PerUserLimit,
+	}
+
+	var problems []utils.ErrorDetail
+	switch req.Type {
+	case models.DiscountPercentage:
+		rule.Percent = req.Percent
+	case models.DiscountFixed:
+		amount, err := basePrice(req.Amount)
+		if err != nil {
+			problems = append(problems, utils.ErrorDetail{Field: "amount", Message: "Invalid amount: " + err.Error()})
+			break
+		}
+		rule.Amount = &amount
+	}
+
+	switch req.Scope {
+	case models.ScopeProduct:
+		for _, id := range req.ProductIDs {
+			objID, _ := primitive.ObjectIDFromHex(id)
+			if !slices.Contains(rule.ProductIDs, objID) {
+				rule.ProductIDs = append(rule.ProductIDs, objID)
+			}
+		}
+	case models.ScopeCategory:
+		for _, category := range req.Categories {
+			slug := utils.Slugify(category)
+			if _, err := h.findCategory(ctx, slug); err != nil {
+				problems = append(problems, utils.ErrorDetail{Field: "categories", Message: "Category " + category + " does not exist"})
+				continue
+			}
+			if !slices.Contains(rule.Categories, slug) {
+				rule.Categories = append(rule.Categories, slug)
+			}
+		}
+	}
+
+	if rule.StartsAt != nil && rule.EndsAt != nil && !rule.EndsAt.After(*rule.StartsAt) {
+		problems = append(problems, utils.ErrorDetail{Field: "ends_at", Message: "Must be after starts_at"})
+	}
+	if rule.Code == "" && (rule.UsageLimit > 0 || rule.PerUserLimit > 0) {
+		problems = append(problems, utils.ErrorDetail{Field: "code", Message: "Usage limits only apply to coupons"})
+	}
+	return rule, problems
+}
+
+// decodePriceRule reads and checks a price rule request body, writing the
+// error response when it is invalid
+func (h *Handler) decodePriceRule(w http.ResponseWriter, r *http.Request) (models.PriceRule, bool) {
+	var req models.PriceRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return models.PriceRule{}, false
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return models.PriceRule{}, false
+	}
+
+	rule, problems := h.priceRuleFromRequest(r.Context(), req)
+	if len(problems) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, problems)
+		return models.PriceRule{}, false
+	}
+	return rule, true
+}
+
+// loadPriceRule fetches the price rule named by the id route variable,
+// writing the error response when it cannot
+func (h *Handler) loadPriceRule(w http.ResponseWriter, r *http.Request) (*models.PriceRule, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid price rule ID")
+		return nil, false
+	}
+
+	var rule models.PriceRule
+	err = h.DB.Database(h.Database).Collection("price_rules").
+		FindOne(r.Context(), bson.M{"_id": objID}).
+		Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Price rule not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching price rule")
+		return nil, false
+	}
+	return &rule, true
+}
+
+// ListPriceRules handles listing price rules by descending priority. They
+// can be filtered with active=true|false and code.
+func (h *Handler) ListPriceRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-priority")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Price rules support page and limit pagination only")
+		return
+	}
+
+	filter := bson.M{}
+	if raw := r.URL.Query().Get("active"); raw != "" {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "active must be true or false")
+			return
+		}
+		filter["active"] = active
+	}
+	if code := r.URL.Query().Get("code"); code != "" {
+		filter["code"] = discounts.NormalizeCode(code)
+	}
+
+	rulesCollection := h.DB.Database(h.Database).Collection("price_rules")
+	total, err := rulesCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting price rules")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := rulesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching price rules")
+		return
+	}
+	rules := []models.PriceRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing price rules data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Price rules fetched successfully", rules, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// GetPriceRule handles retrieving a single price rule
+func (h *Handler) GetPriceRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.loadPriceRule(w, r)
+	if !ok {
+		return
+	}
+	h.ResponseHdlr.Success(w, "Price rule fetched successfully", rule)
+}
+
+// CreatePriceRule handles creating a discount or coupon
+func (h *Handler) CreatePriceRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.decodePriceRule(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	rule.ID = primitive.NewObjectID()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+
+	_, err := h.DB.Database(h.Database).Collection("price_rules").InsertOne(r.Context(), rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			h.ErrorHdlr.HandleConflict(w, "Coupon code already exists")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error creating price rule")
+		return
+	}
+
+	h.audit(r, models.AuditCreate, models.AuditResourcePriceRule, rule.ID.Hex(), nil, rule)
+
+	h.ResponseHdlr.Created(w, "Price rule created successfully", rule)
+}
+
+// UpdatePriceRule handles replacing a price rule. Its usage count is kept.
+func (h *Handler) UpdatePriceRule(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadPriceRule(w, r)
+	if !ok {
+		return
+	}
+	rule, ok := h.decodePriceRule(w, r)
+	if !ok {
+		return
+	}
+
+	rule.ID = existing.ID
+	rule.UsedCount = existing.UsedCount
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+
+	result, err := h.DB.Database(h.Database).Collection("price_rules").
+		ReplaceOne(r.Context(), bson.M{"_id": rule.ID}, rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			h.ErrorHdlr.HandleConflict(w, "Coupon code already exists")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error updating price rule")
+		return
+	}
+	if result.MatchedCount == 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Price rule not found")
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourcePriceRule, rule.ID.Hex(), existing, rule)
+
+	h.ResponseHdlr.Success(w, "Price rule updated successfully", rule)
+}
+
+// DeletePriceRule handles deleting a price rule. Orders keep the discounts
+// it already gave.
+func (h *Handler) DeletePriceRule(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid price rule ID")
+		return
+	}
+
+	var rule models.PriceRule
+	err = h.DB.Database(h.Database).Collection("price_rules").
+		FindOneAndDelete(r.Context(), bson.M{"_id": objID}).
+		Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		h.ErrorHdlr.HandleNotFound(w, "Price rule not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting price rule")
+		return
+	}
+
+	h.audit(r, models.AuditDelete, models.AuditResourcePriceRule, rule.ID.Hex(), rule, nil)
+
+	h.ResponseHdlr.Success(w, "Price rule deleted successfully", nil)
+}
+
+// categoryLineage returns a category slug and the slugs of its ancestors,
+// remembering lookups in seen
+func (h *Handler) categoryLineage(ctx context.Context, slug string, seen map[string][]string) []string {
+	if lineage, ok := seen[slug]; ok {
+		return lineage
+	}
+	lineage := []string{slug}
+	if category, err := h.findCategory(ctx, slug); err == nil {
+		lineage = strings.Split(category.Path, "/")
+	}
+	seen[slug] = lineage
+	return lineage
+}
+
+// discountItems turns priced cart items into items to quote
+func (h *Handler) discountItems(ctx context.Context, items []models.CartItem, products map[primitive.ObjectID]*models.Product) []discounts.Item {
+	seen := make(map[string][]string)
+	result := make([]discounts.Item, 0, len(items))
+	for _, item := range items {
+		var categories []string
+		if product, ok := products[item.ProductID]; ok {
+			categories = h.categoryLineage(ctx, product.Category, seen)
+		}
+		result = append(result, discounts.Item{
+			ProductID:  item.ProductID,
+			VariantID:  item.VariantID,
+			Name:       item.Name,
+			SKU:        item.SKU,
+			Quantity:   item.Quantity,
+			UnitPrice:  item.UnitPrice,
+			Categories: categories,
+		})
+	}
+	return result
+}
+
+// usableCoupons checks coupon codes for a user and returns their rules keyed
+// by code, or the problems with the ones that cannot be used
+func (h *Handler) usableCoupons(ctx context.Context, codes []string, userID string) (map[string]*models.PriceRule, []utils.ErrorDetail, error) {
+	rules := make(map[string]*models.PriceRule, len(codes))
+	var problems []utils.ErrorDetail
+	for _, code := range codes {
+		rule, err := h.Discounts.Coupon(ctx, code, userID, time.Now())
+		if err != nil {
+			if isCouponError(err) {
+				problems = append(problems, utils.ErrorDetail{Field: code, Message: err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+		rules[rule.Code] = rule
+	}
+	return rules, problems, nil
+}
+
+// isCouponError reports whether err explains why a coupon cannot be used
+func isCouponError(err error) bool {
+	return errors.Is(err, discounts.ErrCouponNotFound) || errors.Is(err, discounts.ErrCouponNotStarted) ||
+		errors.Is(err, discounts.ErrCouponExpired) || errors.Is(err, discounts.ErrCouponExhausted) ||
+		errors.Is(err, discounts.ErrCouponLimitReached)
+}
+
+// quoteItems prices items in a currency with the price rules active now and
+// the given coupons
+func (h *Handler) quoteItems(ctx context.Context, items []discounts.Item, currency string, coupons []string) (models.Quote, error) {
+	rules, err := h.Discounts.Rules(ctx, time.Now(), coupons)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	return discounts.Quote(rules, items, currency, h.Rates), nil
+}
+
+// QuoteProduct handles quoting the effective price of a product, or of one
+// of its variants with variant_id, for a quantity (default 1). Coupon codes
+// can be given as a comma separated coupons parameter, and currency picks
+// the currency to quote in.
+func (h *Handler) QuoteProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := r.URL.Query()
+
+	currency, err := h.requestCurrency(r)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+		return
+	}
+	if currency == "" {
+		currency = money.Base()
+	}
+
+	quantity := 1
+	if raw := params.Get("quantity"); raw != "" {
+		quantity, err = strconv.Atoi(raw)
+		if err != nil || quantity < 1 || quantity > 1000 {
+			h.ErrorHdlr.HandleBadRequest(w, "quantity must be between 1 and 1000")
+			return
+		}
+	}
+	variantID, err := optionalObjectID(params.Get("variant_id"))
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	var codes []string
+	if raw := params.Get("coupons"); raw != "" {
+		codes = strings.Split(raw, ",")
+	}
+	coupons, problems, err := h.usableCoupons(ctx, codes, currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking coupons")
+		return
+	}
+	if len(problems) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, problems)
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	item, msg := h.sellableItem(product, variantID, currency)
+	if msg != "" {
+		h.ErrorHdlr.HandleBadRequest(w, msg)
+		return
+	}
+	item.Quantity = quantity
+
+	items := h.discountItems(ctx, []models.CartItem{item}, map[primitive.ObjectID]*models.Product{product.ID: product})
+	quote, err := h.quoteItems(ctx, items, currency, couponCodes(coupons))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error quoting product")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Product quoted successfully", quote)
+}
+
+// couponCodes returns the codes of usable coupons
+func couponCodes(coupons map[string]*models.PriceRule) []string {
+	codes := make([]string, 0, len(coupons))
+	for code := range coupons {
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// QuoteCart handles quoting the current user's cart at current prices with
+// its coupons, the amount checkout would charge. Coupons that can no longer
+// be used are left out.
+func (h *Handler) QuoteCart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := currentUser(r)
+
+	cart, err := h.loadCart(ctx, userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	products, err := h.cartProducts(ctx, cart)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching products")
+		return
+	}
+	items := make([]models.CartItem, 0, len(cart.Items))
+	var unavailable []utils.ErrorDetail
+	for _, cartItem := range cart.Items {
+		product, ok := products[cartItem.ProductID]
+		if !ok {
+			unavailable = append(unavailable, utils.ErrorDetail{Field: cartItem.Name, Message: "Product is no longer available"})
+			continue
+		}
+		item, msg := h.sellableItem(product, cartItem.VariantID, cart.Currency)
+		if msg != "" {
+			unavailable = append(unavailable, utils.ErrorDetail{Field: cartItem.Name, Message: msg})
+			continue
+		}
+		item.Quantity = cartItem.Quantity
+		items = append(items, item)
+	}
+	if len(unavailable) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, unavailable)
+		return
+	}
+
+	coupons, _, err := h.usableCoupons(ctx, cart.Coupons, userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking coupons")
+		return
+	}
+	quote, err := h.quoteItems(ctx, h.discountItems(ctx, items, products), cart.Currency, couponCodes(coupons))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error quoting cart")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Cart quoted successfully", quote)
+}
+
+// ApplyCoupon handles adding a coupon code to the current user's cart. The
+// coupon must currently be usable by the user.
+func (h *Handler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := currentUser(r)
+
+	var req models.ApplyCouponRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	rule, err := h.Discounts.Coupon(ctx, req.Code, userID, time.Now())
+	if err != nil {
+		if isCouponError(err) {
+			h.ErrorHdlr.HandleBadRequest(w, "Coupon cannot be used: "+err.Error())
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error checking coupon")
+		return
+	}
+
+	cart, err := h.loadCart(ctx, userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+	if !slices.Contains(cart.Coupons, rule.Code) {
+		if len(cart.Coupons) >= maxCartCoupons {
+			h.ErrorHdlr.HandleBadRequest(w, "Too many coupons applied")
+			return
+		}
+		cart.Coupons = append(cart.Coupons, rule.Code)
+	}
+
+	if err := h.saveCart(ctx, cart); err != nil {
+		h.writeCartError(w, err)
+		return
+	}
+
+	h.cartResponse(w, "Coupon applied successfully", cart)
+}
+
+// RemoveCoupon handles removing a coupon code from the current user's cart
+func (h *Handler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	cart, err := h.loadCart(ctx, currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+
+	code := discounts.NormalizeCode(mux.Vars(r)["code"])
+	idx := slices.Index(cart.Coupons, code)
+	if idx < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Coupon not applied to cart")
+		return
+	}
+	cart.Coupons = slices.Delete(cart.Coupons, idx, idx+1)
+
+	if err := h.saveCart(ctx, cart); err != nil {
+		h.writeCartError(w, err)
+		return
+	}
+
+	h.cartResponse(w, "Coupon removed successfully", cart)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/audit"
+	"go-tutorial/events"
+)
+
+// withEvents runs change in a transaction and adds the events it returns to
+// the outbox in the same transaction, so they are published if and only if
+// the change is committed. change may run more than once when the
+// transaction is retried.
+func (h *Handler) withEvents(ctx context.Context, change func(sc mongo.SessionContext) ([]events.Event, error)) error {
+	session, err := h.DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		changeEvents, err := change(sc)
+		if err != nil {
+			return nil, err
+		}
+		return nil, h.Events.Add(sc, changeEvents...)
+	})
+	return err
+}
+
+// changedFields lists the top-level fields that differ between before and
+// after, sorted
+func changedFields(before, after interface{}) []string {
+	var fields []string
+	for field := range audit.Diff(before, after) {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+)
+
+// graphqlRequest is the state of a GraphQL request its resolvers share
+type graphqlRequest struct {
+	h       *Handler
+	r       *http.Request
+	user    string
+	role    string
+	loaders *graphqlLoaders
+
+	mu   sync.Mutex
+	cost int
+}
+
+type graphqlRequestKey struct{}
+
+// graphqlState returns the state of the GraphQL request ctx belongs to
+func graphqlState(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// allow checks the caller's role has a permission, the same check
+// middleware.RequirePermission makes for the REST routes
+func (s *graphqlRequest) allow(permission middleware.Permission) error {
+	if !middleware.HasPermission(s.role, permission) {
+		return graphqlErrorf(http.StatusForbidden, "Insufficient permissions")
+	}
+	return nil
+}
+
+// graphqlError is a GraphQL error with the status code the REST API would
+// answer with, given to clients as extensions.code
+type graphqlError struct {
+	code    int
+	message string
+	fields  []models.BulkFieldError
+}
+
+func graphqlErrorf(code int, message string) *graphqlError {
+	return &graphqlError{code: code, message: message}
+}
+
+func (e *graphqlError) Error() string { return e.message }
+
+// graphqlErrorCodes name status codes as GraphQL servers usually do
+var graphqlErrorCodes = map[int]string{
+	http.StatusBadRequest:          "BAD_USER_INPUT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "FORBIDDEN",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusConflict:            "CONFLICT",
+	http.StatusUnprocessableEntity: "BAD_USER_INPUT",
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	code, ok := graphqlErrorCodes[e.code]
+	if !ok {
+		code = "INTERNAL_SERVER_ERROR"
+	}
+	extensions := map[string]interface{}{"code": code}
+	if len(e.fields) > 0 {
+		extensions["fields"] = e.fields
+	}
+	return extensions
+}
+
+// graphqlInternalError hides the cause of an unexpected error from clients
+func graphqlInternalError(message string) error {
+	return graphqlErrorf(http.StatusInternalServerError, message)
+}
+
+// graphqlCost estimates the documents a root field loads: each selected
+// field counts once for every parent, and the fields under a page count once
+// for every item it can hold. limit is the number of items the root field
+// returns. The cost is added to the request's, which must stay under
+// graphqlMaxCost, before the root field loads anything.
+func graphqlCost(ctx context.Context, limit int) error {
+	cost := limit
+	counts := map[string]int{"": limit}
+	// Parents come before their fields
+	for _, path := range graphql.SelectedFieldNames(ctx) {
+		parent, name := "", path
+		if i := strings.LastIndex(path, "."); i >= 0 {
+			parent, name = path[:i], path[i+1:]
+		}
+		count := counts[parent]
+		cost += count
+		switch name {
+		case "products", "users", "reviews":
+			var args struct{ Limit int32 }
+			if _, err := graphql.DecodeSelectedFieldArgs(ctx, path, &args); err != nil {
+				return graphqlErrorf(http.StatusBadRequest, err.Error())
+			}
+			count *= pageLimit(args.Limit)
+		}
+		counts[path] = count
+	}
+
+	state := graphqlState(ctx)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.cost += cost
+	if state.cost > graphqlMaxCost {
+		return graphqlErrorf(http.StatusBadRequest, "Query is too complex, select fewer fields or smaller pages")
+	}
+	return nil
+}
+
+// GraphQL handles GraphQL queries and mutations over products, categories,
+// users and roles, sent as JSON in a POST body. Each resolver checks the
+// permission its REST route requires.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+	if params.Query == "" {
+		h.ErrorHdlr.HandleBadRequest(w, "Query is required")
+		return
+	}
+
+	ctx := r.Context()
+	state := &graphqlRequest{h: h, user: currentUser(r), role: currentRole(r)}
+	ctx = context.WithValue(ctx, graphqlRequestKey{}, state)
+	state.r = r.WithContext(ctx)
+	state.loaders = h.newGraphQLLoaders(ctx)
+
+	response := graphqlSchema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+
+	// GraphQL answers 200 with the errors in the body once the query ran
+	w.Header().Set("Content-Type", "application/json")
+	if response.Data == nil && len(response.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// graphiQLPage loads GraphiQL from a CDN. The token is entered in its
+// headers tab as {"Authorization": "Bearer ..."}.
+const graphiQLPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>GraphiQL</title>
+	<style>body { margin: 0; height: 100vh; } #graphiql { height: 100vh; }</style>
+	<link rel="stylesheet" href="https://unpkg.com/graphiql@3/graphiql.min.css" />
+</head>
+<body>
+	<div id="graphiql">Loading...</div>
+	<script crossorigin src="https://unpkg.com/react@18/umd/react.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/react-dom@18/umd/react-dom.production.min.js"></script>
+	<script crossorigin src="https://unpkg.com/graphiql@3/graphiql.min.js"></script>
+	<script>
+		const fetcher = GraphiQL.createFetcher({ url: "/graphql" });
+		ReactDOM.createRoot(document.getElementById("graphiql")).render(
+			React.createElement(GraphiQL, { fetcher: fetcher, headerEditorEnabled: true, shouldPersistHeaders: true })
+		);
+	</script>
+</body>
+</html>
+`
+
+// GraphiQL serves the GraphiQL IDE for exploring the GraphQL API, meant for
+// development only
+func GraphiQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(graphiQLPage))
+}
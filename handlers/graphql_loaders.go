@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/models"
+	"go-tutorial/trash"
+)
+
+// loaderWait is how long a loader collects keys before fetching them. The
+// resolvers of a list run in parallel, so the keys of a whole page arrive
+// within it.
+const loaderWait = 2 * time.Millisecond
+
+// loader batches the loads of many resolvers into one fetch and caches the
+// results for the rest of the request, so resolving a relation for every
+// item of a page costs one query rather than one per item
+type loader[K comparable, V any] struct {
+	ctx   context.Context
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	results map[K]*loaded[V]
+	pending map[K]*loaded[V]
+}
+
+// loaded is the result of loading one key, ready once done is closed
+type loaded[V any] struct {
+	done  chan struct{}
+	value V
+	found bool
+	err   error
+}
+
+func newLoader[K comparable, V any](ctx context.Context, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{ctx: ctx, fetch: fetch, results: make(map[K]*loaded[V])}
+}
+
+// Load returns the value of key and whether it was found
+func (l *loader[K, V]) Load(ctx context.Context, key K) (V, bool, error) {
+	l.mu.Lock()
+	result, ok := l.results[key]
+	if !ok {
+		result = &loaded[V]{done: make(chan struct{})}
+		l.results[key] = result
+		if l.pending == nil {
+			l.pending = make(map[K]*loaded[V])
+			time.AfterFunc(loaderWait, l.dispatch)
+		}
+		l.pending[key] = result
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-result.done:
+		return result.value, result.found, result.err
+	case <-ctx.Done():
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// dispatch fetches the keys collected since the last fetch
+func (l *loader[K, V]) dispatch() {
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	keys := make([]K, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	values, err := l.fetch(l.ctx, keys)
+	for key, result := range pending {
+		result.value, result.found = values[key]
+		result.err = err
+		close(result.done)
+	}
+}
+
+// pageKey selects a page of the documents related to a parent, e.g. the
+// reviews of a product
+type pageKey struct {
+	parent string
+	page   int
+	limit  int
+}
+
+// pageOf is a page of documents with the total number there are
+type pageOf[T any] struct {
+	items []T
+	total int64
+}
+
+// graphqlLoaders are the loaders of one GraphQL request
+type graphqlLoaders struct {
+	users            *loader[string, models.UserDetails]
+	categories       *loader[string, models.Category]
+	productReviews   *loader[pageKey, pageOf[models.Review]]
+	categoryProducts *loader[pageKey, pageOf[models.Product]]
+	roleUsers        *loader[pageKey, pageOf[models.UserDetails]]
+}
+
+func (h *Handler) newGraphQLLoaders(ctx context.Context) *graphqlLoaders {
+	db := h.DB.Database(h.Database)
+	return &graphqlLoaders{
+		users: newLoader(ctx, func(ctx context.Context, ids []string) (map[string]models.UserDetails, error) {
+			objIDs := make([]primitive.ObjectID, 0, len(ids))
+			for _, id := range ids {
+				if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+					objIDs = append(objIDs, objID)
+				}
+			}
+			return findByID[models.UserDetails](ctx, db.Collection("users"), trash.NotDeleted(bson.M{}), objIDs)
+		}),
+		categories: newLoader(ctx, func(ctx context.Context, slugs []string) (map[string]models.Category, error) {
+			cursor, err := db.Collection("categories").Find(ctx, bson.M{"slug": bson.M{"$in": slugs}})
+			if err != nil {
+				return nil, err
+			}
+			var categories []models.Category
+			if err := cursor.All(ctx, &categories); err != nil {
+				return nil, err
+			}
+			bySlug := make(map[string]models.Category, len(categories))
+			for _, category := range categories {
+				bySlug[category.Slug] = category
+			}
+			return bySlug, nil
+		}),
+		productReviews: newLoader(ctx, func(ctx context.Context, keys []pageKey) (map[pageKey]pageOf[models.Review], error) {
+			return findPages[models.Review](ctx, db.Collection("reviews"), "product_id",
+				bson.M{"status": models.ReviewApproved}, bson.D{{Key: "created_at", Value: -1}}, keys,
+				func(parent string) (interface{}, bool) {
+					objID, err := primitive.ObjectIDFromHex(parent)
+					return objID, err == nil
+				})
+		}),
+		categoryProducts: newLoader(ctx, func(ctx context.Context, keys []pageKey) (map[pageKey]pageOf[models.Product], error) {
+			return findPages[models.Product](ctx, db.Collection("products"), "category",
+				trash.NotDeleted(bson.M{}), bson.D{{Key: "_id", Value: -1}}, keys, parentString)
+		}),
+		roleUsers: newLoader(ctx, func(ctx context.Context, keys []pageKey) (map[pageKey]pageOf[models.UserDetails], error) {
+			return findPages[models.UserDetails](ctx, db.Collection("users"), "role",
+				trash.NotDeleted(bson.M{}), bson.D{{Key: "_id", Value: -1}}, keys, parentString)
+		}),
+	}
+}
+
+// parentString uses a page key's parent as it is
+func parentString(parent string) (interface{}, bool) { return parent, true }
+
+// findByID fetches the documents matching filter with the given IDs, keyed
+// by their hex ID
+func findByID[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, ids []primitive.ObjectID) (map[string]T, error) {
+	filter["_id"] = bson.M{"$in": ids}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	docs := make(map[string]T, len(ids))
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		docs[cursor.Current.Lookup("_id").ObjectID().Hex()] = doc
+	}
+	return docs, cursor.Err()
+}
+
+// findPages fetches pages of the documents matching filter whose field is
+// one of the keys' parents, in two queries per page size: one aggregation
+// picks the IDs of every page, sorted by sort, and one find fetches them.
+// parent converts a key's parent to the field's type, false drops the key.
+func findPages[T any](ctx context.Context, collection *mongo.Collection, field string, filter bson.M, sort bson.D,
+	keys []pageKey, parent func(string) (interface{}, bool)) (map[pageKey]pageOf[T], error) {
+	// Pages of the same size and number are picked by one aggregation
+	type window struct{ page, limit int }
+	windows := make(map[window][]pageKey)
+	for _, key := range keys {
+		w := window{key.page, key.limit}
+		windows[w] = append(windows[w], key)
+	}
+
+	pages := make(map[pageKey]pageOf[T], len(keys))
+	for w, keys := range windows {
+		byParent := make(map[interface{}]pageKey, len(keys))
+		parents := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			if value, ok := parent(key.parent); ok {
+				byParent[value] = key
+				parents = append(parents, value)
+			}
+		}
+
+		match := bson.M{field: bson.M{"$in": parents}}
+		for k, v := range filter {
+			match[k] = v
+		}
+		cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: match}},
+			{{Key: "$sort", Value: sort}},
+			{{Key: "$group", Value: bson.M{
+				"_id":   "$" + field,
+				"total": bson.M{"$sum": 1},
+				"ids":   bson.M{"$push": "$_id"},
+			}}},
+			{{Key: "$project", Value: bson.M{
+				"total": 1,
+				"ids":   bson.M{"$slice": bson.A{"$ids", (w.page - 1) * w.limit, w.limit}},
+			}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var groups []struct {
+			Parent interface{}          `bson:"_id"`
+			Total  int64                `bson:"total"`
+			IDs    []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			return nil, err
+		}
+
+		var ids []primitive.ObjectID
+		for _, group := range groups {
+			ids = append(ids, group.IDs...)
+		}
+		docs, err := findByID[T](ctx, collection, bson.M{}, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			key, ok := byParent[group.Parent]
+			if !ok {
+				continue
+			}
+			page := pageOf[T]{items: make([]T, 0, len(group.IDs)), total: group.Total}
+			for _, id := range group.IDs {
+				if doc, ok := docs[id.Hex()]; ok {
+					page.items = append(page.items, doc)
+				}
+			}
+			pages[key] = page
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,734 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/media"
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// graphqlRoot resolves the fields of Query and Mutation
+type graphqlRoot struct{}
+
+type idArgs struct {
+	ID graphql.ID
+}
+
+// pageArgs are the arguments of a page, defaulted by the schema
+type pageArgs struct {
+	Page  int32
+	Limit int32
+}
+
+// objectID parses the ID argument of a field
+func objectID(id graphql.ID, what string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(string(id))
+	if err != nil {
+		return objID, graphqlErrorf(http.StatusBadRequest, "Invalid "+what+" ID")
+	}
+	return objID, nil
+}
+
+func (*graphqlRoot) Me(ctx context.Context) (*userResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionManageProfile); err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+	user, found, err := state.loaders.users.Load(ctx, state.user)
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching user")
+	}
+	if !found {
+		return nil, graphqlErrorf(http.StatusNotFound, "User not found")
+	}
+	return &userResolver{user}, nil
+}
+
+func (*graphqlRoot) Product(ctx context.Context, args idArgs) (*productResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionReadProduct); err != nil {
+		return nil, err
+	}
+	objID, err := objectID(args.ID, "product")
+	if err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	var product models.Product
+	err = state.h.DB.Database(state.h.Database).Collection("products").
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching product")
+	}
+	return &productResolver{product}, nil
+}
+
+func (*graphqlRoot) Products(ctx context.Context, args struct {
+	pageArgs
+	Category *string
+}) (*pageResolver[*productResolver], error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListProducts); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(args.Page), pageLimit(args.Limit)
+	if err := graphqlCost(ctx, limit); err != nil {
+		return nil, err
+	}
+
+	filter := trash.NotDeleted(bson.M{})
+	if args.Category != nil {
+		filter["category"] = utils.Slugify(*args.Category)
+	}
+	page, err := findPage[models.Product](ctx, state.h.DB.Database(state.h.Database).Collection("products"), filter, number, limit)
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching products")
+	}
+	return newPageResolver(page, number, limit, newProductResolver), nil
+}
+
+func (*graphqlRoot) Categories(ctx context.Context) ([]*categoryResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListCategories); err != nil {
+		return nil, err
+	}
+	// The tree is small but unbounded, it costs as much as a full page
+	if err := graphqlCost(ctx, utils.MaxPageSize); err != nil {
+		return nil, err
+	}
+
+	cursor, err := state.h.DB.Database(state.h.Database).Collection("categories").
+		Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "path", Value: 1}}))
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching categories")
+	}
+	var categories []models.Category
+	if err := cursor.All(ctx, &categories); err != nil {
+		return nil, graphqlInternalError("Error fetching categories")
+	}
+	resolvers := make([]*categoryResolver, len(categories))
+	for i, category := range categories {
+		resolvers[i] = &categoryResolver{category}
+	}
+	return resolvers, nil
+}
+
+func (*graphqlRoot) Category(ctx context.Context, args struct{ Slug string }) (*categoryResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListCategories); err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+	return loadCategory(ctx, args.Slug)
+}
+
+func (*graphqlRoot) User(ctx context.Context, args idArgs) (*userResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionReadUser); err != nil {
+		return nil, err
+	}
+	// Users only see themselves, as on GET /user/{id}
+	if state.role == "user" && state.user != string(args.ID) {
+		return nil, graphqlErrorf(http.StatusForbidden, "Access denied")
+	}
+	if _, err := objectID(args.ID, "user"); err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+	return loadUser(ctx, string(args.ID))
+}
+
+func (*graphqlRoot) Users(ctx context.Context, args struct {
+	pageArgs
+	Role *string
+}) (*pageResolver[*userResolver], error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListUsers); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(args.Page), pageLimit(args.Limit)
+	if err := graphqlCost(ctx, limit); err != nil {
+		return nil, err
+	}
+
+	filter := trash.NotDeleted(bson.M{})
+	if args.Role != nil {
+		filter["role"] = *args.Role
+	}
+	page, err := findPage[models.UserDetails](ctx, state.h.DB.Database(state.h.Database).Collection("users"), filter, number, limit)
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching users")
+	}
+	return newPageResolver(page, number, limit, newUserResolver), nil
+}
+
+func (*graphqlRoot) Roles(ctx context.Context) ([]*roleResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListRoles); err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, len(middleware.RolePermissions)); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(middleware.RolePermissions))
+	for name := range middleware.RolePermissions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	roles := make([]*roleResolver, len(names))
+	for i, name := range names {
+		roles[i] = &roleResolver{name}
+	}
+	return roles, nil
+}
+
+func (*graphqlRoot) Role(ctx context.Context, args struct{ Name string }) (*roleResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListRoles); err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+	if _, ok := middleware.RolePermissions[args.Name]; !ok {
+		return nil, nil
+	}
+	return &roleResolver{args.Name}, nil
+}
+
+// Mutations are applied as a bulk write of one operation, so they are
+// validated, permission checked, audited and evented like the REST writes
+
+type priceInput struct {
+	Currency string
+	Amount   float64
+}
+
+// priceMap converts fixed prices to the map the REST requests take
+func priceMap(prices []priceInput) map[string]float64 {
+	m := make(map[string]float64, len(prices))
+	for _, price := range prices {
+		m[price.Currency] = price.Amount
+	}
+	return m
+}
+
+// graphqlWrite applies one operation with apply, returning its result or
+// the error of the operation
+func graphqlWrite(ctx context.Context, op models.BulkOperation, data map[string]interface{},
+	apply func(r *http.Request, op models.BulkOperation) (*models.BulkItemResult, error)) (*models.BulkItemResult, error) {
+	state := graphqlState(ctx)
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, graphqlInternalError("Error processing request")
+		}
+		op.Data = raw
+	}
+
+	item, err := apply(state.r, op)
+	if err != nil {
+		return nil, graphqlInternalError("Error saving changes")
+	}
+	if item.Error != nil {
+		return nil, &graphqlError{code: item.Error.Code, message: item.Error.Message, fields: item.Error.Fields}
+	}
+	return item, nil
+}
+
+// writeProduct applies a product operation
+func writeProduct(ctx context.Context, op models.BulkOperation, data map[string]interface{}) (*models.BulkItemResult, error) {
+	return graphqlWrite(ctx, op, data, graphqlState(ctx).h.applyProductOp)
+}
+
+// writeUser applies a user operation
+func writeUser(ctx context.Context, op models.BulkOperation, data map[string]interface{}) (*models.BulkItemResult, error) {
+	return graphqlWrite(ctx, op, data, graphqlState(ctx).h.applyUserOp)
+}
+
+func (*graphqlRoot) CreateProduct(ctx context.Context, args struct {
+	Input struct {
+		SKU         *string
+		Name        string
+		Description string
+		Price       float64
+		Prices      *[]priceInput
+		Category    string
+		Stock       int32
+	}
+}) (*productResolver, error) {
+	in := args.Input
+	data := map[string]interface{}{
+		"name":        in.Name,
+		"description": in.Description,
+		"price":       in.Price,
+		"category":    in.Category,
+		"stock":       in.Stock,
+	}
+	if in.SKU != nil {
+		data["sku"] = *in.SKU
+	}
+	if in.Prices != nil {
+		data["prices"] = priceMap(*in.Prices)
+	}
+
+	result, err := writeProduct(ctx, models.BulkOperation{Op: models.BulkCreate}, data)
+	if err != nil {
+		return nil, err
+	}
+	return &productResolver{result.Data.(models.Product)}, nil
+}
+
+func (*graphqlRoot) UpdateProduct(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input struct {
+		SKU         *string
+		Name        *string
+		Description *string
+		Price       *float64
+		Prices      *[]priceInput
+		Category    *string
+		Stock       *int32
+	}
+}) (*productResolver, error) {
+	in := args.Input
+	data := map[string]interface{}{}
+	for field, value := range map[string]*string{
+		"sku":         in.SKU,
+		"name":        in.Name,
+		"description": in.Description,
+		"category":    in.Category,
+	} {
+		if value != nil {
+			data[field] = *value
+		}
+	}
+	if in.Price != nil {
+		data["price"] = *in.Price
+	}
+	if in.Prices != nil {
+		data["prices"] = priceMap(*in.Prices)
+	}
+	if in.Stock != nil {
+		data["stock"] = *in.Stock
+	}
+
+	result, err := writeProduct(ctx, models.BulkOperation{Op: models.BulkUpdate, ID: string(args.ID)}, data)
+	if err != nil {
+		return nil, err
+	}
+	return &productResolver{result.Data.(models.Product)}, nil
+}
+
+func (*graphqlRoot) DeleteProduct(ctx context.Context, args idArgs) (graphql.ID, error) {
+	if _, err := writeProduct(ctx, models.BulkOperation{Op: models.BulkDelete, ID: string(args.ID)}, nil); err != nil {
+		return "", err
+	}
+	return args.ID, nil
+}
+
+func (*graphqlRoot) UpdateUser(ctx context.Context, args struct {
+	ID    graphql.ID
+	Input struct {
+		Name        *string
+		Email       *string
+		Password    *string
+		Gender      *string
+		DateOfBirth *string
+		Phone       *string
+	}
+}) (*userResolver, error) {
+	in := args.Input
+	data := map[string]interface{}{}
+	for field, value := range map[string]*string{
+		"name":          in.Name,
+		"email":         in.Email,
+		"password":      in.Password,
+		"gender":        in.Gender,
+		"date_of_birth": in.DateOfBirth,
+		"phone":         in.Phone,
+	} {
+		if value != nil {
+			data[field] = *value
+		}
+	}
+
+	result, err := writeUser(ctx, models.BulkOperation{Op: models.BulkUpdate, ID: string(args.ID)}, data)
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{result.Data.(models.UserDetails)}, nil
+}
+
+func (*graphqlRoot) DeleteUser(ctx context.Context, args idArgs) (graphql.ID, error) {
+	if _, err := writeUser(ctx, models.BulkOperation{Op: models.BulkDelete, ID: string(args.ID)}, nil); err != nil {
+		return "", err
+	}
+	return args.ID, nil
+}
+
+func (*graphqlRoot) AssignRole(ctx context.Context, args struct {
+	UserID graphql.ID
+	Role   string
+}) (*userResolver, error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionAssignRole); err != nil {
+		return nil, err
+	}
+	if _, ok := middleware.RolePermissions[args.Role]; !ok {
+		return nil, graphqlErrorf(http.StatusBadRequest, "Role must be one of: master_admin, sub_admin, user")
+	}
+	objID, err := objectID(args.UserID, "user")
+	if err != nil {
+		return nil, err
+	}
+	if err := graphqlCost(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	previous, err := state.h.assignRole(state.r, objID, args.Role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, graphqlErrorf(http.StatusNotFound, "User not found")
+	}
+	if err != nil {
+		return nil, graphqlInternalError("Error updating user role")
+	}
+	user := *previous
+	user.Role = args.Role
+	return &userResolver{user}, nil
+}
+
+// pageResolver resolves a page of items
+type pageResolver[R any] struct {
+	items []R
+	info  *pageInfoResolver
+}
+
+func newPageResolver[T, R any](page pageOf[T], number, limit int, resolve func(T) R) *pageResolver[R] {
+	items := make([]R, len(page.items))
+	for i, item := range page.items {
+		items[i] = resolve(item)
+	}
+	return &pageResolver[R]{items: items, info: &pageInfoResolver{page: number, limit: limit, total: page.total}}
+}
+
+func (p *pageResolver[R]) Items() []R                  { return p.items }
+func (p *pageResolver[R]) PageInfo() *pageInfoResolver { return p.info }
+
+type pageInfoResolver struct {
+	page  int
+	limit int
+	total int64
+}
+
+func (p *pageInfoResolver) Page() int32       { return int32(p.page) }
+func (p *pageInfoResolver) Limit() int32      { return int32(p.limit) }
+func (p *pageInfoResolver) Total() int32      { return int32(p.total) }
+func (p *pageInfoResolver) HasNextPage() bool { return int64(p.page*p.limit) < p.total }
+
+// optional returns nil for an empty string
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type moneyResolver struct {
+	m money.Money
+}
+
+func (m *moneyResolver) Amount() float64  { return m.m.Major() }
+func (m *moneyResolver) Currency() string { return m.m.Currency }
+
+type imageResolver struct {
+	i      models.ProductImage
+	images *media.Images
+}
+
+func (i *imageResolver) ID() graphql.ID      { return graphql.ID(i.i.ID.Hex()) }
+func (i *imageResolver) URL() string         { return i.images.Signer.URL(i.i.Key) }
+func (i *imageResolver) ContentType() string { return i.i.ContentType }
+func (i *imageResolver) Width() int32        { return int32(i.i.Width) }
+func (i *imageResolver) Height() int32       { return int32(i.i.Height) }
+func (i *imageResolver) Alt() *string        { return optional(i.i.Alt) }
+
+func (i *imageResolver) Thumbnails() []*thumbnailResolver {
+	resolvers := make([]*thumbnailResolver, len(i.i.Thumbnails))
+	for j, thumbnail := range i.i.Thumbnails {
+		resolvers[j] = &thumbnailResolver{thumbnail, i.images}
+	}
+	return resolvers
+}
+
+type thumbnailResolver struct {
+	t      models.ImageThumbnail
+	images *media.Images
+}
+
+func (t *thumbnailResolver) Size() int32   { return int32(t.t.Size) }
+func (t *thumbnailResolver) URL() string   { return t.images.Signer.URL(t.t.Key) }
+func (t *thumbnailResolver) Width() int32  { return int32(t.t.Width) }
+func (t *thumbnailResolver) Height() int32 { return int32(t.t.Height) }
+
+type ratingResolver struct {
+	r models.ProductRating
+}
+
+func (r *ratingResolver) Average() float64 { return r.r.Average }
+func (r *ratingResolver) Count() int32     { return int32(r.r.Count) }
+
+type productResolver struct {
+	p models.Product
+}
+
+func newProductResolver(p models.Product) *productResolver { return &productResolver{p} }
+
+func (p *productResolver) ID() graphql.ID          { return graphql.ID(p.p.ID.Hex()) }
+func (p *productResolver) SKU() *string            { return optional(p.p.SKU) }
+func (p *productResolver) Name() string            { return p.p.Name }
+func (p *productResolver) Description() string     { return p.p.Description }
+func (p *productResolver) Stock() int32            { return int32(p.p.Stock) }
+func (p *productResolver) Rating() *ratingResolver { return &ratingResolver{p.p.Rating} }
+
+func (p *productResolver) Price(ctx context.Context, args struct{ Currency *string }) (*moneyResolver, error) {
+	if args.Currency == nil {
+		return &moneyResolver{p.p.Price}, nil
+	}
+	h := graphqlState(ctx).h
+	currency, err := money.Normalize(*args.Currency)
+	if err == nil && !h.Rates.Has(currency) {
+		err = fmt.Errorf("%w %s", money.ErrNoRate, currency)
+	}
+	if err != nil {
+		return nil, graphqlErrorf(http.StatusBadRequest, err.Error())
+	}
+	price, err := h.priceIn(p.p, nil, currency)
+	if err != nil {
+		return nil, graphqlInternalError("Error converting price")
+	}
+	return &moneyResolver{price}, nil
+}
+
+// Images resolves the gallery with signed URLs, as the REST endpoints
+// serve it
+func (p *productResolver) Images(ctx context.Context) []*imageResolver {
+	images := graphqlState(ctx).h.Media
+	resolvers := make([]*imageResolver, len(p.p.Images))
+	for i, image := range p.p.Images {
+		resolvers[i] = &imageResolver{image, images}
+	}
+	return resolvers
+}
+
+func (p *productResolver) Category(ctx context.Context) (*categoryResolver, error) {
+	if err := graphqlState(ctx).allow(middleware.PermissionListCategories); err != nil {
+		return nil, err
+	}
+	return loadCategory(ctx, p.p.Category)
+}
+
+func (p *productResolver) Reviews(ctx context.Context, args pageArgs) (*pageResolver[*reviewResolver], error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionReadProduct); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(args.Page), pageLimit(args.Limit)
+	page, _, err := state.loaders.productReviews.Load(ctx, pageKey{parent: p.p.ID.Hex(), page: number, limit: limit})
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching reviews")
+	}
+	return newPageResolver(page, number, limit, newReviewResolver), nil
+}
+
+type categoryResolver struct {
+	c models.Category
+}
+
+// loadCategory resolves a category by its slug, nil when there is none
+func loadCategory(ctx context.Context, slug string) (*categoryResolver, error) {
+	category, found, err := graphqlState(ctx).loaders.categories.Load(ctx, slug)
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching category")
+	}
+	if !found {
+		return nil, nil
+	}
+	return &categoryResolver{category}, nil
+}
+
+func (c *categoryResolver) ID() graphql.ID { return graphql.ID(c.c.ID.Hex()) }
+func (c *categoryResolver) Name() string   { return c.c.Name }
+func (c *categoryResolver) Slug() string   { return c.c.Slug }
+func (c *categoryResolver) Path() string   { return c.c.Path }
+
+func (c *categoryResolver) Parent(ctx context.Context) (*categoryResolver, error) {
+	// The path ends with the parent's slug and then this category's
+	slugs := strings.Split(c.c.Path, "/")
+	if c.c.ParentID == nil || len(slugs) < 2 {
+		return nil, nil
+	}
+	return loadCategory(ctx, slugs[len(slugs)-2])
+}
+
+func (c *categoryResolver) Products(ctx context.Context, args pageArgs) (*pageResolver[*productResolver], error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListProducts); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(args.Page), pageLimit(args.Limit)
+	page, _, err := state.loaders.categoryProducts.Load(ctx, pageKey{parent: c.c.Slug, page: number, limit: limit})
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching products")
+	}
+	return newPageResolver(page, number, limit, newProductResolver), nil
+}
+
+type reviewResolver struct {
+	r models.Review
+}
+
+func newReviewResolver(r models.Review) *reviewResolver { return &reviewResolver{r} }
+
+func (r *reviewResolver) ID() graphql.ID          { return graphql.ID(r.r.ID.Hex()) }
+func (r *reviewResolver) Rating() int32           { return int32(r.r.Rating) }
+func (r *reviewResolver) Title() *string          { return optional(r.r.Title) }
+func (r *reviewResolver) Body() string            { return r.r.Body }
+func (r *reviewResolver) VerifiedPurchase() bool  { return r.r.VerifiedPurchase }
+func (r *reviewResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.r.CreatedAt} }
+
+// Author is nil once the reviewer's account is deleted
+func (r *reviewResolver) Author(ctx context.Context) (*userResolver, error) {
+	return loadUser(ctx, r.r.UserID)
+}
+
+type userResolver struct {
+	u models.UserDetails
+}
+
+func newUserResolver(u models.UserDetails) *userResolver { return &userResolver{u} }
+
+// loadUser resolves a user by their hex ID, nil when there is none
+func loadUser(ctx context.Context, id string) (*userResolver, error) {
+	user, found, err := graphqlState(ctx).loaders.users.Load(ctx, id)
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching user")
+	}
+	if !found {
+		return nil, nil
+	}
+	return &userResolver{user}, nil
+}
+
+// private checks the caller may see the user's personal details: the user
+// themselves and those who can list users
+func (u *userResolver) private(ctx context.Context) error {
+	state := graphqlState(ctx)
+	if state.user == u.u.ID.Hex() {
+		return nil
+	}
+	return state.allow(middleware.PermissionListUsers)
+}
+
+func (u *userResolver) ID() graphql.ID { return graphql.ID(u.u.ID.Hex()) }
+func (u *userResolver) Name() string   { return u.u.Name }
+
+func (u *userResolver) Email(ctx context.Context) (*string, error) {
+	if err := u.private(ctx); err != nil {
+		return nil, err
+	}
+	return &u.u.Email, nil
+}
+
+func (u *userResolver) Gender(ctx context.Context) (*string, error) {
+	if err := u.private(ctx); err != nil {
+		return nil, err
+	}
+	return optional(u.u.Gender), nil
+}
+
+func (u *userResolver) Phone(ctx context.Context) (*string, error) {
+	if err := u.private(ctx); err != nil {
+		return nil, err
+	}
+	return optional(u.u.Phone), nil
+}
+
+func (u *userResolver) DateOfBirth(ctx context.Context) (*graphql.Time, error) {
+	if err := u.private(ctx); err != nil {
+		return nil, err
+	}
+	if u.u.DateOfBirth == nil {
+		return nil, nil
+	}
+	return &graphql.Time{Time: *u.u.DateOfBirth}, nil
+}
+
+func (u *userResolver) Role() *roleResolver { return &roleResolver{u.u.Role} }
+
+type roleResolver struct {
+	name string
+}
+
+func (r *roleResolver) Name() string { return r.name }
+
+func (r *roleResolver) Permissions(ctx context.Context) ([]string, error) {
+	state := graphqlState(ctx)
+	if state.role != r.name {
+		if err := state.allow(middleware.PermissionListRoles); err != nil {
+			return nil, err
+		}
+	}
+	permissions := make([]string, len(middleware.RolePermissions[r.name]))
+	for i, permission := range middleware.RolePermissions[r.name] {
+		permissions[i] = string(permission)
+	}
+	return permissions, nil
+}
+
+func (r *roleResolver) Users(ctx context.Context, args pageArgs) (*pageResolver[*userResolver], error) {
+	state := graphqlState(ctx)
+	if err := state.allow(middleware.PermissionListUsers); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(args.Page), pageLimit(args.Limit)
+	page, _, err := state.loaders.roleUsers.Load(ctx, pageKey{parent: r.name, page: number, limit: limit})
+	if err != nil {
+		return nil, graphqlInternalError("Error fetching users")
+	}
+	return newPageResolver(page, number, limit, newUserResolver), nil
+}
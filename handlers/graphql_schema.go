@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"go-tutorial/utils"
+)
+
+const (
+	// graphqlMaxDepth caps how deeply a GraphQL query nests fields
+	graphqlMaxDepth = 8
+	// graphqlMaxCost caps the estimated cost of a GraphQL query, see
+	// graphqlCost
+	graphqlMaxCost = 5000
+)
+
+// graphqlSDL is the GraphQL schema. Fields named products, users and
+// reviews are always pages taking page and limit, graphqlCost relies on it.
+const graphqlSDL = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	"The authenticated user"
+	me: User!
+	product(id: ID!): Product
+	"Products, newest first, optionally of one category"
+	products(page: Int = 1, limit: Int = 10, category: String): ProductPage!
+	categories: [Category!]!
+	category(slug: String!): Category
+	user(id: ID!): User
+	"Users, newest first, optionally with one role"
+	users(page: Int = 1, limit: Int = 10, role: String): UserPage!
+	roles: [Role!]!
+	role(name: String!): Role
+}
+
+type Mutation {
+	createProduct(input: CreateProductInput!): Product!
+	updateProduct(id: ID!, input: UpdateProductInput!): Product!
+	"Moves a product to the trash, returning its ID"
+	deleteProduct(id: ID!): ID!
+	updateUser(id: ID!, input: UpdateUserInput!): User!
+	"Moves a user to the trash, returning their ID"
+	deleteUser(id: ID!): ID!
+	assignRole(userId: ID!, role: String!): User!
+}
+
+type PageInfo {
+	page: Int!
+	limit: Int!
+	total: Int!
+	hasNextPage: Boolean!
+}
+
+"An amount in major units of its currency"
+type Money {
+	amount: Float!
+	currency: String!
+}
+
+type Rating {
+	average: Float!
+	count: Int!
+}
+
+type Product {
+	id: ID!
+	sku: String
+	name: String!
+	description: String!
+	"The price in the base currency, or in currency when given"
+	price(currency: String): Money!
+	category: Category
+	stock: Int!
+	rating: Rating!
+	"The gallery in display order"
+	images: [ProductImage!]!
+	"Approved reviews, newest first"
+	reviews(page: Int = 1, limit: Int = 10): ReviewPage!
+}
+
+"An image of a product, its URLs are signed and expire"
+type ProductImage {
+	id: ID!
+	url: String!
+	contentType: String!
+	width: Int!
+	height: Int!
+	alt: String
+	thumbnails: [ImageThumbnail!]!
+}
+
+"A scaled down copy of an image fitting a square box of size pixels"
+type ImageThumbnail {
+	size: Int!
+	url: String!
+	width: Int!
+	height: Int!
+}
+
+type ProductPage {
+	items: [Product!]!
+	pageInfo: PageInfo!
+}
+
+type Category {
+	id: ID!
+	name: String!
+	slug: String!
+	path: String!
+	parent: Category
+	"Products directly in the category, newest first"
+	products(page: Int = 1, limit: Int = 10): ProductPage!
+}
+
+type Review {
+	id: ID!
+	rating: Int!
+	title: String
+	body: String!
+	verifiedPurchase: Boolean!
+	createdAt: Time!
+	author: User
+}
+
+type ReviewPage {
+	items: [Review!]!
+	pageInfo: PageInfo!
+}
+
+"A user. Email, phone, gender and date of birth are only shown to the user and to those who can list users."
+type User {
+	id: ID!
+	name: String!
+	email: String
+	gender: String
+	phone: String
+	dateOfBirth: Time
+	role: Role!
+}
+
+type UserPage {
+	items: [User!]!
+	pageInfo: PageInfo!
+}
+
+type Role {
+	name: String!
+	"Shown for the caller's own role and to those who can list roles"
+	permissions: [String!]!
+	users(page: Int = 1, limit: Int = 10): UserPage!
+}
+
+"A fixed price in another currency"
+input PriceInput {
+	currency: String!
+	amount: Float!
+}
+
+input CreateProductInput {
+	sku: String
+	name: String!
+	description: String!
+	price: Float!
+	prices: [PriceInput!]
+	category: String!
+	stock: Int!
+}
+
+"Only the fields given are changed, prices replaces all fixed prices"
+input UpdateProductInput {
+	sku: String
+	name: String
+	description: String
+	price: Float
+	prices: [PriceInput!]
+	category: String
+	stock: Int
+}
+
+"Only the fields given are changed. dateOfBirth is written as YYYY-MM-DD."
+input UpdateUserInput {
+	name: String
+	email: String
+	password: String
+	gender: String
+	dateOfBirth: String
+	phone: String
+}
+`
+
+// graphqlSchema is the parsed schema. Resolvers find the handler and the
+// request in their context, so one schema serves every request. Lists run
+// their items' resolvers in parallel up to a full page, which lets the
+// loaders batch a whole page at once.
+var graphqlSchema = graphql.MustParseSchema(graphqlSDL, &graphqlRoot{},
+	graphql.UseStringDescriptions(),
+	graphql.MaxDepth(graphqlMaxDepth),
+	graphql.MaxParallelism(utils.MaxPageSize),
+)
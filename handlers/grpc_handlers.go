@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	storev1 "go-tutorial/proto/store/v1"
+)
+
+// RegisterGRPC registers the gRPC services on s. They share the handlers'
+// business logic and check the permissions of the matching REST routes, so
+// both APIs behave the same. s authenticates calls with the interceptors of
+// the middleware package.
+func (h *Handler) RegisterGRPC(s *grpc.Server) {
+	storev1.RegisterProductServiceServer(s, &productServer{h: h})
+	storev1.RegisterUserServiceServer(s, &userServer{h: h})
+}
+
+// grpcCodes map the status codes the REST API answers with to gRPC codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+}
+
+// grpcError builds the error of a call from the status code and message the
+// REST API would answer with. Invalid fields are attached as a
+// google.rpc.BadRequest detail.
+func grpcError(code int, message string, fields ...models.BulkFieldError) error {
+	c, ok := grpcCodes[code]
+	if !ok {
+		c = codes.Internal
+	}
+	st := status.New(c, message)
+	if len(fields) > 0 {
+		details := &errdetails.BadRequest{}
+		for _, field := range fields {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field.Field,
+				Description: field.Message,
+			})
+		}
+		if withDetails, err := st.WithDetails(details); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+// grpcRequest builds the request the shared handler code reads the caller
+// from: the claims and request ID the interceptors added to ctx, and the
+// peer's address for the audit log
+func grpcRequest(ctx context.Context) *http.Request {
+	method, _ := grpc.Method(ctx)
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, method, nil)
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// grpcAllow checks the caller has a permission, as RequirePermission does
+// for the REST routes
+func grpcAllow(r *http.Request, permission middleware.Permission) error {
+	if !middleware.HasPermission(currentRole(r), permission) {
+		return status.Error(codes.PermissionDenied, "Insufficient permissions")
+	}
+	return nil
+}
+
+// grpcWrite applies one operation with apply, data being the body the REST
+// endpoint takes, and returns its result
+func grpcWrite(r *http.Request, op models.BulkOperation, data interface{},
+	apply func(r *http.Request, op models.BulkOperation) (*models.BulkItemResult, error)) (*models.BulkItemResult, error) {
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "Error processing request")
+		}
+		op.Data = raw
+	}
+
+	item, err := apply(r, op)
+	if err != nil {
+		log.Printf("Error applying %s operation over gRPC: %v", op.Op, err)
+		return nil, status.Error(codes.Internal, "Error saving changes")
+	}
+	if item.Error != nil {
+		return nil, grpcError(item.Error.Code, item.Error.Message, item.Error.Fields...)
+	}
+	return item, nil
+}
+
+// grpcPageInfo describes a page of a list
+func grpcPageInfo(number, limit int, total int64) *storev1.PageInfo {
+	return &storev1.PageInfo{
+		Page:        int32(number),
+		Limit:       int32(limit),
+		Total:       total,
+		HasNextPage: int64(number*limit) < total,
+	}
+}
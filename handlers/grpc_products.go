@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"go-tutorial/events"
+	"go-tutorial/live"
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	"go-tutorial/money"
+	storev1 "go-tutorial/proto/store/v1"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// productServer serves ProductService
+type productServer struct {
+	storev1.UnimplementedProductServiceServer
+	h *Handler
+}
+
+func moneyMessage(m money.Money) *storev1.Money {
+	return &storev1.Money{Amount: m.Amount, Currency: m.Currency}
+}
+
+func productMessage(p models.Product) *storev1.Product {
+	msg := &storev1.Product{
+		Id:          p.ID.Hex(),
+		Sku:         p.SKU,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       moneyMessage(p.Price),
+		Category:    p.Category,
+		Stock:       int32(p.Stock),
+		Rating:      &storev1.Rating{Average: p.Rating.Average, Count: int32(p.Rating.Count)},
+	}
+	for _, price := range p.Prices {
+		msg.Prices = append(msg.Prices, moneyMessage(price))
+	}
+	for _, variant := range p.Variants {
+		v := &storev1.Variant{
+			Id:         variant.ID.Hex(),
+			Sku:        variant.SKU,
+			Attributes: variant.Attributes,
+			Stock:      int32(variant.Stock),
+		}
+		if variant.Price != nil {
+			v.Price = moneyMessage(*variant.Price)
+		}
+		msg.Variants = append(msg.Variants, v)
+	}
+	return msg
+}
+
+// currency checks the currency prices are asked in
+func (s *productServer) currency(raw string) (string, error) {
+	currency, err := s.h.parseCurrency(raw)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, "Invalid currency: "+err.Error())
+	}
+	return currency, nil
+}
+
+func (s *productServer) GetProduct(ctx context.Context, req *storev1.GetProductRequest) (*storev1.Product, error) {
+	r := grpcRequest(ctx)
+	if err := grpcAllow(r, middleware.PermissionReadProduct); err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid product ID")
+	}
+	currency, err := s.currency(req.GetCurrency())
+	if err != nil {
+		return nil, err
+	}
+
+	var product models.Product
+	err = s.h.DB.Database(s.h.Database).Collection("products").
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, status.Error(codes.NotFound, "Product not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error fetching product")
+	}
+	if err := s.h.localizeProduct(&product, currency); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid currency: "+err.Error())
+	}
+	return productMessage(product), nil
+}
+
+func (s *productServer) ListProducts(ctx context.Context, req *storev1.ListProductsRequest) (*storev1.ListProductsResponse, error) {
+	r := grpcRequest(ctx)
+	if err := grpcAllow(r, middleware.PermissionListProducts); err != nil {
+		return nil, err
+	}
+	currency, err := s.currency(req.GetCurrency())
+	if err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(req.GetPage().GetPage()), pageLimit(req.GetPage().GetLimit())
+
+	filter := trash.NotDeleted(bson.M{})
+	if req.GetCategory() != "" {
+		filter["category"] = utils.Slugify(req.GetCategory())
+	}
+	page, err := findPage[models.Product](ctx, s.h.DB.Database(s.h.Database).Collection("products"), filter, number, limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error fetching products")
+	}
+	if err := s.h.localizeProducts(page.items, currency); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid currency: "+err.Error())
+	}
+
+	resp := &storev1.ListProductsResponse{PageInfo: grpcPageInfo(number, limit, page.total)}
+	for _, product := range page.items {
+		resp.Products = append(resp.Products, productMessage(product))
+	}
+	return resp, nil
+}
+
+func (s *productServer) CreateProduct(ctx context.Context, req *storev1.CreateProductRequest) (*storev1.Product, error) {
+	data := models.CreateProductRequest{
+		SKU:         req.GetSku(),
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Price:       req.GetPrice(),
+		Prices:      req.GetPrices(),
+		Category:    req.GetCategory(),
+		Stock:       int(req.GetStock()),
+	}
+	result, err := grpcWrite(grpcRequest(ctx), models.BulkOperation{Op: models.BulkCreate}, data, s.h.applyProductOp)
+	if err != nil {
+		return nil, err
+	}
+	return productMessage(result.Data.(models.Product)), nil
+}
+
+func (s *productServer) UpdateProduct(ctx context.Context, req *storev1.UpdateProductRequest) (*storev1.Product, error) {
+	data := map[string]interface{}{}
+	if req.Sku != nil {
+		data["sku"] = req.GetSku()
+	}
+	if req.Name != nil {
+		data["name"] = req.GetName()
+	}
+	if req.Description != nil {
+		data["description"] = req.GetDescription()
+	}
+	if req.Price != nil {
+		data["price"] = req.GetPrice()
+	}
+	if req.Prices != nil {
+		prices := req.GetPrices().GetPrices()
+		if prices == nil {
+			prices = map[string]float64{}
+		}
+		data["prices"] = prices
+	}
+	if req.Category != nil {
+		data["category"] = req.GetCategory()
+	}
+	if req.Stock != nil {
+		data["stock"] = req.GetStock()
+	}
+
+	op := models.BulkOperation{Op: models.BulkUpdate, ID: req.GetId()}
+	result, err := grpcWrite(grpcRequest(ctx), op, data, s.h.applyProductOp)
+	if err != nil {
+		return nil, err
+	}
+	return productMessage(result.Data.(models.Product)), nil
+}
+
+func (s *productServer) DeleteProduct(ctx context.Context, req *storev1.DeleteProductRequest) (*emptypb.Empty, error) {
+	op := models.BulkOperation{Op: models.BulkDelete, ID: req.GetId()}
+	if _, err := grpcWrite(grpcRequest(ctx), op, nil, s.h.applyProductOp); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// stockChangeMessage converts a stock event, false for other product events
+func stockChangeMessage(msg live.Message) (*storev1.StockChange, bool) {
+	if msg.Event.Type != events.StockChanged {
+		return nil, false
+	}
+	var data events.StockChangedData
+	if err := json.Unmarshal(msg.Event.Data, &data); err != nil {
+		log.Printf("Invalid stock event %s: %v", msg.Event.ID.Hex(), err)
+		return nil, false
+	}
+	return &storev1.StockChange{
+		EventId:    msg.Event.ID.Hex(),
+		ProductId:  data.ProductID,
+		VariantId:  data.VariantID,
+		Category:   msg.Category,
+		Delta:      int32(data.Delta),
+		Quantity:   int32(data.Quantity),
+		Reason:     data.Reason,
+		Actor:      msg.Event.Actor,
+		OccurredAt: timestamppb.New(msg.Event.OccurredAt),
+	}, true
+}
+
+// WatchStock streams the stock changes the live hub receives, as
+// StreamProductEvents does over Server-Sent Events. The stream ends with
+// Unavailable when the client falls too far behind, it resumes from the
+// last change it got.
+func (s *productServer) WatchStock(req *storev1.WatchStockRequest, stream grpc.ServerStreamingServer[storev1.WatchStockResponse]) error {
+	ctx := stream.Context()
+	r := grpcRequest(ctx)
+	if err := grpcAllow(r, middleware.PermissionListProducts); err != nil {
+		return err
+	}
+
+	var filter live.Filter
+	if len(req.GetCategories()) > 0 {
+		filter.Categories = make(map[string]bool)
+		for _, category := range req.GetCategories() {
+			filter.Categories[utils.Slugify(category)] = true
+		}
+	}
+	if len(req.GetProductIds()) > 0 {
+		filter.ProductIDs = make(map[string]bool)
+		for _, id := range req.GetProductIds() {
+			if _, err := primitive.ObjectIDFromHex(id); err != nil {
+				return status.Error(codes.InvalidArgument, "Invalid product ID "+id)
+			}
+			filter.ProductIDs[id] = true
+		}
+	}
+
+	sub, missed, resumed := s.h.Live.Subscribe(filter, req.GetLastEventId())
+	defer s.h.Live.Unsubscribe(sub)
+
+	send := func(msg live.Message) error {
+		change, ok := stockChangeMessage(msg)
+		if !ok {
+			return nil
+		}
+		return stream.Send(&storev1.WatchStockResponse{Event: &storev1.WatchStockResponse_Change{Change: change}})
+	}
+
+	if !resumed {
+		if err := stream.Send(&storev1.WatchStockResponse{Event: &storev1.WatchStockResponse_Resync{Resync: true}}); err != nil {
+			return err
+		}
+	}
+	for _, msg := range missed {
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "Stream fell behind, resume from the last change received")
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	storev1 "go-tutorial/proto/store/v1"
+	"go-tutorial/trash"
+)
+
+// userServer serves UserService
+type userServer struct {
+	storev1.UnimplementedUserServiceServer
+	h *Handler
+}
+
+func userMessage(u models.UserDetails) *storev1.User {
+	msg := &storev1.User{
+		Id:     u.ID.Hex(),
+		Name:   u.Name,
+		Email:  u.Email,
+		Role:   u.Role,
+		Gender: u.Gender,
+		Phone:  u.Phone,
+	}
+	if u.DateOfBirth != nil {
+		msg.DateOfBirth = u.DateOfBirth.Format(time.DateOnly)
+	}
+	return msg
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *storev1.GetUserRequest) (*storev1.User, error) {
+	r := grpcRequest(ctx)
+	if err := grpcAllow(r, middleware.PermissionReadUser); err != nil {
+		return nil, err
+	}
+	// Users only see themselves, as on GET /user/{id}
+	if currentRole(r) == "user" && currentUser(r) != req.GetId() {
+		return nil, status.Error(codes.PermissionDenied, "Access denied")
+	}
+	objID, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid user ID")
+	}
+
+	var user models.UserDetails
+	err = s.h.DB.Database(s.h.Database).Collection("users").
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, status.Error(codes.NotFound, "User not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error fetching user details")
+	}
+	return userMessage(user), nil
+}
+
+func (s *userServer) ListUsers(ctx context.Context, req *storev1.ListUsersRequest) (*storev1.ListUsersResponse, error) {
+	r := grpcRequest(ctx)
+	if err := grpcAllow(r, middleware.PermissionListUsers); err != nil {
+		return nil, err
+	}
+	number, limit := pageNumber(req.GetPage().GetPage()), pageLimit(req.GetPage().GetLimit())
+
+	filter := trash.NotDeleted(bson.M{})
+	if req.GetRole() != "" {
+		filter["role"] = req.GetRole()
+	}
+	page, err := findPage[models.UserDetails](ctx, s.h.DB.Database(s.h.Database).Collection("users"), filter, number, limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "Error fetching users")
+	}
+
+	resp := &storev1.ListUsersResponse{PageInfo: grpcPageInfo(number, limit, page.total)}
+	for _, user := range page.items {
+		resp.Users = append(resp.Users, userMessage(user))
+	}
+	return resp, nil
+}
+
+func (s *userServer) CreateUser(ctx context.Context, req *storev1.CreateUserRequest) (*storev1.User, error) {
+	data := models.CreateUserRequest{
+		Name:        req.GetName(),
+		Email:       req.GetEmail(),
+		Password:    req.GetPassword(),
+		Role:        req.GetRole(),
+		Gender:      req.GetGender(),
+		DateOfBirth: req.GetDateOfBirth(),
+		Phone:       req.GetPhone(),
+	}
+	result, err := grpcWrite(grpcRequest(ctx), models.BulkOperation{Op: models.BulkCreate}, data, s.h.applyUserOp)
+	if err != nil {
+		return nil, err
+	}
+	return userMessage(result.Data.(models.UserDetails)), nil
+}
+
+func (s *userServer) UpdateUser(ctx context.Context, req *storev1.UpdateUserRequest) (*storev1.User, error) {
+	data := map[string]interface{}{}
+	for field, value := range map[string]*string{
+		"name":          req.Name,
+		"email":         req.Email,
+		"password":      req.Password,
+		"gender":        req.Gender,
+		"date_of_birth": req.DateOfBirth,
+		"phone":         req.Phone,
+	} {
+		if value != nil {
+			data[field] = *value
+		}
+	}
+
+	op := models.BulkOperation{Op: models.BulkUpdate, ID: req.GetId()}
+	result, err := grpcWrite(grpcRequest(ctx), op, data, s.h.applyUserOp)
+	if err != nil {
+		return nil, err
+	}
+	return userMessage(result.Data.(models.UserDetails)), nil
+}
+
+func (s *userServer) DeleteUser(ctx context.Context, req *storev1.DeleteUserRequest) (*emptypb.Empty, error) {
+	op := models.BulkOperation{Op: models.BulkDelete, ID: req.GetId()}
+	if _, err := grpcWrite(grpcRequest(ctx), op, nil, s.h.applyUserOp); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/media"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+)
+
+// maxProductImages caps the gallery of a single product
+const maxProductImages = 20
+
+// maxAltLength caps the alternative text of an image
+const maxAltLength = 200
+
+// multipartMemory is how much of a multipart upload is held in memory,
+// larger files are spooled to disk by the standard library
+const multipartMemory = 1 << 20
+
+// signProductImages sets the signed image URLs of products about to be
+// served
+func (h *Handler) signProductImages(products []models.Product) {
+	for i := range products {
+		h.Media.Sign(products[i].Images)
+	}
+}
+
+// writeUploadError maps an image processing error to a response
+func (h *Handler) writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		h.ErrorHdlr.HandleError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Image is too large, the limit is %d bytes and %d megapixels",
+				h.Media.MaxSize(), media.MaxPixels/1_000_000))
+	case errors.Is(err, media.ErrUnsupportedType):
+		h.ErrorHdlr.HandleError(w, http.StatusUnsupportedMediaType, "Unsupported image type, use JPEG, PNG or GIF")
+	case errors.Is(err, media.ErrInvalidImage):
+		h.ErrorHdlr.HandleBadRequest(w, "Image could not be decoded")
+	default:
+		h.ErrorHdlr.HandleInternalError(w, "Error storing image")
+	}
+}
+
+// readUpload parses a multipart form and reads the file sent as field,
+// writing an error response when it cannot. Other form values are available
+// from r afterwards.
+func (h *Handler) readUpload(w http.ResponseWriter, r *http.Request, field string) ([]byte, bool) {
+	// Leave room for the other form fields and the multipart framing
+	r.Body = http.MaxBytesReader(w, r.Body, h.Media.MaxSize()+multipartMemory)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.writeUploadError(w, media.ErrTooLarge)
+			return nil, false
+		}
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid multipart form")
+		return nil, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+			{Field: field, Message: "This field is required"},
+		})
+		return nil, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.Media.MaxSize()+1))
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Error reading upload")
+		return nil, false
+	}
+	return data, true
+}
+
+// UploadProductImage handles adding an image to the end of a product's
+// gallery. The image is sent as the "image" field of a multipart form with
+// an optional "alt" text. Its type is detected from the content, and
+// thumbnails are generated at the configured sizes.
+func (h *Handler) UploadProductImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	if len(product.Images) >= maxProductImages {
+		h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("A product can have at most %d images", maxProductImages))
+		return
+	}
+
+	data, ok := h.readUpload(w, r, "image")
+	if !ok {
+		return
+	}
+
+	alt := strings.TrimSpace(r.FormValue("alt"))
+	if len(alt) > maxAltLength {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+			{Field: "alt", Message: fmt.Sprintf("Maximum length is %d", maxAltLength)},
+		})
+		return
+	}
+
+	image, err := h.Media.Upload(ctx, product.ID, data, alt)
+	if err != nil {
+		h.writeUploadError(w, err)
+		return
+	}
+
+	// Append unless a concurrent upload filled the gallery meanwhile
+	result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{
+			"_id": product.ID,
+			fmt.Sprintf("images.%d", maxProductImages-1): bson.M{"$exists": false},
+		},
+		bson.M{"$push": bson.M{"images": image}})
+	if err != nil || result.MatchedCount == 0 {
+		h.Media.Delete(ctx, image)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error saving image")
+			return
+		}
+		h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("A product can have at most %d images", maxProductImages))
+		return
+	}
+
+	invalidateProductCache(ctx, product.ID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceProduct, product.ID.Hex(),
+		bson.M{"images": product.Images}, bson.M{"images": append(product.Images, *image)})
+
+	images := []models.ProductImage{*image}
+	h.Media.Sign(images)
+	h.ResponseHdlr.Created(w, "Image uploaded successfully", images[0])
+}
+
+// DeleteProductImage handles removing an image from a product's gallery
+// together with its stored files
+func (h *Handler) DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	imageID, err := primitive.ObjectIDFromHex(mux.Vars(r)["imageId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid image ID")
+		return
+	}
+
+	var image *models.ProductImage
+	for i := range product.Images {
+		if product.Images[i].ID == imageID {
+			image = &product.Images[i]
+			break
+		}
+	}
+	if image == nil {
+		h.ErrorHdlr.HandleNotFound(w, "Image not found")
+		return
+	}
+
+	result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{"_id": product.ID},
+		bson.M{"$pull": bson.M{"images": bson.M{"_id": imageID}}})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting image")
+		return
+	}
+	if result.ModifiedCount == 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Image not found")
+		return
+	}
+
+	h.Media.Delete(ctx, image)
+	invalidateProductCache(ctx, product.ID.Hex())
+
+	remaining := make([]models.ProductImage, 0, len(product.Images)-1)
+	for _, other := range product.Images {
+		if other.ID != imageID {
+			remaining = append(remaining, other)
+		}
+	}
+	h.audit(r, models.AuditUpdate, models.AuditResourceProduct, product.ID.Hex(),
+		bson.M{"images": product.Images}, bson.M{"images": remaining})
+
+	h.ResponseHdlr.Success(w, "Image deleted successfully", nil)
+}
+
+// ReorderProductImages handles setting the order of a product's gallery,
+// the first image becomes the main image
+func (h *Handler) ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.ReorderImagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var errors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			errors = append(errors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+
+	// The new order must be a permutation of the current images
+	byID := make(map[string]models.ProductImage, len(product.Images))
+	for _, image := range product.Images {
+		byID[image.ID.Hex()] = image
+	}
+	images := make([]models.ProductImage, 0, len(req.ImageIDs))
+	ids := make([]primitive.ObjectID, 0, len(req.ImageIDs))
+	for _, id := range req.ImageIDs {
+		image, ok := byID[strings.ToLower(id)]
+		if !ok {
+			h.ErrorHdlr.HandleBadRequest(w, "image_ids must list every image of the product exactly once")
+			return
+		}
+		delete(byID, image.ID.Hex())
+		images = append(images, image)
+		ids = append(ids, image.ID)
+	}
+	if len(byID) > 0 {
+		h.ErrorHdlr.HandleBadRequest(w, "image_ids must list every image of the product exactly once")
+		return
+	}
+
+	// Only write if the gallery still holds exactly these images
+	result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{
+			"_id":        product.ID,
+			"images":     bson.M{"$size": len(ids)},
+			"images._id": bson.M{"$all": ids},
+		},
+		bson.M{"$set": bson.M{"images": images}})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error reordering images")
+		return
+	}
+	if result.MatchedCount == 0 {
+		h.ErrorHdlr.HandleConflict(w, "The product's images changed, reload them and try again")
+		return
+	}
+
+	invalidateProductCache(ctx, product.ID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceProduct, product.ID.Hex(),
+		bson.M{"images": product.Images}, bson.M{"images": images})
+
+	h.Media.Sign(images)
+	h.ResponseHdlr.Success(w, "Images reordered successfully", images)
+}
+
+// ServeMedia handles serving a stored image through a signed URL. Blobs are
+// immutable, so responses may be cached until the URL expires.
+func (h *Handler) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	expiry, ok := h.Media.Signer.Verify(key, r.URL.Query().Get("expires"), r.URL.Query().Get("sig"))
+	if !ok {
+		h.ErrorHdlr.HandleForbidden(w, "Invalid or expired media URL")
+		return
+	}
+
+	etag := `"` + key + `"`
+	cacheControl := fmt.Sprintf("public, max-age=%d, immutable", int(time.Until(expiry).Seconds()))
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, object, err := h.Media.Store.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, media.ErrNotFound) || errors.Is(err, media.ErrInvalidKey) {
+			h.ErrorHdlr.HandleNotFound(w, "Media not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error reading media")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", object.ContentType)
+	if object.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, body)
+}
@@ -0,0 +1,479 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/audit"
+	"go-tutorial/cache"
+	"go-tutorial/catalog"
+	"go-tutorial/jobs"
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// exportFlushEvery is how many exported rows are written between flushes
+const exportFlushEvery = 500
+
+// ImportProducts handles queueing a file of products for import. The file
+// is sent as the "file" field of a multipart form, with optional fields:
+//   - format: csv, ndjson or xlsx, taken from the file name by default
+//   - mapping: a JSON object mapping file columns to product fields, by
+//     default columns named after a field are imported
+//   - match: name (default) or sku, the field rows are matched to existing
+//     products on
+//   - dry_run: true to only validate the rows
+//
+// The import runs as a background job, poll GET /product/imports/{id} for
+// its progress and row errors.
+func (h *Handler) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.Imports.MaxSize()+multipartMemory)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.ErrorHdlr.HandleError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Import files can be at most %d bytes", h.Imports.MaxSize()))
+			return
+		}
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+			{Field: "file", Message: "This field is required"},
+		})
+		return
+	}
+	defer file.Close()
+
+	var validationErrors []utils.ErrorDetail
+	format, err := catalog.ParseFormat(r.FormValue("format"), header.Filename)
+	if err != nil {
+		validationErrors = append(validationErrors, utils.ErrorDetail{Field: "format", Message: "Must be one of: csv, ndjson, xlsx"})
+	}
+	var mapping catalog.Mapping
+	if raw := r.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			validationErrors = append(validationErrors, utils.ErrorDetail{Field: "mapping", Message: "Must be a JSON object of column names to fields"})
+		} else if err := mapping.Validate(); err != nil {
+			validationErrors = append(validationErrors, utils.ErrorDetail{Field: "mapping", Message: err.Error()})
+		}
+	}
+	match := r.FormValue("match")
+	if match == "" {
+		match = models.ImportMatchName
+	}
+	if match != models.ImportMatchName && match != models.ImportMatchSKU {
+		validationErrors = append(validationErrors, utils.ErrorDetail{Field: "match", Message: "Must be one of: name, sku"})
+	}
+	if len(validationErrors) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, h.Imports.MaxSize()+1))
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Error reading upload")
+		return
+	}
+	if int64(len(data)) > h.Imports.MaxSize() {
+		h.ErrorHdlr.HandleError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Import files can be at most %d bytes", h.Imports.MaxSize()))
+		return
+	}
+
+	job := &models.ProductImport{
+		Filename:  header.Filename,
+		Format:    format,
+		Mapping:   mapping,
+		Match:     match,
+		DryRun:    r.FormValue("dry_run") == "true",
+		CreatedBy: currentUser(r),
+	}
+	if err := h.Imports.Create(r.Context(), job, data); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error queueing import")
+		return
+	}
+
+	w.Header().Set("Location", "/product/imports/"+job.ID.Hex())
+	h.ResponseHdlr.JSON(w, http.StatusAccepted, utils.Response{
+		Status:  http.StatusAccepted,
+		Message: "Import queued",
+		Data:    job,
+	})
+}
+
+// GetProductImport handles fetching the progress and row errors of an
+// import
+func (h *Handler) GetProductImport(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid import ID")
+		return
+	}
+
+	job, err := h.Imports.Get(r.Context(), id)
+	if errors.Is(err, catalog.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Import not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching import")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Import fetched successfully", job)
+}
+
+// CancelProductImport handles stopping an import. Rows imported before it
+// stops keep their changes.
+func (h *Handler) CancelProductImport(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid import ID")
+		return
+	}
+
+	job, err := h.Imports.Cancel(r.Context(), id)
+	if errors.Is(err, catalog.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Import not found")
+		return
+	}
+	if errors.Is(err, jobs.ErrFinished) || errors.Is(err, jobs.ErrNotFound) {
+		h.ErrorHdlr.HandleConflict(w, "Import already finished")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error cancelling import")
+		return
+	}
+
+	if job.Status == models.ImportCancelled {
+		h.ResponseHdlr.Success(w, "Import cancelled", job)
+		return
+	}
+	h.ResponseHdlr.JSON(w, http.StatusAccepted, utils.Response{
+		Status:  http.StatusAccepted,
+		Message: "Import is being cancelled",
+		Data:    job,
+	})
+}
+
+// ExportProducts handles downloading the products of a product list as a
+// file. It takes the filter, sort, category, min_rating and search
+// parameters of GET /product and a format of csv (default), ndjson or
+// xlsx. Rows are written as they are read, without paging.
+func (h *Handler) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format := catalog.FormatCSV
+	if raw := r.URL.Query().Get("format"); raw != "" {
+		var err error
+		if format, err = catalog.ParseFormat(raw, ""); err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid format: "+err.Error())
+			return
+		}
+	}
+	q, err := h.parseProductQuery(r)
+	if err != nil {
+		h.handleQueryError(w, err)
+		return
+	}
+
+	cursor, err := h.DB.Database(h.Database).Collection("products").Find(ctx,
+		productListFilter(q, r.URL.Query().Get("search")),
+		options.Find().SetSort(q.SortDoc(false)).SetBatchSize(exportFlushEvery))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching products")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	w.Header().Set("Content-Type", catalog.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	writer, err := catalog.NewWriter(format, w, catalog.Columns)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error starting export")
+		return
+	}
+
+	// Once rows are written the status is sent, a failure can only cut the
+	// file short
+	rc := http.NewResponseController(w)
+	rows := 0
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			log.Printf("Error decoding exported product: %v", err)
+			return
+		}
+		if err := writer.Write(catalog.Row(product)); err != nil {
+			return
+		}
+		if rows++; rows%exportFlushEvery == 0 {
+			if writer.Flush() != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Error exporting products: %v", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("Error finishing product export: %v", err)
+	}
+}
+
+// ImportProduct creates or updates the product of an imported row. A row
+// matching an existing product by the import's match field only changes
+// the fields it gives, other rows must give every field a new product
+// needs.
+func (h *Handler) ImportProduct(ctx context.Context, job *models.ProductImport, item catalog.Item) (bool, []models.ImportRowError, error) {
+	rowError := func(field, message string) []models.ImportRowError {
+		return []models.ImportRowError{{Row: item.Row, Field: field, Message: message}}
+	}
+
+	var key bson.M
+	switch job.Match {
+	case models.ImportMatchSKU:
+		if item.SKU == "" {
+			return false, rowError(catalog.FieldSKU, "This field is required"), nil
+		}
+		key = bson.M{"sku": normalizeSKU(item.SKU)}
+	default:
+		if item.Name == "" {
+			return false, rowError(catalog.FieldName, "This field is required"), nil
+		}
+		key = bson.M{"name": item.Name}
+	}
+
+	// Names are not unique, a row matching several products is ambiguous
+	cursor, err := h.DB.Database(h.Database).Collection("products").
+		Find(ctx, trash.NotDeleted(key), options.Find().SetLimit(2))
+	if err != nil {
+		return false, nil, err
+	}
+	var matches []models.Product
+	if err := cursor.All(ctx, &matches); err != nil {
+		return false, nil, err
+	}
+	if len(matches) > 1 {
+		return false, rowError(job.Match, "Several products match, import by SKU instead"), nil
+	}
+
+	var category string
+	if item.Category != "" {
+		category = utils.Slugify(item.Category)
+		if _, err := h.findCategory(ctx, category); err == mongo.ErrNoDocuments {
+			return false, rowError(catalog.FieldCategory, "Category does not exist"), nil
+		} else if err != nil {
+			return false, nil, err
+		}
+	}
+	var prices []money.Money
+	if item.Prices != nil {
+		if prices, err = priceList(item.Prices); err != nil {
+			return false, rowError(catalog.FieldPrices, err.Error()), nil
+		}
+	}
+
+	if len(matches) == 0 {
+		errs, err := h.importNewProduct(ctx, job, item, category, prices)
+		return true, errs, err
+	}
+	errs, err := h.importProductUpdate(ctx, job, matches[0], item, category, prices)
+	return false, errs, err
+}
+
+// importNewProduct creates the product of an imported row
+func (h *Handler) importNewProduct(ctx context.Context, job *models.ProductImport, item catalog.Item,
+	category string, prices []money.Money) ([]models.ImportRowError, error) {
+	req := models.CreateProductRequest{
+		SKU:         item.SKU,
+		Name:        item.Name,
+		Description: item.Description,
+		Category:    item.Category,
+		Prices:      item.Prices,
+	}
+	if item.Price != nil {
+		req.Price = *item.Price
+	}
+	// Unlike the API, an import may create products that are out of stock
+	stock := 0
+	if item.Stock != nil {
+		stock = *item.Stock
+	}
+	errs := importValidationErrors(item.Row, validator.New().StructExcept(req, "Stock"))
+	if stock < 0 {
+		errs = append(errs, models.ImportRowError{Row: item.Row, Field: catalog.FieldStock, Message: "Must be at least 0"})
+	}
+	price, err := basePrice(req.Price)
+	if err != nil && len(errs) == 0 {
+		errs = append(errs, models.ImportRowError{Row: item.Row, Field: catalog.FieldPrice, Message: err.Error()})
+	}
+	if len(errs) > 0 || job.DryRun {
+		return errs, nil
+	}
+
+	product := models.Product{
+		ID:          primitive.NewObjectID(),
+		SKU:         normalizeSKU(item.SKU),
+		Name:        item.Name,
+		Description: item.Description,
+		Price:       price,
+		Prices:      prices,
+		Category:    category,
+		Stock:       stock,
+	}
+	err = h.insertProduct(ctx, product, job.CreatedBy)
+	if mongo.IsDuplicateKeyError(err) {
+		return []models.ImportRowError{{Row: item.Row, Field: catalog.FieldSKU, Message: "A product with this SKU already exists"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := h.Revisions.Record(ctx, product, models.RevisionCreate, job.CreatedBy, 0); err != nil {
+		log.Printf("Failed to record revision of product %s: %v", product.ID.Hex(), err)
+	}
+	h.auditImport(job, models.AuditCreate, product.ID.Hex(), nil, product)
+	return nil, nil
+}
+
+// importProductUpdate applies an imported row to the product it matched
+func (h *Handler) importProductUpdate(ctx context.Context, job *models.ProductImport, previous models.Product,
+	item catalog.Item, category string, prices []money.Money) ([]models.ImportRowError, error) {
+	req := models.UpdateProductRequest{
+		SKU:         item.SKU,
+		Name:        item.Name,
+		Description: item.Description,
+		Category:    item.Category,
+		Prices:      item.Prices,
+		Stock:       item.Stock,
+	}
+	errs := importValidationErrors(item.Row, validator.New().Struct(req))
+	// The request treats a zero price as not given, a row gives it
+	var price money.Money
+	if item.Price != nil {
+		var err error
+		if *item.Price <= 0 {
+			errs = append(errs, models.ImportRowError{Row: item.Row, Field: catalog.FieldPrice, Message: "Must be greater than 0"})
+		} else if price, err = basePrice(*item.Price); err != nil {
+			errs = append(errs, models.ImportRowError{Row: item.Row, Field: catalog.FieldPrice, Message: err.Error()})
+		}
+	}
+	if len(errs) > 0 || job.DryRun {
+		return errs, nil
+	}
+
+	update := bson.M{}
+	if item.SKU != "" {
+		update["sku"] = normalizeSKU(item.SKU)
+	}
+	if item.Name != "" {
+		update["name"] = item.Name
+	}
+	if item.Description != "" {
+		update["description"] = item.Description
+	}
+	if item.Price != nil {
+		update["price"] = price
+	}
+	if item.Prices != nil {
+		update["prices"] = prices
+	}
+	if category != "" {
+		update["category"] = category
+	}
+
+	updated, err := h.saveProduct(ctx, previous, update, item.Stock, job.CreatedBy)
+	var stockErr *inventoryError
+	if errors.As(err, &stockErr) {
+		return []models.ImportRowError{{Row: item.Row, Field: catalog.FieldStock, Message: stockErr.err.Error()}}, nil
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return []models.ImportRowError{{Row: item.Row, Message: "The product was deleted during the import"}}, nil
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return []models.ImportRowError{{Row: item.Row, Field: catalog.FieldSKU, Message: "A product with this SKU already exists"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Lists are invalidated once when the import finishes
+	if err := cache.DeleteCache(ctx, fmt.Sprintf(cache.ProductDetailPattern, updated.ID.Hex())); err != nil {
+		log.Printf("Failed to invalidate product detail cache: %v", err)
+	}
+	if err := h.Revisions.EnsureBaseline(ctx, previous, job.CreatedBy); err != nil {
+		log.Printf("Failed to record baseline revision of product %s: %v", updated.ID.Hex(), err)
+	}
+	if _, err := h.Revisions.Record(ctx, updated, models.RevisionUpdate, job.CreatedBy, 0); err != nil {
+		log.Printf("Failed to record revision of product %s: %v", updated.ID.Hex(), err)
+	}
+	h.auditImport(job, models.AuditUpdate, updated.ID.Hex(), previous, updated)
+	return nil, nil
+}
+
+// FinishImport invalidates the cached product lists once an import that
+// changed products is done
+func (h *Handler) FinishImport(ctx context.Context, job *models.ProductImport) {
+	if job.DryRun || job.Created+job.Updated == 0 {
+		return
+	}
+	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
+		log.Printf("Failed to invalidate product list cache: %v", err)
+	}
+}
+
+// auditImport records a change made by an import on behalf of the user who
+// started it
+func (h *Handler) auditImport(job *models.ProductImport, action, productID string, before, after interface{}) {
+	h.Audit.Record(models.AuditEntry{
+		Actor:        job.CreatedBy,
+		Action:       action,
+		ResourceType: models.AuditResourceProduct,
+		ResourceID:   productID,
+		Changes:      audit.Diff(before, after),
+		RequestID:    "import:" + job.ID.Hex(),
+	})
+}
+
+// importValidationErrors converts validation errors to row errors named
+// after the import fields
+func importValidationErrors(row int, err error) []models.ImportRowError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+	errs := make([]models.ImportRowError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		errs = append(errs, models.ImportRowError{
+			Row:     row,
+			Field:   strings.ToLower(fieldErr.Field()),
+			Message: utils.FormatValidationError(fieldErr),
+		})
+	}
+	return errs
+}
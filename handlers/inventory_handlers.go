@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/events"
+	"go-tutorial/inventory"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+)
+
+// optionalObjectID parses an optional hex ID, returning nil when it is empty
+func optionalObjectID(hex string) (*primitive.ObjectID, error) {
+	if hex == "" {
+		return nil, nil
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// inventoryError marks a failed stock change within a larger transaction,
+// so it gets the response of writeInventoryError
+type inventoryError struct {
+	err error
+}
+
+func (e *inventoryError) Error() string { return e.err.Error() }
+func (e *inventoryError) Unwrap() error { return e.err }
+
+// writeInventoryError writes the response for a failed stock change
+func (h *Handler) writeInventoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		h.ErrorHdlr.HandleConflict(w, "Insufficient stock")
+	case errors.Is(err, inventory.ErrVariantRequired):
+		h.ErrorHdlr.HandleBadRequest(w, "Stock of a product with variants is set per variant")
+	case errors.Is(err, inventory.ErrReservationNotActive):
+		h.ErrorHdlr.HandleConflict(w, "Reservation is no longer active")
+	case errors.Is(err, inventory.ErrNotFound):
+		h.ErrorHdlr.HandleNotFound(w, "Product, variant or reservation not found")
+	default:
+		h.ErrorHdlr.HandleInternalError(w, "Error updating stock")
+	}
+}
+
+// AdjustStock handles adding or removing stock of a product or variant. The
+// change is applied atomically and never takes stock below zero.
+func (h *Handler) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	var req models.AdjustStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	variantID, err := optionalObjectID(req.VariantID)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	// The stock, its movement and the StockChanged event commit together
+	var movement *models.StockMovement
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		var err error
+		movement, err = h.Inventory.Adjust(sc, objID, variantID, req.Delta, inventory.Change{
+			Reason: req.Reason,
+			Actor:  currentUser(r),
+			Note:   req.Note,
+		})
+		return nil, err
+	})
+	if err != nil {
+		h.writeInventoryError(w, err)
+		return
+	}
+
+	invalidateProductCache(ctx, objID.Hex())
+
+	before := bson.M{"stock": movement.Quantity - movement.Delta}
+	after := bson.M{"stock": movement.Quantity}
+	if variantID != nil {
+		before = bson.M{"variants": bson.M{variantID.Hex(): before}}
+		after = bson.M{"variants": bson.M{variantID.Hex(): after}}
+	}
+	h.audit(r, models.AuditUpdate, models.AuditResourceProduct, objID.Hex(), before, after)
+
+	h.ResponseHdlr.Success(w, "Stock adjusted successfully", movement)
+}
+
+// CreateReservation handles holding stock of a product or variant for a
+// limited time
+func (h *Handler) CreateReservation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	var req models.CreateReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	variantID, err := optionalObjectID(req.VariantID)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	ttl := inventory.DefaultReservationTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+
+	var reservation *models.Reservation
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		var err error
+		reservation, err = h.Inventory.Reserve(sc, objID, variantID, req.Quantity, ttl, currentUser(r))
+		return nil, err
+	})
+	if err != nil {
+		h.writeInventoryError(w, err)
+		return
+	}
+
+	invalidateProductCache(ctx, objID.Hex())
+
+	h.ResponseHdlr.Created(w, "Stock reserved successfully", reservation)
+}
+
+// CommitReservation handles keeping the stock of a reservation taken, e.g.
+// once the order it was held for is placed
+func (h *Handler) CommitReservation(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid reservation ID")
+		return
+	}
+
+	reservation, err := h.Inventory.Commit(r.Context(), objID)
+	if err != nil {
+		h.writeInventoryError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Reservation committed successfully", reservation)
+}
+
+// ReleaseReservation handles cancelling a reservation and returning its stock
+func (h *Handler) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid reservation ID")
+		return
+	}
+
+	var reservation *models.Reservation
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		var err error
+		reservation, err = h.Inventory.Release(sc, objID, currentUser(r))
+		return nil, err
+	})
+	if err != nil {
+		h.writeInventoryError(w, err)
+		return
+	}
+
+	invalidateProductCache(ctx, reservation.ProductID.Hex())
+
+	h.ResponseHdlr.Success(w, "Reservation released successfully", reservation)
+}
+
+// GetStockHistory handles listing the stock movements of a product, newest
+// first
+func (h *Handler) GetStockHistory(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Stock history supports page and limit pagination only")
+		return
+	}
+
+	movements, total, err := h.Inventory.History(r.Context(), objID, pageParams.Skip(), int64(pageParams.Limit))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching stock history")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Stock history fetched successfully", movements, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// ReconcileStock handles comparing the stored stock of a product and its
+// variants with the sum of their stock movements
+func (h *Handler) ReconcileStock(w http.ResponseWriter, r *http.Request) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	result, err := h.Inventory.Reconcile(r.Context(), objID)
+	if err != nil {
+		h.writeInventoryError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Stock reconciled successfully", result)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/catalog"
+	"go-tutorial/jobs"
+	"go-tutorial/models"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// reindexJob is the type of the job rebuilding the search index
+const reindexJob = "search.reindex"
+
+// reindexProgressEvery is how many indexed products go between progress
+// saves
+const reindexProgressEvery = 100
+
+// RegisterJobs registers the handlers of the background jobs the API
+// enqueues
+func (h *Handler) RegisterJobs(registry *jobs.Registry) {
+	registry.Register(catalog.ImportJob, 2, jobs.Typed(catalog.NewImporter(h.Imports, h).Run))
+	registry.Register(reindexJob, 1, h.reindexProducts)
+}
+
+// jobID reads the job ID of the URL, writing a bad request when it is
+// invalid
+func (h *Handler) jobID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid job ID")
+		return id, false
+	}
+	return id, true
+}
+
+// ListJobs handles listing background jobs, newest first. They can be
+// filtered by status and type.
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Jobs support page and limit pagination only")
+		return
+	}
+
+	filter := bson.M{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		switch status {
+		case models.JobQueued, models.JobRunning, models.JobSucceeded, models.JobFailed, models.JobCancelled:
+		default:
+			h.ErrorHdlr.HandleBadRequest(w, "status must be queued, running, succeeded, failed or cancelled")
+			return
+		}
+		filter["status"] = status
+	}
+	if jobType := r.URL.Query().Get("type"); jobType != "" {
+		filter["type"] = jobType
+	}
+
+	collection := h.DB.Database(h.Database).Collection(jobs.Collection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting jobs")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching jobs")
+		return
+	}
+	list := []models.Job{}
+	if err := cursor.All(ctx, &list); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing jobs data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Jobs fetched successfully", list, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// GetJob handles fetching the status, progress and result of a job
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.Jobs.Get(r.Context(), id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Job not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching job")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Job fetched successfully", job)
+}
+
+// CancelJob handles stopping a job. A queued job is cancelled at once, a
+// running one is asked to stop and answers 202 until its worker notices.
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.jobID(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.Jobs.Cancel(r.Context(), id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Job not found")
+		return
+	}
+	if errors.Is(err, jobs.ErrFinished) {
+		h.ErrorHdlr.HandleConflict(w, "Job already finished")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error cancelling job")
+		return
+	}
+
+	if job.Status == models.JobCancelled {
+		h.ResponseHdlr.Success(w, "Job cancelled", job)
+		return
+	}
+	h.ResponseHdlr.JSON(w, http.StatusAccepted, utils.Response{
+		Status:  http.StatusAccepted,
+		Message: "Job is being cancelled",
+		Data:    job,
+	})
+}
+
+// ReindexProducts handles queueing a rebuild of the search index from the
+// products collection
+func (h *Handler) ReindexProducts(w http.ResponseWriter, r *http.Request) {
+	job, err := h.Jobs.Enqueue(r.Context(), reindexJob, struct{}{}, jobs.Options{CreatedBy: currentUser(r)})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error queueing reindex")
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID.Hex())
+	h.ResponseHdlr.JSON(w, http.StatusAccepted, utils.Response{
+		Status:  http.StatusAccepted,
+		Message: "Reindex queued",
+		Data:    job,
+	})
+}
+
+// reindexProducts adds every product that is not in the trash to the search
+// index
+func (h *Handler) reindexProducts(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	collection := h.DB.Database(h.Database).Collection("products")
+	filter := trash.NotDeleted(bson.M{})
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	indexed := 0
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		if err := h.Search.Index(ctx, product); err != nil {
+			return nil, err
+		}
+		if indexed++; indexed%reindexProgressEvery == 0 {
+			if err := task.Progress(ctx, indexed, int(total), ""); err != nil {
+				log.Printf("Error saving progress of reindex: %v", err)
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	if err := task.Progress(ctx, indexed, int(total), ""); err != nil {
+		log.Printf("Error saving progress of reindex: %v", err)
+	}
+	return map[string]int{"indexed": indexed}, nil
+}
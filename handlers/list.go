@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/query"
+	"go-tutorial/utils"
+)
+
+// handleQueryError writes the response for a list query that could not be
+// built, a 400 for invalid parameters and a 500 otherwise
+func (h *Handler) handleQueryError(w http.ResponseWriter, err error) {
+	var queryErr *query.Error
+	if errors.As(err, &queryErr) {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+			{Field: queryErr.Field, Message: queryErr.Message},
+		})
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error processing query")
+}
+
+// itemCursor builds the cursor token pointing at an item of a list
+func itemCursor[T any](schema *query.Schema[T], q *query.Query, item T, id string) string {
+	return utils.EncodeCursor(utils.Cursor{
+		Sort:   q.SortKey(),
+		Values: schema.SortValues(q, item),
+		ID:     id,
+	})
+}
+
+// finishPage trims the extra item fetched to detect a following page, puts
+// items fetched backwards in the requested order and sets the page cursors
+func finishPage[T any](items []T, params utils.PageParams, page *utils.Page, cursor func(T) string) []T {
+	hasNext, hasPrev := params.PageLinks(len(items))
+	if len(items) > params.Limit {
+		items = items[:params.Limit]
+	}
+	if params.Before != nil {
+		slices.Reverse(items)
+	}
+
+	if len(items) > 0 {
+		if hasNext {
+			page.NextCursor = cursor(items[len(items)-1])
+		}
+		if hasPrev {
+			page.PrevCursor = cursor(items[0])
+		}
+	}
+	return items
+}
+
+// pageInMemory applies a query and page parameters to items already held in
+// memory. It mirrors the MongoDB query, returning up to Limit+1 items in
+// fetch order, and the number of items matching the filter.
+func pageInMemory[T any](items []T, schema *query.Schema[T], q *query.Query, params utils.PageParams, id func(T) string) ([]T, int64) {
+	key := func(item T) []interface{} {
+		return append(schema.SortValues(q, item), id(item))
+	}
+
+	var matched []T
+	for _, item := range items {
+		if schema.Match(q, item) {
+			matched = append(matched, item)
+		}
+	}
+	total := int64(len(matched))
+
+	slices.SortFunc(matched, func(a, b T) int {
+		return query.CompareKeys(q, key(a), key(b))
+	})
+
+	if c := params.After; c != nil {
+		cursorKey := append(append([]interface{}{}, c.Values...), c.ID)
+		matched = slices.DeleteFunc(matched, func(item T) bool {
+			return query.CompareKeys(q, key(item), cursorKey) <= 0
+		})
+	} else if c := params.Before; c != nil {
+		cursorKey := append(append([]interface{}{}, c.Values...), c.ID)
+		matched = slices.DeleteFunc(matched, func(item T) bool {
+			return query.CompareKeys(q, key(item), cursorKey) >= 0
+		})
+		slices.Reverse(matched)
+	}
+
+	skip := min(int(params.Skip()), len(matched))
+	matched = matched[skip:]
+	return matched[:min(params.Limit+1, len(matched))], total
+}
+
+// selectFields converts items to their sparse fieldset when fields were
+// requested, otherwise the items are returned unchanged
+func selectFields[T any](items []T, schema *query.Schema[T], q *query.Query) interface{} {
+	if len(q.Fields) == 0 {
+		return items
+	}
+	selected := make([]map[string]interface{}, len(items))
+	for i, item := range items {
+		selected[i] = schema.Select(q, item)
+	}
+	return selected
+}
+
+// pageNumber returns the page number asked for by the GraphQL and gRPC
+// lists, which page by number only
+func pageNumber(page int32) int {
+	if page < 1 {
+		return 1
+	}
+	return int(page)
+}
+
+// pageLimit returns the page size asked for, capped like the REST lists
+func pageLimit(limit int32) int {
+	if limit < 1 {
+		return utils.DefaultPageSize
+	}
+	return min(int(limit), utils.MaxPageSize)
+}
+
+// findPage fetches a page of the documents matching filter, newest first
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page, limit int) (pageOf[T], error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return pageOf[T]{}, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return pageOf[T]{}, err
+	}
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		return pageOf[T]{}, err
+	}
+	return pageOf[T]{items: items, total: total}, nil
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/live"
+	"go-tutorial/utils"
+)
+
+// sseHeartbeat is how often an idle event stream sends a comment, so
+// proxies keep the connection open
+const sseHeartbeat = 15 * time.Second
+
+// splitList splits a comma separated query parameter, dropping blanks
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// writeSSE writes one message of an event stream
+func writeSSE(w http.ResponseWriter, id, event string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// StreamProductEvents handles streaming product and stock changes as
+// Server-Sent Events. The category and product_ids parameters (comma
+// separated) narrow the stream. A client reconnecting with Last-Event-ID,
+// or the last_event_id parameter, first gets the events it missed; when
+// they are no longer buffered it gets a reset event and should reload.
+func (h *Handler) StreamProductEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var filter live.Filter
+	if categories := splitList(r.URL.Query().Get("category")); len(categories) > 0 {
+		filter.Categories = make(map[string]bool)
+		for _, category := range categories {
+			filter.Categories[utils.Slugify(category)] = true
+		}
+	}
+	if ids := splitList(r.URL.Query().Get("product_ids")); len(ids) > 0 {
+		filter.ProductIDs = make(map[string]bool)
+		for _, id := range ids {
+			if _, err := primitive.ObjectIDFromHex(id); err != nil {
+				h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID "+id)
+				return
+			}
+			filter.ProductIDs[id] = true
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	rc := http.NewResponseController(w)
+	sub, missed, resumed := h.Live.Subscribe(filter, lastEventID)
+	defer h.Live.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(msg live.Message) error {
+		data, err := json.Marshal(msg.Event)
+		if err != nil {
+			return err
+		}
+		return writeSSE(w, msg.Event.ID.Hex(), msg.Event.Type, data)
+	}
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !resumed {
+		writeSSE(w, "", "reset", []byte("{}"))
+	}
+	for _, msg := range missed {
+		if err := send(msg); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-sub.C:
+			// A closed channel means the client fell behind, it resumes
+			// from its last event when it reconnects
+			if !ok {
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
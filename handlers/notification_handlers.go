@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+	"go-tutorial/notifications"
+	"go-tutorial/utils"
+)
+
+const (
+	// wsWriteWait is how long a write to a notification socket may take
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a notification socket may go without a pong
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod is how often a notification socket is pinged, within
+	// wsPongWait
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// notificationUpgrader accepts cross-origin sockets, they are authenticated
+// by the access token rather than by cookies a page could reuse
+var notificationUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// socketMessage is a message sent over a notification socket
+type socketMessage struct {
+	Type         string               `json:"type"`
+	Unread       *int64               `json:"unread,omitempty"`
+	Notification *models.Notification `json:"notification,omitempty"`
+}
+
+// notify sends a notification to a user. A failure is logged, it never
+// fails the request that caused it.
+func (h *Handler) notify(ctx context.Context, userID, kind, title, body string, data map[string]interface{}) {
+	if _, err := h.Notifications.Send(ctx, userID, kind, title, body, data); err != nil {
+		log.Printf("Failed to notify user %s: %v", userID, err)
+	}
+}
+
+// ServeNotifications handles the notification socket of the authenticated
+// user. Browsers can't set headers on a socket, so the access token may be
+// given in the access_token parameter instead. The socket first gets a
+// hello message with the unread count, then every new notification.
+func (h *Handler) ServeNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r)
+	unread, err := h.Notifications.UnreadCount(r.Context(), userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting notifications")
+		return
+	}
+
+	conn, err := notificationUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	client, err := h.NotificationHub.Connect(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to connect notifications of user %s: %v", userID, err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""), time.Now().Add(wsWriteWait))
+		return
+	}
+	defer h.NotificationHub.Disconnect(client)
+
+	// Read until the client goes away, the socket only carries pongs and
+	// the close handshake from the client
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg socketMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteJSON(msg)
+	}
+	if err := send(socketMessage{Type: "hello", Unread: &unread}); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case notification, ok := <-client.C:
+			// A closed channel means the client fell behind, it lists what
+			// it missed when it reconnects
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(wsWriteWait))
+				return
+			}
+			if err := send(socketMessage{Type: "notification", Notification: &notification}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ListNotifications handles listing the authenticated user's
+// notifications, newest first. unread=true lists only unread ones.
+func (h *Handler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Notifications support page and limit pagination only")
+		return
+	}
+
+	list, total, err := h.Notifications.List(r.Context(), currentUser(r),
+		r.URL.Query().Get("unread") == "true", pageParams.Skip(), int64(pageParams.Limit))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching notifications")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Notifications fetched successfully", list, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// MarkNotificationRead handles marking one of the authenticated user's
+// notifications read
+func (h *Handler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid notification ID")
+		return
+	}
+
+	notification, err := h.Notifications.MarkRead(r.Context(), currentUser(r), id)
+	if errors.Is(err, notifications.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Notification not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating notification")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Notification marked read", notification)
+}
+
+// MarkAllNotificationsRead handles marking all of the authenticated user's
+// notifications read
+func (h *Handler) MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	count, err := h.Notifications.MarkAllRead(r.Context(), currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating notifications")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Notifications marked read", map[string]int64{"marked": count})
+}
+
+// GetUserPresence handles reporting whether a user has a notification
+// socket open on any instance
+func (h *Handler) GetUserPresence(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid user ID")
+		return
+	}
+
+	online, err := h.NotificationHub.Online(r.Context(), id)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking presence")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Presence fetched successfully", map[string]interface{}{
+		"user_id": id,
+		"online":  online,
+	})
+}
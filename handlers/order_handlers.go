@@ -0,0 +1,570 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/inventory"
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	"go-tutorial/query"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// orderQuerySchema lists the order fields usable in filter, sort and fields
+var orderQuerySchema = query.NewSchema("-created_at",
+	query.Field[models.Order]{Name: "id", Path: "_id", Selected: true,
+		Value: func(o models.Order) interface{} { return o.ID.Hex() }},
+	query.Field[models.Order]{Name: "user_id", Path: "user_id", Type: query.String, Filter: true,
+		Value: func(o models.Order) interface{} { return o.UserID }},
+	query.Field[models.Order]{Name: "status", Path: "status", Type: query.String, Filter: true, Sort: true,
+		Value: func(o models.Order) interface{} { return o.Status }},
+	query.Field[models.Order]{Name: "total", Path: "total.amount", Type: query.Integer, Filter: true, Sort: true,
+		Value:  func(o models.Order) interface{} { return o.Total.Amount },
+		Output: func(o models.Order) interface{} { return o.Total }},
+	query.Field[models.Order]{Name: "currency", Path: "total.currency", Type: query.String, Filter: true,
+		Value: func(o models.Order) interface{} { return o.Total.Currency }},
+	query.Field[models.Order]{Name: "items", Path: "items",
+		Value: func(o models.Order) interface{} { return o.Items }},
+	query.Field[models.Order]{Name: "created_at", Path: "created_at", Sort: true,
+		Value: func(o models.Order) interface{} { return o.CreatedAt }},
+)
+
+// errOrderChanged is returned when an order's status changed between being
+// read and updated
+var errOrderChanged = errors.New("order status changed concurrently")
+
+// outOfStockError names the cart item that could not be taken from stock
+type outOfStockError struct {
+	Name string
+	Err  error
+}
+
+func (e *outOfStockError) Error() string { return fmt.Sprintf("%s: %v", e.Name, e.Err) }
+func (e *outOfStockError) Unwrap() error { return e.Err }
+
+// canSeeAllOrders reports whether the current user may see orders of others
+func canSeeAllOrders(r *http.Request) bool {
+	return middleware.HasPermission(currentRole(r), middleware.PermissionListOrders)
+}
+
+// loadOrder fetches the order named by the id route variable, writing an
+// error response when it cannot or when it belongs to another user
+func (h *Handler) loadOrder(w http.ResponseWriter, r *http.Request) (*models.Order, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid order ID")
+		return nil, false
+	}
+
+	var order models.Order
+	err = h.DB.Database(h.Database).Collection("orders").
+		FindOne(r.Context(), bson.M{"_id": objID}).
+		Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Order not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching order")
+		return nil, false
+	}
+
+	// Orders of other users are reported as missing rather than forbidden
+	if order.UserID != currentUser(r) && !canSeeAllOrders(r) {
+		h.ErrorHdlr.HandleNotFound(w, "Order not found")
+		return nil, false
+	}
+	return &order, true
+}
+
+// cartProducts fetches the products in a cart keyed by ID. Products that no
+// longer exist are missing from the result.
+func (h *Handler) cartProducts(ctx context.Context, cart *models.Cart) (map[primitive.ObjectID]*models.Product, error) {
+	productIDs := make([]primitive.ObjectID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	cursor, err := h.DB.Database(h.Database).Collection("products").
+		Find(ctx, trash.NotDeleted(bson.M{"_id": bson.M{"$in": productIDs}}))
+	if err != nil {
+		return nil, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]*models.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+	return byID, nil
+}
+
+// Checkout handles turning the current user's cart into a pending order.
+// Stock of every item is taken, the order is created and the cart is
+// emptied in a single transaction, so either all of it happens or none.
+// Transactions need MongoDB to run as a replica set.
+func (h *Handler) Checkout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := currentUser(r)
+
+	cart, err := h.loadCart(ctx, userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching cart")
+		return
+	}
+	if len(cart.Items) == 0 {
+		h.ErrorHdlr.HandleBadRequest(w, "Cart is empty")
+		return
+	}
+
+	// Price the items at the current product prices
+	byID, err := h.cartProducts(ctx, cart)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching products")
+		return
+	}
+
+	items := make([]models.CartItem, 0, len(cart.Items))
+	var unavailable []utils.ErrorDetail
+	pricesChanged := false
+	for i, item := range cart.Items {
+		product, ok := byID[item.ProductID]
+		if !ok {
+			unavailable = append(unavailable, utils.ErrorDetail{Field: item.Name, Message: "Product is no longer available"})
+			continue
+		}
+		current, msg := h.sellableItem(product, item.VariantID, cart.Currency)
+		if msg != "" {
+			unavailable = append(unavailable, utils.ErrorDetail{Field: item.Name, Message: msg})
+			continue
+		}
+		current.Quantity = item.Quantity
+		if current.UnitPrice != item.UnitPrice || current.Name != item.Name {
+			cart.Items[i] = current
+			pricesChanged = true
+		}
+		items = append(items, current)
+	}
+	if len(unavailable) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, unavailable)
+		return
+	}
+
+	// Coupons may have expired or been used up since they were applied
+	coupons, problems, err := h.usableCoupons(ctx, cart.Coupons, userID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking coupons")
+		return
+	}
+	if len(problems) > 0 {
+		cart.Coupons = couponCodes(coupons)
+	}
+
+	if pricesChanged || len(problems) > 0 {
+		// Show the new prices before charging them
+		if err := h.saveCart(ctx, cart); err != nil {
+			h.writeCartError(w, err)
+			return
+		}
+		if len(problems) > 0 {
+			h.ErrorHdlr.HandleConflict(w, "Coupon "+problems[0].Field+" can no longer be used: "+problems[0].Message)
+			return
+		}
+		h.ErrorHdlr.HandleConflict(w, "Prices changed since items were added, please review your cart")
+		return
+	}
+
+	quote, err := h.quoteItems(ctx, h.discountItems(ctx, items, byID), cart.Currency, couponCodes(coupons))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error pricing order")
+		return
+	}
+
+	now := time.Now()
+	order := models.Order{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Subtotal:  quote.Subtotal,
+		Discount:  quote.Discount,
+		Total:     quote.Total,
+		Coupons:   quote.Coupons,
+		Status:    models.OrderPending,
+		History:   []models.OrderStatusChange{{To: models.OrderPending, Actor: userID, ChangedAt: now}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, line := range quote.Lines {
+		order.Items = append(order.Items, models.OrderItem{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			Name:      line.Name,
+			SKU:       line.SKU,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			Discounts: line.Discounts,
+			LineTotal: line.LineTotal,
+		})
+	}
+
+	session, err := h.DB.StartSession()
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error placing order")
+		return
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		for _, item := range order.Items {
+			_, err := h.Inventory.Adjust(sc, item.ProductID, item.VariantID, -item.Quantity, inventory.Change{
+				Reason:  models.MovementSale,
+				Actor:   userID,
+				OrderID: &order.ID,
+			})
+			if errors.Is(err, inventory.ErrInsufficientStock) || errors.Is(err, inventory.ErrNotFound) ||
+				errors.Is(err, inventory.ErrVariantRequired) {
+				return nil, &outOfStockError{Name: item.Name, Err: err}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, code := range order.Coupons {
+			if err := h.Discounts.Redeem(sc, *coupons[code], userID); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := h.DB.Database(h.Database).Collection("orders").InsertOne(sc, order); err != nil {
+			return nil, err
+		}
+
+		result, err := h.DB.Database(h.Database).Collection("carts").
+			DeleteOne(sc, bson.M{"_id": cart.ID, "updated_at": cart.UpdatedAt})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, errCartChanged
+		}
+		return nil, nil
+	})
+	if err != nil {
+		var stockErr *outOfStockError
+		switch {
+		case errors.Is(err, errCartChanged):
+			h.writeCartError(w, err)
+		case isCouponError(err):
+			h.ErrorHdlr.HandleConflict(w, "A coupon can no longer be used: "+err.Error())
+		case errors.As(err, &stockErr) && errors.Is(err, inventory.ErrInsufficientStock):
+			h.ErrorHdlr.HandleConflict(w, "Insufficient stock for "+stockErr.Name)
+		case errors.As(err, &stockErr):
+			h.ErrorHdlr.HandleConflict(w, stockErr.Name+" is no longer available")
+		default:
+			h.ErrorHdlr.HandleInternalError(w, "Error placing order")
+		}
+		return
+	}
+
+	for _, item := range order.Items {
+		invalidateProductCache(ctx, item.ProductID.Hex())
+	}
+
+	h.audit(r, models.AuditCreate, models.AuditResourceOrder, order.ID.Hex(), nil, order)
+
+	h.ResponseHdlr.Created(w, "Order placed successfully", order)
+}
+
+// GetOrders handles listing orders. Users see their own orders, roles with
+// the list orders permission see every order and may filter by user_id.
+// Orders can also be filtered by creation time with created_from and
+// created_to (RFC 3339), and by total in minor units of their currency.
+func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse filter, sort and sparse fieldset
+	q, err := orderQuerySchema.Parse(r.URL.Query())
+	if err == nil && !canSeeAllOrders(r) {
+		err = orderQuerySchema.Where(q, "user_id", query.OpEq, currentUser(r))
+	}
+	if err != nil {
+		h.handleQueryError(w, err)
+		return
+	}
+
+	filter := q.Filter()
+	created := bson.M{}
+	for param, op := range map[string]string{"created_from": "$gte", "created_to": "$lte"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{
+					{Field: param, Message: "must be an RFC 3339 timestamp"},
+				})
+				return
+			}
+			created[op] = t
+		}
+	}
+	if len(created) > 0 {
+		filter = bson.M{"$and": []bson.M{filter, {"created_at": created}}}
+	}
+
+	// Orders change status often, so they are paged by offset only
+	pageParams, err := utils.ParsePageParams(r, q.SortKey())
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Orders support page and limit pagination only")
+		return
+	}
+
+	ordersCollection := h.DB.Database(h.Database).Collection("orders")
+	page := utils.Page{Number: pageParams.Page, Limit: pageParams.Limit}
+	if pageParams.IncludeTotal {
+		total, err := ordersCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error counting orders")
+			return
+		}
+		page.Total = &total
+	}
+
+	opts := options.Find().
+		SetLimit(int64(pageParams.Limit)).
+		SetSkip(pageParams.Skip()).
+		SetSort(q.SortDoc(false))
+	if projection := orderQuerySchema.Projection(q); projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := ordersCollection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching orders")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	orders := []models.Order{}
+	if err := cursor.All(ctx, &orders); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing orders data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Orders fetched successfully",
+		selectFields(orders, orderQuerySchema, q), page)
+}
+
+// GetOrderDetails handles retrieving a single order
+func (h *Handler) GetOrderDetails(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.loadOrder(w, r)
+	if !ok {
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Order details fetched successfully", order)
+}
+
+// orderCoupons returns the IDs of the coupon rules used on an order
+func orderCoupons(order *models.Order) []primitive.ObjectID {
+	var ids []primitive.ObjectID
+	for _, item := range order.Items {
+		for _, d := range item.Discounts {
+			if d.Code != "" && !slices.Contains(ids, d.RuleID) {
+				ids = append(ids, d.RuleID)
+			}
+		}
+	}
+	return ids
+}
+
+// transitionOrder moves an order to status. The update only applies if the
+// order still has the status it was read with. Cancelling or refunding an
+// order whose goods were not shipped puts its stock back, and cancelling
+// releases its coupons, in the same transaction.
+func (h *Handler) transitionOrder(ctx context.Context, order *models.Order, status, actor, note string) error {
+	restock := ""
+	if order.Status == models.OrderPending || order.Status == models.OrderPaid {
+		switch status {
+		case models.OrderCancelled:
+			restock = models.MovementOrderCancelled
+		case models.OrderRefunded:
+			restock = models.MovementOrderRefunded
+		}
+	}
+
+	now := time.Now()
+	change := models.OrderStatusChange{
+		From:      order.Status,
+		To:        status,
+		Actor:     actor,
+		Note:      note,
+		ChangedAt: now,
+	}
+
+	session, err := h.DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := h.DB.Database(h.Database).Collection("orders").UpdateOne(sc,
+			bson.M{"_id": order.ID, "status": order.Status},
+			bson.M{
+				"$set":  bson.M{"status": status, "updated_at": now},
+				"$push": bson.M{"history": change},
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errOrderChanged
+		}
+
+		// A cancelled order gives its coupons back
+		if status == models.OrderCancelled {
+			for _, ruleID := range orderCoupons(order) {
+				if err := h.Discounts.Release(sc, ruleID, order.UserID); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if restock == "" {
+			return nil, nil
+		}
+		for _, item := range order.Items {
+			_, err := h.Inventory.Adjust(sc, item.ProductID, item.VariantID, item.Quantity, inventory.Change{
+				Reason:  restock,
+				Actor:   actor,
+				OrderID: &order.ID,
+			})
+			if errors.Is(err, inventory.ErrNotFound) {
+				// The product or variant was deleted since, nothing to restock
+				log.Printf("Skipping restock of deleted product %s for order %s", item.ProductID.Hex(), order.ID.Hex())
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	order.Status = status
+	order.UpdatedAt = now
+	order.History = append(order.History, change)
+	if restock != "" {
+		for _, item := range order.Items {
+			invalidateProductCache(ctx, item.ProductID.Hex())
+		}
+	}
+
+	// Customers hear about changes they didn't make themselves
+	if actor != order.UserID {
+		h.notify(ctx, order.UserID, models.NotificationOrderStatus,
+			"Order "+status, "Your order "+order.ID.Hex()+" is now "+status+".",
+			map[string]interface{}{"order_id": order.ID.Hex(), "status": status})
+	}
+	return nil
+}
+
+// writeTransitionError writes the response for a failed status change
+func (h *Handler) writeTransitionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errOrderChanged) {
+		h.ErrorHdlr.HandleConflict(w, "Order status was changed by another request, please retry")
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error updating order status")
+}
+
+// UpdateOrderStatus handles moving an order to another status. Only the
+// transitions in models.OrderTransitions are allowed.
+func (h *Handler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateOrderStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	order, ok := h.loadOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if !models.CanTransition(order.Status, req.Status) {
+		h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("Order cannot move from %s to %s", order.Status, req.Status))
+		return
+	}
+
+	from := order.Status
+	if err := h.transitionOrder(r.Context(), order, req.Status, currentUser(r), req.Note); err != nil {
+		h.writeTransitionError(w, err)
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceOrder, order.ID.Hex(),
+		bson.M{"status": from}, bson.M{"status": order.Status})
+
+	h.ResponseHdlr.Success(w, "Order status updated successfully", order)
+}
+
+// CancelOrder handles a user cancelling their own pending order
+func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := h.loadOrder(w, r)
+	if !ok {
+		return
+	}
+
+	if order.UserID != currentUser(r) {
+		h.ErrorHdlr.HandleForbidden(w, "Only the customer can cancel an order this way")
+		return
+	}
+	if order.Status != models.OrderPending {
+		h.ErrorHdlr.HandleConflict(w, "Only pending orders can be cancelled")
+		return
+	}
+
+	if err := h.transitionOrder(r.Context(), order, models.OrderCancelled, currentUser(r), "Cancelled by customer"); err != nil {
+		h.writeTransitionError(w, err)
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceOrder, order.ID.Hex(),
+		bson.M{"status": models.OrderPending}, bson.M{"status": order.Status})
+
+	h.ResponseHdlr.Success(w, "Order cancelled successfully", order)
+}
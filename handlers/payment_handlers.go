@@ -0,0 +1,532 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/payments"
+	"go-tutorial/utils"
+)
+
+// maxWebhookSize caps the size of webhook request bodies
+const maxWebhookSize = 1 << 20
+
+// findPayment fetches a payment by filter, nil when there is none
+func (h *Handler) findPayment(ctx context.Context, filter bson.M) (*models.Payment, error) {
+	var payment models.Payment
+	err := h.DB.Database(h.Database).Collection("payments").FindOne(ctx, filter).Decode(&payment)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// updatePaymentStatus moves a payment to status if it is in one of the from
+// statuses, reporting whether it did. Repeated events therefore apply once.
+func (h *Handler) updatePaymentStatus(ctx context.Context, paymentID primitive.ObjectID, from []string, status string, set bson.M) (bool, error) {
+	update := bson.M{"status": status, "updated_at": time.Now()}
+	for k, v := range set {
+		update[k] = v
+	}
+	changes := bson.M{"$set": update}
+	if status == models.PaymentFailed {
+		changes["$unset"] = bson.M{"open": "", "next_action_url": ""}
+	}
+
+	result, err := h.DB.Database(h.Database).Collection("payments").UpdateOne(ctx,
+		bson.M{"_id": paymentID, "status": bson.M{"$in": from}},
+		changes,
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// moveOrder moves the order of a payment to status when the order workflow
+// allows it, e.g. to paid once the payment is captured
+func (h *Handler) moveOrder(ctx context.Context, orderID primitive.ObjectID, status, note string) {
+	var order models.Order
+	err := h.DB.Database(h.Database).Collection("orders").
+		FindOne(ctx, bson.M{"_id": orderID}).
+		Decode(&order)
+	if err != nil {
+		log.Printf("Failed to load order %s for payment update: %v", orderID.Hex(), err)
+		return
+	}
+	if order.Status == status {
+		return
+	}
+	if !models.CanTransition(order.Status, status) {
+		log.Printf("Order %s cannot move from %s to %s after payment update", orderID.Hex(), order.Status, status)
+		return
+	}
+	if err := h.transitionOrder(ctx, &order, status, "system", note); err != nil {
+		log.Printf("Failed to move order %s to %s: %v", orderID.Hex(), status, err)
+	}
+}
+
+// capturePayment takes the money of an authorized payment and marks its
+// order as paid
+func (h *Handler) capturePayment(ctx context.Context, payment *models.Payment) error {
+	if _, err := h.Payments.Capture(ctx, payment.ProviderRef); err != nil {
+		return err
+	}
+	return h.markCaptured(ctx, payment)
+}
+
+// markCaptured records that the provider took the money of a payment
+func (h *Handler) markCaptured(ctx context.Context, payment *models.Payment) error {
+	changed, err := h.updatePaymentStatus(ctx, payment.ID,
+		[]string{models.PaymentPending, models.PaymentRequiresAction, models.PaymentAuthorized},
+		models.PaymentCaptured, bson.M{"next_action_url": ""})
+	if err != nil {
+		return err
+	}
+	if changed {
+		h.moveOrder(ctx, payment.OrderID, models.OrderPaid, "Payment captured")
+	}
+	return nil
+}
+
+// recordRefund adds a refund to a payment once, keyed by the provider's
+// refund reference, and refunds the order when the payment is fully
+// refunded. The refund and the new payment status are written together. A
+// refund recorded before still refunds the order, in case that didn't
+// happen the first time.
+func (h *Handler) recordRefund(ctx context.Context, payment *models.Payment, refund models.PaymentRefund) error {
+	payments := h.DB.Database(h.Database).Collection("payments")
+	var updated models.Payment
+	err := payments.FindOneAndUpdate(ctx,
+		bson.M{"_id": payment.ID, "refunds.reference": bson.M{"$ne": refund.Reference}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				// The refund is taken as is, its reason may hold a "$"
+				"refunds":                bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$refunds", bson.A{}}}, bson.A{bson.M{"$literal": refund}}}},
+				"refunded_amount.amount": bson.M{"$add": bson.A{"$refunded_amount.amount", refund.Amount.Amount}},
+				"updated_at":             time.Now(),
+			}}},
+			{{Key: "$set", Value: bson.M{"status": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$refunded_amount.amount", "$amount.amount"}},
+				models.PaymentRefunded,
+				models.PaymentPartiallyRefunded,
+			}}}}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		// Already recorded
+		err = payments.FindOne(ctx, bson.M{"_id": payment.ID}).Decode(&updated)
+	}
+	if err != nil {
+		return err
+	}
+	if updated.Status == models.PaymentRefunded {
+		h.moveOrder(ctx, payment.OrderID, models.OrderRefunded, "Payment refunded")
+	}
+	*payment = updated
+	return nil
+}
+
+// applyIntent stores the provider's view of a new payment and captures it
+// when it was authorized straight away
+func (h *Handler) applyIntent(ctx context.Context, payment *models.Payment, intent *payments.Intent) error {
+	var err error
+	switch intent.Status {
+	case payments.IntentRequiresAction:
+		_, err = h.updatePaymentStatus(ctx, payment.ID, []string{models.PaymentPending},
+			models.PaymentRequiresAction, bson.M{"next_action_url": intent.NextActionURL})
+	case payments.IntentRequiresCapture:
+		if _, err = h.updatePaymentStatus(ctx, payment.ID, []string{models.PaymentPending},
+			models.PaymentAuthorized, nil); err == nil {
+			err = h.capturePayment(ctx, payment)
+		}
+	case payments.IntentSucceeded:
+		err = h.markCaptured(ctx, payment)
+	case payments.IntentFailed:
+		_, err = h.updatePaymentStatus(ctx, payment.ID, []string{models.PaymentPending},
+			models.PaymentFailed, bson.M{"failure_reason": intent.FailureReason})
+	}
+	return err
+}
+
+// loadPayment fetches the payment named by the id route variable, writing an
+// error response when it cannot or when it belongs to another user
+func (h *Handler) loadPayment(w http.ResponseWriter, r *http.Request) (*models.Payment, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid payment ID")
+		return nil, false
+	}
+
+	payment, err := h.findPayment(r.Context(), bson.M{"_id": objID})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching payment")
+		return nil, false
+	}
+	if payment == nil || (payment.UserID != currentUser(r) && !canSeeAllOrders(r)) {
+		h.ErrorHdlr.HandleNotFound(w, "Payment not found")
+		return nil, false
+	}
+	return payment, true
+}
+
+// CreatePayment handles paying for a pending order of the current user. The
+// response carries the payment status, with a next action URL when the payer
+// must authenticate before the payment completes.
+func (h *Handler) CreatePayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.CreatePaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	order, ok := h.loadOrder(w, r)
+	if !ok {
+		return
+	}
+	if order.UserID != currentUser(r) {
+		h.ErrorHdlr.HandleForbidden(w, "Only the customer can pay for an order")
+		return
+	}
+	if order.Status != models.OrderPending {
+		h.ErrorHdlr.HandleConflict(w, "Only pending orders can be paid")
+		return
+	}
+
+	now := time.Now()
+	payment := models.Payment{
+		ID:             primitive.NewObjectID(),
+		OrderID:        order.ID,
+		UserID:         order.UserID,
+		Amount:         order.Total,
+		RefundedAmount: money.Zero(order.Total.Currency),
+		Provider:       h.Payments.Name(),
+		Status:         models.PaymentPending,
+		Open:           true,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	for _, item := range order.Items {
+		payment.Lines = append(payment.Lines, models.PaymentLine{
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Name:      item.Name,
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			UnitPrice: item.UnitPrice,
+		})
+	}
+
+	paymentsCollection := h.DB.Database(h.Database).Collection("payments")
+	if _, err := paymentsCollection.InsertOne(ctx, payment); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			h.ErrorHdlr.HandleConflict(w, "Order already has a payment in progress")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error creating payment")
+		return
+	}
+
+	intent, err := h.Payments.CreateIntent(ctx, payments.IntentRequest{
+		Amount:         payment.Amount,
+		PaymentMethod:  req.PaymentMethod,
+		Description:    "Order " + order.ID.Hex(),
+		IdempotencyKey: payment.ID.Hex(),
+	})
+	if err != nil {
+		reason := "provider_error"
+		if errors.Is(err, payments.ErrInvalidPaymentMethod) {
+			reason = "invalid_payment_method"
+		}
+		h.updatePaymentStatus(ctx, payment.ID, []string{models.PaymentPending},
+			models.PaymentFailed, bson.M{"failure_reason": reason})
+		if reason == "invalid_payment_method" {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid payment method")
+			return
+		}
+		h.ErrorHdlr.HandleError(w, http.StatusBadGateway, "Payment provider error")
+		return
+	}
+
+	_, err = paymentsCollection.UpdateOne(ctx, bson.M{"_id": payment.ID},
+		bson.M{"$set": bson.M{"provider_ref": intent.Reference}})
+	if err == nil {
+		payment.ProviderRef = intent.Reference
+		err = h.applyIntent(ctx, &payment, intent)
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating payment")
+		return
+	}
+
+	current, err := h.findPayment(ctx, bson.M{"_id": payment.ID})
+	if err != nil || current == nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching payment")
+		return
+	}
+
+	h.audit(r, models.AuditCreate, models.AuditResourcePayment, current.ID.Hex(), nil, current)
+
+	h.ResponseHdlr.Created(w, "Payment created successfully", current)
+}
+
+// GetPayment handles retrieving a single payment
+func (h *Handler) GetPayment(w http.ResponseWriter, r *http.Request) {
+	payment, ok := h.loadPayment(w, r)
+	if !ok {
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Payment fetched successfully", payment)
+}
+
+// GetOrderPayments handles listing the payments of an order, newest first
+func (h *Handler) GetOrderPayments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	order, ok := h.loadOrder(w, r)
+	if !ok {
+		return
+	}
+
+	cursor, err := h.DB.Database(h.Database).Collection("payments").Find(ctx,
+		bson.M{"order_id": order.ID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching payments")
+		return
+	}
+	defer cursor.Close(ctx)
+
+	list := []models.Payment{}
+	if err := cursor.All(ctx, &list); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing payments data")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Payments fetched successfully", list)
+}
+
+// RefundPayment handles refunding part or all of a captured payment
+func (h *Handler) RefundPayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.RefundPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	payment, ok := h.loadPayment(w, r)
+	if !ok {
+		return
+	}
+	if payment.Status != models.PaymentCaptured && payment.Status != models.PaymentPartiallyRefunded {
+		h.ErrorHdlr.HandleConflict(w, "Only captured payments can be refunded")
+		return
+	}
+	previous := *payment
+
+	remaining, err := payment.Amount.Sub(payment.RefundedAmount)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error computing refundable amount")
+		return
+	}
+	amount := remaining
+	if req.Amount > 0 {
+		if amount, err = money.FromMajor(req.Amount, payment.Amount.Currency); err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid amount: "+err.Error())
+			return
+		}
+	}
+	if amount.Amount > remaining.Amount {
+		h.ErrorHdlr.HandleBadRequest(w, "Refund exceeds the amount left to refund")
+		return
+	}
+
+	refund, err := h.Payments.Refund(ctx, payment.ProviderRef, amount)
+	if err != nil {
+		if errors.Is(err, payments.ErrInvalidState) {
+			h.ErrorHdlr.HandleConflict(w, "Payment provider rejected the refund")
+			return
+		}
+		h.ErrorHdlr.HandleError(w, http.StatusBadGateway, "Payment provider error")
+		return
+	}
+
+	err = h.recordRefund(ctx, payment, models.PaymentRefund{
+		Reference: refund.Reference,
+		Amount:    refund.Amount,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error recording refund")
+		return
+	}
+
+	current, err := h.findPayment(ctx, bson.M{"_id": payment.ID})
+	if err != nil || current == nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching payment")
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourcePayment, current.ID.Hex(), previous, current)
+
+	h.ResponseHdlr.Success(w, "Payment refunded successfully", current)
+}
+
+// PaymentWebhook handles notifications from the payment provider. Requests
+// must carry a valid signature. Each event is applied at most once, and
+// every state change is conditional on the current payment status, so
+// retried and out of order deliveries are harmless.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	event, err := h.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid webhook signature")
+		return
+	}
+
+	events := h.DB.Database(h.Database).Collection("payment_events")
+	if err := events.FindOne(ctx, bson.M{"_id": event.ID}).Err(); err == nil {
+		h.ResponseHdlr.Success(w, "Event already processed", nil)
+		return
+	} else if err != mongo.ErrNoDocuments {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing event")
+		return
+	}
+
+	payment, err := h.findPayment(ctx, bson.M{"provider": h.Payments.Name(), "provider_ref": event.Reference})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching payment")
+		return
+	}
+	if payment == nil {
+		// The provider retries, the payment may not be stored yet
+		h.ErrorHdlr.HandleNotFound(w, "Payment not found")
+		return
+	}
+
+	switch event.Type {
+	case payments.EventAuthorized:
+		var changed bool
+		changed, err = h.updatePaymentStatus(ctx, payment.ID,
+			[]string{models.PaymentPending, models.PaymentRequiresAction},
+			models.PaymentAuthorized, bson.M{"next_action_url": ""})
+		if err == nil && changed {
+			err = h.capturePayment(ctx, payment)
+		}
+	case payments.EventCaptured:
+		err = h.markCaptured(ctx, payment)
+	case payments.EventFailed:
+		_, err = h.updatePaymentStatus(ctx, payment.ID,
+			[]string{models.PaymentPending, models.PaymentRequiresAction, models.PaymentAuthorized},
+			models.PaymentFailed, bson.M{"failure_reason": event.Reason})
+	case payments.EventRefunded:
+		err = h.recordRefund(ctx, payment, models.PaymentRefund{
+			Reference: event.RefundRef,
+			Amount:    event.Amount,
+			CreatedAt: time.Now(),
+		})
+	default:
+		log.Printf("Ignoring payment event %s of type %s", event.ID, event.Type)
+	}
+	if err != nil {
+		log.Printf("Failed to apply payment event %s: %v", event.ID, err)
+		h.ErrorHdlr.HandleInternalError(w, "Error processing event")
+		return
+	}
+
+	_, err = events.InsertOne(ctx, models.PaymentEvent{
+		ID:          event.ID,
+		Provider:    h.Payments.Name(),
+		Type:        event.Type,
+		PaymentID:   payment.ID,
+		ProcessedAt: time.Now(),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Failed to record payment event %s: %v", event.ID, err)
+	}
+
+	h.ResponseHdlr.Success(w, "Event processed successfully", nil)
+}
+
+// FakeAuthenticate stands in for the bank's 3-D Secure page when the fake
+// provider is used. The result query parameter is approve or decline.
+func (h *Handler) FakeAuthenticate(w http.ResponseWriter, r *http.Request) {
+	fake, ok := h.Payments.(*payments.FakeProvider)
+	if !ok {
+		h.ErrorHdlr.HandleNotFound(w, "Not found")
+		return
+	}
+
+	approve := r.URL.Query().Get("result") != "decline"
+	err := fake.Authenticate(mux.Vars(r)["reference"], approve)
+	switch {
+	case errors.Is(err, payments.ErrUnknownIntent):
+		h.ErrorHdlr.HandleNotFound(w, "Payment intent not found")
+	case errors.Is(err, payments.ErrInvalidState):
+		h.ErrorHdlr.HandleConflict(w, "Payment does not require authentication")
+	case err != nil:
+		h.ErrorHdlr.HandleInternalError(w, "Error authenticating payment")
+	default:
+		h.ResponseHdlr.Success(w, "Payment authentication completed", nil)
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+)
+
+// parseBasePrice reads a price filter written in major units of the base
+// currency as the minor units prices are stored in
+func parseBasePrice(raw string) (interface{}, error) {
+	price, err := money.Parse(raw, money.Base())
+	if err != nil {
+		return nil, err
+	}
+	return price.Amount, nil
+}
+
+// basePrice converts a request price in major units of the base currency
+func basePrice(amount float64) (money.Money, error) {
+	return money.FromMajor(amount, money.Base())
+}
+
+// variantPrice converts an optional variant price in major units of the base
+// currency, nil keeps the product price
+func variantPrice(amount *float64) (*money.Money, error) {
+	if amount == nil {
+		return nil, nil
+	}
+	price, err := basePrice(*amount)
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// priceList converts request prices keyed by currency code to the sorted
+// list stored on products. The base currency is skipped, Price holds it.
+func priceList(prices map[string]float64) ([]money.Money, error) {
+	list := make([]money.Money, 0, len(prices))
+	for code, amount := range prices {
+		price, err := money.FromMajor(amount, code)
+		if err != nil {
+			return nil, err
+		}
+		if price.Currency == money.Base() {
+			continue
+		}
+		list = append(list, price)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list, nil
+}
+
+// requestCurrency reads the currency query parameter, empty when prices
+// should stay in the base currency
+func (h *Handler) requestCurrency(r *http.Request) (string, error) {
+	return h.parseCurrency(r.URL.Query().Get("currency"))
+}
+
+// parseCurrency checks a currency prices are asked in has an exchange rate,
+// empty keeps the base currency
+func (h *Handler) parseCurrency(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	currency, err := money.Normalize(raw)
+	if err != nil {
+		return "", err
+	}
+	if !h.Rates.Has(currency) {
+		return "", fmt.Errorf("%w %s", money.ErrNoRate, currency)
+	}
+	return currency, nil
+}
+
+// priceIn returns the price of a product, or of one of its variants, in a
+// currency. A fixed price for the currency wins over converting the base
+// price, variant prices are always converted.
+func (h *Handler) priceIn(product models.Product, variant *models.Variant, currency string) (money.Money, error) {
+	if variant != nil && variant.Price != nil {
+		return h.Rates.Convert(*variant.Price, currency)
+	}
+	for _, price := range product.Prices {
+		if price.Currency == currency {
+			return price, nil
+		}
+	}
+	return h.Rates.Convert(product.Price, currency)
+}
+
+// localizeProduct rewrites the price, variant prices and price range of a
+// product in a currency
+func (h *Handler) localizeProduct(product *models.Product, currency string) error {
+	if currency == "" {
+		return nil
+	}
+
+	for i := range product.Variants {
+		variant := &product.Variants[i]
+		price, err := h.priceIn(*product, variant, currency)
+		if err != nil {
+			return err
+		}
+		if variant.Price != nil {
+			variant.Price = &price
+		}
+
+		if product.PriceRange == nil {
+			continue
+		}
+		if i == 0 {
+			product.PriceRange = &models.PriceRange{Min: price, Max: price}
+		}
+		if price.Amount < product.PriceRange.Min.Amount {
+			product.PriceRange.Min = price
+		}
+		if price.Amount > product.PriceRange.Max.Amount {
+			product.PriceRange.Max = price
+		}
+	}
+
+	price, err := h.priceIn(*product, nil, currency)
+	if err != nil {
+		return err
+	}
+	product.Price = price
+	return nil
+}
+
+// localizeProducts rewrites the prices of a list of products in a currency
+func (h *Handler) localizeProducts(products []models.Product, currency string) error {
+	for i := range products {
+		if err := h.localizeProduct(&products[i], currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,119 +1,245 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"regexp"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"go-tutorial/cache"
+	"go-tutorial/events"
+	"go-tutorial/inventory"
 	"go-tutorial/models"
+	"go-tutorial/query"
+	"go-tutorial/trash"
 	"go-tutorial/utils"
 )
 
-// GetProducts handles retrieving a list of products with basic filtering and sorting
-func (h *Handler) GetProducts(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// productQuerySchema lists the product fields usable in filter, sort and fields
+var productQuerySchema = query.NewSchema("name",
+	query.Field[models.Product]{Name: "id", Path: "_id", Selected: true,
+		Value: func(p models.Product) interface{} { return p.ID.Hex() }},
+	query.Field[models.Product]{Name: "sku", Path: "sku", Type: query.String, Filter: true,
+		Value: func(p models.Product) interface{} { return p.SKU }},
+	query.Field[models.Product]{Name: "name", Path: "name", Type: query.String, Filter: true, Sort: true,
+		Value: func(p models.Product) interface{} { return p.Name }},
+	query.Field[models.Product]{Name: "description", Path: "description",
+		Value: func(p models.Product) interface{} { return p.Description }},
+	query.Field[models.Product]{Name: "price", Path: "price.amount", Type: query.Number, Filter: true, Sort: true,
+		Value:  func(p models.Product) interface{} { return p.Price.Amount },
+		Output: func(p models.Product) interface{} { return p.Price },
+		Parse:  parseBasePrice},
+	query.Field[models.Product]{Name: "category", Path: "category", Type: query.String, Filter: true, Sort: true,
+		Value: func(p models.Product) interface{} { return p.Category }},
+	query.Field[models.Product]{Name: "stock", Path: "stock", Type: query.Integer, Filter: true, Sort: true,
+		Value: func(p models.Product) interface{} { return p.Stock }},
+	query.Field[models.Product]{Name: "images", Path: "images",
+		Value: func(p models.Product) interface{} { return p.Images }},
+	query.Field[models.Product]{Name: "rating", Path: "rating.average", Type: query.Number, Filter: true, Sort: true,
+		Value:  func(p models.Product) interface{} { return p.Rating.Average },
+		Output: func(p models.Product) interface{} { return p.Rating }},
+).
+	WithSortAlias("price_asc", "price").
+	WithSortAlias("price_desc", "-price").
+	WithSortAlias("name_asc", "name").
+	WithSortAlias("name_desc", "-name").
+	WithSortAlias("rating_desc", "-rating")
+
+// whereCategory restricts a product query to a category. With
+// include_descendants=true products of its subcategories are included too.
+func (h *Handler) whereCategory(r *http.Request, q *query.Query, category string) error {
+	if r.URL.Query().Get("include_descendants") != "true" {
+		return productQuerySchema.Where(q, "category", query.OpEq, category)
+	}
+
+	slugs, err := h.categorySubtree(r.Context(), utils.Slugify(category))
+	if err == mongo.ErrNoDocuments {
+		return &query.Error{Field: "category", Message: "category does not exist"}
+	}
+	if err != nil {
+		return err
+	}
+	return productQuerySchema.Where(q, "category", query.OpIn, slugs...)
+}
 
-	// Get pagination parameters
-	pageStr := r.URL.Query().Get("page")
-	limitStr := r.URL.Query().Get("limit")
-	page := 1
-	limit := 10
-
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
+// invalidateProductCache deletes the cached details of a product and all
+// cached product lists
+func invalidateProductCache(ctx context.Context, productID string) {
+	detailCacheKey := fmt.Sprintf(cache.ProductDetailPattern, productID)
+	if err := cache.DeleteCache(ctx, detailCacheKey); err != nil {
+		log.Printf("Failed to invalidate product detail cache: %v", err)
+	}
+	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
+		log.Printf("Failed to invalidate product list cache: %v", err)
+	}
+}
+
+// productID returns the hex ID of a product
+func productID(p models.Product) string { return p.ID.Hex() }
+
+// parseProductQuery reads the filter, sort and sparse fieldset of a product
+// list, including the legacy category and min_rating parameters
+func (h *Handler) parseProductQuery(r *http.Request) (*query.Query, error) {
+	q, err := productQuerySchema.Parse(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if category := r.URL.Query().Get("category"); category != "" {
+		if err := h.whereCategory(r, q, category); err != nil {
+			return nil, err
 		}
 	}
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+	if minRating := r.URL.Query().Get("min_rating"); minRating != "" {
+		if err := productQuerySchema.Where(q, "rating", query.OpGte, minRating); err != nil {
+			return nil, err
 		}
 	}
+	return q, nil
+}
+
+// productListFilter builds the filter of a product list. The search text,
+// if any, is escaped so the input is matched literally.
+func productListFilter(q *query.Query, search string) bson.M {
+	filter := trash.NotDeleted(q.Filter())
+	if search != "" {
+		search := regexp.QuoteMeta(search)
+		filter = bson.M{"$and": []bson.M{filter, {"$or": []bson.M{
+			{"name": bson.M{"$regex": search, "$options": "i"}},
+			{"description": bson.M{"$regex": search, "$options": "i"}},
+		}}}}
+	}
+	return filter
+}
+
+// GetProducts handles retrieving a list of products. Besides the filter, sort
+// and fields query language it still accepts the legacy category, search,
+// min_rating and sort values (price_asc, price_desc, name_asc, name_desc,
+// rating_desc). Prices are
+// filtered and sorted in the base currency, the currency parameter only
+// changes how they are shown.
+func (h *Handler) GetProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-	// Get basic filter parameters
-	category := r.URL.Query().Get("category")
+	q, err := h.parseProductQuery(r)
+	if err != nil {
+		h.handleQueryError(w, err)
+		return
+	}
 	searchQuery := r.URL.Query().Get("search")
-	sortBy := r.URL.Query().Get("sort") // Possible values: price_asc, price_desc, name_asc, name_desc
 
-	// Create cache key
-	cacheKey := fmt.Sprintf("products:p%d:l%d:cat%s:q%s:sort%s",
-		page, limit, category, searchQuery, sortBy)
+	currency, err := h.requestCurrency(r)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+		return
+	}
+
+	// Get pagination parameters
+	pageParams, err := utils.ParsePageParams(r, q.SortKey())
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid pagination parameters: "+err.Error())
+		return
+	}
+
+	// Create cache key. Cached products keep base prices but are fetched
+	// unprojected when a currency is requested.
+	cacheKey := fmt.Sprintf("products:%s:q%s:p%d:l%d:a%s:b%s:t%t:c%s",
+		q.Canonical(), searchQuery, pageParams.Page, pageParams.Limit,
+		r.URL.Query().Get("after"), r.URL.Query().Get("before"), pageParams.IncludeTotal, currency)
 
 	// Try to get from cache
 	var cachedData struct {
 		Products []models.Product `json:"products"`
-		Total    int64            `json:"total"`
+		Page     utils.Page       `json:"page"`
 	}
 
-	err := cache.GetCache(ctx, cacheKey, &cachedData)
+	err = cache.GetCache(ctx, cacheKey, &cachedData)
 	if err == nil {
+		if err := h.localizeProducts(cachedData.Products, currency); err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+			return
+		}
+		h.signProductImages(cachedData.Products)
 		w.Header().Set("X-Cache", "HIT")
-		h.ResponseHdlr.Paginated(w, "Products fetched from cache", cachedData.Products, page, limit, int(cachedData.Total))
+		h.ResponseHdlr.Paginated(w, r, "Products fetched from cache",
+			selectFields(cachedData.Products, productQuerySchema, q), cachedData.Page)
 		return
 	}
 
-	w.Header().Set("X-Cache", "MISS")
-
-	// Build filter query
-	filterQuery := bson.M{}
-
-	// Add category filter if provided
-	if category != "" {
-		filterQuery["category"] = category
+	page := utils.Page{Limit: pageParams.Limit}
+	if !pageParams.Keyset() {
+		page.Number = pageParams.Page
+	}
+	cursorFor := func(p models.Product) string {
+		return itemCursor(productQuerySchema, q, p, productID(p))
 	}
 
-	// Add search filter if provided
-	if searchQuery != "" {
-		filterQuery["$or"] = []bson.M{
-			{"name": bson.M{"$regex": searchQuery, "$options": "i"}},
-			{"description": bson.M{"$regex": searchQuery, "$options": "i"}},
+	// Serve from the full products snapshot when the update job has one
+	var snapshot struct {
+		Products []models.Product `json:"products"`
+	}
+	if searchQuery == "" && cache.GetCache(ctx, cache.ProductSnapshotKey, &snapshot) == nil {
+		w.Header().Set("X-Cache", "HIT")
+		products, total := pageInMemory(snapshot.Products, productQuerySchema, q, pageParams, productID)
+		if pageParams.IncludeTotal {
+			page.Total = &total
+		}
+		products = finishPage(products, pageParams, &page, cursorFor)
+		if err := h.localizeProducts(products, currency); err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+			return
 		}
+		h.signProductImages(products)
+		h.ResponseHdlr.Paginated(w, r, "Products fetched from cache",
+			selectFields(products, productQuerySchema, q), page)
+		return
 	}
 
-	// Get total count with filters
+	w.Header().Set("X-Cache", "MISS")
+
+	filterQuery := productListFilter(q, searchQuery)
+
+	// Get total count with filters, only when requested
 	productsCollection := h.DB.Database(h.Database).Collection("products")
-	total, err := productsCollection.CountDocuments(ctx, filterQuery)
-	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error counting products")
-		return
+	if pageParams.IncludeTotal {
+		total, err := productsCollection.CountDocuments(ctx, filterQuery)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error counting products")
+			return
+		}
+		page.Total = &total
 	}
 
-	// Calculate skip for pagination
-	skip := (page - 1) * limit
-
-	// Build sort options
-	sortOptions := bson.D{}
-	switch sortBy {
-	case "price_asc":
-		sortOptions = append(sortOptions, bson.E{Key: "price", Value: 1})
-	case "price_desc":
-		sortOptions = append(sortOptions, bson.E{Key: "price", Value: -1})
-	case "name_asc":
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: 1})
-	case "name_desc":
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: -1})
-	default:
-		// Default sorting by name ascending
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: 1})
+	// Restrict to items after or before the cursor when paging by cursor
+	pageFilter := filterQuery
+	if c := pageParams.After; c != nil {
+		pageFilter = bson.M{"$and": []bson.M{filterQuery, utils.KeysetFilter(c, q.SortDoc(false), false)}}
+	} else if c := pageParams.Before; c != nil {
+		pageFilter = bson.M{"$and": []bson.M{filterQuery, utils.KeysetFilter(c, q.SortDoc(false), true)}}
 	}
 
-	// Find products with filters and sort
+	// Find products with filters and sort, fetching one extra item to know
+	// whether another page follows
 	opts := options.Find().
-		SetLimit(int64(limit)).
-		SetSkip(int64(skip)).
-		SetSort(sortOptions)
+		SetLimit(int64(pageParams.Limit + 1)).
+		SetSkip(pageParams.Skip()).
+		SetSort(q.SortDoc(pageParams.Before != nil))
+	// Localized prices need the fixed prices and variants of each product
+	if projection := productQuerySchema.Projection(q); projection != nil && currency == "" {
+		opts.SetProjection(projection)
+	}
 
-	cursor, err := productsCollection.Find(ctx, filterQuery, opts)
+	cursor, err := productsCollection.Find(ctx, pageFilter, opts)
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error fetching products")
 		return
@@ -126,34 +252,55 @@ func (h *Handler) GetProducts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	products = finishPage(products, pageParams, &page, cursorFor)
+
 	// Store in cache
 	dataToCache := struct {
 		Products []models.Product `json:"products"`
-		Total    int64            `json:"total"`
+		Page     utils.Page       `json:"page"`
 	}{
 		Products: products,
-		Total:    total,
+		Page:     page,
 	}
 
 	if err := cache.SetCache(ctx, cacheKey, dataToCache, 5*time.Minute); err != nil {
 		log.Printf("Failed to cache products list: %v", err)
 	}
 
-	h.ResponseHdlr.Paginated(w, "Products fetched successfully", products, page, limit, int(total))
+	if err := h.localizeProducts(products, currency); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+		return
+	}
+	h.signProductImages(products)
+
+	h.ResponseHdlr.Paginated(w, r, "Products fetched successfully",
+		selectFields(products, productQuerySchema, q), page)
 }
 
-// GetProductDetails handles retrieving a single product by ID
+// GetProductDetails handles retrieving a single product by ID, priced in the
+// currency query parameter when given
 func (h *Handler) GetProductDetails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID := vars["id"]
 
+	currency, err := h.requestCurrency(r)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+		return
+	}
+
 	// Try to get from cache first
 	var product models.Product
 	ctx := r.Context()
 	cacheKey := fmt.Sprintf("product:%s", productID)
 
-	err := cache.GetCache(ctx, cacheKey, &product)
+	err = cache.GetCache(ctx, cacheKey, &product)
 	if err == nil {
+		if err := h.localizeProduct(&product, currency); err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+			return
+		}
+		h.Media.Sign(product.Images)
 		w.Header().Set("X-Cache", "HIT")
 		h.ResponseHdlr.Success(w, "Product details fetched from cache", product)
 		return
@@ -169,7 +316,7 @@ func (h *Handler) GetProductDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.DB.Database(h.Database).Collection("products").
-		FindOne(ctx, bson.M{"_id": objID}).
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
 		Decode(&product)
 
 	if err != nil {
@@ -182,6 +329,12 @@ func (h *Handler) GetProductDetails(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to cache product data: %v", err)
 	}
 
+	if err := h.localizeProduct(&product, currency); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid currency: "+err.Error())
+		return
+	}
+	h.Media.Sign(product.Images)
+
 	h.ResponseHdlr.Success(w, "Product details fetched successfully", product)
 }
 
@@ -207,28 +360,137 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Products must belong to an existing category
+	categorySlug, ok := h.resolveProductCategory(w, r.Context(), req.Category)
+	if !ok {
+		return
+	}
+
+	price, err := basePrice(req.Price)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid price: "+err.Error())
+		return
+	}
+	prices, err := priceList(req.Prices)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid prices: "+err.Error())
+		return
+	}
+
 	// Create new product
 	newProduct := models.Product{
 		ID:          primitive.NewObjectID(),
+		SKU:         normalizeSKU(req.SKU),
 		Name:        req.Name,
 		Description: req.Description,
-		Price:       req.Price,
-		Category:    req.Category,
+		Price:       price,
+		Prices:      prices,
+		Category:    categorySlug,
 		Stock:       req.Stock,
 	}
 
-	// Insert into database
-	_, err := h.DB.Database(h.Database).Collection("products").
-		InsertOne(r.Context(), newProduct)
-
+	err = h.insertProduct(r.Context(), newProduct, currentUser(r))
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "A product with this SKU already exists")
+		return
+	}
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error creating product")
 		return
 	}
 
+	h.recordRevision(r, newProduct, models.RevisionCreate, 0)
+	h.audit(r, models.AuditCreate, models.AuditResourceProduct, newProduct.ID.Hex(), nil, newProduct)
+
 	h.ResponseHdlr.Created(w, "Product created successfully", newProduct)
 }
 
+// insertProduct saves a new product with its opening stock and created
+// event, then indexes it for search
+func (h *Handler) insertProduct(ctx context.Context, product models.Product, actor string) error {
+	err := h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		if _, err := h.DB.Database(h.Database).Collection("products").InsertOne(sc, product); err != nil {
+			return nil, err
+		}
+		if product.Stock > 0 {
+			_, err := h.Inventory.Record(sc, product.ID, nil, product.Stock, product.Stock, inventory.Change{
+				Reason: models.MovementInitial,
+				Actor:  actor,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return []events.Event{events.NewProductEvent(events.ProductCreated, product, nil, actor)}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := h.Search.Index(ctx, product); err != nil {
+		log.Printf("Failed to index product %s: %v", product.ID.Hex(), err)
+	}
+	return nil
+}
+
+// saveProduct applies update and an optional new stock level to a product,
+// together with its updated event, then reindexes it. previous is the
+// product before the change. A rejected stock change is returned as an
+// inventoryError.
+func (h *Handler) saveProduct(ctx context.Context, previous models.Product, update bson.M, stock *int, actor string) (models.Product, error) {
+	var updatedProduct models.Product
+	err := h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		// Overwriting the stock is recorded in the ledger as a manual set.
+		// Products with variants derive their stock from the variants.
+		if stock != nil {
+			_, err := h.Inventory.Set(sc, previous.ID, nil, *stock, inventory.Change{
+				Reason: models.MovementManualSet,
+				Actor:  actor,
+			})
+			if err != nil {
+				return nil, &inventoryError{err}
+			}
+		}
+
+		// Update product in database
+		if len(update) > 0 {
+			result, err := h.DB.Database(h.Database).Collection("products").
+				UpdateOne(sc, trash.NotDeleted(bson.M{"_id": previous.ID}), bson.M{"$set": update})
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, mongo.ErrNoDocuments
+			}
+		}
+
+		// The variant price range depends on the base price
+		if _, ok := update["price"]; ok {
+			if err := h.refreshVariantSummary(sc, previous.ID); err != nil {
+				return nil, err
+			}
+		}
+
+		// Get updated product
+		err := h.DB.Database(h.Database).Collection("products").
+			FindOne(sc, bson.M{"_id": previous.ID}).
+			Decode(&updatedProduct)
+		if err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewProductEvent(events.ProductUpdated, updatedProduct,
+			changedFields(previous, updatedProduct), actor)}, nil
+	})
+	if err != nil {
+		return models.Product{}, err
+	}
+
+	if err := h.Search.Index(ctx, updatedProduct); err != nil {
+		log.Printf("Failed to index product %s: %v", updatedProduct.ID.Hex(), err)
+	}
+	return updatedProduct, nil
+}
+
 // UpdateProduct handles updating an existing product
 func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -263,6 +525,9 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 
 	// Build update document
 	update := bson.M{}
+	if req.SKU != "" {
+		update["sku"] = normalizeSKU(req.SKU)
+	}
 	if req.Name != "" {
 		update["name"] = req.Name
 	}
@@ -270,61 +535,82 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		update["description"] = req.Description
 	}
 	if req.Price > 0 {
-		update["price"] = req.Price
+		price, err := basePrice(req.Price)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid price: "+err.Error())
+			return
+		}
+		update["price"] = price
 	}
-	if req.Category != "" {
-		update["category"] = req.Category
+	if req.Prices != nil {
+		prices, err := priceList(req.Prices)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid prices: "+err.Error())
+			return
+		}
+		update["prices"] = prices
 	}
-	if req.Stock >= 0 {
-		update["stock"] = req.Stock
+	if req.Category != "" {
+		categorySlug, ok := h.resolveProductCategory(w, ctx, req.Category)
+		if !ok {
+			return
+		}
+		update["category"] = categorySlug
 	}
 
-	if len(update) == 0 {
+	if len(update) == 0 && req.Stock == nil {
 		h.ErrorHdlr.HandleBadRequest(w, "No fields to update")
 		return
 	}
 
-	// Update product in database
-	result, err := h.DB.Database(h.Database).Collection("products").
-		UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
-
+	// Keep the current version for the audit log
+	var previous models.Product
+	err = h.DB.Database(h.Database).Collection("products").
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		h.ErrorHdlr.HandleNotFound(w, "Product not found")
+		return
+	}
 	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error updating product")
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching product")
 		return
 	}
 
-	if result.MatchedCount == 0 {
+	updatedProduct, err := h.saveProduct(ctx, previous, update, req.Stock, currentUser(r))
+	var stockErr *inventoryError
+	if errors.As(err, &stockErr) {
+		h.writeInventoryError(w, stockErr.err)
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		h.ErrorHdlr.HandleNotFound(w, "Product not found")
 		return
 	}
-
-	// Invalidate cache
-	// 1. Delete specific product cache
-	detailCacheKey := fmt.Sprintf(cache.ProductDetailPattern, productID)
-	if err := cache.DeleteCache(ctx, detailCacheKey); err != nil {
-		log.Printf("Failed to invalidate product detail cache: %v", err)
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "A product with this SKU already exists")
+		return
 	}
-
-	// 2. Delete all product list caches
-	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
-		log.Printf("Failed to invalidate product list cache: %v", err)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating product")
+		return
 	}
 
-	// Get updated product
-	var updatedProduct models.Product
-	err = h.DB.Database(h.Database).Collection("products").
-		FindOne(ctx, bson.M{"_id": objID}).
-		Decode(&updatedProduct)
+	invalidateProductCache(ctx, productID)
 
-	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error getting updated product")
-		return
+	// Products saved before revisions were kept get a baseline first, so
+	// this change can be rolled back
+	if err := h.Revisions.EnsureBaseline(ctx, previous, currentUser(r)); err != nil {
+		log.Printf("Failed to record baseline revision of product %s: %v", productID, err)
 	}
+	h.recordRevision(r, updatedProduct, models.RevisionUpdate, 0)
+	h.audit(r, models.AuditUpdate, models.AuditResourceProduct, productID, previous, updatedProduct)
 
 	h.ResponseHdlr.Success(w, "Product updated successfully", updatedProduct)
 }
 
-// DeleteProduct handles deleting a product
+// DeleteProduct handles moving a product to the trash, from where it can be
+// restored until it is purged
 func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -336,30 +622,33 @@ func (h *Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.DB.Database(h.Database).Collection("products").
-		DeleteOne(ctx, bson.M{"_id": objID})
-
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		products := h.DB.Database(h.Database).Collection("products")
+		if err := trash.SoftDelete(sc, products, objID, currentUser(r)); err != nil {
+			return nil, err
+		}
+		var deleted models.Product
+		if err := products.FindOne(sc, bson.M{"_id": objID}).Decode(&deleted); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewProductEvent(events.ProductDeleted, deleted, nil, currentUser(r))}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "Product not found")
+		return
+	}
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error deleting product")
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		h.ErrorHdlr.HandleNotFound(w, "Product not found")
-		return
-	}
+	invalidateProductCache(ctx, productID)
 
-	// Invalidate cache
-	// 1. Delete specific product cache
-	detailCacheKey := fmt.Sprintf(cache.ProductDetailPattern, productID)
-	if err := cache.DeleteCache(ctx, detailCacheKey); err != nil {
-		log.Printf("Failed to invalidate product detail cache: %v", err)
+	if err := h.Search.Remove(ctx, productID); err != nil {
+		log.Printf("Failed to remove product %s from search index: %v", productID, err)
 	}
 
-	// 2. Delete all product list caches
-	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
-		log.Printf("Failed to invalidate product list cache: %v", err)
-	}
+	h.audit(r, models.AuditDelete, models.AuditResourceProduct, productID, nil, nil)
 
 	h.ResponseHdlr.Success(w, "Product successfully deleted", nil)
 }
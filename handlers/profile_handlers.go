@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/cache"
+	"go-tutorial/events"
+	"go-tutorial/models"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// maxAddresses caps the address book of a single user
+const maxAddresses = 20
+
+// errAddressesChanged is returned when a user's addresses changed between
+// reading and writing them
+var errAddressesChanged = errors.New("addresses changed")
+
+// parseDateOfBirth parses a YYYY-MM-DD date of birth, which must lie in the
+// past and within a human lifetime
+func parseDateOfBirth(value string) (time.Time, error) {
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, errors.New("Invalid date of birth, use YYYY-MM-DD")
+	}
+	now := time.Now()
+	if date.After(now) || date.Before(now.AddDate(-150, 0, 0)) {
+		return time.Time{}, errors.New("Date of birth must be in the past 150 years")
+	}
+	return date, nil
+}
+
+// invalidateUserCache deletes the cached details of a user and all cached
+// user lists
+func invalidateUserCache(ctx context.Context, userID string) {
+	if err := cache.DeleteCache(ctx, fmt.Sprintf(cache.UserDetailPattern, userID)); err != nil {
+		log.Printf("Failed to invalidate user detail cache: %v", err)
+	}
+	if err := cache.DeleteByPattern(ctx, cache.UserListPattern); err != nil {
+		log.Printf("Failed to invalidate user list cache: %v", err)
+	}
+}
+
+// loadMe fetches the authenticated user, writing an error response when it
+// cannot
+func (h *Handler) loadMe(w http.ResponseWriter, r *http.Request) (*models.UserDetails, bool) {
+	objID, err := primitive.ObjectIDFromHex(currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleUnauthorized(w, "Invalid token claims")
+		return nil, false
+	}
+
+	var user models.UserDetails
+	err = h.DB.Database(h.Database).Collection("users").
+		FindOne(r.Context(), trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "User not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching user details")
+		return nil, false
+	}
+	return &user, true
+}
+
+// GetMe handles retrieving the authenticated user's own profile
+func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadMe(w, r)
+	if !ok {
+		return
+	}
+
+	h.Media.SignAvatar(user.Avatar)
+	h.ResponseHdlr.Success(w, "Profile fetched successfully", user)
+}
+
+// UpdateMe handles partial updates of the authenticated user's profile and
+// preferences. Email, password and role are changed elsewhere.
+func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var errors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			errors = append(errors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return
+	}
+
+	// Build update document
+	set := bson.M{}
+	unset := bson.M{}
+	if req.Name != nil {
+		set["name"] = *req.Name
+	}
+	if req.Gender != nil {
+		set["gender"] = *req.Gender
+	}
+	if req.Phone != nil {
+		set["phone"] = *req.Phone
+	}
+	if req.DateOfBirth != nil {
+		if *req.DateOfBirth == "" {
+			unset["date_of_birth"] = ""
+		} else {
+			dateOfBirth, err := parseDateOfBirth(*req.DateOfBirth)
+			if err != nil {
+				h.ErrorHdlr.HandleBadRequest(w, err.Error())
+				return
+			}
+			set["date_of_birth"] = dateOfBirth
+		}
+	}
+	if p := req.Preferences; p != nil {
+		if p.Locale != nil {
+			set["preferences.locale"] = *p.Locale
+		}
+		if p.Timezone != nil {
+			set["preferences.timezone"] = *p.Timezone
+		}
+		if p.Notifications != nil {
+			set["preferences.notifications"] = *p.Notifications
+		}
+	}
+
+	if len(set) == 0 && len(unset) == 0 {
+		h.ErrorHdlr.HandleBadRequest(w, "No fields to update")
+		return
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	objID, err := primitive.ObjectIDFromHex(currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleUnauthorized(w, "Invalid token claims")
+		return
+	}
+
+	users := h.DB.Database(h.Database).Collection("users")
+	var previous, user models.UserDetails
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		err := users.FindOneAndUpdate(sc, trash.NotDeleted(bson.M{"_id": objID}), update).
+			Decode(&previous)
+		if err != nil {
+			return nil, err
+		}
+		if err := users.FindOne(sc, bson.M{"_id": objID}).Decode(&user); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserUpdated, user.User, objID.Hex())}, nil
+	})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			h.ErrorHdlr.HandleNotFound(w, "User not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error updating profile")
+		return
+	}
+
+	invalidateUserCache(ctx, objID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceUser, objID.Hex(), previous, user)
+
+	h.Media.SignAvatar(user.Avatar)
+	h.ResponseHdlr.Success(w, "Profile updated successfully", user)
+}
+
+// UploadAvatar handles setting the authenticated user's avatar, sent as the
+// "avatar" field of a multipart form. It is cropped to a square and stored
+// in a few sizes, replacing the previous avatar.
+func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleUnauthorized(w, "Invalid token claims")
+		return
+	}
+
+	data, ok := h.readUpload(w, r, "avatar")
+	if !ok {
+		return
+	}
+
+	avatar, err := h.Media.UploadAvatar(ctx, objID, data)
+	if err != nil {
+		h.writeUploadError(w, err)
+		return
+	}
+
+	var previous models.UserDetails
+	err = h.DB.Database(h.Database).Collection("users").
+		FindOneAndUpdate(ctx, trash.NotDeleted(bson.M{"_id": objID}), bson.M{"$set": bson.M{"avatar": avatar}},
+			options.FindOneAndUpdate().SetProjection(bson.M{"avatar": 1})).
+		Decode(&previous)
+	if err != nil {
+		h.Media.DeleteAvatar(ctx, avatar)
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "User not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error saving avatar")
+		return
+	}
+	if previous.Avatar != nil {
+		h.Media.DeleteAvatar(ctx, previous.Avatar)
+	}
+
+	invalidateUserCache(ctx, objID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceUser, objID.Hex(),
+		bson.M{"avatar": previous.Avatar}, bson.M{"avatar": avatar})
+
+	h.Media.SignAvatar(avatar)
+	h.ResponseHdlr.Success(w, "Avatar updated successfully", avatar)
+}
+
+// DeleteAvatar handles removing the authenticated user's avatar
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(currentUser(r))
+	if err != nil {
+		h.ErrorHdlr.HandleUnauthorized(w, "Invalid token claims")
+		return
+	}
+
+	var previous models.UserDetails
+	err = h.DB.Database(h.Database).Collection("users").
+		FindOneAndUpdate(ctx, trash.NotDeleted(bson.M{"_id": objID, "avatar": bson.M{"$exists": true}}),
+			bson.M{"$unset": bson.M{"avatar": ""}},
+			options.FindOneAndUpdate().SetProjection(bson.M{"avatar": 1})).
+		Decode(&previous)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "No avatar to delete")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting avatar")
+		return
+	}
+	h.Media.DeleteAvatar(ctx, previous.Avatar)
+
+	invalidateUserCache(ctx, objID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceUser, objID.Hex(),
+		bson.M{"avatar": previous.Avatar}, nil)
+
+	h.ResponseHdlr.Success(w, "Avatar deleted successfully", nil)
+}
+
+// applyAddressDefaults makes sure each address type has exactly one default
+// address. The address named by preferred becomes the default of its type,
+// otherwise the current default is kept or the first address of the type
+// takes over.
+func applyAddressDefaults(addresses []models.Address, preferred primitive.ObjectID) {
+	var preferredType string
+	for _, a := range addresses {
+		if a.ID == preferred {
+			preferredType = a.Type
+		}
+	}
+
+	hasDefault := map[string]bool{}
+	for i := range addresses {
+		a := &addresses[i]
+		if a.Type == preferredType {
+			a.Default = a.ID == preferred
+		} else if hasDefault[a.Type] {
+			a.Default = false
+		}
+		if a.Default {
+			hasDefault[a.Type] = true
+		}
+	}
+	for i := range addresses {
+		if !hasDefault[addresses[i].Type] {
+			addresses[i].Default = true
+			hasDefault[addresses[i].Type] = true
+		}
+	}
+}
+
+// saveAddresses replaces a user's addresses unless they changed since user
+// was read, so concurrent edits can't undo each other
+func (h *Handler) saveAddresses(r *http.Request, user *models.UserDetails, addresses []models.Address) error {
+	ctx := r.Context()
+	filter := trash.NotDeleted(bson.M{"_id": user.ID, "addresses": user.Addresses})
+	if len(user.Addresses) == 0 {
+		filter["addresses"] = bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+
+	result, err := h.DB.Database(h.Database).Collection("users").
+		UpdateOne(ctx, filter, bson.M{"$set": bson.M{"addresses": addresses}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errAddressesChanged
+	}
+
+	invalidateUserCache(ctx, user.ID.Hex())
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceUser, user.ID.Hex(),
+		bson.M{"addresses": user.Addresses}, bson.M{"addresses": addresses})
+	return nil
+}
+
+// writeAddressSaveError maps an error saving addresses to a response
+func (h *Handler) writeAddressSaveError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAddressesChanged) {
+		h.ErrorHdlr.HandleConflict(w, "Your addresses changed meanwhile, reload them and try again")
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error saving address")
+}
+
+// decodeAddressRequest reads and validates an address, writing an error
+// response when it is invalid
+func (h *Handler) decodeAddressRequest(w http.ResponseWriter, r *http.Request) (*models.AddressRequest, bool) {
+	var req models.AddressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return nil, false
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var errors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			errors = append(errors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return nil, false
+	}
+	return &req, true
+}
+
+// addressFromRequest builds an address with the given ID from a request
+func addressFromRequest(id primitive.ObjectID, req *models.AddressRequest) models.Address {
+	return models.Address{
+		ID:         id,
+		Type:       req.Type,
+		Label:      req.Label,
+		Name:       req.Name,
+		Line1:      req.Line1,
+		Line2:      req.Line2,
+		City:       req.City,
+		Region:     req.Region,
+		PostalCode: req.PostalCode,
+		Country:    req.Country,
+		Phone:      req.Phone,
+		Default:    req.Default,
+	}
+}
+
+// ListAddresses handles retrieving the authenticated user's addresses
+func (h *Handler) ListAddresses(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadMe(w, r)
+	if !ok {
+		return
+	}
+
+	addresses := user.Addresses
+	if addresses == nil {
+		addresses = []models.Address{}
+	}
+	h.ResponseHdlr.Success(w, "Addresses fetched successfully", addresses)
+}
+
+// CreateAddress handles adding an address to the authenticated user's
+// address book
+func (h *Handler) CreateAddress(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.decodeAddressRequest(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.loadMe(w, r)
+	if !ok {
+		return
+	}
+	if len(user.Addresses) >= maxAddresses {
+		h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("You can have at most %d addresses", maxAddresses))
+		return
+	}
+
+	address := addressFromRequest(primitive.NewObjectID(), req)
+	addresses := append(append([]models.Address{}, user.Addresses...), address)
+	preferred := primitive.NilObjectID
+	if req.Default {
+		preferred = address.ID
+	}
+	applyAddressDefaults(addresses, preferred)
+
+	if err := h.saveAddresses(r, user, addresses); err != nil {
+		h.writeAddressSaveError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Created(w, "Address added successfully", addresses[len(addresses)-1])
+}
+
+// UpdateAddress handles replacing one of the authenticated user's addresses.
+// A default address stays the default of its type until another address is
+// made default.
+func (h *Handler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := primitive.ObjectIDFromHex(mux.Vars(r)["addressId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid address ID")
+		return
+	}
+	req, ok := h.decodeAddressRequest(w, r)
+	if !ok {
+		return
+	}
+	user, ok := h.loadMe(w, r)
+	if !ok {
+		return
+	}
+
+	addresses := append([]models.Address{}, user.Addresses...)
+	index := -1
+	for i, a := range addresses {
+		if a.ID == addressID {
+			index = i
+		}
+	}
+	if index < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Address not found")
+		return
+	}
+
+	previous := addresses[index]
+	addresses[index] = addressFromRequest(addressID, req)
+	preferred := primitive.NilObjectID
+	if req.Default || (previous.Default && previous.Type == req.Type) {
+		preferred = addressID
+	}
+	applyAddressDefaults(addresses, preferred)
+
+	if err := h.saveAddresses(r, user, addresses); err != nil {
+		h.writeAddressSaveError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Address updated successfully", addresses[index])
+}
+
+// DeleteAddress handles removing one of the authenticated user's addresses,
+// another address of its type becomes the default when it was
+func (h *Handler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	addressID, err := primitive.ObjectIDFromHex(mux.Vars(r)["addressId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid address ID")
+		return
+	}
+	user, ok := h.loadMe(w, r)
+	if !ok {
+		return
+	}
+
+	addresses := make([]models.Address, 0, len(user.Addresses))
+	for _, a := range user.Addresses {
+		if a.ID != addressID {
+			addresses = append(addresses, a)
+		}
+	}
+	if len(addresses) == len(user.Addresses) {
+		h.ErrorHdlr.HandleNotFound(w, "Address not found")
+		return
+	}
+	applyAddressDefaults(addresses, primitive.NilObjectID)
+
+	if err := h.saveAddresses(r, user, addresses); err != nil {
+		h.writeAddressSaveError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Address deleted successfully", nil)
+}
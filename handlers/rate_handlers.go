@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/utils"
+)
+
+// currentRatesID is the ID of the exchange rates document
+const currentRatesID = "current"
+
+// LoadExchangeRates fills a rate store with the rates last saved through the
+// API, or with the ones in file when none were saved yet. A missing file is
+// not an error, prices then stay in the base currency only.
+func LoadExchangeRates(ctx context.Context, db *mongo.Database, store *money.RateStore, file string) error {
+	var rates money.Rates
+	err := db.Collection("exchange_rates").
+		FindOne(ctx, bson.M{"_id": currentRatesID}).
+		Decode(&rates)
+	if err == nil {
+		if rates.Base != money.Base() {
+			log.Printf("Ignoring saved exchange rates based on %s", rates.Base)
+		} else {
+			return store.Set(rates)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if file == "" {
+		return nil
+	}
+	if err := store.LoadFile(file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if base := store.Get().Base; base != money.Base() {
+		return errors.New("exchange rates file is based on " + base + ", not " + money.Base())
+	}
+	return nil
+}
+
+// GetExchangeRates handles retrieving the exchange rates prices are
+// converted with
+func (h *Handler) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	h.ResponseHdlr.Success(w, "Exchange rates fetched successfully", h.Rates.Get())
+}
+
+// UpdateExchangeRates handles replacing the exchange rates. Rates are
+// relative to the base currency and take effect immediately.
+func (h *Handler) UpdateExchangeRates(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateExchangeRatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	rates := money.Rates{Base: money.Base(), Rates: req.Rates, UpdatedAt: time.Now()}
+	if err := rates.Validate(); err != nil {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{{Field: "rates", Message: err.Error()}})
+		return
+	}
+
+	_, err := h.DB.Database(h.Database).Collection("exchange_rates").ReplaceOne(r.Context(),
+		bson.M{"_id": currentRatesID},
+		rates,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error saving exchange rates")
+		return
+	}
+	previous := h.Rates.Get()
+	if err := h.Rates.Set(rates); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error applying exchange rates")
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceExchangeRate, currentRatesID, previous, rates)
+
+	h.ResponseHdlr.Success(w, "Exchange rates updated successfully", h.Rates.Get())
+}
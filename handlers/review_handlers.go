@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/middleware"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+)
+
+// canModerateReviews reports whether the current user may moderate reviews
+func canModerateReviews(r *http.Request) bool {
+	return middleware.HasPermission(currentRole(r), middleware.PermissionModerateReviews)
+}
+
+// refreshProductRating recomputes the rating of a product from its approved
+// reviews and invalidates the cached product
+func (h *Handler) refreshProductRating(ctx context.Context, productID primitive.ObjectID) error {
+	cursor, err := h.DB.Database(h.Database).Collection("reviews").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID, "status": models.ReviewApproved}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"average": bson.M{"$avg": "$rating"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var results []models.ProductRating
+	if err := cursor.All(ctx, &results); err != nil {
+		return err
+	}
+
+	rating := models.ProductRating{}
+	if len(results) > 0 {
+		rating = results[0]
+		rating.Average = math.Round(rating.Average*100) / 100
+	}
+
+	_, err = h.DB.Database(h.Database).Collection("products").
+		UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{"rating": rating}})
+	if err != nil {
+		return err
+	}
+
+	invalidateProductCache(ctx, productID.Hex())
+	return nil
+}
+
+// hasPurchased reports whether a user has a paid order containing a product
+func (h *Handler) hasPurchased(ctx context.Context, userID string, productID primitive.ObjectID) (bool, error) {
+	count, err := h.DB.Database(h.Database).Collection("orders").CountDocuments(ctx, bson.M{
+		"user_id":          userID,
+		"items.product_id": productID,
+		"status":           bson.M{"$in": []string{models.OrderPaid, models.OrderShipped, models.OrderDelivered}},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// loadReview fetches the review named by the id route variable, writing an
+// error response when it cannot
+func (h *Handler) loadReview(w http.ResponseWriter, r *http.Request) (*models.Review, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid review ID")
+		return nil, false
+	}
+
+	var review models.Review
+	err = h.DB.Database(h.Database).Collection("reviews").
+		FindOne(r.Context(), bson.M{"_id": objID}).
+		Decode(&review)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Review not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching review")
+		return nil, false
+	}
+	return &review, true
+}
+
+// decodeReviewRequest reads and validates a review, writing an error
+// response when it is invalid
+func (h *Handler) decodeReviewRequest(w http.ResponseWriter, r *http.Request) (*models.ReviewRequest, bool) {
+	var req models.ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return nil, false
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var errors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			errors = append(errors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return nil, false
+	}
+	return &req, true
+}
+
+// listReviews writes a page of the reviews matching filter, newest first.
+// The rating query parameter restricts them to a single star rating.
+func (h *Handler) listReviews(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Reviews support page and limit pagination only")
+		return
+	}
+
+	if raw := r.URL.Query().Get("rating"); raw != "" {
+		rating, err := strconv.Atoi(raw)
+		if err != nil || rating < 1 || rating > 5 {
+			h.ErrorHdlr.HandleBadRequest(w, "rating must be a whole number from 1 to 5")
+			return
+		}
+		filter["rating"] = rating
+	}
+
+	reviewsCollection := h.DB.Database(h.Database).Collection("reviews")
+	total, err := reviewsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting reviews")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := reviewsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching reviews")
+		return
+	}
+	reviews := []models.Review{}
+	if err := cursor.All(ctx, &reviews); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing reviews data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Reviews fetched successfully", reviews, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// ListProductReviews handles listing the approved reviews of a product.
+// Moderators may list reviews of another status with status=pending|hidden.
+func (h *Handler) ListProductReviews(w http.ResponseWriter, r *http.Request) {
+	productID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	status := models.ReviewApproved
+	if raw := r.URL.Query().Get("status"); raw != "" && raw != status {
+		if !canModerateReviews(r) {
+			h.ErrorHdlr.HandleForbidden(w, "Only moderators can list unapproved reviews")
+			return
+		}
+		if raw != models.ReviewPending && raw != models.ReviewHidden {
+			h.ErrorHdlr.HandleBadRequest(w, "status must be one of: pending approved hidden")
+			return
+		}
+		status = raw
+	}
+
+	h.listReviews(w, r, bson.M{"product_id": productID, "status": status})
+}
+
+// ListReviews handles the moderation queue, listing reviews across products.
+// They can be filtered with status (pending by default), product_id and
+// user_id.
+func (h *Handler) ListReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ReviewPending
+	case models.ReviewPending, models.ReviewApproved, models.ReviewHidden:
+	default:
+		h.ErrorHdlr.HandleBadRequest(w, "status must be one of: pending approved hidden")
+		return
+	}
+
+	filter := bson.M{"status": status}
+	if raw := r.URL.Query().Get("product_id"); raw != "" {
+		productID, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+			return
+		}
+		filter["product_id"] = productID
+	}
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		filter["user_id"] = userID
+	}
+
+	h.listReviews(w, r, filter)
+}
+
+// CreateReview handles posting the current user's review of a product. It
+// is shown once a moderator approves it.
+func (h *Handler) CreateReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, ok := h.decodeReviewRequest(w, r)
+	if !ok {
+		return
+	}
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+
+	userID := currentUser(r)
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		h.ErrorHdlr.HandleUnauthorized(w, "Invalid token claims")
+		return
+	}
+	var user models.User
+	err = h.DB.Database(h.Database).Collection("users").
+		FindOne(ctx, bson.M{"_id": objID}, options.FindOne().SetProjection(bson.M{"name": 1})).
+		Decode(&user)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching user details")
+		return
+	}
+
+	verified, err := h.hasPurchased(ctx, userID, product.ID)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error checking orders")
+		return
+	}
+
+	now := time.Now()
+	review := models.Review{
+		ID:               primitive.NewObjectID(),
+		ProductID:        product.ID,
+		UserID:           userID,
+		UserName:         user.Name,
+		Rating:           req.Rating,
+		Title:            req.Title,
+		Body:             req.Body,
+		VerifiedPurchase: verified,
+		Status:           models.ReviewPending,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	_, err = h.DB.Database(h.Database).Collection("reviews").InsertOne(ctx, review)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			h.ErrorHdlr.HandleConflict(w, "You have already reviewed this product")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error creating review")
+		return
+	}
+
+	h.audit(r, models.AuditCreate, models.AuditResourceReview, review.ID.Hex(), nil, review)
+
+	h.ResponseHdlr.Created(w, "Review submitted for moderation", review)
+}
+
+// UpdateReview handles editing the current user's own review. The edited
+// review waits for moderation again.
+func (h *Handler) UpdateReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	review, ok := h.loadReview(w, r)
+	if !ok {
+		return
+	}
+	if review.UserID != currentUser(r) {
+		h.ErrorHdlr.HandleForbidden(w, "You can only edit your own reviews")
+		return
+	}
+	req, ok := h.decodeReviewRequest(w, r)
+	if !ok {
+		return
+	}
+
+	previous := *review
+	wasApproved := review.Status == models.ReviewApproved
+	review.Rating = req.Rating
+	review.Title = req.Title
+	review.Body = req.Body
+	review.Status = models.ReviewPending
+	review.UpdatedAt = time.Now()
+
+	_, err := h.DB.Database(h.Database).Collection("reviews").UpdateOne(ctx,
+		bson.M{"_id": review.ID},
+		bson.M{"$set": bson.M{
+			"rating":     review.Rating,
+			"title":      review.Title,
+			"body":       review.Body,
+			"status":     review.Status,
+			"updated_at": review.UpdatedAt,
+		}})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating review")
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceReview, review.ID.Hex(), previous, review)
+
+	if wasApproved {
+		if err := h.refreshProductRating(ctx, review.ProductID); err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error updating product rating")
+			return
+		}
+	}
+
+	h.ResponseHdlr.Success(w, "Review updated and submitted for moderation", review)
+}
+
+// DeleteReview handles deleting a review, by its author or a moderator
+func (h *Handler) DeleteReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	review, ok := h.loadReview(w, r)
+	if !ok {
+		return
+	}
+	if review.UserID != currentUser(r) && !canModerateReviews(r) {
+		h.ErrorHdlr.HandleForbidden(w, "You can only delete your own reviews")
+		return
+	}
+
+	result, err := h.DB.Database(h.Database).Collection("reviews").
+		DeleteOne(ctx, bson.M{"_id": review.ID})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting review")
+		return
+	}
+	if result.DeletedCount == 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Review not found")
+		return
+	}
+
+	h.audit(r, models.AuditDelete, models.AuditResourceReview, review.ID.Hex(), review, nil)
+
+	if review.Status == models.ReviewApproved {
+		if err := h.refreshProductRating(ctx, review.ProductID); err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error updating product rating")
+			return
+		}
+	}
+
+	h.ResponseHdlr.Success(w, "Review successfully deleted", nil)
+}
+
+// ModerateReview handles approving or hiding a review
+func (h *Handler) ModerateReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.ModerateReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var errors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			errors = append(errors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid review ID")
+		return
+	}
+
+	now := time.Now()
+	var previous models.Review
+	err = h.DB.Database(h.Database).Collection("reviews").FindOneAndUpdate(ctx,
+		bson.M{"_id": objID},
+		bson.M{"$set": bson.M{
+			"status":       req.Status,
+			"moderated_by": currentUser(r),
+			"moderated_at": now,
+		}}).
+		Decode(&previous)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Review not found")
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error moderating review")
+		return
+	}
+
+	review := previous
+	review.Status = req.Status
+	review.ModeratedBy = currentUser(r)
+	review.ModeratedAt = &now
+	h.audit(r, models.AuditUpdate, models.AuditResourceReview, review.ID.Hex(), previous, review)
+
+	// Only approved reviews count towards the rating
+	if (previous.Status == models.ReviewApproved) != (req.Status == models.ReviewApproved) {
+		if err := h.refreshProductRating(ctx, previous.ProductID); err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error updating product rating")
+			return
+		}
+	}
+
+	h.ResponseHdlr.Success(w, "Review moderated successfully", review)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+	"go-tutorial/revisions"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// recordRevision stores the product as its next revision. A failure is
+// logged rather than failing a change that was already saved.
+func (h *Handler) recordRevision(r *http.Request, product models.Product, action string, revertedFrom int) {
+	if _, err := h.Revisions.Record(r.Context(), product, action, currentUser(r), revertedFrom); err != nil {
+		log.Printf("Failed to record revision of product %s: %v", product.ID.Hex(), err)
+	}
+}
+
+// writeRevisionError maps a revision lookup error to a response
+func (h *Handler) writeRevisionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, revisions.ErrNotFound) {
+		h.ErrorHdlr.HandleNotFound(w, "Revision not found")
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error fetching revision")
+}
+
+// ListProductRevisions handles listing a product's revisions, newest first.
+// Snapshots are left out, fetch a single revision to see one.
+func (h *Handler) ListProductRevisions(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+
+	pageParams, err := utils.ParsePageParams(r, "-number")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Revisions support page and limit pagination only")
+		return
+	}
+
+	list, total, err := h.Revisions.List(r.Context(), product.ID, pageParams.Skip(), int64(pageParams.Limit))
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching revisions")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Revisions fetched successfully", list, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// GetProductRevision handles fetching a single revision with its snapshot
+func (h *Handler) GetProductRevision(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	number, _ := strconv.Atoi(mux.Vars(r)["number"])
+
+	revision, err := h.Revisions.Get(r.Context(), product.ID, number)
+	if err != nil {
+		h.writeRevisionError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Revision fetched successfully", revision)
+}
+
+// DiffProductRevisions handles comparing two revisions of a product, given
+// by the from and to query parameters, field by field
+func (h *Handler) DiffProductRevisions(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var numbers [2]int
+	var errors []utils.ErrorDetail
+	for i, param := range []string{"from", "to"} {
+		n, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil || n < 1 {
+			errors = append(errors, utils.ErrorDetail{Field: param, Message: "Must be a revision number"})
+		}
+		numbers[i] = n
+	}
+	if len(errors) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, errors)
+		return
+	}
+
+	diff, err := h.Revisions.Diff(r.Context(), product.ID, numbers[0], numbers[1])
+	if err != nil {
+		h.writeRevisionError(w, err)
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Revisions compared successfully", diff)
+}
+
+// RevertProduct handles restoring the name, description, prices and
+// category a product had at a revision. Stock, variants, images and the
+// rating have their own history and are left alone. The revert is recorded
+// as a new revision.
+func (h *Handler) RevertProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	number, _ := strconv.Atoi(mux.Vars(r)["number"])
+
+	revision, err := h.Revisions.Get(ctx, product.ID, number)
+	if err != nil {
+		h.writeRevisionError(w, err)
+		return
+	}
+	snapshot := revision.Snapshot
+
+	if _, err := h.findCategory(ctx, snapshot.Category); err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleConflict(w, fmt.Sprintf("Category %s no longer exists", snapshot.Category))
+			return
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error checking category")
+		return
+	}
+
+	// Products saved before revisions were kept get a baseline, so the
+	// revert itself can be undone
+	if err := h.Revisions.EnsureBaseline(ctx, *product, currentUser(r)); err != nil {
+		log.Printf("Failed to record baseline revision of product %s: %v", product.ID.Hex(), err)
+	}
+
+	set := bson.M{
+		"name":        snapshot.Name,
+		"description": snapshot.Description,
+		"price":       snapshot.Price,
+		"category":    snapshot.Category,
+	}
+	update := bson.M{"$set": set}
+	if len(snapshot.Prices) > 0 {
+		set["prices"] = snapshot.Prices
+	} else {
+		update["$unset"] = bson.M{"prices": ""}
+	}
+
+	products := h.DB.Database(h.Database).Collection("products")
+	var reverted models.Product
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		result, err := products.UpdateOne(sc, trash.NotDeleted(bson.M{"_id": product.ID}), update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		// The variant price range depends on the base price
+		if err := h.refreshVariantSummary(sc, product.ID); err != nil {
+			return nil, err
+		}
+
+		if err := products.FindOne(sc, bson.M{"_id": product.ID}).Decode(&reverted); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewProductEvent(events.ProductUpdated, reverted,
+			changedFields(product, reverted), currentUser(r))}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "Product not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error reverting product")
+		return
+	}
+
+	invalidateProductCache(ctx, product.ID.Hex())
+	if err := h.Search.Index(ctx, reverted); err != nil {
+		log.Printf("Failed to index product %s: %v", product.ID.Hex(), err)
+	}
+
+	h.recordRevision(r, reverted, models.RevisionRevert, number)
+	h.audit(r, models.AuditRevert, models.AuditResourceProduct, product.ID.Hex(), product, reverted)
+
+	h.Media.Sign(reverted.Images)
+	h.ResponseHdlr.Success(w, "Product reverted successfully", reverted)
+}
@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"go-tutorial/events"
 	"go-tutorial/middleware"
 	"go-tutorial/models"
+	"go-tutorial/trash"
 	"go-tutorial/utils"
 	"net/http"
 
@@ -101,38 +104,16 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user exists before updating
-	var existingUser models.UserDetails
-	err = h.DB.Database(h.Database).Collection("users").
-		FindOne(r.Context(), bson.M{"_id": objID}).
-		Decode(&existingUser)
-
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			h.ErrorHdlr.HandleNotFound(w, "User not found")
-		} else {
-			h.ErrorHdlr.HandleInternalError(w, "Error checking user existence")
-		}
+	existingUser, err := h.assignRole(r, objID, req.Role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "User not found")
 		return
 	}
-
-	// Update user's role in database
-	result, err := h.DB.Database(h.Database).Collection("users").UpdateOne(
-		r.Context(),
-		bson.M{"_id": objID},
-		bson.M{"$set": bson.M{"role": req.Role}},
-	)
-
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error updating user role")
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		h.ErrorHdlr.HandleNotFound(w, "User not found")
-		return
-	}
-
 	// Return success with updated user details
 	updatedUser := models.UserResponse{
 		ID:    existingUser.ID,
@@ -143,3 +124,45 @@ func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
 
 	h.ResponseHdlr.Success(w, "User role updated successfully", updatedUser)
 }
+
+// assignRole gives a user a role and tells them about the change. It returns
+// the user as it was before, or mongo.ErrNoDocuments when there is no such
+// user.
+func (h *Handler) assignRole(r *http.Request, objID primitive.ObjectID, role string) (*models.UserDetails, error) {
+	// Check if user exists before updating
+	var existingUser models.UserDetails
+	err := h.DB.Database(h.Database).Collection("users").
+		FindOne(r.Context(), trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&existingUser)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update user's role in database
+	err = h.withEvents(r.Context(), func(sc mongo.SessionContext) ([]events.Event, error) {
+		result, err := h.DB.Database(h.Database).Collection("users").UpdateOne(
+			sc,
+			trash.NotDeleted(bson.M{"_id": objID}),
+			bson.M{"$set": bson.M{"role": role}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+		return []events.Event{events.NewRoleAssigned(objID, existingUser.Role, role, currentUser(r))}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.audit(r, models.AuditAssignRole, models.AuditResourceUser, existingUser.ID.Hex(),
+		bson.M{"role": existingUser.Role}, bson.M{"role": role})
+	if existingUser.Role != role {
+		h.notify(r.Context(), existingUser.ID.Hex(), models.NotificationRoleChanged,
+			"Your role has changed", "Your role is now "+role+". Sign in again to use it.",
+			map[string]interface{}{"from": existingUser.Role, "to": role})
+	}
+	return &existingUser, nil
+}
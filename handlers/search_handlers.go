@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-tutorial/cache"
+	"go-tutorial/money"
+	"go-tutorial/search"
+	"go-tutorial/utils"
+)
+
+// SearchProducts handles full-text product search with relevance ranking,
+// highlighting and facet counts
+func (h *Handler) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	req := search.Request{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+	}
+	if req.Query == "" {
+		h.ErrorHdlr.HandleBadRequest(w, "Search query is required")
+		return
+	}
+
+	// Get optional filters
+	var validationErrors []utils.ErrorDetail
+	for param, dest := range map[string]**int64{"min_price": &req.MinPrice, "max_price": &req.MaxPrice} {
+		if raw := query.Get(param); raw != "" {
+			value, err := money.Parse(raw, money.Base())
+			if err != nil {
+				validationErrors = append(validationErrors, utils.ErrorDetail{Field: param, Message: "Must be a number"})
+				continue
+			}
+			*dest = &value.Amount
+		}
+	}
+	if raw := query.Get("in_stock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			validationErrors = append(validationErrors, utils.ErrorDetail{Field: "in_stock", Message: "Must be true or false"})
+		} else {
+			req.InStock = &inStock
+		}
+	}
+	if len(validationErrors) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	// Get pagination parameters, search results are ranked so only pages apply
+	pageParams, err := utils.ParsePageParams(r, "relevance")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Search supports page and limit pagination only")
+		return
+	}
+	req.Page, req.Limit = pageParams.Page, pageParams.Limit
+
+	// Create cache key
+	cacheKey := fmt.Sprintf("products:search:q%s:cat%s:min%s:max%s:stock%s:p%d:l%d",
+		strings.ToLower(req.Query), req.Category, query.Get("min_price"), query.Get("max_price"),
+		query.Get("in_stock"), req.Page, req.Limit)
+
+	// Try to get from cache
+	var result *search.Result
+	if err := cache.GetCache(ctx, cacheKey, &result); err == nil {
+		h.signHits(result.Hits)
+		w.Header().Set("X-Cache", "HIT")
+		h.ResponseHdlr.Success(w, "Search results fetched from cache", result)
+		return
+	}
+
+	w.Header().Set("X-Cache", "MISS")
+
+	result, err = h.Search.Search(ctx, req)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error searching products")
+		return
+	}
+
+	// Store in cache
+	if err := cache.SetCache(ctx, cacheKey, result, 5*time.Minute); err != nil {
+		log.Printf("Failed to cache search results: %v", err)
+	}
+	h.signHits(result.Hits)
+
+	h.ResponseHdlr.Success(w, "Search results fetched successfully", result)
+}
+
+// signHits sets the signed image URLs of the products found. Results are
+// cached unsigned, the signatures expire sooner.
+func (h *Handler) signHits(hits []search.Hit) {
+	for i := range hits {
+		h.Media.Sign(hits[i].Product.Images)
+	}
+}
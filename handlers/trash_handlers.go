@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// listTrash writes a page of the trashed documents of collection, most
+// recently deleted first
+func listTrash[T any](h *Handler, w http.ResponseWriter, r *http.Request, collection, message string) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-deleted_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "The trash supports page and limit pagination only")
+		return
+	}
+
+	coll := h.DB.Database(h.Database).Collection(collection)
+	filter := trash.Deleted(bson.M{})
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting the trash")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deleted_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching the trash")
+		return
+	}
+	items := []T{}
+	if err := cursor.All(ctx, &items); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing the trash")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, message, items, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// ListDeletedProducts handles listing the products in the trash
+func (h *Handler) ListDeletedProducts(w http.ResponseWriter, r *http.Request) {
+	listTrash[models.Product](h, w, r, "products", "Deleted products fetched successfully")
+}
+
+// ListDeletedUsers handles listing the users in the trash
+func (h *Handler) ListDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	listTrash[models.UserDetails](h, w, r, "users", "Deleted users fetched successfully")
+}
+
+// RestoreProduct handles taking a product out of the trash. It fails when
+// another product has taken one of its SKUs in the meantime.
+func (h *Handler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return
+	}
+
+	products := h.DB.Database(h.Database).Collection("products")
+	var product models.Product
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		if err := trash.Restore(sc, products, objID); err != nil {
+			return nil, err
+		}
+		if err := products.FindOne(sc, bson.M{"_id": objID}).Decode(&product); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewProductEvent(events.ProductRestored, product, nil, currentUser(r))}, nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "Another product has taken this product's SKU")
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "Product not found in the trash")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error restoring product")
+		return
+	}
+
+	invalidateProductCache(ctx, objID.Hex())
+	if err := h.Search.Index(ctx, product); err != nil {
+		log.Printf("Failed to index product %s: %v", objID.Hex(), err)
+	}
+
+	h.audit(r, models.AuditRestore, models.AuditResourceProduct, objID.Hex(), nil, nil)
+
+	h.Media.Sign(product.Images)
+	h.ResponseHdlr.Success(w, "Product restored successfully", product)
+}
+
+// RestoreUser handles taking a user out of the trash. It fails when another
+// user has registered with the same email in the meantime.
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid user ID format")
+		return
+	}
+
+	users := h.DB.Database(h.Database).Collection("users")
+	var user models.UserDetails
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		if err := trash.Restore(sc, users, objID); err != nil {
+			return nil, err
+		}
+		if err := users.FindOne(sc, bson.M{"_id": objID}).Decode(&user); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserRestored, user.User, currentUser(r))}, nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "Another user has registered with this email")
+		return
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "User not found in the trash")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error restoring user")
+		return
+	}
+
+	invalidateUserCache(ctx, objID.Hex())
+
+	h.audit(r, models.AuditRestore, models.AuditResourceUser, objID.Hex(), nil, nil)
+
+	h.Media.SignAvatar(user.Avatar)
+	h.ResponseHdlr.Success(w, "User restored successfully", user)
+}
@@ -3,10 +3,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"regexp"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -14,7 +15,22 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 
+	"go-tutorial/audit"
+	"go-tutorial/catalog"
+	"go-tutorial/discounts"
+	"go-tutorial/events"
+	"go-tutorial/inventory"
+	"go-tutorial/jobs"
+	"go-tutorial/live"
+	"go-tutorial/media"
 	"go-tutorial/models"
+	"go-tutorial/money"
+	"go-tutorial/notifications"
+	"go-tutorial/payments"
+	"go-tutorial/query"
+	"go-tutorial/revisions"
+	"go-tutorial/search"
+	"go-tutorial/trash"
 	"go-tutorial/utils"
 
 	"github.com/gorilla/mux"
@@ -33,112 +49,182 @@ type Handler struct {
 	Router       *mux.Router
 	ResponseHdlr *utils.ResponseHandler
 	ErrorHdlr    *utils.ErrorHandler
+	Search       search.Index
+	Inventory    *inventory.Service
+	Discounts    *discounts.Service
+	Payments     payments.Provider
+	Rates        *money.RateStore
+	Media        *media.Images
+	Audit        *audit.Logger
+	Revisions    *revisions.Service
+	Events       *events.Outbox
+	Live         *live.Hub
+	Imports      *catalog.Imports
+	Jobs         *jobs.Queue
+
+	Notifications   *notifications.Service
+	NotificationHub *notifications.Hub
 }
 
-// NewHandler creates a new handler with all dependencies
+// NewHandler creates a new handler with all dependencies. Notifications
+// are delivered within the process until main wires a shared broker.
 func NewHandler(db *mongo.Client, database string) *Handler {
-	return &Handler{
+	broker := notifications.NewMemoryBroker()
+	h := &Handler{
 		DB:           db,
 		Database:     database,
 		ResponseHdlr: utils.NewResponseHandler(),
 		ErrorHdlr:    utils.NewErrorHandler(),
-	}
+		Search:       search.NewMongoIndex(db.Database(database)),
+		Inventory:    inventory.NewService(db.Database(database)),
+		Discounts:    discounts.NewService(db.Database(database)),
+		Payments:     payments.NewFakeProvider("your-webhook-secret", ""),
+		Rates:        money.NewRateStore(money.Base()),
+		Media: media.NewImages(media.NewLocalStore("uploads"),
+			media.NewURLSigner("your-media-secret", "/media", 24*time.Hour), []int{160, 480}, 10<<20),
+		Audit:     audit.NewLogger(db.Database(database), 1000),
+		Revisions: revisions.NewService(db.Database(database), revisions.Policy{Keep: 100}),
+		Events:    events.NewOutbox(db.Database(database)),
+		Live:      live.NewHub(cache.GetRedisClient(), "events:live", db.Database(database), 1000),
+		Jobs:      jobs.NewQueue(db.Database(database)),
+
+		Notifications:   notifications.NewService(db.Database(database), broker),
+		NotificationHub: notifications.NewHub(broker, notifications.NewMemoryPresence()),
+	}
+	h.Imports = catalog.NewImports(db.Database(database), media.NewLocalStore("uploads"), h.Jobs, 50<<20)
+	return h
 }
 
+// userQuerySchema lists the user fields usable in filter, sort and fields
+var userQuerySchema = query.NewSchema("name",
+	query.Field[models.UserResponse]{Name: "id", Path: "_id", Selected: true,
+		Value: func(u models.UserResponse) interface{} { return u.ID.Hex() }},
+	query.Field[models.UserResponse]{Name: "name", Path: "name", Type: query.String, Filter: true, Sort: true,
+		Value: func(u models.UserResponse) interface{} { return u.Name }},
+	query.Field[models.UserResponse]{Name: "email", Path: "email", Type: query.String, Filter: true, Sort: true,
+		Value: func(u models.UserResponse) interface{} { return u.Email }},
+	query.Field[models.UserResponse]{Name: "role", Path: "role", Type: query.String, Filter: true, Sort: true,
+		Value: func(u models.UserResponse) interface{} { return u.Role }},
+).
+	WithSortAlias("name_asc", "name").
+	WithSortAlias("name_desc", "-name").
+	WithSortAlias("email_asc", "email").
+	WithSortAlias("email_desc", "-email")
+
+// userID returns the hex ID of a user
+func userID(u models.UserResponse) string { return u.ID.Hex() }
+
 func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Get pagination parameters
-	pageStr := r.URL.Query().Get("page")
-	limitStr := r.URL.Query().Get("limit")
-	page := 1
-	limit := 10
-
-	if pageStr != "" {
-		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
-			page = p
+	// Parse filter, sort and sparse fieldset, plus the legacy role filter
+	q, err := userQuerySchema.Parse(r.URL.Query())
+	if err == nil {
+		if role := r.URL.Query().Get("role"); role != "" {
+			err = userQuerySchema.Where(q, "role", query.OpEq, role)
 		}
 	}
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
-		}
+	if err != nil {
+		h.handleQueryError(w, err)
+		return
 	}
-
-	// Get basic filter parameters
-	role := r.URL.Query().Get("role")          // Filter by role
 	searchQuery := r.URL.Query().Get("search") // Search in name and email
-	sortBy := r.URL.Query().Get("sort")        // Possible values: name_asc, name_desc, email_asc, email_desc
+
+	// Get pagination parameters
+	pageParams, err := utils.ParsePageParams(r, q.SortKey())
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid pagination parameters: "+err.Error())
+		return
+	}
 
 	// Create cache key
-	cacheKey := fmt.Sprintf("users:p%d:l%d:role%s:q%s:sort%s",
-		page, limit, role, searchQuery, sortBy)
+	cacheKey := fmt.Sprintf("users:%s:q%s:p%d:l%d:a%s:b%s:t%t",
+		q.Canonical(), searchQuery, pageParams.Page, pageParams.Limit,
+		r.URL.Query().Get("after"), r.URL.Query().Get("before"), pageParams.IncludeTotal)
 
 	// Try to get from cache
 	var cachedData struct {
 		Users []models.UserResponse `json:"users"`
-		Total int64                 `json:"total"`
+		Page  utils.Page            `json:"page"`
 	}
 
-	err := cache.GetCache(ctx, cacheKey, &cachedData)
+	err = cache.GetCache(ctx, cacheKey, &cachedData)
 	if err == nil {
 		w.Header().Set("X-Cache", "HIT")
-		h.ResponseHdlr.Paginated(w, "Users fetched from cache", cachedData.Users, page, limit, int(cachedData.Total))
+		h.ResponseHdlr.Paginated(w, r, "Users fetched from cache",
+			selectFields(cachedData.Users, userQuerySchema, q), cachedData.Page)
+		return
+	}
+
+	page := utils.Page{Limit: pageParams.Limit}
+	if !pageParams.Keyset() {
+		page.Number = pageParams.Page
+	}
+	cursorFor := func(u models.UserResponse) string {
+		return itemCursor(userQuerySchema, q, u, userID(u))
+	}
+
+	// Serve from the full users snapshot when the update job has one
+	var snapshot struct {
+		Users []models.UserResponse `json:"users"`
+	}
+	if searchQuery == "" && cache.GetCache(ctx, cache.UserSnapshotKey, &snapshot) == nil {
+		w.Header().Set("X-Cache", "HIT")
+		users, total := pageInMemory(snapshot.Users, userQuerySchema, q, pageParams, userID)
+		if pageParams.IncludeTotal {
+			page.Total = &total
+		}
+		users = finishPage(users, pageParams, &page, cursorFor)
+		h.ResponseHdlr.Paginated(w, r, "Users fetched from cache",
+			selectFields(users, userQuerySchema, q), page)
 		return
 	}
 
 	w.Header().Set("X-Cache", "MISS")
 
 	// Build filter query
-	filterQuery := bson.M{}
-
-	// Add role filter if provided
-	if role != "" {
-		filterQuery["role"] = role
-	}
+	filterQuery := trash.NotDeleted(q.Filter())
 
-	// Add search filter if provided (search in name and email)
+	// Add search filter if provided (search in name and email), escaped so
+	// the input is matched literally
 	if searchQuery != "" {
-		filterQuery["$or"] = []bson.M{
+		searchQuery := regexp.QuoteMeta(searchQuery)
+		filterQuery = bson.M{"$and": []bson.M{filterQuery, {"$or": []bson.M{
 			{"name": bson.M{"$regex": searchQuery, "$options": "i"}},
 			{"email": bson.M{"$regex": searchQuery, "$options": "i"}},
-		}
+		}}}}
 	}
 
-	// Get total count with filters
+	// Get total count with filters, only when requested
 	usersCollection := h.DB.Database(h.Database).Collection("users")
-	total, err := usersCollection.CountDocuments(ctx, filterQuery)
-	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error counting users")
-		return
+	if pageParams.IncludeTotal {
+		total, err := usersCollection.CountDocuments(ctx, filterQuery)
+		if err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error counting users")
+			return
+		}
+		page.Total = &total
 	}
 
-	// Calculate skip for pagination
-	skip := (page - 1) * limit
-
-	// Build sort options
-	sortOptions := bson.D{}
-	switch sortBy {
-	case "name_asc":
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: 1})
-	case "name_desc":
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: -1})
-	case "email_asc":
-		sortOptions = append(sortOptions, bson.E{Key: "email", Value: 1})
-	case "email_desc":
-		sortOptions = append(sortOptions, bson.E{Key: "email", Value: -1})
-	default:
-		// Default sorting by name ascending
-		sortOptions = append(sortOptions, bson.E{Key: "name", Value: 1})
+	// Restrict to items after or before the cursor when paging by cursor
+	pageFilter := filterQuery
+	if c := pageParams.After; c != nil {
+		pageFilter = bson.M{"$and": []bson.M{filterQuery, utils.KeysetFilter(c, q.SortDoc(false), false)}}
+	} else if c := pageParams.Before; c != nil {
+		pageFilter = bson.M{"$and": []bson.M{filterQuery, utils.KeysetFilter(c, q.SortDoc(false), true)}}
 	}
 
-	// Find users with filters and sort
+	// Find users with filters and sort, fetching one extra item to know
+	// whether another page follows
 	opts := options.Find().
-		SetLimit(int64(limit)).
-		SetSkip(int64(skip)).
-		SetSort(sortOptions)
+		SetLimit(int64(pageParams.Limit + 1)).
+		SetSkip(pageParams.Skip()).
+		SetSort(q.SortDoc(pageParams.Before != nil))
+	if projection := userQuerySchema.Projection(q); projection != nil {
+		opts.SetProjection(projection)
+	}
 
-	cursor, err := usersCollection.Find(ctx, filterQuery, opts)
+	cursor, err := usersCollection.Find(ctx, pageFilter, opts)
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error fetching users")
 		return
@@ -151,20 +237,23 @@ func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	users = finishPage(users, pageParams, &page, cursorFor)
+
 	// Store in cache
 	dataToCache := struct {
 		Users []models.UserResponse `json:"users"`
-		Total int64                 `json:"total"`
+		Page  utils.Page            `json:"page"`
 	}{
 		Users: users,
-		Total: total,
+		Page:  page,
 	}
 
 	if err := cache.SetCache(ctx, cacheKey, dataToCache, 5*time.Minute); err != nil {
 		log.Printf("Failed to cache users list: %v", err)
 	}
 
-	h.ResponseHdlr.Paginated(w, "Users fetched successfully", users, page, limit, int(total))
+	h.ResponseHdlr.Paginated(w, r, "Users fetched successfully",
+		selectFields(users, userQuerySchema, q), page)
 }
 
 func (h *Handler) GetUserDetails(w http.ResponseWriter, r *http.Request) {
@@ -183,12 +272,20 @@ func (h *Handler) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	requestedUserID := vars["id"]
 
+	// Check permissions, before the cache so it can't leak other users
+	if userRole == "user" && currentUserID != requestedUserID {
+		h.ErrorHdlr.HandleForbidden(w, "Access denied")
+		return
+	}
+
 	// Try to get user from cache first
 	var user models.UserDetails
 	ctx := r.Context()
-	err := cache.GetCache(ctx, requestedUserID, &user)
+	cacheKey := fmt.Sprintf(cache.UserDetailPattern, requestedUserID)
+	err := cache.GetCache(ctx, cacheKey, &user)
 	if err == nil {
 		// Cache hit
+		h.Media.SignAvatar(user.Avatar)
 		w.Header().Set("X-Cache", "HIT")
 		h.ResponseHdlr.Success(w, "User details fetched from cache", user)
 		return
@@ -204,14 +301,8 @@ func (h *Handler) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check permissions
-	if userRole == "user" && currentUserID != requestedUserID {
-		h.ErrorHdlr.HandleForbidden(w, "Access denied")
-		return
-	}
-
 	err = h.DB.Database(h.Database).Collection("users").
-		FindOne(ctx, bson.M{"_id": objID}).
+		FindOne(ctx, trash.NotDeleted(bson.M{"_id": objID})).
 		Decode(&user)
 
 	if err != nil {
@@ -224,15 +315,57 @@ func (h *Handler) GetUserDetails(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Store in cache for future requests (cache for 30 minutes)
-	go func() {
-		if err := cache.SetCache(context.Background(), requestedUserID, user, 30*time.Minute); err != nil {
+	go func(user models.UserDetails) {
+		if err := cache.SetCache(context.Background(), cacheKey, user, 30*time.Minute); err != nil {
 			log.Printf("Failed to cache user data: %v", err)
 		}
-	}()
+	}(user)
 
+	h.Media.SignAvatar(user.Avatar)
 	h.ResponseHdlr.Success(w, "User details fetched successfully", user)
 }
 
+// dateOfBirthError is an invalid date of birth in a user update
+type dateOfBirthError struct {
+	err error
+}
+
+func (e *dateOfBirthError) Error() string { return e.err.Error() }
+
+// userUpdate builds the update document of a user update request, hashing
+// a new password. An invalid date of birth is returned as a
+// *dateOfBirthError.
+func userUpdate(req models.UpdateUserRequest) (bson.M, error) {
+	update := bson.M{}
+	if req.Name != "" {
+		update["name"] = req.Name
+	}
+	if req.Email != "" {
+		update["email"] = req.Email
+	}
+	if req.Gender != "" {
+		update["gender"] = req.Gender
+	}
+	if req.DateOfBirth != "" {
+		dateOfBirth, err := parseDateOfBirth(req.DateOfBirth)
+		if err != nil {
+			return nil, &dateOfBirthError{err}
+		}
+		update["date_of_birth"] = dateOfBirth
+	}
+	if req.Phone != "" {
+		update["phone"] = req.Phone
+	}
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		update["password"] = string(hashedPassword)
+	}
+	return update, nil
+}
+
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -258,32 +391,15 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Build update document
-	update := bson.M{}
-	if req.Name != "" {
-		update["name"] = req.Name
-	}
-	if req.Email != "" {
-		update["email"] = req.Email
-	}
-	if req.Gender != "" {
-		update["gender"] = req.Gender
-	}
-	if req.Age != 0 {
-		update["age"] = req.Age
-	}
-	if req.Address != "" {
-		update["address"] = req.Address
-	}
-	if req.Phone != "" {
-		update["phone"] = req.Phone
+	update, err := userUpdate(req)
+	var dateErr *dateOfBirthError
+	if errors.As(err, &dateErr) {
+		h.ErrorHdlr.HandleBadRequest(w, err.Error())
+		return
 	}
-	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		if err != nil {
-			h.ErrorHdlr.HandleInternalError(w, "Error processing request")
-			return
-		}
-		update["password"] = string(hashedPassword)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing request")
+		return
 	}
 
 	if len(update) == 0 {
@@ -291,19 +407,33 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update user in database
-	result, err := h.DB.Database(h.Database).Collection("users").
-		UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": update})
+	// Update user in database, keeping the previous version for the audit log
+	users := h.DB.Database(h.Database).Collection("users")
+	var previous, updatedUser models.UserDetails
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		err := users.FindOneAndUpdate(sc, trash.NotDeleted(bson.M{"_id": objID}), bson.M{"$set": update}).
+			Decode(&previous)
+		if err != nil {
+			return nil, err
+		}
+		if err := users.FindOne(sc, bson.M{"_id": objID}).Decode(&updatedUser); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserUpdated, updatedUser.User, currentUser(r))}, nil
+	})
 
-	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error updating user")
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "User with this email already exists")
 		return
 	}
-
-	if result.MatchedCount == 0 {
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		h.ErrorHdlr.HandleNotFound(w, "User not found")
 		return
 	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating user")
+		return
+	}
 
 	// Invalidate cache
 	// 1. Delete specific user cache
@@ -317,17 +447,9 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to invalidate user list cache: %v", err)
 	}
 
-	// Get updated user
-	var updatedUser models.UserDetails
-	err = h.DB.Database(h.Database).Collection("users").
-		FindOne(ctx, bson.M{"_id": objID}).
-		Decode(&updatedUser)
-
-	if err != nil {
-		h.ErrorHdlr.HandleInternalError(w, "Error getting updated user")
-		return
-	}
+	h.audit(r, models.AuditUpdate, models.AuditResourceUser, userID, previous, updatedUser)
 
+	h.Media.SignAvatar(updatedUser.Avatar)
 	h.ResponseHdlr.Success(w, "User updated successfully", updatedUser)
 }
 
@@ -342,18 +464,27 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.DB.Database(h.Database).Collection("users").
-		DeleteOne(ctx, bson.M{"_id": objID})
-
+	err = h.withEvents(ctx, func(sc mongo.SessionContext) ([]events.Event, error) {
+		users := h.DB.Database(h.Database).Collection("users")
+		if err := trash.SoftDelete(sc, users, objID, currentUser(r)); err != nil {
+			return nil, err
+		}
+		var deleted models.User
+		if err := users.FindOne(sc, bson.M{"_id": objID}).Decode(&deleted); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserDeleted, deleted, currentUser(r))}, nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		h.ErrorHdlr.HandleNotFound(w, "User not found")
+		return
+	}
 	if err != nil {
 		h.ErrorHdlr.HandleInternalError(w, "Error deleting user")
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		h.ErrorHdlr.HandleNotFound(w, "User not found")
-		return
-	}
+	h.audit(r, models.AuditDelete, models.AuditResourceUser, userID, nil, nil)
 
 	// Invalidate cache
 	// 1. Delete specific user cache
@@ -401,7 +532,7 @@ func (h *Handler) SignUp(w http.ResponseWriter, r *http.Request) {
 	// Check if user already exists
 	var existingUser models.UserDetails
 	err := h.DB.Database(h.Database).Collection("users").
-		FindOne(context.TODO(), bson.M{"email": req.Email}).
+		FindOne(context.TODO(), trash.NotDeleted(bson.M{"email": req.Email})).
 		Decode(&existingUser)
 	if err == nil {
 		// If the user already exists, return a 400 error
@@ -417,6 +548,16 @@ func (h *Handler) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var dateOfBirth *time.Time
+	if req.DateOfBirth != "" {
+		dob, err := parseDateOfBirth(req.DateOfBirth)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, err.Error())
+			return
+		}
+		dateOfBirth = &dob
+	}
+
 	// Create new user
 	// Admin roles should be assigned manually or through a separate admin creation process
 	newUser := models.UserDetails{
@@ -427,21 +568,34 @@ func (h *Handler) SignUp(w http.ResponseWriter, r *http.Request) {
 			Password: string(hashedPassword),
 			Role:     req.Role, // Default role
 		},
-		Gender:  req.Gender,
-		Age:     req.Age,
-		Address: req.Address,
-		Phone:   req.Phone,
+		Gender:      req.Gender,
+		DateOfBirth: dateOfBirth,
+		Phone:       req.Phone,
+		Preferences: models.DefaultPreferences(),
 	}
 
-	// Insert the user into the database
-	_, err = h.DB.Database(h.Database).Collection("users").
-		InsertOne(context.TODO(), newUser)
+	// Insert the user into the database. Users sign themselves up.
+	err = h.withEvents(r.Context(), func(sc mongo.SessionContext) ([]events.Event, error) {
+		if _, err := h.DB.Database(h.Database).Collection("users").InsertOne(sc, newUser); err != nil {
+			return nil, err
+		}
+		return []events.Event{events.NewUserEvent(events.UserRegistered, newUser.User, newUser.ID.Hex())}, nil
+	})
 
+	if mongo.IsDuplicateKeyError(err) {
+		// Another sign up with the same email won the race
+		h.ErrorHdlr.HandleBadRequest(w, "User with this email already exists")
+		return
+	}
 	if err != nil {
 		// If there is an error, return a 500 error
 		h.ErrorHdlr.HandleInternalError(w, "Error creating user")
 		return
 	}
+
+	// Users sign themselves up
+	h.auditAs(r, newUser.ID.Hex(), models.AuditCreate, models.AuditResourceUser, newUser.ID.Hex(), nil, newUser)
+
 	// Return a success response
 	h.ResponseHdlr.Created(w, "User created successfully", newUser)
 }
@@ -457,7 +611,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	// Find user
 	var user models.UserDetails
 	err := h.DB.Database(h.Database).Collection("users").
-		FindOne(context.TODO(), bson.M{"email": req.Email}).
+		FindOne(context.TODO(), trash.NotDeleted(bson.M{"email": req.Email})).
 		Decode(&user)
 
 	if err != nil {
@@ -472,6 +626,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		h.auditAs(r, "", models.AuditLoginFailed, models.AuditResourceUser, user.ID.Hex(), nil, nil)
 		h.ErrorHdlr.HandleUnauthorized(w, "Invalid email or password")
 		return
 	}
@@ -483,6 +638,8 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.auditAs(r, user.ID.Hex(), models.AuditLogin, models.AuditResourceUser, user.ID.Hex(), nil, nil)
+
 	// Create response
 	response := models.LoginResponse{
 		Token: token,
@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/inventory"
+	"go-tutorial/models"
+	"go-tutorial/trash"
+	"go-tutorial/utils"
+)
+
+// maxVariants caps the number of variants of a single product
+const maxVariants = 100
+
+// refreshVariantSummary recomputes the aggregate stock and price range of a
+// product from its variants. It is derived from the stored variants in a
+// single update, so running it after every variant change keeps it correct
+// even when changes race.
+func (h *Handler) refreshVariantSummary(ctx context.Context, productID primitive.ObjectID) error {
+	hasVariants := bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$variants", bson.A{}}}}, 0}}
+	prices := bson.M{"$map": bson.M{
+		"input": "$variants",
+		"as":    "v",
+		"in":    bson.M{"$ifNull": bson.A{"$$v.price.amount", "$price.amount"}},
+	}}
+
+	_, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{"_id": productID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"stock": bson.M{"$cond": bson.A{hasVariants, bson.M{"$sum": "$variants.stock"}, "$stock"}},
+			"price_range": bson.M{"$cond": bson.A{
+				hasVariants,
+				bson.M{
+					"min": bson.M{"amount": bson.M{"$min": prices}, "currency": "$price.currency"},
+					"max": bson.M{"amount": bson.M{"$max": prices}, "currency": "$price.currency"},
+				},
+				"$$REMOVE",
+			}},
+		}}}},
+	)
+	return err
+}
+
+// recordVariantMovements records in the stock ledger the stock variants
+// brought in or took away when they were added or removed. A product that
+// gets its first variants moves its own stock onto them.
+func (h *Handler) recordVariantMovements(ctx context.Context, actor string, before *models.Product) {
+	var after models.Product
+	err := h.DB.Database(h.Database).Collection("products").
+		FindOne(ctx, bson.M{"_id": before.ID}).
+		Decode(&after)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to load product %s for stock ledger: %v", before.ID.Hex(), err)
+		return
+	}
+
+	record := func(variantID *primitive.ObjectID, delta, quantity int, reason string) {
+		if delta == 0 {
+			return
+		}
+		_, err := h.Inventory.Record(ctx, before.ID, variantID, delta, quantity, inventory.Change{
+			Reason: reason,
+			Actor:  actor,
+		})
+		if err != nil {
+			log.Printf("Failed to record stock movement of product %s: %v", before.ID.Hex(), err)
+		}
+	}
+	hasVariant := func(variants []models.Variant, id primitive.ObjectID) bool {
+		return slices.ContainsFunc(variants, func(v models.Variant) bool { return v.ID == id })
+	}
+
+	if len(before.Variants) == 0 && len(after.Variants) > 0 {
+		record(nil, -before.Stock, 0, models.MovementMovedToVariants)
+	}
+	for _, v := range before.Variants {
+		if !hasVariant(after.Variants, v.ID) {
+			record(&v.ID, -v.Stock, 0, models.MovementVariantRemoved)
+		}
+	}
+	for _, v := range after.Variants {
+		if !hasVariant(before.Variants, v.ID) {
+			record(&v.ID, v.Stock, v.Stock, models.MovementInitial)
+		}
+	}
+}
+
+// loadProduct fetches the product named by the id route variable, writing an
+// error response when it cannot
+func (h *Handler) loadProduct(w http.ResponseWriter, r *http.Request) (*models.Product, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid product ID")
+		return nil, false
+	}
+
+	var product models.Product
+	err = h.DB.Database(h.Database).Collection("products").
+		FindOne(r.Context(), trash.NotDeleted(bson.M{"_id": objID})).
+		Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Product not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching product")
+		return nil, false
+	}
+	return &product, true
+}
+
+// validateAttributes checks variant attributes against the product options.
+// Products without options accept any attributes.
+func validateAttributes(product *models.Product, attributes map[string]string) string {
+	if len(product.Options) == 0 {
+		return ""
+	}
+	if len(attributes) != len(product.Options) {
+		return "Attributes must set a value for every product option"
+	}
+	for _, option := range product.Options {
+		value, ok := attributes[option.Name]
+		if !ok {
+			return fmt.Sprintf("Missing value for option %s", option.Name)
+		}
+		if !slices.Contains(option.Values, value) {
+			return fmt.Sprintf("%s is not a valid value for option %s", value, option.Name)
+		}
+	}
+	return ""
+}
+
+// normalizeSKU trims and upper-cases a SKU
+func normalizeSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// variantConflict reports whether another variant of the product already
+// uses the SKU or the attribute combination
+func variantConflict(product *models.Product, exceptID primitive.ObjectID, sku string, attributes map[string]string) string {
+	for _, v := range product.Variants {
+		if v.ID == exceptID {
+			continue
+		}
+		if v.SKU == sku {
+			return "Another variant already uses this SKU"
+		}
+		if attributes != nil && maps.Equal(v.Attributes, attributes) {
+			return "Another variant already has these attributes"
+		}
+	}
+	return ""
+}
+
+// writeVariantUpdateError writes the response for a failed variant update
+func (h *Handler) writeVariantUpdateError(w http.ResponseWriter, err error) {
+	if mongo.IsDuplicateKeyError(err) {
+		h.ErrorHdlr.HandleConflict(w, "SKU is already used by another product")
+		return
+	}
+	h.ErrorHdlr.HandleInternalError(w, "Error updating product variants")
+}
+
+// variantsResponse returns the variant view of a product after a change.
+// before is the product prior to the change, nil when nothing changed.
+func (h *Handler) variantsResponse(w http.ResponseWriter, r *http.Request, message string, created bool, before *models.Product) {
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	if before != nil {
+		invalidateProductCache(r.Context(), product.ID.Hex())
+		h.audit(r, models.AuditUpdate, models.AuditResourceProduct, product.ID.Hex(), before, product)
+	}
+
+	data := struct {
+		Options    []models.ProductOption `json:"options"`
+		Variants   []models.Variant       `json:"variants"`
+		PriceRange *models.PriceRange     `json:"price_range,omitempty"`
+		Stock      int                    `json:"stock"`
+	}{
+		Options:    product.Options,
+		Variants:   product.Variants,
+		PriceRange: product.PriceRange,
+		Stock:      product.Stock,
+	}
+	if data.Options == nil {
+		data.Options = []models.ProductOption{}
+	}
+	if data.Variants == nil {
+		data.Variants = []models.Variant{}
+	}
+
+	if created {
+		h.ResponseHdlr.Created(w, message, data)
+		return
+	}
+	h.ResponseHdlr.Success(w, message, data)
+}
+
+// ListVariants handles listing a product's options and variants
+func (h *Handler) ListVariants(w http.ResponseWriter, r *http.Request) {
+	h.variantsResponse(w, r, "Product variants fetched successfully", false, nil)
+}
+
+// GenerateVariants handles setting a product's options and generating one
+// variant per combination of option values. Existing variants whose
+// attributes are still valid keep their SKU, price and stock.
+func (h *Handler) GenerateVariants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.GenerateVariantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	combinations := 1
+	for _, option := range req.Options {
+		combinations *= len(option.Values)
+	}
+	if combinations > maxVariants {
+		h.ErrorHdlr.HandleBadRequest(w, fmt.Sprintf("Options produce more than %d variants", maxVariants))
+		return
+	}
+	price, err := variantPrice(req.Price)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid price: "+err.Error())
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+
+	// Build every combination of option values, reusing existing variants
+	prefix := strings.ToUpper(utils.Slugify(product.Name))
+	if len(prefix) > 20 {
+		prefix = strings.TrimRight(prefix[:20], "-")
+	}
+	variants := []models.Variant{}
+	usedSKUs := make(map[string]bool)
+	for _, existing := range product.Variants {
+		usedSKUs[existing.SKU] = true
+	}
+	var build func(i int, attributes map[string]string)
+	build = func(i int, attributes map[string]string) {
+		if i == len(req.Options) {
+			for _, existing := range product.Variants {
+				if maps.Equal(existing.Attributes, attributes) {
+					variants = append(variants, existing)
+					return
+				}
+			}
+
+			parts := []string{prefix}
+			for _, option := range req.Options {
+				parts = append(parts, strings.ToUpper(utils.Slugify(attributes[option.Name])))
+			}
+			sku := strings.Join(parts, "-")
+			for n := 2; usedSKUs[sku]; n++ {
+				sku = fmt.Sprintf("%s-%d", strings.Join(parts, "-"), n)
+			}
+			usedSKUs[sku] = true
+
+			variants = append(variants, models.Variant{
+				ID:         primitive.NewObjectID(),
+				SKU:        sku,
+				Attributes: maps.Clone(attributes),
+				Price:      price,
+				Stock:      req.Stock,
+			})
+			return
+		}
+		for _, value := range req.Options[i].Values {
+			attributes[req.Options[i].Name] = value
+			build(i+1, attributes)
+		}
+		delete(attributes, req.Options[i].Name)
+	}
+	build(0, make(map[string]string))
+
+	_, err = h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{"_id": product.ID},
+		bson.M{"$set": bson.M{"options": req.Options, "variants": variants}},
+	)
+	if err == nil {
+		err = h.refreshVariantSummary(ctx, product.ID)
+	}
+	if err != nil {
+		h.writeVariantUpdateError(w, err)
+		return
+	}
+	h.recordVariantMovements(ctx, currentUser(r), product)
+
+	h.variantsResponse(w, r, "Product variants generated successfully", false, product)
+}
+
+// CreateVariant handles adding a single variant to a product
+func (h *Handler) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req models.CreateVariantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	if len(product.Variants) >= maxVariants {
+		h.ErrorHdlr.HandleBadRequest(w, fmt.Sprintf("A product cannot have more than %d variants", maxVariants))
+		return
+	}
+
+	price, err := variantPrice(req.Price)
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid price: "+err.Error())
+		return
+	}
+	variant := models.Variant{
+		ID:         primitive.NewObjectID(),
+		SKU:        normalizeSKU(req.SKU),
+		Attributes: req.Attributes,
+		Price:      price,
+		Stock:      req.Stock,
+	}
+	if msg := validateAttributes(product, variant.Attributes); msg != "" {
+		h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{{Field: "attributes", Message: msg}})
+		return
+	}
+	if msg := variantConflict(product, variant.ID, variant.SKU, variant.Attributes); msg != "" {
+		h.ErrorHdlr.HandleConflict(w, msg)
+		return
+	}
+
+	// The SKU condition guards against a concurrent insert of the same SKU
+	result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{"_id": product.ID, "variants.sku": bson.M{"$ne": variant.SKU}},
+		bson.M{"$push": bson.M{"variants": variant}},
+	)
+	if err == nil && result.MatchedCount == 0 {
+		h.ErrorHdlr.HandleConflict(w, "Another variant already uses this SKU")
+		return
+	}
+	if err == nil {
+		err = h.refreshVariantSummary(ctx, product.ID)
+	}
+	if err != nil {
+		h.writeVariantUpdateError(w, err)
+		return
+	}
+	h.recordVariantMovements(ctx, currentUser(r), product)
+
+	h.variantsResponse(w, r, "Product variant created successfully", true, product)
+}
+
+// UpdateVariant handles changing the SKU, attributes, price or stock of a
+// variant
+func (h *Handler) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	variantID, err := primitive.ObjectIDFromHex(mux.Vars(r)["variantId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	var req models.UpdateVariantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	idx := slices.IndexFunc(product.Variants, func(v models.Variant) bool { return v.ID == variantID })
+	if idx < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Variant not found")
+		return
+	}
+
+	// Build update document
+	update := bson.M{}
+	sku := product.Variants[idx].SKU
+	if req.SKU != "" {
+		sku = normalizeSKU(req.SKU)
+		update["variants.$.sku"] = sku
+	}
+	if req.Attributes != nil {
+		if msg := validateAttributes(product, req.Attributes); msg != "" {
+			h.ErrorHdlr.HandleValidationError(w, []utils.ErrorDetail{{Field: "attributes", Message: msg}})
+			return
+		}
+		update["variants.$.attributes"] = req.Attributes
+	}
+	if req.Price != nil {
+		price, err := basePrice(*req.Price)
+		if err != nil {
+			h.ErrorHdlr.HandleBadRequest(w, "Invalid price: "+err.Error())
+			return
+		}
+		update["variants.$.price"] = price
+	}
+
+	if len(update) == 0 && req.Stock == nil {
+		h.ErrorHdlr.HandleBadRequest(w, "No fields to update")
+		return
+	}
+	if msg := variantConflict(product, variantID, sku, req.Attributes); msg != "" {
+		h.ErrorHdlr.HandleConflict(w, msg)
+		return
+	}
+
+	if len(update) > 0 {
+		result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+			bson.M{"_id": product.ID, "variants._id": variantID},
+			bson.M{"$set": update},
+		)
+		if err == nil && result.MatchedCount == 0 {
+			h.ErrorHdlr.HandleNotFound(w, "Variant not found")
+			return
+		}
+		if err != nil {
+			h.writeVariantUpdateError(w, err)
+			return
+		}
+	}
+
+	// Overwriting the stock is recorded in the ledger as a manual set
+	if req.Stock != nil {
+		_, err := h.Inventory.Set(ctx, product.ID, &variantID, *req.Stock, inventory.Change{
+			Reason: models.MovementManualSet,
+			Actor:  currentUser(r),
+		})
+		if err != nil {
+			h.writeInventoryError(w, err)
+			return
+		}
+	}
+
+	if err := h.refreshVariantSummary(ctx, product.ID); err != nil {
+		h.writeVariantUpdateError(w, err)
+		return
+	}
+
+	h.variantsResponse(w, r, "Product variant updated successfully", false, product)
+}
+
+// DeleteVariant handles removing a variant from a product
+func (h *Handler) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	variantID, err := primitive.ObjectIDFromHex(mux.Vars(r)["variantId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid variant ID")
+		return
+	}
+
+	product, ok := h.loadProduct(w, r)
+	if !ok {
+		return
+	}
+	idx := slices.IndexFunc(product.Variants, func(v models.Variant) bool { return v.ID == variantID })
+	if idx < 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Variant not found")
+		return
+	}
+
+	// Take the variant's stock out of the aggregate in the same update, so a
+	// product losing its last variant is left without that stock. The stock
+	// condition fails if the variant's stock changed since it was read.
+	variant := product.Variants[idx]
+	result, err := h.DB.Database(h.Database).Collection("products").UpdateOne(ctx,
+		bson.M{"_id": product.ID, "variants": bson.M{"$elemMatch": bson.M{"_id": variantID, "stock": variant.Stock}}},
+		bson.M{
+			"$pull": bson.M{"variants": bson.M{"_id": variantID}},
+			"$inc":  bson.M{"stock": -variant.Stock},
+		},
+	)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting product variant")
+		return
+	}
+	if result.MatchedCount == 0 {
+		h.ErrorHdlr.HandleConflict(w, "Variant was changed or removed, please retry")
+		return
+	}
+	if err := h.refreshVariantSummary(ctx, product.ID); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating product stock")
+		return
+	}
+	h.recordVariantMovements(ctx, currentUser(r), product)
+
+	h.variantsResponse(w, r, "Product variant successfully deleted", false, product)
+}
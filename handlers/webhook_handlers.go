@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+	"go-tutorial/utils"
+	"go-tutorial/webhooks"
+)
+
+// checkWebhook returns the problems with a subscription's URL and event
+// types
+func checkWebhook(rawURL string, eventTypes []string) []utils.ErrorDetail {
+	var problems []utils.ErrorDetail
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, utils.ErrorDetail{Field: "url", Message: "Must be an http or https URL"})
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(events.Types, eventType) {
+			problems = append(problems, utils.ErrorDetail{Field: "events", Message: "Unknown event type " + eventType})
+		}
+	}
+	return problems
+}
+
+// loadWebhook fetches the subscription named by the id route variable,
+// writing the error response when it cannot
+func (h *Handler) loadWebhook(w http.ResponseWriter, r *http.Request) (*models.WebhookSubscription, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid webhook ID")
+		return nil, false
+	}
+
+	var subscription models.WebhookSubscription
+	err = h.DB.Database(h.Database).Collection(webhooks.SubscriptionsCollection).
+		FindOne(r.Context(), bson.M{"_id": objID}).
+		Decode(&subscription)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			h.ErrorHdlr.HandleNotFound(w, "Webhook not found")
+			return nil, false
+		}
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching webhook")
+		return nil, false
+	}
+	return &subscription, true
+}
+
+// ListWebhooks handles listing webhook subscriptions, newest first. They
+// can be filtered with active=true|false.
+func (h *Handler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Webhooks support page and limit pagination only")
+		return
+	}
+
+	filter := bson.M{}
+	switch r.URL.Query().Get("active") {
+	case "":
+	case "true":
+		filter["active"] = true
+	case "false":
+		filter["active"] = false
+	default:
+		h.ErrorHdlr.HandleBadRequest(w, "active must be true or false")
+		return
+	}
+
+	collection := h.DB.Database(h.Database).Collection(webhooks.SubscriptionsCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting webhooks")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching webhooks")
+		return
+	}
+	subscriptions := []models.WebhookSubscription{}
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing webhooks data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Webhooks fetched successfully", subscriptions, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// GetWebhook handles retrieving a single webhook subscription. Its secret
+// is only shown when it is set.
+func (h *Handler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	h.ResponseHdlr.Success(w, "Webhook fetched successfully", subscription)
+}
+
+// CreateWebhook handles subscribing a URL to event types. The response
+// carries the signing secret, which is not shown again.
+func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+	if problems := checkWebhook(req.URL, req.Events); len(problems) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, problems)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhooks.GenerateSecret(); err != nil {
+			h.ErrorHdlr.HandleInternalError(w, "Error generating webhook secret")
+			return
+		}
+	}
+
+	now := time.Now()
+	subscription := models.WebhookSubscription{
+		ID:          primitive.NewObjectID(),
+		URL:         req.URL,
+		Events:      slices.Compact(slices.Sorted(slices.Values(req.Events))),
+		Secret:      secret,
+		Description: req.Description,
+		Active:      true,
+		CreatedBy:   currentUser(r),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err := h.DB.Database(h.Database).Collection(webhooks.SubscriptionsCollection).
+		InsertOne(r.Context(), subscription)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error creating webhook")
+		return
+	}
+
+	h.audit(r, models.AuditCreate, models.AuditResourceWebhook, subscription.ID.Hex(), nil, subscription)
+
+	h.ResponseHdlr.Created(w, "Webhook created successfully", models.WebhookSecretResponse{
+		WebhookSubscription: subscription,
+		Secret:              secret,
+	})
+}
+
+// UpdateWebhook handles changing a webhook subscription. Setting active to
+// true re-enables a subscription that was disabled for failing, with a
+// clean failure count.
+func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid request body")
+		return
+	}
+
+	// Validate request
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		var validationErrors []utils.ErrorDetail
+		for _, err := range err.(validator.ValidationErrors) {
+			validationErrors = append(validationErrors, utils.ErrorDetail{
+				Field:   err.Field(),
+				Message: utils.FormatValidationError(err),
+			})
+		}
+		h.ErrorHdlr.HandleValidationError(w, validationErrors)
+		return
+	}
+
+	updated := *existing
+	if req.URL != nil {
+		updated.URL = *req.URL
+	}
+	if req.Events != nil {
+		updated.Events = slices.Compact(slices.Sorted(slices.Values(req.Events)))
+	}
+	if req.Secret != nil {
+		updated.Secret = *req.Secret
+	}
+	if req.Description != nil {
+		updated.Description = *req.Description
+	}
+	if req.Active != nil && *req.Active != existing.Active {
+		updated.Active = *req.Active
+		if updated.Active {
+			updated.ConsecutiveFailures = 0
+			updated.DisabledAt = nil
+			updated.DisabledReason = ""
+		} else {
+			now := time.Now()
+			updated.DisabledAt = &now
+			updated.DisabledReason = "Disabled by " + currentUser(r)
+		}
+	}
+	if problems := checkWebhook(updated.URL, updated.Events); len(problems) > 0 {
+		h.ErrorHdlr.HandleValidationError(w, problems)
+		return
+	}
+	updated.UpdatedAt = time.Now()
+
+	result, err := h.DB.Database(h.Database).Collection(webhooks.SubscriptionsCollection).
+		ReplaceOne(r.Context(), bson.M{"_id": existing.ID}, updated)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error updating webhook")
+		return
+	}
+	if result.MatchedCount == 0 {
+		h.ErrorHdlr.HandleNotFound(w, "Webhook not found")
+		return
+	}
+
+	h.audit(r, models.AuditUpdate, models.AuditResourceWebhook, existing.ID.Hex(), existing, updated)
+
+	if req.Secret != nil {
+		h.ResponseHdlr.Success(w, "Webhook updated successfully", models.WebhookSecretResponse{
+			WebhookSubscription: updated,
+			Secret:              updated.Secret,
+		})
+		return
+	}
+	h.ResponseHdlr.Success(w, "Webhook updated successfully", updated)
+}
+
+// DeleteWebhook handles deleting a webhook subscription with its delivery
+// history
+func (h *Handler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid webhook ID")
+		return
+	}
+
+	var subscription models.WebhookSubscription
+	err = h.DB.Database(h.Database).Collection(webhooks.SubscriptionsCollection).
+		FindOneAndDelete(ctx, bson.M{"_id": objID}).
+		Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		h.ErrorHdlr.HandleNotFound(w, "Webhook not found")
+		return
+	}
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting webhook")
+		return
+	}
+
+	// Pending deliveries would be buried by the worker anyway
+	_, err = h.DB.Database(h.Database).Collection(webhooks.DeliveriesCollection).
+		DeleteMany(ctx, bson.M{"subscription_id": objID})
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error deleting webhook deliveries")
+		return
+	}
+
+	h.audit(r, models.AuditDelete, models.AuditResourceWebhook, objID.Hex(), subscription, nil)
+
+	h.ResponseHdlr.Success(w, "Webhook deleted successfully", nil)
+}
+
+// ListWebhookDeliveries handles listing the deliveries of a subscription,
+// newest first, with every attempt and its response code. They can be
+// filtered by status and event_type.
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	subscription, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	pageParams, err := utils.ParsePageParams(r, "-created_at")
+	if err != nil || pageParams.Keyset() {
+		h.ErrorHdlr.HandleBadRequest(w, "Webhook deliveries support page and limit pagination only")
+		return
+	}
+
+	filter := bson.M{"subscription_id": subscription.ID}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if status != models.DeliveryPending && status != models.DeliverySucceeded && status != models.DeliveryDead {
+			h.ErrorHdlr.HandleBadRequest(w, "status must be pending, succeeded or dead")
+			return
+		}
+		filter["status"] = status
+	}
+	if eventType := r.URL.Query().Get("event_type"); eventType != "" {
+		filter["event_type"] = eventType
+	}
+
+	collection := h.DB.Database(h.Database).Collection(webhooks.DeliveriesCollection)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error counting webhook deliveries")
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(pageParams.Skip()).
+		SetLimit(int64(pageParams.Limit))
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error fetching webhook deliveries")
+		return
+	}
+	deliveries := []models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error processing webhook deliveries data")
+		return
+	}
+
+	h.ResponseHdlr.Paginated(w, r, "Webhook deliveries fetched successfully", deliveries, utils.Page{
+		Number: pageParams.Page,
+		Limit:  pageParams.Limit,
+		Total:  &total,
+	})
+}
+
+// RedeliverWebhook handles queueing a delivery to be sent again right away,
+// whatever its status, with a fresh set of attempts. The subscription must
+// be active.
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	subscription, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !subscription.Active {
+		h.ErrorHdlr.HandleConflict(w, "Webhook is disabled, enable it first")
+		return
+	}
+
+	deliveryID, err := primitive.ObjectIDFromHex(mux.Vars(r)["deliveryId"])
+	if err != nil {
+		h.ErrorHdlr.HandleBadRequest(w, "Invalid delivery ID")
+		return
+	}
+
+	store := webhooks.NewMongoStore(h.DB.Database(h.Database))
+	delivery, err := store.Redeliver(ctx, subscription.ID, deliveryID, time.Now())
+	if err != nil {
+		h.ErrorHdlr.HandleInternalError(w, "Error queueing delivery")
+		return
+	}
+	if delivery == nil {
+		h.ErrorHdlr.HandleNotFound(w, "Delivery not found")
+		return
+	}
+
+	h.ResponseHdlr.Success(w, "Delivery queued successfully", delivery)
+}
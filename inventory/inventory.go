@@ -0,0 +1,478 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+)
+
+var (
+	// ErrInsufficientStock is returned when a change would make stock negative
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrNotFound is returned when the product, variant or reservation does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrVariantRequired is returned when changing the stock of a product with
+	// variants without naming the variant
+	ErrVariantRequired = errors.New("product has variants, a variant must be given")
+	// ErrReservationNotActive is returned when a reservation was already
+	// committed, released or has expired
+	ErrReservationNotActive = errors.New("reservation is not active")
+)
+
+// DefaultReservationTTL is how long a reservation holds stock by default
+const DefaultReservationTTL = 15 * time.Minute
+
+// Change describes why stock changed, recorded in the ledger
+type Change struct {
+	Reason        string
+	Actor         string
+	Note          string
+	ReservationID *primitive.ObjectID
+	OrderID       *primitive.ObjectID
+}
+
+// Service changes product stock atomically and records every change in the
+// stock_movements ledger, with a StockChanged event in the outbox. Each
+// operation writes all three in one transaction, or in the caller's when
+// given a transaction's session.
+type Service struct {
+	products     *mongo.Collection
+	movements    *mongo.Collection
+	reservations *mongo.Collection
+	outbox       *events.Outbox
+}
+
+// NewService creates an inventory service over db
+func NewService(db *mongo.Database) *Service {
+	return &Service{
+		products:     db.Collection("products"),
+		movements:    db.Collection("stock_movements"),
+		reservations: db.Collection("reservations"),
+		outbox:       events.NewOutbox(db),
+	}
+}
+
+// transaction runs fn in a transaction, so the stock, the ledger and the
+// outbox change together. When ctx is already a transaction's session fn
+// joins it and commits with the caller's changes. fn may run more than once
+// when the transaction is retried.
+func (s *Service) transaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	if sc, ok := ctx.(mongo.SessionContext); ok {
+		return fn(sc)
+	}
+	session, err := s.products.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// Adjust changes the stock of a product, or of one of its variants, by delta.
+// The change is a single conditional $inc, so stock never goes below zero
+// even under concurrent requests. A variant's change is applied to the
+// product's aggregate stock in the same update, and the movement is recorded
+// in the same transaction.
+func (s *Service) Adjust(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, delta int, change Change) (*models.StockMovement, error) {
+	var movement *models.StockMovement
+	err := s.transaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		movement, err = s.adjust(sc, productID, variantID, delta, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// adjust is Adjust within a transaction
+func (s *Service) adjust(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, delta int, change Change) (*models.StockMovement, error) {
+	filter := bson.M{"_id": productID}
+	inc := bson.M{"stock": delta}
+	if variantID != nil {
+		variant := bson.M{"_id": *variantID}
+		if delta < 0 {
+			variant["stock"] = bson.M{"$gte": -delta}
+		}
+		filter["variants"] = bson.M{"$elemMatch": variant}
+		inc["variants.$.stock"] = delta
+	} else {
+		filter["variants.0"] = bson.M{"$exists": false}
+		if delta < 0 {
+			filter["stock"] = bson.M{"$gte": -delta}
+		}
+	}
+
+	var product models.Product
+	err := s.products.FindOneAndUpdate(ctx, filter, bson.M{"$inc": inc},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, s.diagnose(ctx, productID, variantID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Record(ctx, productID, variantID, delta, quantityOf(&product, variantID), change)
+}
+
+// Set overwrites the stock of a product or variant and records the
+// difference from the previous value, in one transaction
+func (s *Service) Set(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, quantity int, change Change) (*models.StockMovement, error) {
+	var movement *models.StockMovement
+	err := s.transaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		movement, err = s.set(sc, productID, variantID, quantity, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// set is Set within a transaction
+func (s *Service) set(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, quantity int, change Change) (*models.StockMovement, error) {
+	filter := bson.M{"_id": productID}
+	set := bson.M{"stock": quantity}
+	if variantID != nil {
+		filter["variants._id"] = *variantID
+		set = bson.M{"variants.$.stock": quantity}
+	} else {
+		filter["variants.0"] = bson.M{"$exists": false}
+	}
+
+	var before models.Product
+	err := s.products.FindOneAndUpdate(ctx, filter, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, s.diagnose(ctx, productID, variantID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delta := quantity - quantityOf(&before, variantID)
+	if variantID != nil && delta != 0 {
+		// Keep the aggregate in step within the same transaction
+		_, err := s.products.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$inc": bson.M{"stock": delta}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Record(ctx, productID, variantID, delta, quantity, change)
+}
+
+// Record appends a movement to the ledger for a stock change that was
+// already applied, e.g. the initial stock of a new product. ctx should be the
+// session of the transaction that applied the change, the movement and its
+// event are then committed with it.
+func (s *Service) Record(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, delta, quantity int, change Change) (*models.StockMovement, error) {
+	movement := models.StockMovement{
+		ID:            primitive.NewObjectID(),
+		ProductID:     productID,
+		VariantID:     variantID,
+		Delta:         delta,
+		Quantity:      quantity,
+		Reason:        change.Reason,
+		Actor:         change.Actor,
+		ReservationID: change.ReservationID,
+		OrderID:       change.OrderID,
+		Note:          change.Note,
+		CreatedAt:     time.Now(),
+	}
+	if _, err := s.movements.InsertOne(ctx, movement); err != nil {
+		return nil, err
+	}
+	if err := s.outbox.Add(ctx, events.NewStockChanged(movement)); err != nil {
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// diagnose works out why a conditional stock update matched nothing
+func (s *Service) diagnose(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID) error {
+	var product models.Product
+	err := s.products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if variantID == nil {
+		if len(product.Variants) > 0 {
+			return ErrVariantRequired
+		}
+		return ErrInsufficientStock
+	}
+	for _, v := range product.Variants {
+		if v.ID == *variantID {
+			return ErrInsufficientStock
+		}
+	}
+	return ErrNotFound
+}
+
+// quantityOf returns the stock of a product or of one of its variants
+func quantityOf(product *models.Product, variantID *primitive.ObjectID) int {
+	if variantID == nil {
+		return product.Stock
+	}
+	for _, v := range product.Variants {
+		if v.ID == *variantID {
+			return v.Stock
+		}
+	}
+	return 0
+}
+
+// Reserve takes stock out of the available quantity for ttl. The stock and
+// the reservation are written in one transaction. The stock comes back when
+// the reservation is released or expires, and stays taken when it is
+// committed.
+func (s *Service) Reserve(ctx context.Context, productID primitive.ObjectID, variantID *primitive.ObjectID, quantity int, ttl time.Duration, actor string) (*models.Reservation, error) {
+	now := time.Now()
+	reservation := models.Reservation{
+		ID:        primitive.NewObjectID(),
+		ProductID: productID,
+		VariantID: variantID,
+		Quantity:  quantity,
+		Status:    models.ReservationActive,
+		CreatedBy: actor,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	err := s.transaction(ctx, func(sc mongo.SessionContext) error {
+		_, err := s.adjust(sc, productID, variantID, -quantity, Change{
+			Reason:        models.MovementReservation,
+			Actor:         actor,
+			ReservationID: &reservation.ID,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = s.reservations.InsertOne(sc, reservation)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// Release ends an active reservation and returns its stock
+func (s *Service) Release(ctx context.Context, reservationID primitive.ObjectID, actor string) (*models.Reservation, error) {
+	return s.release(ctx, reservationID, actor, models.ReservationReleased, models.MovementReservationReleased)
+}
+
+// Commit ends an active, unexpired reservation keeping its stock taken, e.g.
+// once the order it was held for is placed
+func (s *Service) Commit(ctx context.Context, reservationID primitive.ObjectID) (*models.Reservation, error) {
+	var reservation models.Reservation
+	err := s.reservations.FindOneAndUpdate(ctx,
+		bson.M{"_id": reservationID, "status": models.ReservationActive, "expires_at": bson.M{"$gt": time.Now()}},
+		bson.M{"$set": bson.M{"status": models.ReservationCommitted}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return nil, s.reservationError(ctx, reservationID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// release gives the stock of an active reservation back and flips it to
+// status in one transaction. The flip is conditional on the reservation
+// still being active, so when a concurrent release or commit gets there
+// first the restock is rolled back and the stock is returned only once.
+func (s *Service) release(ctx context.Context, reservationID primitive.ObjectID, actor, status, reason string) (*models.Reservation, error) {
+	var reservation models.Reservation
+	err := s.transaction(ctx, func(sc mongo.SessionContext) error {
+		err := s.reservations.FindOne(sc, bson.M{"_id": reservationID, "status": models.ReservationActive}).
+			Decode(&reservation)
+		if err == mongo.ErrNoDocuments {
+			return s.reservationError(sc, reservationID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = s.adjust(sc, reservation.ProductID, reservation.VariantID, reservation.Quantity, Change{
+			Reason:        reason,
+			Actor:         actor,
+			ReservationID: &reservation.ID,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = s.reservations.FindOneAndUpdate(sc,
+			bson.M{"_id": reservationID, "status": models.ReservationActive},
+			bson.M{"$set": bson.M{"status": status}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&reservation)
+		if err == mongo.ErrNoDocuments {
+			return ErrReservationNotActive
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// reservationError tells a missing reservation from an inactive one
+func (s *Service) reservationError(ctx context.Context, reservationID primitive.ObjectID) error {
+	count, err := s.reservations.CountDocuments(ctx, bson.M{"_id": reservationID})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrReservationNotActive
+}
+
+// ReleaseExpired releases every active reservation past its expiry time, each
+// in its own transaction, and returns the released reservations. A
+// reservation that fails to release doesn't stop the others.
+func (s *Service) ReleaseExpired(ctx context.Context) ([]models.Reservation, error) {
+	cursor, err := s.reservations.Find(ctx, bson.M{
+		"status":     models.ReservationActive,
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var expired []models.Reservation
+	if err := cursor.All(ctx, &expired); err != nil {
+		return nil, err
+	}
+
+	var released []models.Reservation
+	var errs []error
+	for _, r := range expired {
+		reservation, err := s.release(ctx, r.ID, "system", models.ReservationExpired, models.MovementReservationExpired)
+		if errors.Is(err, ErrReservationNotActive) {
+			// Committed or released since it was listed
+			continue
+		}
+		if err != nil {
+			// The others are released anyway, this one is retried next time
+			errs = append(errs, err)
+			continue
+		}
+		released = append(released, *reservation)
+	}
+	return released, errors.Join(errs...)
+}
+
+// History returns a page of a product's stock movements, newest first
+func (s *Service) History(ctx context.Context, productID primitive.ObjectID, skip, limit int64) ([]models.StockMovement, int64, error) {
+	filter := bson.M{"product_id": productID}
+	total, err := s.movements.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := s.movements.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	movements := []models.StockMovement{}
+	if err := cursor.All(ctx, &movements); err != nil {
+		return nil, 0, err
+	}
+	return movements, total, nil
+}
+
+// Reconcile sums the ledger of a product and compares it with the stored
+// stock of the product and of each variant
+func (s *Service) Reconcile(ctx context.Context, productID primitive.ObjectID) (*models.StockReconciliation, error) {
+	var product models.Product
+	err := s.products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.movements.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$variant_id", "total": bson.M{"$sum": "$delta"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		VariantID *primitive.ObjectID `bson:"_id"`
+		Total     int                 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, err
+	}
+
+	ledgerTotal := 0
+	variantTotals := make(map[primitive.ObjectID]int)
+	for _, t := range totals {
+		ledgerTotal += t.Total
+		if t.VariantID != nil {
+			variantTotals[*t.VariantID] = t.Total
+		}
+	}
+
+	result := &models.StockReconciliation{
+		ProductID:   productID,
+		Stock:       product.Stock,
+		LedgerTotal: ledgerTotal,
+		Difference:  product.Stock - ledgerTotal,
+	}
+	result.Consistent = result.Difference == 0
+	for _, v := range product.Variants {
+		variant := models.VariantReconciliation{
+			VariantID:   v.ID,
+			SKU:         v.SKU,
+			Stock:       v.Stock,
+			LedgerTotal: variantTotals[v.ID],
+			Difference:  v.Stock - variantTotals[v.ID],
+		}
+		if variant.Difference != 0 {
+			result.Consistent = false
+		}
+		result.Variants = append(result.Variants, variant)
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go-tutorial/cache"
+)
+
+// ReservationSweeper periodically releases expired reservations
+type ReservationSweeper struct {
+	service  *Service
+	interval time.Duration
+}
+
+func NewReservationSweeper(service *Service, interval time.Duration) *ReservationSweeper {
+	return &ReservationSweeper{
+		service:  service,
+		interval: interval,
+	}
+}
+
+func (s *ReservationSweeper) Start() {
+	ticker := time.NewTicker(s.interval)
+	go func() {
+		for range ticker.C {
+			s.sweep()
+		}
+	}()
+}
+
+// sweep releases the expired reservations. Each one is released in its own
+// transaction, which restocks it, records the movement, adds its
+// StockChanged event to the outbox and flips its status, so one failing
+// reservation doesn't hold back the others.
+func (s *ReservationSweeper) sweep() {
+	ctx := context.Background()
+
+	released, err := s.service.ReleaseExpired(ctx)
+	if err != nil {
+		log.Printf("Error releasing expired reservations: %v", err)
+	}
+	if len(released) == 0 {
+		return
+	}
+
+	// Stock changed, drop the cached copies of the affected products
+	for _, r := range released {
+		if err := cache.DeleteCache(ctx, fmt.Sprintf(cache.ProductDetailPattern, r.ProductID.Hex())); err != nil {
+			log.Printf("Failed to invalidate product detail cache: %v", err)
+		}
+	}
+	if err := cache.DeleteByPattern(ctx, cache.ProductListPattern); err != nil {
+		log.Printf("Failed to invalidate product list cache: %v", err)
+	}
+
+	log.Printf("Released %d expired reservations", len(released))
+}
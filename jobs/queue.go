@@ -0,0 +1,140 @@
+// Package jobs runs background work from a queue kept in MongoDB. Jobs are
+// enqueued by type with a JSON payload and run by the handler registered for
+// that type in any process running a Worker, so the API and the workers can
+// be scaled apart. Failed jobs are retried with backoff, and a job whose
+// worker stopped is run again once its lease runs out.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// Collection is where jobs are stored
+const Collection = "jobs"
+
+// DefaultMaxAttempts is how many times a job is tried unless it is enqueued
+// with its own limit
+const DefaultMaxAttempts = 5
+
+var (
+	// ErrNotFound is returned when there is no job with that ID
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when cancelling a job that already finished
+	ErrFinished = errors.New("job already finished")
+	// ErrCancelled is the cause of a running job's context being cancelled
+	// because the job was cancelled, see context.Cause
+	ErrCancelled = errors.New("job cancelled")
+	// ErrLeaseLost is the cause of a running job's context being cancelled
+	// because another worker took the job over. The handler must stop
+	// without recording anything, the job is running elsewhere.
+	ErrLeaseLost = errors.New("job lease lost")
+)
+
+// Options change how an enqueued job is run
+type Options struct {
+	MaxAttempts int       // Attempts before the job fails, DefaultMaxAttempts when 0
+	RunAt       time.Time // The job is not run before this time, now when zero
+	CreatedBy   string
+}
+
+// Queue stores jobs
+type Queue struct {
+	jobs *mongo.Collection
+}
+
+// NewQueue creates a queue over db
+func NewQueue(db *mongo.Database) *Queue {
+	return &Queue{jobs: db.Collection(Collection)}
+}
+
+// Enqueue adds a job of the given type, payload is stored as JSON
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}, opts Options) (*models.Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &models.Job{
+		ID:          primitive.NewObjectID(),
+		Type:        jobType,
+		Payload:     raw,
+		Status:      models.JobQueued,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       opts.RunAt,
+		CreatedBy:   opts.CreatedBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = DefaultMaxAttempts
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	if _, err := q.jobs.InsertOne(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Get returns a job
+func (q *Queue) Get(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	var job models.Job
+	err := q.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Cancel stops a job. A queued job is cancelled at once; a running job is
+// asked to stop, its worker cancels the handler's context at its next
+// heartbeat and records it as cancelled when the handler returns.
+func (q *Queue) Cancel(ctx context.Context, id primitive.ObjectID) (*models.Job, error) {
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job models.Job
+	err := q.jobs.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": models.JobQueued},
+		bson.M{"$set": bson.M{"status": models.JobCancelled, "finished_at": now, "updated_at": now}},
+		opts,
+	).Decode(&job)
+	if err == nil {
+		return &job, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	err = q.jobs.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "status": models.JobRunning},
+		bson.M{"$set": bson.M{"cancel_requested": true, "updated_at": now}},
+		opts,
+	).Decode(&job)
+	if err == nil {
+		return &job, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	if _, err := q.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return nil, ErrFinished
+}
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Handler runs a job. What it returns is stored as the job's result; an
+// error fails the attempt, and the job is tried again unless the error is
+// Permanent or the job is out of attempts. Handlers must stop when ctx is
+// done, which happens when the job is cancelled or its worker loses it.
+// A job may run more than once, handlers should be safe to repeat.
+type Handler func(ctx context.Context, task *Task) (interface{}, error)
+
+// Typed adapts a handler taking the job's payload decoded into P. A payload
+// that doesn't decode fails the job without retrying it.
+func Typed[P any](fn func(ctx context.Context, task *Task, payload P) (interface{}, error)) Handler {
+	return func(ctx context.Context, task *Task) (interface{}, error) {
+		var payload P
+		if err := json.Unmarshal(task.Job.Payload, &payload); err != nil {
+			return nil, Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+		return fn(ctx, task, payload)
+	}
+}
+
+// permanentError is an error retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying won't fix, failing the job at
+// once
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// isPermanent reports whether err was marked Permanent
+func isPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+type registration struct {
+	handler     Handler
+	concurrency int
+}
+
+// Registry maps job types to their handlers
+type Registry struct {
+	handlers map[string]registration
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]registration)}
+}
+
+// Register sets the handler of a job type. At most concurrency jobs of the
+// type run at once in a worker, 0 leaves it to the worker's own limit.
+func (r *Registry) Register(jobType string, concurrency int, handler Handler) {
+	r.handlers[jobType] = registration{handler: handler, concurrency: concurrency}
+}
+
+// Types returns the registered job types, sorted
+func (r *Registry) Types() []string {
+	types := make([]string, 0, len(r.handlers))
+	for jobType := range r.handlers {
+		types = append(types, jobType)
+	}
+	sort.Strings(types)
+	return types
+}
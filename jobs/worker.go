@@ -0,0 +1,364 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+const (
+	// baseBackoff is the wait after the first failed attempt, doubled after
+	// each further one
+	baseBackoff = 10 * time.Second
+	// maxBackoff caps the wait between attempts
+	maxBackoff = time.Hour
+)
+
+// Policy sizes a worker
+type Policy struct {
+	Concurrency       int           // Jobs run at once, over all types
+	PollInterval      time.Duration // Wait between looks at the queue while it is idle
+	VisibilityTimeout time.Duration // How long a job stays leased without a heartbeat before another worker takes it over
+}
+
+// Task is a job being run, given to its handler
+type Task struct {
+	Job   *models.Job
+	queue *Queue
+}
+
+// Progress records how far the job has got, shown with the job
+func (t *Task) Progress(ctx context.Context, done, total int, message string) error {
+	t.Job.Progress = models.JobProgress{Done: done, Total: total, Message: message}
+	_, err := t.queue.jobs.UpdateOne(ctx, t.claimed(), bson.M{"$set": bson.M{
+		"progress":   t.Job.Progress,
+		"updated_at": time.Now(),
+	}})
+	return err
+}
+
+// claimed matches the job only while this attempt still holds it
+func (t *Task) claimed() bson.M {
+	return bson.M{"_id": t.Job.ID, "status": models.JobRunning, "attempts": t.Job.Attempts}
+}
+
+// Worker runs queued jobs with the handlers of a registry. Each job is
+// leased while it runs and the lease is renewed by a heartbeat, which also
+// picks up cancellations. Several workers, in any number of processes, can
+// share a queue.
+type Worker struct {
+	queue    *Queue
+	registry *Registry
+	policy   Policy
+	id       string
+
+	mu      sync.Mutex
+	running map[string]int // Running jobs by type
+	total   int
+	wake    chan struct{}
+}
+
+func NewWorker(queue *Queue, registry *Registry, policy Policy) *Worker {
+	if policy.Concurrency < 1 {
+		policy.Concurrency = 1
+	}
+	return &Worker{
+		queue:    queue,
+		registry: registry,
+		policy:   policy,
+		id:       workerID(),
+		running:  make(map[string]int),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (w *Worker) Start() {
+	log.Printf("Job worker %s running %v", w.id, w.registry.Types())
+	ticker := time.NewTicker(w.policy.PollInterval)
+	go func() {
+		for {
+			w.work()
+			select {
+			case <-ticker.C:
+			case <-w.wake:
+			}
+		}
+	}()
+}
+
+// work claims jobs until the queue is empty or every slot is taken
+func (w *Worker) work() {
+	ctx := context.Background()
+	for {
+		types := w.free()
+		if len(types) == 0 {
+			return
+		}
+		job, err := w.claim(ctx, types)
+		if err != nil {
+			log.Printf("Error claiming job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+		w.acquire(job.Type)
+		go w.run(job)
+	}
+}
+
+// free returns the job types that can be started now
+func (w *Worker) free() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.total >= w.policy.Concurrency {
+		return nil
+	}
+	var types []string
+	for jobType, reg := range w.registry.handlers {
+		if reg.concurrency <= 0 || w.running[jobType] < reg.concurrency {
+			types = append(types, jobType)
+		}
+	}
+	return types
+}
+
+func (w *Worker) acquire(jobType string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running[jobType]++
+	w.total++
+}
+
+// release frees the slot of a finished job and looks for the next one
+// without waiting for the poll interval
+func (w *Worker) release(jobType string) {
+	w.mu.Lock()
+	w.running[jobType]--
+	w.total--
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// claim leases the job that has been due the longest among the given
+// types, including running jobs whose lease ran out, returning nil when
+// there is none
+func (w *Worker) claim(ctx context.Context, types []string) (*models.Job, error) {
+	now := time.Now()
+	var job models.Job
+	err := w.queue.jobs.FindOneAndUpdate(ctx,
+		bson.M{
+			"type": bson.M{"$in": types},
+			"$or": []bson.M{
+				{"status": models.JobQueued, "run_at": bson.M{"$lte": now}},
+				{"status": models.JobRunning, "lease_until": bson.M{"$lt": now}},
+			},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":      models.JobRunning,
+				"worker":      w.id,
+				"lease_until": now.Add(w.policy.VisibilityTimeout),
+				"started_at":  now,
+				"updated_at":  now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "run_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// run runs a claimed job and records its outcome
+func (w *Worker) run(job *models.Job) {
+	defer w.release(job.Type)
+	ctx := context.Background()
+	task := &Task{Job: job, queue: w.queue}
+
+	// A job taken over from a stopped worker may have been cancelled or
+	// have used up its attempts meanwhile
+	if job.CancelRequested {
+		w.cancelled(ctx, task)
+		return
+	}
+	if job.Attempts > job.MaxAttempts {
+		w.failed(ctx, task, fmt.Errorf("stopped after %d attempts, the last worker stopped while running it", job.MaxAttempts))
+		return
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		if cause := w.heartbeat(runCtx, task); cause != nil {
+			cancel(cause)
+		}
+	}()
+
+	result, err := w.call(runCtx, task)
+	cancel(nil)
+	<-heartbeatDone
+
+	cause := context.Cause(runCtx)
+	switch {
+	case errors.Is(cause, ErrLeaseLost):
+		log.Printf("Job %s (%s) was taken over by another worker", job.ID.Hex(), job.Type)
+	case err == nil:
+		w.succeeded(ctx, task, result)
+	case errors.Is(cause, ErrCancelled):
+		w.cancelled(ctx, task)
+	case isPermanent(err) || job.Attempts >= job.MaxAttempts:
+		w.failed(ctx, task, err)
+	default:
+		w.retry(ctx, task, err)
+	}
+}
+
+// call runs the handler of a job, turning a panic into an error
+func (w *Worker) call(ctx context.Context, task *Task) (result interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return w.registry.handlers[task.Job.Type].handler(ctx, task)
+}
+
+// heartbeat renews the lease of a running job until ctx is done. It returns
+// why the job must stop: ErrCancelled or ErrLeaseLost.
+func (w *Worker) heartbeat(ctx context.Context, task *Task) error {
+	ticker := time.NewTicker(w.policy.VisibilityTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		var current models.Job
+		err := w.queue.jobs.FindOneAndUpdate(ctx, task.claimed(),
+			bson.M{"$set": bson.M{"lease_until": time.Now().Add(w.policy.VisibilityTimeout)}},
+			options.FindOneAndUpdate().
+				SetProjection(bson.M{"cancel_requested": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return ErrLeaseLost
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error renewing lease of job %s: %v", task.Job.ID.Hex(), err)
+			}
+			continue
+		}
+		if current.CancelRequested {
+			return ErrCancelled
+		}
+	}
+}
+
+func (w *Worker) succeeded(ctx context.Context, task *Task, result interface{}) {
+	set := bson.M{"status": models.JobSucceeded}
+	if result != nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			w.failed(ctx, task, fmt.Errorf("encoding result: %w", err))
+			return
+		}
+		set["result"] = json.RawMessage(raw)
+	}
+	w.finish(ctx, task, set)
+}
+
+func (w *Worker) failed(ctx context.Context, task *Task, err error) {
+	log.Printf("Job %s (%s) failed: %v", task.Job.ID.Hex(), task.Job.Type, err)
+	w.finish(ctx, task, bson.M{"status": models.JobFailed, "error": err.Error()})
+}
+
+func (w *Worker) cancelled(ctx context.Context, task *Task) {
+	w.finish(ctx, task, bson.M{"status": models.JobCancelled})
+}
+
+// finish records the final status of a job
+func (w *Worker) finish(ctx context.Context, task *Task, set bson.M) {
+	now := time.Now()
+	set["finished_at"] = now
+	set["updated_at"] = now
+	w.record(ctx, task, bson.M{"$set": set, "$unset": bson.M{"lease_until": ""}})
+}
+
+// retry puts a job back in the queue after a failed attempt
+func (w *Worker) retry(ctx context.Context, task *Task, err error) {
+	wait := backoff(task.Job.Attempts)
+	log.Printf("Job %s (%s) attempt %d failed, retrying in %s: %v",
+		task.Job.ID.Hex(), task.Job.Type, task.Job.Attempts, wait, err)
+	now := time.Now()
+	w.record(ctx, task, bson.M{
+		"$set": bson.M{
+			"status":     models.JobQueued,
+			"error":      err.Error(),
+			"run_at":     now.Add(wait),
+			"updated_at": now,
+		},
+		"$unset": bson.M{"lease_until": "", "worker": ""},
+	})
+}
+
+func (w *Worker) record(ctx context.Context, task *Task, update bson.M) {
+	if _, err := w.queue.jobs.UpdateOne(ctx, task.claimed(), update); err != nil {
+		log.Printf("Error recording job %s: %v", task.Job.ID.Hex(), err)
+	}
+}
+
+// backoff returns the wait before the attempt following the given number of
+// failed ones: 10s, 20s, 40s and so on up to maxBackoff
+func backoff(failures int) time.Duration {
+	if failures < 1 {
+		return baseBackoff
+	}
+	if failures > 16 {
+		return maxBackoff
+	}
+	wait := baseBackoff << (failures - 1)
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+// workerID names a worker after its host and process, with a random suffix
+// in case a process runs several
+func workerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
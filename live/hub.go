@@ -0,0 +1,220 @@
+// Package live pushes product events to connected clients, e.g. over
+// Server-Sent Events. Every instance receives all events through Redis
+// pub/sub and fans them out to its own subscribers, keeping the latest ones
+// so clients can resume after reconnecting.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+)
+
+const (
+	// subscriberBuffer is how many events a subscriber may fall behind
+	// before it is dropped and has to resume
+	subscriberBuffer = 64
+	// maxKnownCategories bounds the product category lookups remembered
+	maxKnownCategories = 10000
+)
+
+// Filter restricts the events a subscriber receives. Empty sets match
+// everything.
+type Filter struct {
+	Categories map[string]bool
+	ProductIDs map[string]bool
+}
+
+// Message is an event with the category of its product
+type Message struct {
+	Event    events.Event
+	Category string
+}
+
+// matches reports whether msg passes the filter
+func (f Filter) matches(msg Message) bool {
+	if len(f.Categories) > 0 && !f.Categories[msg.Category] {
+		return false
+	}
+	if len(f.ProductIDs) > 0 && !f.ProductIDs[msg.Event.AggregateID] {
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events matching its filter on C. C is closed
+// when the subscriber falls too far behind.
+type Subscription struct {
+	C      chan Message
+	filter Filter
+}
+
+// Hub fans product events out to subscribers
+type Hub struct {
+	client   *redis.Client
+	channel  string
+	products *mongo.Collection
+
+	mu          sync.Mutex
+	replay      []Message // ring buffer of the latest events
+	next        int       // position of the next event in replay
+	full        bool
+	subscribers map[*Subscription]struct{}
+	categories  map[string]string // product ID to category
+}
+
+// NewHub creates a hub receiving events on channel and keeping the latest
+// replaySize of them
+func NewHub(client *redis.Client, channel string, db *mongo.Database, replaySize int) *Hub {
+	return &Hub{
+		client:      client,
+		channel:     channel,
+		products:    db.Collection("products"),
+		replay:      make([]Message, replaySize),
+		subscribers: make(map[*Subscription]struct{}),
+		categories:  make(map[string]string),
+	}
+}
+
+// Start receives events until the process exits. The Redis client
+// resubscribes by itself after a lost connection, events published in the
+// meantime are missed.
+func (h *Hub) Start() {
+	pubsub := h.client.Subscribe(context.Background(), h.channel)
+	go func() {
+		for msg := range pubsub.Channel() {
+			var event events.Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("Invalid event on %s: %v", h.channel, err)
+				continue
+			}
+			if event.AggregateType != events.AggregateProduct {
+				continue
+			}
+			h.broadcast(Message{Event: event, Category: h.categoryOf(event)})
+		}
+	}()
+}
+
+// broadcast records msg for replay and hands it to the matching
+// subscribers. Subscribers that can't keep up are dropped.
+func (h *Hub) broadcast(msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.replay) > 0 {
+		h.replay[h.next] = msg
+		h.next = (h.next + 1) % len(h.replay)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(msg) {
+			continue
+		}
+		select {
+		case sub.C <- msg:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. When lastEventID is given, the matching
+// events after it are returned for replay. resumed is false when that event
+// is no longer in the buffer, the subscriber has then missed events.
+func (h *Hub) Subscribe(filter Filter, lastEventID string) (sub *Subscription, missed []Message, resumed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resumed = lastEventID == ""
+	for _, msg := range h.buffered() {
+		if !resumed {
+			resumed = msg.Event.ID.Hex() == lastEventID
+			continue
+		}
+		if lastEventID != "" && filter.matches(msg) {
+			missed = append(missed, msg)
+		}
+	}
+
+	sub = &Subscription{C: make(chan Message, subscriberBuffer), filter: filter}
+	h.subscribers[sub] = struct{}{}
+	return sub, missed, resumed
+}
+
+// Unsubscribe removes a subscriber
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// buffered returns the replay buffer oldest first. The caller holds mu.
+func (h *Hub) buffered() []Message {
+	if !h.full {
+		return h.replay[:h.next]
+	}
+	return append(append([]Message{}, h.replay[h.next:]...), h.replay[:h.next]...)
+}
+
+// categoryOf returns the category of the product an event is about. Product
+// events carry it, stock events are looked up.
+func (h *Hub) categoryOf(event events.Event) string {
+	if event.Type != events.StockChanged {
+		var data events.ProductData
+		if err := json.Unmarshal(event.Data, &data); err == nil {
+			h.remember(event.AggregateID, data.Product.Category)
+			return data.Product.Category
+		}
+	}
+
+	h.mu.Lock()
+	category, ok := h.categories[event.AggregateID]
+	h.mu.Unlock()
+	if ok {
+		return category
+	}
+
+	productID, err := primitive.ObjectIDFromHex(event.AggregateID)
+	if err != nil {
+		return ""
+	}
+	var product models.Product
+	err = h.products.FindOne(context.Background(), bson.M{"_id": productID},
+		options.FindOne().SetProjection(bson.M{"category": 1}),
+	).Decode(&product)
+	if err != nil {
+		log.Printf("Failed to look up category of product %s: %v", event.AggregateID, err)
+		return ""
+	}
+	h.remember(event.AggregateID, product.Category)
+	return product.Category
+}
+
+// remember caches the category of a product, forgetting them all when
+// there are too many
+func (h *Hub) remember(productID, category string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.categories) >= maxKnownCategories {
+		h.categories = make(map[string]string)
+	}
+	h.categories[productID] = category
+}
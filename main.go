@@ -2,16 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"time"
 
+	"google.golang.org/grpc"
+
+	"go-tutorial/audit"
 	"go-tutorial/cache"
+	"go-tutorial/catalog"
 	"go-tutorial/config"
 	"go-tutorial/database"
+	"go-tutorial/discounts"
+	"go-tutorial/events"
 	"go-tutorial/handlers"
+	"go-tutorial/inventory"
+	"go-tutorial/jobs"
+	"go-tutorial/live"
+	"go-tutorial/media"
+	"go-tutorial/middleware"
+	"go-tutorial/migrations"
+	"go-tutorial/money"
+	"go-tutorial/notifications"
+	"go-tutorial/payments"
+	"go-tutorial/revisions"
 	"go-tutorial/router"
+	"go-tutorial/search"
+	"go-tutorial/trash"
 	"go-tutorial/utils"
+	"go-tutorial/webhooks"
 )
 
 type App struct {
@@ -23,6 +45,19 @@ func main() {
 	// Load MongoDB configuration
 	cfg := config.LoadConfig()
 
+	// The same binary serves the API, runs the background work or both, so
+	// workers can be scaled apart from the API
+	flag.StringVar(&cfg.Mode, "mode", cfg.Mode, "api, worker or all")
+	// The GraphQL IDE lets anyone browse the schema, so it is off unless
+	// asked for in development
+	flag.BoolVar(&cfg.GraphiQL, "graphiql", cfg.GraphiQL, "serve the GraphiQL IDE at /graphiql, for development")
+	flag.Parse()
+	if cfg.Mode != "api" && cfg.Mode != "worker" && cfg.Mode != "all" {
+		log.Fatalf("Invalid mode %q, must be api, worker or all", cfg.Mode)
+	}
+	serveAPI := cfg.Mode != "worker"
+	runWorker := cfg.Mode != "api"
+
 	// Initialize MongoDB connection
 	client, err := database.Connect(cfg)
 	if err != nil {
@@ -30,6 +65,26 @@ func main() {
 	}
 	defer client.Disconnect(context.TODO())
 
+	// Prices are stored in the base currency, migrations depend on it
+	if err := money.SetBase(cfg.Currency); err != nil {
+		log.Fatalf("Invalid base currency: %v", err)
+	}
+
+	// Create collection indexes
+	if err := database.EnsureIndexes(context.TODO(), client.Database(cfg.Database)); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+
+	// Expire audit entries after the retention period
+	if err := audit.EnsureRetention(context.TODO(), client.Database(cfg.Database), cfg.AuditRetention); err != nil {
+		log.Fatalf("Failed to set audit log retention: %v", err)
+	}
+
+	// Apply pending data migrations
+	if err := migrations.Run(context.TODO(), client.Database(cfg.Database)); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
 	// Initialize Redis
 	redisConfig := cache.RedisConfig{
 		Host:     "localhost", // Change this to your Redis host
@@ -43,19 +98,159 @@ func main() {
 	}
 
 	// Initialize and start Redis update job
-	redisUpdateJob := utils.NewRedisUpdateJob(client, cfg.Database)
-	redisUpdateJob.Start()
+	if runWorker {
+		redisUpdateJob := utils.NewRedisUpdateJob(client, cfg.Database)
+		redisUpdateJob.Start()
+	}
+
+	// Initialize and start the expired reservation sweeper
+	stock := inventory.NewService(client.Database(cfg.Database))
+	if runWorker {
+		inventory.NewReservationSweeper(stock, time.Minute).Start()
+	}
 
 	// Initialize application
 	app := &App{}
 	app.DB = client
 	app.Database = cfg.Database
+	app.Search = search.NewMongoIndex(client.Database(cfg.Database))
+	app.Inventory = stock
+	app.Discounts = discounts.NewService(client.Database(cfg.Database))
+	app.Revisions = revisions.NewService(client.Database(cfg.Database), revisions.Policy{
+		Keep:   cfg.RevisionsKeep,
+		MaxAge: cfg.RevisionsMaxAge,
+	})
+
+	// Load exchange rates, saved ones win over the rates file
+	app.Rates = money.NewRateStore(money.Base())
+	if err := handlers.LoadExchangeRates(context.TODO(), client.Database(cfg.Database), app.Rates, cfg.ExchangeRatesFile); err != nil {
+		log.Fatalf("Failed to load exchange rates: %v", err)
+	}
+
+	// The fake payment provider notifies this server's own webhook endpoint
+	app.Payments = payments.NewFakeProvider(cfg.PaymentWebhookSecret,
+		"http://localhost"+cfg.Port+"/payments/webhook")
+
+	// Product images are kept on local disk or in an S3-compatible bucket
+	var blobs media.BlobStore = media.NewLocalStore(cfg.MediaDir)
+	if cfg.MediaStore == "s3" {
+		blobs = media.NewS3Store(media.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	}
+	app.Media = media.NewImages(blobs,
+		media.NewURLSigner(cfg.MediaURLSecret, "/media", cfg.MediaURLTTL),
+		cfg.ThumbnailSizes, cfg.MaxImageSize)
+
+	// Background jobs are queued by either mode and run by workers
+	app.Jobs = jobs.NewQueue(client.Database(cfg.Database))
+
+	// Product import files are kept with the images until they are imported
+	app.Imports = catalog.NewImports(client.Database(cfg.Database), blobs, app.Jobs, cfg.ImportMaxSize)
+
+	// Hard delete trashed users and products once their retention is over
+	if runWorker {
+		trash.NewPurger(client.Database(cfg.Database), app.Media,
+			cfg.TrashRetention, cfg.TrashPurgeInterval).Start()
+	}
+
+	// Audit entries are written in the background
+	app.Audit = audit.NewLogger(client.Database(cfg.Database), cfg.AuditBufferSize)
+	app.Audit.Start()
+
+	// Domain events are written to the outbox with the changes they describe
+	// and relayed to the configured publisher
+	app.Events = events.NewOutbox(client.Database(cfg.Database))
+	var publisher events.Publisher = events.LogPublisher{}
+	switch cfg.EventPublisher {
+	case "redis":
+		publisher = events.NewRedisStream(cache.GetRedisClient(), cfg.EventStream, cfg.EventStreamMaxLen)
+	case "memory":
+		publisher = events.NewBus()
+	}
+	if runWorker {
+		events.NewRelay(app.Events, events.Fanout{
+			publisher,
+			webhooks.NewDispatcher(client.Database(cfg.Database)),
+			events.NewRedisPubSub(cache.GetRedisClient(), cfg.LiveChannel),
+		}, cfg.EventRelayInterval, cfg.EventMaxAttempts).Start()
+	}
+
+	// Every instance streams the broadcast events to its own clients
+	app.Live = live.NewHub(cache.GetRedisClient(), cfg.LiveChannel, client.Database(cfg.Database), cfg.LiveReplaySize)
+	if serveAPI {
+		app.Live.Start()
+	}
+
+	// Webhook deliveries are sent and retried in the background
+	if runWorker {
+		webhooks.NewWorker(webhooks.NewMongoStore(client.Database(cfg.Database)), cfg.WebhookInterval, webhooks.Policy{
+			MaxAttempts:  cfg.WebhookMaxAttempts,
+			DisableAfter: cfg.WebhookDisableAfter,
+			Timeout:      cfg.WebhookTimeout,
+		}).Start()
+	}
+
+	// Notifications are pushed to the users' sockets on whichever instance
+	// holds them, workers need the redis broker to reach them
+	var broker notifications.Broker = notifications.NewMemoryBroker()
+	var presence notifications.Presence = notifications.NewMemoryPresence()
+	if cfg.NotificationBroker == "redis" {
+		broker = notifications.NewRedisBroker(cache.GetRedisClient(), cfg.NotificationChannel)
+		presence = notifications.NewRedisPresence(cache.GetRedisClient())
+	}
+	app.Notifications = notifications.NewService(client.Database(cfg.Database), broker)
+	app.NotificationHub = notifications.NewHub(broker, presence)
+	if serveAPI {
+		app.NotificationHub.Start()
+	}
+
+	// Queued jobs run through the fully wired handler
+	if runWorker {
+		registry := jobs.NewRegistry()
+		app.RegisterJobs(registry)
+		jobs.NewWorker(app.Jobs, registry, jobs.Policy{
+			Concurrency:       cfg.JobConcurrency,
+			PollInterval:      cfg.JobPollInterval,
+			VisibilityTimeout: cfg.JobVisibilityTimeout,
+		}).Start()
+	}
+
+	fmt.Println("Connected to MongoDB!")
+	if !serveAPI {
+		fmt.Println("Worker running")
+		select {}
+	}
+
+	// Internal services authenticate with API keys on both servers
+	middleware.SetAPIKeys(cfg.APIKeys)
 
 	// Setup router
 	app.Router = router.SetupRoutes(&app.Handler)
+	if cfg.GraphiQL {
+		app.Router.HandleFunc("/graphiql", handlers.GraphiQL).Methods("GET")
+	}
+
+	// The gRPC server runs alongside the HTTP server on the same handlers
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(middleware.UnaryAuthInterceptor()),
+		grpc.StreamInterceptor(middleware.StreamAuthInterceptor()),
+	)
+	app.RegisterGRPC(grpcServer)
+	listener, err := net.Listen("tcp", cfg.GRPCPort)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		log.Fatal(grpcServer.Serve(listener))
+	}()
+	fmt.Printf("gRPC server running at localhost%s\n", cfg.GRPCPort)
 
 	// Start server
-	fmt.Println("Connected to MongoDB!")
 	fmt.Printf("Server running at http://localhost%s\n", cfg.Port)
 
 	log.Fatal(http.ListenAndServe(cfg.Port, app.Router))
@@ -0,0 +1,88 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"path"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+)
+
+// AvatarSizes are the square sizes an avatar is stored in
+var AvatarSizes = []int{64, 256}
+
+// UploadAvatar checks an uploaded picture, crops it to a centred square and
+// stores it under "users/<user>/avatars/<avatar>/" in each of AvatarSizes.
+// The original is not kept.
+func (m *Images) UploadAvatar(ctx context.Context, userID primitive.ObjectID, data []byte) (*models.Avatar, error) {
+	if int64(len(data)) > m.maxSize {
+		return nil, ErrTooLarge
+	}
+	contentType, err := sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	avatar := &models.Avatar{ID: primitive.NewObjectID(), UpdatedAt: time.Now()}
+	dir := path.Join("users", userID.Hex(), "avatars", avatar.ID.Hex())
+
+	square := cropSquare(toRGBA(img))
+	side := square.Bounds().Dx()
+	for _, size := range AvatarSizes {
+		size := min(size, side)
+		if n := len(avatar.Sizes); n > 0 && avatar.Sizes[n-1].Size == size {
+			continue // Small pictures aren't scaled up
+		}
+		data, thumbType, err := encode(resize(square, size, size), contentType)
+		if err != nil {
+			m.DeleteAvatar(ctx, avatar)
+			return nil, err
+		}
+		key := path.Join(dir, fmt.Sprintf("%d%s", size, extensions[thumbType]))
+		if err := m.Store.Put(ctx, key, data, thumbType); err != nil {
+			m.DeleteAvatar(ctx, avatar)
+			return nil, err
+		}
+		avatar.Sizes = append(avatar.Sizes, models.ImageThumbnail{
+			Size:   size,
+			Key:    key,
+			Width:  size,
+			Height: size,
+		})
+	}
+	return avatar, nil
+}
+
+// DeleteAvatar removes the stored sizes of an avatar
+func (m *Images) DeleteAvatar(ctx context.Context, avatar *models.Avatar) {
+	for _, size := range avatar.Sizes {
+		m.deleteBlob(ctx, size.Key)
+	}
+}
+
+// SignAvatar sets the signed URLs of an avatar's sizes
+func (m *Images) SignAvatar(avatar *models.Avatar) {
+	if avatar == nil {
+		return
+	}
+	for i := range avatar.Sizes {
+		avatar.Sizes[i].URL = m.Signer.URL(avatar.Sizes[i].Key)
+	}
+}
+
+// cropSquare returns the largest centred square of an image
+func cropSquare(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	return src.SubImage(image.Rect(x, y, x+side, y+side)).(*image.RGBA)
+}
@@ -0,0 +1,120 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+)
+
+// Images stores product images and their thumbnails in a blob store and
+// signs the URLs they are served from
+type Images struct {
+	Store   BlobStore
+	Signer  *URLSigner
+	sizes   []int
+	maxSize int64
+}
+
+// NewImages creates an image service. A thumbnail is generated for each of
+// sizes, fitting the image into a square box of that many pixels. Uploads
+// larger than maxSize bytes are rejected.
+func NewImages(store BlobStore, signer *URLSigner, sizes []int, maxSize int64) *Images {
+	sizes = slices.Clone(sizes)
+	slices.Sort(sizes)
+	return &Images{Store: store, Signer: signer, sizes: slices.Compact(sizes), maxSize: maxSize}
+}
+
+// MaxSize is the largest accepted upload in bytes
+func (m *Images) MaxSize() int64 {
+	return m.maxSize
+}
+
+// Upload checks an uploaded image, stores it with its thumbnails under
+// "products/<product>/<image>/" and returns the stored image
+func (m *Images) Upload(ctx context.Context, productID primitive.ObjectID, data []byte, alt string) (*models.ProductImage, error) {
+	if int64(len(data)) > m.maxSize {
+		return nil, ErrTooLarge
+	}
+	contentType, err := sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &models.ProductImage{
+		ID:          primitive.NewObjectID(),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Alt:         alt,
+		CreatedAt:   time.Now(),
+	}
+	dir := path.Join("products", productID.Hex(), image.ID.Hex())
+	image.Key = path.Join(dir, "original"+extensions[contentType])
+
+	if err := m.Store.Put(ctx, image.Key, data, contentType); err != nil {
+		return nil, err
+	}
+
+	rgba := toRGBA(img)
+	for _, size := range m.sizes {
+		width, height := fit(image.Width, image.Height, size)
+		thumb, thumbType, err := encode(resize(rgba, width, height), contentType)
+		if err != nil {
+			m.Delete(ctx, image)
+			return nil, err
+		}
+		key := path.Join(dir, fmt.Sprintf("%d%s", size, extensions[thumbType]))
+		if err := m.Store.Put(ctx, key, thumb, thumbType); err != nil {
+			m.Delete(ctx, image)
+			return nil, err
+		}
+		image.Thumbnails = append(image.Thumbnails, models.ImageThumbnail{
+			Size:   size,
+			Key:    key,
+			Width:  width,
+			Height: height,
+		})
+	}
+	return image, nil
+}
+
+// Delete removes an image and its thumbnails from the store. Failures are
+// logged, a leftover blob is unreachable once the image is gone.
+func (m *Images) Delete(ctx context.Context, image *models.ProductImage) {
+	keys := []string{image.Key}
+	for _, thumb := range image.Thumbnails {
+		keys = append(keys, thumb.Key)
+	}
+	for _, key := range keys {
+		m.deleteBlob(ctx, key)
+	}
+}
+
+// deleteBlob removes a blob, logging failures
+func (m *Images) deleteBlob(ctx context.Context, key string) {
+	if err := m.Store.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete blob %s: %v", key, err)
+	}
+}
+
+// Sign sets the signed URLs of images and their thumbnails
+func (m *Images) Sign(images []models.ProductImage) {
+	for i := range images {
+		images[i].URL = m.Signer.URL(images[i].Key)
+		for j := range images[i].Thumbnails {
+			images[i].Thumbnails[j].URL = m.Signer.URL(images[i].Thumbnails[j].Key)
+		}
+	}
+}
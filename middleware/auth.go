@@ -2,15 +2,87 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"go-tutorial/config"
 	"go-tutorial/utils"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
-// AuthMiddleware verifies the JWT token and adds claims to the request context
+// APIKeyHeader carries the API key of an internal service
+const APIKeyHeader = "X-API-Key"
+
+// SocketPath is the notification socket route, the only one accepting the
+// access token as a parameter
+const SocketPath = "/ws"
+
+// tokenParam is the query parameter a socket may pass its access token in
+const tokenParam = "access_token"
+
+// Authentication errors, their messages are given to clients
+var (
+	ErrMissingCredentials = errors.New("Missing authorization header")
+	ErrInvalidFormat      = errors.New("Invalid authorization format")
+	ErrInvalidToken       = errors.New("Invalid token")
+	ErrInvalidAPIKey      = errors.New("Invalid API key")
+)
+
+// apiKeys are the keys internal services authenticate with
+var apiKeys map[string]config.APIKey
+
+// SetAPIKeys sets the keys internal services authenticate with. It must be
+// called before serving.
+func SetAPIKeys(keys map[string]config.APIKey) {
+	apiKeys = keys
+}
+
+// Authenticate returns the claims of a caller from its Authorization header,
+// "Bearer <token>", or from its API key when it has no token. A service
+// calling with a key gets the user_id "service:<name>" and the key's role.
+// The HTTP and gRPC servers both authenticate through it.
+func Authenticate(authorization, apiKey string) (jwt.MapClaims, error) {
+	if authorization == "" && apiKey != "" {
+		// Compare with every key in constant time, so timing doesn't tell
+		// how much of a key is right
+		var match *config.APIKey
+		for key, service := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				match = &service
+			}
+		}
+		if match == nil {
+			return nil, ErrInvalidAPIKey
+		}
+		return jwt.MapClaims{"user_id": "service:" + match.Service, "role": match.Role}, nil
+	}
+	if authorization == "" {
+		return nil, ErrMissingCredentials
+	}
+
+	// Extract token from "Bearer <token>"
+	tokenString := strings.TrimPrefix(authorization, "Bearer ")
+	if tokenString == authorization {
+		return nil, ErrInvalidFormat
+	}
+
+	// Parse and validate token
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte("your-secret-key"), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// AuthMiddleware verifies the JWT token, or the API key of an internal
+// service, and adds claims to the request context
 func AuthMiddleware() mux.MiddlewareFunc {
 	errorHandler := utils.NewErrorHandler()
 
@@ -18,26 +90,20 @@ func AuthMiddleware() mux.MiddlewareFunc {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get token from Authorization header
 			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				errorHandler.HandleUnauthorized(w, "Missing authorization header")
-				return
-			}
-
-			// Extract token from "Bearer <token>"
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			if tokenString == authHeader {
-				errorHandler.HandleUnauthorized(w, "Invalid authorization format")
-				return
+			if strings.Contains(r.URL.RawQuery, tokenParam) {
+				var token string
+				r, token = stripTokenParam(r)
+				// Browsers can't set headers on a socket, so it may pass
+				// the token as a parameter. Other routes ignore it, a
+				// token in a URL ends up in logs and browser history.
+				if authHeader == "" && token != "" && r.URL.Path == SocketPath && websocket.IsWebSocketUpgrade(r) {
+					authHeader = "Bearer " + token
+				}
 			}
 
-			// Parse and validate token
-			claims := jwt.MapClaims{}
-			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte("your-secret-key"), nil
-			})
-
-			if err != nil || !token.Valid {
-				errorHandler.HandleUnauthorized(w, "Invalid token")
+			claims, err := Authenticate(authHeader, r.Header.Get(APIKeyHeader))
+			if err != nil {
+				errorHandler.HandleUnauthorized(w, err.Error())
 				return
 			}
 
@@ -47,3 +113,16 @@ func AuthMiddleware() mux.MiddlewareFunc {
 		})
 	}
 }
+
+// stripTokenParam returns a copy of r without the access token parameter,
+// so it isn't logged or passed on, and the token it held
+func stripTokenParam(r *http.Request) (*http.Request, string) {
+	query := r.URL.Query()
+	token := query.Get(tokenParam)
+	query.Del(tokenParam)
+
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	r.RequestURI = r.URL.RequestURI()
+	return r, token
+}
@@ -14,10 +14,14 @@ type Permission string
 const (
 	// User permissions
 	PermissionListUsers  Permission = "list:users"
+	PermissionCreateUser Permission = "create:user"
 	PermissionReadUser   Permission = "read:user"
 	PermissionUpdateUser Permission = "update:user"
 	PermissionDeleteUser Permission = "delete:user"
 
+	// Own profile permissions
+	PermissionManageProfile Permission = "manage:profile"
+
 	// Role permissions
 	PermissionListRoles  Permission = "list:roles"
 	PermissionAssignRole Permission = "assign:role"
@@ -28,6 +32,59 @@ const (
 	PermissionCreateProduct Permission = "create:product"
 	PermissionUpdateProduct Permission = "update:product"
 	PermissionDeleteProduct Permission = "delete:product"
+
+	// Product import and export permissions
+	PermissionImportProducts Permission = "import:products"
+	PermissionExportProducts Permission = "export:products"
+
+	// Category permissions
+	PermissionListCategories Permission = "list:categories"
+	PermissionCreateCategory Permission = "create:category"
+	PermissionUpdateCategory Permission = "update:category"
+	PermissionDeleteCategory Permission = "delete:category"
+
+	// Inventory permissions
+	PermissionReadStock    Permission = "read:stock"
+	PermissionAdjustStock  Permission = "adjust:stock"
+	PermissionReserveStock Permission = "reserve:stock"
+
+	// Cart and order permissions
+	PermissionManageCart        Permission = "manage:cart"
+	PermissionCreateOrder       Permission = "create:order"
+	PermissionReadOrder         Permission = "read:order"
+	PermissionCancelOrder       Permission = "cancel:order"
+	PermissionListOrders        Permission = "list:orders"
+	PermissionUpdateOrderStatus Permission = "update:order_status"
+
+	// Payment permissions
+	PermissionCreatePayment Permission = "create:payment"
+	PermissionReadPayment   Permission = "read:payment"
+	PermissionRefundPayment Permission = "refund:payment"
+
+	// Currency permissions
+	PermissionManageRates Permission = "manage:rates"
+
+	// Pricing rule permissions
+	PermissionManagePricing Permission = "manage:pricing"
+
+	// Review permissions
+	PermissionWriteReview     Permission = "write:review"
+	PermissionModerateReviews Permission = "moderate:reviews"
+
+	// Trash permissions
+	PermissionManageTrash Permission = "manage:trash"
+
+	// Audit permissions
+	PermissionReadAuditLog Permission = "read:audit_log"
+
+	// Webhook permissions
+	PermissionManageWebhooks Permission = "manage:webhooks"
+
+	// Notification permissions
+	PermissionReadNotifications Permission = "read:notifications"
+
+	// Background job permissions
+	PermissionManageJobs Permission = "manage:jobs"
 )
 
 // RolePermissions maps roles to their permissions
@@ -35,9 +92,11 @@ var RolePermissions = map[string][]Permission{
 	"master_admin": {
 		// User permissions
 		PermissionListUsers,
+		PermissionCreateUser,
 		PermissionReadUser,
 		PermissionUpdateUser,
 		PermissionDeleteUser,
+		PermissionManageProfile,
 
 		// Role permissions
 		PermissionListRoles,
@@ -49,12 +108,66 @@ var RolePermissions = map[string][]Permission{
 		PermissionCreateProduct,
 		PermissionUpdateProduct,
 		PermissionDeleteProduct,
+
+		// Product import and export permissions
+		PermissionImportProducts,
+		PermissionExportProducts,
+
+		// Category permissions
+		PermissionListCategories,
+		PermissionCreateCategory,
+		PermissionUpdateCategory,
+		PermissionDeleteCategory,
+
+		// Inventory permissions
+		PermissionReadStock,
+		PermissionAdjustStock,
+		PermissionReserveStock,
+
+		// Cart and order permissions
+		PermissionManageCart,
+		PermissionCreateOrder,
+		PermissionReadOrder,
+		PermissionCancelOrder,
+		PermissionListOrders,
+		PermissionUpdateOrderStatus,
+
+		// Payment permissions
+		PermissionCreatePayment,
+		PermissionReadPayment,
+		PermissionRefundPayment,
+
+		// Currency permissions
+		PermissionManageRates,
+
+		// Pricing rule permissions
+		PermissionManagePricing,
+
+		// Review permissions
+		PermissionWriteReview,
+		PermissionModerateReviews,
+
+		// Trash permissions
+		PermissionManageTrash,
+
+		// Audit permissions
+		PermissionReadAuditLog,
+
+		// Webhook permissions
+		PermissionManageWebhooks,
+
+		// Notification permissions
+		PermissionReadNotifications,
+
+		// Background job permissions
+		PermissionManageJobs,
 	},
 	"sub_admin": {
 		// User permissions
 		PermissionListUsers,
 		PermissionReadUser,
 		PermissionUpdateUser,
+		PermissionManageProfile,
 		PermissionListRoles,
 
 		// Product permissions
@@ -62,15 +175,72 @@ var RolePermissions = map[string][]Permission{
 		PermissionReadProduct,
 		PermissionCreateProduct,
 		PermissionUpdateProduct,
+
+		// Product import and export permissions
+		PermissionImportProducts,
+		PermissionExportProducts,
+
+		// Category permissions
+		PermissionListCategories,
+		PermissionCreateCategory,
+		PermissionUpdateCategory,
+
+		// Inventory permissions
+		PermissionReadStock,
+		PermissionAdjustStock,
+		PermissionReserveStock,
+
+		// Cart and order permissions
+		PermissionManageCart,
+		PermissionCreateOrder,
+		PermissionReadOrder,
+		PermissionCancelOrder,
+		PermissionListOrders,
+		PermissionUpdateOrderStatus,
+
+		// Payment permissions
+		PermissionCreatePayment,
+		PermissionReadPayment,
+		PermissionRefundPayment,
+
+		// Pricing rule permissions
+		PermissionManagePricing,
+
+		// Review permissions
+		PermissionWriteReview,
+		PermissionModerateReviews,
+
+		// Notification permissions
+		PermissionReadNotifications,
 	},
 	"user": {
 		// User permissions
 		PermissionReadUser,
 		PermissionUpdateUser,
+		PermissionManageProfile,
 
 		// Product permissions
 		PermissionListProducts,
 		PermissionReadProduct,
+
+		// Category permissions
+		PermissionListCategories,
+
+		// Cart and order permissions
+		PermissionManageCart,
+		PermissionCreateOrder,
+		PermissionReadOrder,
+		PermissionCancelOrder,
+
+		// Payment permissions
+		PermissionCreatePayment,
+		PermissionReadPayment,
+
+		// Review permissions
+		PermissionWriteReview,
+
+		// Notification permissions
+		PermissionReadNotifications,
 	},
 }
 
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/utils"
+)
+
+// categoriesFromProducts turns the free-form category strings of existing
+// products into top-level category documents. Spellings that only differ in
+// case or punctuation share a slug and are merged, the most used spelling
+// becomes the category name. Products are updated to reference the slug.
+var categoriesFromProducts = Migration{
+	ID:          "0001_categories_from_products",
+	Description: "Create categories from distinct product category strings",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		products := db.Collection("products")
+		categories := db.Collection("categories")
+
+		// Count products per category string, most used first
+		cursor, err := products.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		})
+		if err != nil {
+			return err
+		}
+		var counts []struct {
+			Category string `bson:"_id"`
+			Count    int    `bson:"count"`
+		}
+		if err := cursor.All(ctx, &counts); err != nil {
+			return err
+		}
+
+		for _, c := range counts {
+			slug := utils.Slugify(c.Category)
+			if slug == "" {
+				continue
+			}
+
+			// The first spelling seen for a slug is the most used one
+			_, err := categories.UpdateOne(ctx,
+				bson.M{"slug": slug},
+				bson.M{"$setOnInsert": bson.M{
+					"_id":   primitive.NewObjectID(),
+					"name":  c.Category,
+					"slug":  slug,
+					"path":  slug,
+					"depth": 0,
+				}},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return err
+			}
+
+			if c.Category != slug {
+				_, err = products.UpdateMany(ctx,
+					bson.M{"category": c.Category},
+					bson.M{"$set": bson.M{"category": slug}},
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a one-off data change applied once per database
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// all lists every migration in the order they must be applied
+var all = []Migration{
+	categoriesFromProducts,
+	stockOpeningBalances,
+	moneyPrices,
+	orderSubtotals,
+	userProfiles,
+	productRatings,
+	liveSKUIndexes,
+}
+
+// appliedMigration is the record kept for each applied migration
+type appliedMigration struct {
+	ID          string    `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Run applies the migrations that have not been applied to db yet
+func Run(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("migrations")
+
+	for _, m := range all {
+		err := collection.FindOne(ctx, bson.M{"_id": m.ID}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		log.Printf("Applying migration %s: %s", m.ID, m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return err
+		}
+
+		_, err = collection.InsertOne(ctx, appliedMigration{
+			ID:          m.ID,
+			Description: m.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"context"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/money"
+)
+
+// moneyPrices turns every float price and total, which were in the base
+// currency, into an amount in minor units with its currency
+var moneyPrices = Migration{
+	ID:          "0003_money_prices",
+	Description: "Convert float prices and totals to amounts in minor units with a currency",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		base := money.Base()
+		scale := math.Pow10(money.Exponent(base))
+
+		// toMoney converts a float expression, leaving converted values and
+		// missing fields alone
+		toMoney := func(value, currency interface{}) bson.M {
+			return bson.M{"$cond": bson.A{
+				bson.M{"$isNumber": value},
+				bson.M{
+					"amount":   bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{value, scale}}, 0}}},
+					"currency": currency,
+				},
+				value,
+			}}
+		}
+		// eachOf converts fields of every element of an array
+		eachOf := func(array string, fields func(elem string) bson.M) bson.M {
+			return bson.M{"$cond": bson.A{
+				bson.M{"$isArray": array},
+				bson.M{"$map": bson.M{
+					"input": array,
+					"as":    "e",
+					"in":    bson.M{"$mergeObjects": bson.A{"$$e", fields("$$e")}},
+				}},
+				array,
+			}}
+		}
+
+		updates := map[string]mongo.Pipeline{
+			"products": {{{Key: "$set", Value: bson.M{
+				"price": toMoney("$price", base),
+				"variants": eachOf("$variants", func(v string) bson.M {
+					return bson.M{"price": toMoney(v+".price", base)}
+				}),
+				"price_range": bson.M{"$cond": bson.A{
+					bson.M{"$eq": bson.A{bson.M{"$type": "$price_range"}, "object"}},
+					bson.M{
+						"min": toMoney("$price_range.min", base),
+						"max": toMoney("$price_range.max", base),
+					},
+					"$price_range",
+				}},
+			}}}},
+			"carts": {{{Key: "$set", Value: bson.M{
+				"currency": bson.M{"$ifNull": bson.A{"$currency", base}},
+				"items": eachOf("$items", func(i string) bson.M {
+					return bson.M{"unit_price": toMoney(i+".unit_price", base)}
+				}),
+			}}}},
+			"orders": {{{Key: "$set", Value: bson.M{
+				"total": toMoney("$total", base),
+				"items": eachOf("$items", func(i string) bson.M {
+					return bson.M{
+						"unit_price": toMoney(i+".unit_price", base),
+						"line_total": toMoney(i+".line_total", base),
+					}
+				}),
+			}}}},
+		}
+
+		// Payments carried their currency in a separate field
+		currency := bson.M{"$ifNull": bson.A{"$currency", base}}
+		updates["payments"] = mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"amount":          toMoney("$amount", currency),
+				"refunded_amount": toMoney(bson.M{"$ifNull": bson.A{"$refunded_amount", 0}}, currency),
+				"lines": eachOf("$lines", func(l string) bson.M {
+					return bson.M{"unit_price": toMoney(l+".unit_price", currency)}
+				}),
+				"refunds": eachOf("$refunds", func(r string) bson.M {
+					return bson.M{"amount": toMoney(r+".amount", currency)}
+				}),
+			}}},
+			{{Key: "$unset", Value: "currency"}},
+		}
+
+		for _, name := range []string{"products", "carts", "orders", "payments"} {
+			if _, err := db.Collection(name).UpdateMany(ctx, bson.M{}, updates[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// orderSubtotals gives orders placed before discounts existed a subtotal
+// equal to their total and no discount
+var orderSubtotals = Migration{
+	ID:          "0004_order_subtotals",
+	Description: "Set the subtotal and discount of orders placed before price rules",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("orders").UpdateMany(ctx,
+			bson.M{"subtotal": bson.M{"$exists": false}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"subtotal": "$total",
+				"discount": bson.M{"amount": bson.M{"$toLong": 0}, "currency": "$total.currency"},
+			}}}},
+		)
+		return err
+	},
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// liveSKUIndexes drops the SKU indexes that also covered trashed products.
+// database.EnsureIndexes creates their replacements keyed on deleted_at too,
+// so a trashed product no longer blocks its SKUs.
+var liveSKUIndexes = Migration{
+	ID:          "0007_live_sku_indexes",
+	Description: "Drop the SKU indexes covering trashed products",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		indexes := db.Collection("products").Indexes()
+		for _, name := range []string{"sku_1", "variants.sku_1"} {
+			_, err := indexes.DropOne(ctx, name)
+			var cmdErr mongo.CommandError
+			// Databases created after the change never had them
+			if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
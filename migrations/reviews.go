@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/models"
+)
+
+// productRatings gives every product an empty rating, so sorting and
+// filtering by rating treat unrated products alike in MongoDB and in memory
+var productRatings = Migration{
+	ID:          "0006_product_ratings",
+	Description: "Add an empty rating to products",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("products").UpdateMany(ctx,
+			bson.M{"rating": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"rating": models.ProductRating{}}})
+		return err
+	},
+}
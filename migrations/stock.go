@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// stockOpeningBalances starts the stock ledger with the stock each product,
+// or each variant of products with variants, has when the ledger is
+// introduced, so reconciling existing products balances
+var stockOpeningBalances = Migration{
+	ID:          "0002_stock_opening_balances",
+	Description: "Record the current stock of products as opening balance movements",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		// Only the stock is read, prices may predate the current model
+		opts := options.Find().SetProjection(bson.M{"stock": 1, "variants._id": 1, "variants.stock": 1})
+		cursor, err := db.Collection("products").Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return err
+		}
+		var products []struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Stock    int                `bson:"stock"`
+			Variants []struct {
+				ID    primitive.ObjectID `bson:"_id"`
+				Stock int                `bson:"stock"`
+			} `bson:"variants"`
+		}
+		if err := cursor.All(ctx, &products); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var movements []interface{}
+		balance := func(productID primitive.ObjectID, variantID *primitive.ObjectID, stock int) {
+			if stock == 0 {
+				return
+			}
+			movements = append(movements, models.StockMovement{
+				ID:        primitive.NewObjectID(),
+				ProductID: productID,
+				VariantID: variantID,
+				Delta:     stock,
+				Quantity:  stock,
+				Reason:    models.MovementOpeningBalance,
+				Actor:     "system",
+				CreatedAt: now,
+			})
+		}
+		for _, p := range products {
+			if len(p.Variants) == 0 {
+				balance(p.ID, nil, p.Stock)
+				continue
+			}
+			for _, v := range p.Variants {
+				balance(p.ID, &v.ID, v.Stock)
+			}
+		}
+
+		if len(movements) == 0 {
+			return nil
+		}
+		_, err = db.Collection("stock_movements").InsertMany(ctx, movements)
+		return err
+	},
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// userProfiles moves the free text address of users into a default shipping
+// address and gives users the default preferences. Stored ages are left in
+// place, a date of birth can't be derived from them.
+var userProfiles = Migration{
+	ID:          "0005_user_profiles",
+	Description: "Convert user addresses to structured addresses and add default preferences",
+	Up: func(ctx context.Context, db *mongo.Database) error {
+		users := db.Collection("users")
+
+		opts := options.Find().SetProjection(bson.M{"name": 1, "address": 1})
+		cursor, err := users.Find(ctx, bson.M{"address": bson.M{"$type": "string", "$ne": ""}}, opts)
+		if err != nil {
+			return err
+		}
+		var legacy []struct {
+			ID      primitive.ObjectID `bson:"_id"`
+			Name    string             `bson:"name"`
+			Address string             `bson:"address"`
+		}
+		if err := cursor.All(ctx, &legacy); err != nil {
+			return err
+		}
+
+		for _, u := range legacy {
+			address := models.Address{
+				ID:      primitive.NewObjectID(),
+				Type:    models.AddressShipping,
+				Name:    u.Name,
+				Line1:   u.Address,
+				Default: true,
+			}
+			_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{
+				"$set":   bson.M{"addresses": []models.Address{address}},
+				"$unset": bson.M{"address": ""},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := users.UpdateMany(ctx, bson.M{"address": bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{"address": ""}}); err != nil {
+			return err
+		}
+
+		_, err = users.UpdateMany(ctx, bson.M{"preferences": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"preferences": models.DefaultPreferences()}})
+		return err
+	},
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audited actions
+const (
+	AuditCreate      = "create"
+	AuditUpdate      = "update"
+	AuditDelete      = "delete"
+	AuditRestore     = "restore"
+	AuditRevert      = "revert"
+	AuditAssignRole  = "assign_role"
+	AuditLogin       = "login"
+	AuditLoginFailed = "login_failed"
+)
+
+// Audited resource types
+const (
+	AuditResourceUser         = "user"
+	AuditResourceProduct      = "product"
+	AuditResourceCategory     = "category"
+	AuditResourcePriceRule    = "price_rule"
+	AuditResourceExchangeRate = "exchange_rate"
+	AuditResourceReview       = "review"
+	AuditResourceOrder        = "order"
+	AuditResourcePayment      = "payment"
+	AuditResourceWebhook      = "webhook"
+)
+
+// AuditEntry records who changed what. Changes holds the top-level fields
+// that differ between the resource before and after the change, keyed by
+// field name.
+type AuditEntry struct {
+	ID           primitive.ObjectID     `json:"id" bson:"_id"`
+	Actor        string                 `json:"actor" bson:"actor"`
+	Action       string                 `json:"action" bson:"action"`
+	ResourceType string                 `json:"resource_type" bson:"resource_type"`
+	ResourceID   string                 `json:"resource_id" bson:"resource_id"`
+	Changes      map[string]AuditChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	RequestID    string                 `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP           string                 `json:"ip,omitempty" bson:"ip,omitempty"`
+	CreatedAt    time.Time              `json:"created_at" bson:"created_at"`
+}
+
+// AuditChange is the value of a field before and after a change, a missing
+// side means the field was added or removed
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import "encoding/json"
+
+// Bulk operation kinds
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// Bulk item statuses. Skipped items were valid but not applied because
+// another item of an atomic batch failed.
+const (
+	BulkCreated = "created"
+	BulkUpdated = "updated"
+	BulkDeleted = "deleted"
+	BulkFailed  = "failed"
+	BulkSkipped = "skipped"
+)
+
+// BulkRequest is a batch of operations on one kind of resource. An atomic
+// batch is applied in full or not at all; otherwise every operation that
+// can be applied is, and the others are reported as failed.
+type BulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkOperation creates, updates or deletes one resource. Data is the body
+// the single-resource endpoint takes for a create or update.
+type BulkOperation struct {
+	Op   string          `json:"op"`
+	ID   string          `json:"id,omitempty"` // The resource to update or delete
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// BulkResult reports the outcome of every operation of a batch, in order
+type BulkResult struct {
+	Atomic    bool             `json:"atomic"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Items     []BulkItemResult `json:"items"`
+}
+
+// BulkItemResult is the outcome of one operation. Data is the resource as
+// saved, left out for deletes.
+type BulkItemResult struct {
+	Index  int            `json:"index"`
+	Op     string         `json:"op"`
+	ID     string         `json:"id,omitempty"`
+	Status string         `json:"status"`
+	Data   interface{}    `json:"data,omitempty"`
+	Error  *BulkItemError `json:"error,omitempty"`
+}
+
+// BulkItemError is why an operation failed. Code is the status the
+// single-resource endpoint would have answered with.
+type BulkItemError struct {
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Fields  []BulkFieldError `json:"fields,omitempty"`
+}
+
+// BulkFieldError is an invalid field of an operation's data
+type BulkFieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Category is a node in the product category tree. Path holds the slugs from
+// the root down to this category joined by "/", e.g. "electronics/phones",
+// so a subtree can be selected with a single prefix match.
+type Category struct {
+	ID       primitive.ObjectID  `json:"id" bson:"_id"`
+	Name     string              `json:"name" bson:"name"`
+	Slug     string              `json:"slug" bson:"slug"`
+	ParentID *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Path     string              `json:"path" bson:"path"`
+	Depth    int                 `json:"depth" bson:"depth"`
+}
+
+// CreateCategoryRequest is used for category creation requests
+type CreateCategoryRequest struct {
+	Name     string `json:"name" validate:"required,min=2,max=100"`
+	Slug     string `json:"slug,omitempty" validate:"omitempty,min=2,max=100"`
+	ParentID string `json:"parent_id,omitempty" validate:"omitempty,len=24,hexadecimal"`
+}
+
+// UpdateCategoryRequest is used for category update requests. Setting
+// ParentID to an empty string moves the category to the root.
+type UpdateCategoryRequest struct {
+	Name     string  `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
+	ParentID *string `json:"parent_id,omitempty" validate:"omitempty,len=24,hexadecimal"`
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/money"
+)
+
+// Price rule discount types
+const (
+	DiscountPercentage = "percentage"
+	DiscountFixed      = "fixed"
+)
+
+// Price rule scopes
+const (
+	ScopeProduct  = "product"
+	ScopeCategory = "category"
+	ScopeCatalog  = "catalog"
+)
+
+// PriceRule is a discount on the unit price of the products in its scope.
+// Rules with a Code are coupons, only applied when the code is given. A
+// category scope includes the subcategories of the listed categories.
+//
+// Stackable rules combine with each other, applied in priority order, while
+// an exclusive rule applies alone. For each item the larger discount of the
+// best exclusive rule and of all stackable rules together wins.
+type PriceRule struct {
+	ID           primitive.ObjectID   `json:"id" bson:"_id"`
+	Name         string               `json:"name" bson:"name"`
+	Type         string               `json:"type" bson:"type"`
+	Percent      float64              `json:"percent,omitempty" bson:"percent,omitempty"`
+	Amount       *money.Money         `json:"amount,omitempty" bson:"amount,omitempty"` // Taken off each unit, converted to the item's currency
+	Scope        string               `json:"scope" bson:"scope"`
+	ProductIDs   []primitive.ObjectID `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+	Categories   []string             `json:"categories,omitempty" bson:"categories,omitempty"`
+	Code         string               `json:"code,omitempty" bson:"code,omitempty"`
+	StartsAt     *time.Time           `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	EndsAt       *time.Time           `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	Priority     int                  `json:"priority" bson:"priority"`
+	Stackable    bool                 `json:"stackable" bson:"stackable"`
+	Active       bool                 `json:"active" bson:"active"`
+	UsageLimit   int                  `json:"usage_limit,omitempty" bson:"usage_limit,omitempty"`       // Orders that may use the coupon, zero for no limit
+	PerUserLimit int                  `json:"per_user_limit,omitempty" bson:"per_user_limit,omitempty"` // Orders each user may use the coupon on
+	UsedCount    int                  `json:"used_count" bson:"used_count"`
+	CreatedAt    time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// PriceRuleRequest is used to create or replace a price rule. Amount is in
+// major units of the base currency.
+type PriceRuleRequest struct {
+	Name         string     `json:"name" validate:"required,min=2,max=100"`
+	Type         string     `json:"type" validate:"required,oneof=percentage fixed"`
+	Percent      float64    `json:"percent,omitempty" validate:"required_if=Type percentage,omitempty,gt=0,lte=100"`
+	Amount       float64    `json:"amount,omitempty" validate:"required_if=Type fixed,omitempty,gt=0,lte=1000000000"`
+	Scope        string     `json:"scope" validate:"required,oneof=product category catalog"`
+	ProductIDs   []string   `json:"product_ids,omitempty" validate:"required_if=Scope product,omitempty,max=500,dive,len=24,hexadecimal"`
+	Categories   []string   `json:"categories,omitempty" validate:"required_if=Scope category,omitempty,max=100,dive,min=1"`
+	Code         string     `json:"code,omitempty" validate:"omitempty,min=3,max=32,alphanum"`
+	StartsAt     *time.Time `json:"starts_at,omitempty"`
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	Priority     int        `json:"priority"`
+	Stackable    bool       `json:"stackable"`
+	Active       *bool      `json:"active,omitempty"`
+	UsageLimit   int        `json:"usage_limit,omitempty" validate:"gte=0"`
+	PerUserLimit int        `json:"per_user_limit,omitempty" validate:"gte=0"`
+}
+
+// AppliedDiscount is a price rule applied to an item, Amount is taken off
+// each unit
+type AppliedDiscount struct {
+	RuleID primitive.ObjectID `json:"rule_id" bson:"rule_id"`
+	Name   string             `json:"name" bson:"name"`
+	Code   string             `json:"code,omitempty" bson:"code,omitempty"`
+	Amount money.Money        `json:"amount" bson:"amount"`
+}
+
+// QuoteLine is an item of a quote with the discounts applied to it
+type QuoteLine struct {
+	ProductID primitive.ObjectID  `json:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty"`
+	Name      string              `json:"name"`
+	SKU       string              `json:"sku,omitempty"`
+	Quantity  int                 `json:"quantity"`
+	UnitPrice money.Money         `json:"unit_price"`
+	Discounts []AppliedDiscount   `json:"discounts"`
+	UnitTotal money.Money         `json:"unit_total"` // Unit price after discounts
+	LineTotal money.Money         `json:"line_total"`
+}
+
+// Quote is the effective price of a product or cart with a breakdown of the
+// price rules that applied
+type Quote struct {
+	Currency string      `json:"currency"`
+	Lines    []QuoteLine `json:"lines"`
+	Subtotal money.Money `json:"subtotal"`
+	Discount money.Money `json:"discount"`
+	Total    money.Money `json:"total"`
+	Coupons  []string    `json:"coupons,omitempty"`
+}
+
+// ApplyCouponRequest is used to add a coupon code to the cart
+type ApplyCouponRequest struct {
+	Code string `json:"code" validate:"required,min=3,max=32,alphanum"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// UpdateExchangeRatesRequest replaces the exchange rates. Rates maps
+// currency codes to how many units of them one unit of the base currency
+// buys.
+type UpdateExchangeRatesRequest struct {
+	Rates map[string]float64 `json:"rates" validate:"required,min=1,max=50,dive,gt=0"`
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stock movement reasons
+const (
+	MovementOpeningBalance      = "opening_balance"
+	MovementInitial             = "initial"
+	MovementManualSet           = "manual_set"
+	MovementRestock             = "restock"
+	MovementDamaged             = "damaged"
+	MovementReturned            = "returned"
+	MovementCorrection          = "correction"
+	MovementLost                = "lost"
+	MovementReservation         = "reservation"
+	MovementReservationReleased = "reservation_released"
+	MovementReservationExpired  = "reservation_expired"
+	MovementVariantRemoved      = "variant_removed"
+	MovementMovedToVariants     = "moved_to_variants"
+	MovementSale                = "sale"
+	MovementOrderCancelled      = "order_cancelled"
+	MovementOrderRefunded       = "order_refunded"
+)
+
+// StockMovement is an entry of the append-only stock ledger. Quantity is the
+// stock of the product, or of the variant when VariantID is set, right after
+// the movement.
+type StockMovement struct {
+	ID            primitive.ObjectID  `json:"id" bson:"_id"`
+	ProductID     primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID     *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Delta         int                 `json:"delta" bson:"delta"`
+	Quantity      int                 `json:"quantity" bson:"quantity"`
+	Reason        string              `json:"reason" bson:"reason"`
+	Actor         string              `json:"actor" bson:"actor"`
+	ReservationID *primitive.ObjectID `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+	OrderID       *primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Note          string              `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt     time.Time           `json:"created_at" bson:"created_at"`
+}
+
+// Reservation statuses
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// Reservation holds stock for a limited time, e.g. while a customer checks out
+type Reservation struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id"`
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	Status    string              `json:"status" bson:"status"`
+	CreatedBy string              `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time           `json:"expires_at" bson:"expires_at"`
+}
+
+// AdjustStockRequest is used to add or remove stock
+type AdjustStockRequest struct {
+	Delta     int    `json:"delta" validate:"required,ne=0"`
+	Reason    string `json:"reason" validate:"required,oneof=restock damaged returned correction lost"`
+	VariantID string `json:"variant_id,omitempty" validate:"omitempty,len=24,hexadecimal"`
+	Note      string `json:"note,omitempty" validate:"omitempty,max=500"`
+}
+
+// CreateReservationRequest is used to hold stock for a while
+type CreateReservationRequest struct {
+	Quantity   int    `json:"quantity" validate:"required,gt=0"`
+	VariantID  string `json:"variant_id,omitempty" validate:"omitempty,len=24,hexadecimal"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty" validate:"omitempty,gte=60,lte=86400"`
+}
+
+// StockReconciliation compares the current stock with the ledger
+type StockReconciliation struct {
+	ProductID   primitive.ObjectID      `json:"product_id"`
+	Stock       int                     `json:"stock"`
+	LedgerTotal int                     `json:"ledger_total"`
+	Difference  int                     `json:"difference"`
+	Consistent  bool                    `json:"consistent"`
+	Variants    []VariantReconciliation `json:"variants,omitempty"`
+}
+
+// VariantReconciliation compares the stock of one variant with the ledger
+type VariantReconciliation struct {
+	VariantID   primitive.ObjectID `json:"variant_id"`
+	SKU         string             `json:"sku"`
+	Stock       int                `json:"stock"`
+	LedgerTotal int                `json:"ledger_total"`
+	Difference  int                `json:"difference"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Job statuses. A failed attempt puts a job back in the queue until it runs
+// out of attempts.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a unit of background work of a registered type. A running job is
+// leased to one worker; when the lease runs out because the worker stopped,
+// another worker runs it again.
+type Job struct {
+	ID              primitive.ObjectID `json:"id" bson:"_id"`
+	Type            string             `json:"type" bson:"type"`
+	Payload         json.RawMessage    `json:"payload" bson:"payload"`
+	Status          string             `json:"status" bson:"status"`
+	Attempts        int                `json:"attempts" bson:"attempts"`
+	MaxAttempts     int                `json:"max_attempts" bson:"max_attempts"`
+	Progress        JobProgress        `json:"progress" bson:"progress"`
+	Result          json.RawMessage    `json:"result,omitempty" bson:"result,omitempty"`
+	Error           string             `json:"error,omitempty" bson:"error,omitempty"` // Error of the last failed attempt
+	CancelRequested bool               `json:"cancel_requested,omitempty" bson:"cancel_requested,omitempty"`
+	RunAt           time.Time          `json:"run_at" bson:"run_at"` // When the job is next due, later after a failed attempt
+	Worker          string             `json:"worker,omitempty" bson:"worker,omitempty"`
+	LeaseUntil      *time.Time         `json:"-" bson:"lease_until,omitempty"`
+	CreatedBy       string             `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	StartedAt       *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt      *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// JobProgress is how far a running job has got, as reported by its handler
+type JobProgress struct {
+	Done    int    `json:"done" bson:"done"`
+	Total   int    `json:"total" bson:"total"`
+	Message string `json:"message,omitempty" bson:"message,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification types
+const (
+	NotificationRoleChanged = "role_changed"
+	NotificationOrderStatus = "order_status"
+)
+
+// Notification is a message to a single user. It is unread until ReadAt is
+// set.
+type Notification struct {
+	ID        primitive.ObjectID     `json:"id" bson:"_id"`
+	UserID    string                 `json:"user_id" bson:"user_id"`
+	Type      string                 `json:"type" bson:"type"`
+	Title     string                 `json:"title" bson:"title"`
+	Body      string                 `json:"body" bson:"body"`
+	Data      map[string]interface{} `json:"data,omitempty" bson:"data,omitempty"`
+	ReadAt    *time.Time             `json:"read_at,omitempty" bson:"read_at,omitempty"`
+	CreatedAt time.Time              `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/money"
+)
+
+// Cart holds the items a user intends to buy. Each user has at most one cart,
+// priced in a single currency. Coupons are applied at checkout.
+type Cart struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	UserID    string             `json:"user_id" bson:"user_id"`
+	Currency  string             `json:"currency" bson:"currency"`
+	Items     []CartItem         `json:"items" bson:"items"`
+	Coupons   []string           `json:"coupons,omitempty" bson:"coupons,omitempty"`
+	Subtotal  money.Money        `json:"subtotal" bson:"-"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CartItem is a product, or a variant of it, in a cart. Name, SKU and
+// UnitPrice are snapshots taken when the item was added.
+type CartItem struct {
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Name      string              `json:"name" bson:"name"`
+	SKU       string              `json:"sku,omitempty" bson:"sku,omitempty"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	UnitPrice money.Money         `json:"unit_price" bson:"unit_price"`
+}
+
+// AddCartItemRequest is used to put a product in the cart. Currency picks
+// the currency of an empty cart, the base currency by default.
+type AddCartItemRequest struct {
+	ProductID string `json:"product_id" validate:"required,len=24,hexadecimal"`
+	VariantID string `json:"variant_id,omitempty" validate:"omitempty,len=24,hexadecimal"`
+	Quantity  int    `json:"quantity" validate:"required,gt=0,lte=1000"`
+	Currency  string `json:"currency,omitempty" validate:"omitempty,len=3"`
+}
+
+// UpdateCartItemRequest is used to change the quantity of a cart item, zero
+// removes it
+type UpdateCartItemRequest struct {
+	Quantity *int `json:"quantity" validate:"required,gte=0,lte=1000"`
+}
+
+// Order statuses
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+// OrderTransitions lists the statuses an order may move to from each status
+var OrderTransitions = map[string][]string{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderShipped, OrderRefunded},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+	OrderCancelled: {},
+	OrderRefunded:  {},
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to string) bool {
+	return slices.Contains(OrderTransitions[from], to)
+}
+
+// Order is a placed cart. Items keep the prices charged at checkout, Total
+// is Subtotal less the discounts of the price rules that applied.
+type Order struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id"`
+	UserID    string              `json:"user_id" bson:"user_id"`
+	Items     []OrderItem         `json:"items" bson:"items"`
+	Subtotal  money.Money         `json:"subtotal" bson:"subtotal"`
+	Discount  money.Money         `json:"discount" bson:"discount"`
+	Total     money.Money         `json:"total" bson:"total"`
+	Coupons   []string            `json:"coupons,omitempty" bson:"coupons,omitempty"`
+	Status    string              `json:"status" bson:"status"`
+	History   []OrderStatusChange `json:"history" bson:"history"`
+	CreatedAt time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// OrderItem is a line of an order. LineTotal is after discounts.
+type OrderItem struct {
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Name      string              `json:"name" bson:"name"`
+	SKU       string              `json:"sku,omitempty" bson:"sku,omitempty"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	UnitPrice money.Money         `json:"unit_price" bson:"unit_price"`
+	Discounts []AppliedDiscount   `json:"discounts,omitempty" bson:"discounts,omitempty"`
+	LineTotal money.Money         `json:"line_total" bson:"line_total"`
+}
+
+// OrderStatusChange records a status change of an order
+type OrderStatusChange struct {
+	From      string    `json:"from,omitempty" bson:"from,omitempty"`
+	To        string    `json:"to" bson:"to"`
+	Actor     string    `json:"actor" bson:"actor"`
+	Note      string    `json:"note,omitempty" bson:"note,omitempty"`
+	ChangedAt time.Time `json:"changed_at" bson:"changed_at"`
+}
+
+// UpdateOrderStatusRequest is used to move an order to another status
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=pending paid shipped delivered cancelled refunded"`
+	Note   string `json:"note,omitempty" validate:"omitempty,max=500"`
+}
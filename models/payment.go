@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/money"
+)
+
+// Payment statuses
+const (
+	PaymentPending           = "pending"
+	PaymentRequiresAction    = "requires_action"
+	PaymentAuthorized        = "authorized"
+	PaymentCaptured          = "captured"
+	PaymentFailed            = "failed"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentRefunded          = "refunded"
+)
+
+// Payment records the collection of money for an order
+type Payment struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	OrderID        primitive.ObjectID `json:"order_id" bson:"order_id"`
+	UserID         string             `json:"user_id" bson:"user_id"` // Payer
+	Amount         money.Money        `json:"amount" bson:"amount"`
+	Lines          []PaymentLine      `json:"lines" bson:"lines"`
+	Provider       string             `json:"provider" bson:"provider"`
+	ProviderRef    string             `json:"provider_ref,omitempty" bson:"provider_ref,omitempty"`
+	Status         string             `json:"status" bson:"status"`
+	FailureReason  string             `json:"failure_reason,omitempty" bson:"failure_reason,omitempty"`
+	NextActionURL  string             `json:"next_action_url,omitempty" bson:"next_action_url,omitempty"`
+	RefundedAmount money.Money        `json:"refunded_amount" bson:"refunded_amount"`
+	Refunds        []PaymentRefund    `json:"refunds,omitempty" bson:"refunds,omitempty"`
+	Open           bool               `json:"-" bson:"open,omitempty"` // Set until the payment fails, one open payment per order
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// PaymentLine is a snapshot of a product line being paid for
+type PaymentLine struct {
+	ProductID primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	VariantID *primitive.ObjectID `json:"variant_id,omitempty" bson:"variant_id,omitempty"`
+	Name      string              `json:"name" bson:"name"`
+	SKU       string              `json:"sku,omitempty" bson:"sku,omitempty"`
+	Quantity  int                 `json:"quantity" bson:"quantity"`
+	UnitPrice money.Money         `json:"unit_price" bson:"unit_price"`
+}
+
+// PaymentRefund is a refund of part or all of a payment
+type PaymentRefund struct {
+	Reference string      `json:"reference" bson:"reference"`
+	Amount    money.Money `json:"amount" bson:"amount"`
+	Reason    string      `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time   `json:"created_at" bson:"created_at"`
+}
+
+// PaymentEvent records a processed webhook event so it is applied only once
+type PaymentEvent struct {
+	ID          string             `json:"id" bson:"_id"`
+	Provider    string             `json:"provider" bson:"provider"`
+	Type        string             `json:"type" bson:"type"`
+	PaymentID   primitive.ObjectID `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	ProcessedAt time.Time          `json:"processed_at" bson:"processed_at"`
+}
+
+// CreatePaymentRequest is used to pay for an order
+type CreatePaymentRequest struct {
+	PaymentMethod string `json:"payment_method" validate:"required,max=100"`
+}
+
+// RefundPaymentRequest is used to refund a payment, all of what remains
+// when Amount is not set. Amount is in major units of the payment currency.
+type RefundPaymentRequest struct {
+	Amount float64 `json:"amount,omitempty" validate:"omitempty,gt=0"`
+	Reason string  `json:"reason,omitempty" validate:"omitempty,max=500"`
+}
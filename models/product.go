@@ -1,31 +1,138 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
-// Product represents the basic product structure
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/money"
+)
+
+// Product represents the basic product structure. For products with variants
+// Stock is the sum of the variant stocks and PriceRange spans the variant
+// prices, both kept up to date whenever variants change. Price is in the
+// store's base currency, Prices lists fixed prices in other currencies.
+// Rating is maintained from the product's approved reviews. Deleted
+// products stay in the trash until they are restored or purged. SKU is
+// optional, products with variants usually leave it to their variants.
 type Product struct {
 	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	SKU         string             `json:"sku,omitempty" bson:"sku,omitempty"`
 	Name        string             `json:"name" bson:"name"`
 	Description string             `json:"description" bson:"description"`
-	Price       float64            `json:"price" bson:"price"`
+	Price       money.Money        `json:"price" bson:"price"`
+	Prices      []money.Money      `json:"prices,omitempty" bson:"prices,omitempty"`
 	Category    string             `json:"category" bson:"category"`
 	Stock       int                `json:"stock" bson:"stock"`
+	Options     []ProductOption    `json:"options,omitempty" bson:"options,omitempty"`
+	Variants    []Variant          `json:"variants,omitempty" bson:"variants,omitempty"`
+	PriceRange  *PriceRange        `json:"price_range,omitempty" bson:"price_range,omitempty"`
+	Images      []ProductImage     `json:"images,omitempty" bson:"images,omitempty"`
+	Rating      ProductRating      `json:"rating" bson:"rating"`
+	DeletedAt   *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy   string             `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+}
+
+// ProductOption is a dimension a product comes in, e.g. size or color
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name" validate:"required,min=1,max=50"`
+	Values []string `json:"values" bson:"values" validate:"required,min=1,max=50,dive,required,max=50"`
+}
+
+// Variant is a sellable combination of option values with its own SKU
+type Variant struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	SKU        string             `json:"sku" bson:"sku"`
+	Attributes map[string]string  `json:"attributes" bson:"attributes"`
+	Price      *money.Money       `json:"price,omitempty" bson:"price,omitempty"` // Overrides the product price when set
+	Stock      int                `json:"stock" bson:"stock"`
+}
+
+// PriceRange is the lowest and highest price across a product's variants
+type PriceRange struct {
+	Min money.Money `json:"min" bson:"min"`
+	Max money.Money `json:"max" bson:"max"`
+}
+
+// ProductImage is an uploaded product image, the first image of a product
+// is its main image. URLs are signed when the product is served and never
+// stored.
+type ProductImage struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Key         string             `json:"key" bson:"key"`
+	URL         string             `json:"url" bson:"-"`
+	ContentType string             `json:"content_type" bson:"content_type"`
+	Size        int64              `json:"size" bson:"size"`
+	Width       int                `json:"width" bson:"width"`
+	Height      int                `json:"height" bson:"height"`
+	Alt         string             `json:"alt,omitempty" bson:"alt,omitempty"`
+	Thumbnails  []ImageThumbnail   `json:"thumbnails,omitempty" bson:"thumbnails,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
 }
 
-// CreateProductRequest is used for product creation requests
+// ImageThumbnail is a scaled down copy of an image that fits a square box
+// of Size pixels
+type ImageThumbnail struct {
+	Size   int    `json:"size" bson:"size"`
+	Key    string `json:"key" bson:"key"`
+	URL    string `json:"url" bson:"-"`
+	Width  int    `json:"width" bson:"width"`
+	Height int    `json:"height" bson:"height"`
+}
+
+// ReorderImagesRequest sets the order of a product's images, it must list
+// every image exactly once
+type ReorderImagesRequest struct {
+	ImageIDs []string `json:"image_ids" validate:"required,min=1,max=20,dive,len=24,hexadecimal"`
+}
+
+// CreateProductRequest is used for product creation requests. Price is in
+// major units of the base currency, Prices maps other currency codes to
+// fixed prices in their major units. Prices are at most a billion major
+// units, far below what the stored minor units can hold.
 type CreateProductRequest struct {
-	Name        string  `json:"name" validate:"required,min=2,max=100"`
-	Description string  `json:"description" validate:"required,min=10,max=1000"`
-	Price       float64 `json:"price" validate:"required,gt=0"`
-	Category    string  `json:"category" validate:"required"`
-	Stock       int     `json:"stock" validate:"required,gte=0"`
+	SKU         string             `json:"sku,omitempty" validate:"omitempty,min=2,max=64"`
+	Name        string             `json:"name" validate:"required,min=2,max=100"`
+	Description string             `json:"description" validate:"required,min=10,max=1000"`
+	Price       float64            `json:"price" validate:"required,gt=0,lte=1000000000"`
+	Prices      map[string]float64 `json:"prices,omitempty" validate:"omitempty,max=20,dive,gt=0,lte=1000000000"`
+	Category    string             `json:"category" validate:"required"`
+	Stock       int                `json:"stock" validate:"required,gte=0"`
 }
 
-// UpdateProductRequest is used for product update requests
+// UpdateProductRequest is used for product update requests. Prices replaces
+// all fixed prices when set, an empty object removes them.
 type UpdateProductRequest struct {
-	Name        string  `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
-	Description string  `json:"description,omitempty" validate:"omitempty,min=10,max=1000"`
-	Price       float64 `json:"price,omitempty" validate:"omitempty,gt=0"`
-	Category    string  `json:"category,omitempty"`
-	Stock       int     `json:"stock,omitempty" validate:"omitempty,gte=0"`
+	SKU         string             `json:"sku,omitempty" validate:"omitempty,min=2,max=64"`
+	Name        string             `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
+	Description string             `json:"description,omitempty" validate:"omitempty,min=10,max=1000"`
+	Price       float64            `json:"price,omitempty" validate:"omitempty,gt=0,lte=1000000000"`
+	Prices      map[string]float64 `json:"prices,omitempty" validate:"omitempty,max=20,dive,gt=0,lte=1000000000"`
+	Category    string             `json:"category,omitempty"`
+	Stock       *int               `json:"stock,omitempty" validate:"omitempty,gte=0"`
+}
+
+// CreateVariantRequest is used to add a single variant to a product. Variant
+// prices are in major units of the base currency.
+type CreateVariantRequest struct {
+	SKU        string            `json:"sku" validate:"required,min=2,max=64"`
+	Attributes map[string]string `json:"attributes" validate:"required,min=1"`
+	Price      *float64          `json:"price,omitempty" validate:"omitempty,gt=0,lte=1000000000"`
+	Stock      int               `json:"stock" validate:"gte=0"`
+}
+
+// UpdateVariantRequest is used for variant update requests
+type UpdateVariantRequest struct {
+	SKU        string            `json:"sku,omitempty" validate:"omitempty,min=2,max=64"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Price      *float64          `json:"price,omitempty" validate:"omitempty,gt=0,lte=1000000000"`
+	Stock      *int              `json:"stock,omitempty" validate:"omitempty,gte=0"`
+}
+
+// GenerateVariantsRequest sets a product's options and generates one variant
+// per combination of option values
+type GenerateVariantsRequest struct {
+	Options []ProductOption `json:"options" validate:"required,min=1,max=5,dive"`
+	Price   *float64        `json:"price,omitempty" validate:"omitempty,gt=0,lte=1000000000"`
+	Stock   int             `json:"stock" validate:"gte=0"`
 }
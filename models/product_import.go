@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Product import statuses
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+	ImportCancelled = "cancelled"
+)
+
+// Keys imported rows are matched on
+const (
+	ImportMatchName = "name"
+	ImportMatchSKU  = "sku"
+)
+
+// ProductImport is a file of products being imported in the background.
+// Rows matching an existing product by Match update it, other rows create
+// products. A dry run validates every row without saving anything, Created
+// and Updated then count what the import would do.
+type ProductImport struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id"`
+	Status     string              `json:"status" bson:"status"`
+	Filename   string              `json:"filename" bson:"filename"`
+	Format     string              `json:"format" bson:"format"`
+	Mapping    map[string]string   `json:"mapping,omitempty" bson:"mapping,omitempty"` // Source column to product field
+	Match      string              `json:"match" bson:"match"`
+	DryRun     bool                `json:"dry_run" bson:"dry_run"`
+	BlobKey    string              `json:"-" bson:"blob_key"`
+	Total      int                 `json:"total" bson:"total"` // Rows in the file, known once the import has started
+	Processed  int                 `json:"processed" bson:"processed"`
+	Created    int                 `json:"created" bson:"created"`
+	Updated    int                 `json:"updated" bson:"updated"`
+	Failed     int                 `json:"failed" bson:"failed"`
+	Errors     []ImportRowError    `json:"errors" bson:"errors"` // The first errors, Failed counts them all
+	Error      string              `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedBy  string              `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+	StartedAt  *time.Time          `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	JobID      *primitive.ObjectID `json:"job_id,omitempty" bson:"job_id,omitempty"` // The background job running the import
+}
+
+// ImportRowError is a problem with one row of an import. Rows are numbered
+// from 1 for the first row after the header.
+type ImportRowError struct {
+	Row     int    `json:"row" bson:"row"`
+	Field   string `json:"field,omitempty" bson:"field,omitempty"`
+	Message string `json:"message" bson:"message"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Review statuses. New and edited reviews wait for a moderator, only
+// approved reviews are shown and count towards the product rating.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewHidden   = "hidden"
+)
+
+// Review is a customer's rating of a product, each user reviews a product
+// at most once
+type Review struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id"`
+	ProductID        primitive.ObjectID `json:"product_id" bson:"product_id"`
+	UserID           string             `json:"user_id" bson:"user_id"`
+	UserName         string             `json:"user_name" bson:"user_name"`
+	Rating           int                `json:"rating" bson:"rating"`
+	Title            string             `json:"title,omitempty" bson:"title,omitempty"`
+	Body             string             `json:"body" bson:"body"`
+	VerifiedPurchase bool               `json:"verified_purchase" bson:"verified_purchase"`
+	Status           string             `json:"status" bson:"status"`
+	ModeratedBy      string             `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt      *time.Time         `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	CreatedAt        time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// ProductRating summarises the approved reviews of a product
+type ProductRating struct {
+	Average float64 `json:"average" bson:"average"`
+	Count   int     `json:"count" bson:"count"`
+}
+
+// ReviewRequest is used to post or edit a review
+type ReviewRequest struct {
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Title  string `json:"title,omitempty" validate:"max=100"`
+	Body   string `json:"body" validate:"required,min=10,max=5000"`
+}
+
+// ModerateReviewRequest is used to approve or hide a review
+type ModerateReviewRequest struct {
+	Status string `json:"status" validate:"required,oneof=approved hidden"`
+}
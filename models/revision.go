@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision actions
+const (
+	RevisionCreate   = "create"
+	RevisionBaseline = "baseline"
+	RevisionUpdate   = "update"
+	RevisionRevert   = "revert"
+)
+
+// ProductRevision is a full snapshot of a product taken after a change.
+// Numbers count up from 1 per product. A baseline revision records a product
+// that existed before revisions were kept, ahead of its first change.
+type ProductRevision struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	ProductID    primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Number       int                `json:"number" bson:"number"`
+	Action       string             `json:"action" bson:"action"`
+	RevertedFrom int                `json:"reverted_from,omitempty" bson:"reverted_from,omitempty"`
+	Actor        string             `json:"actor" bson:"actor"`
+	Snapshot     *Product           `json:"snapshot,omitempty" bson:"snapshot,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// RevisionDiff lists the fields that differ between two revisions
+type RevisionDiff struct {
+	From    int                    `json:"from"`
+	To      int                    `json:"to"`
+	Changes map[string]AuditChange `json:"changes"`
+}
@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // BaseUser contains the basic user fields
 type User struct {
@@ -11,13 +15,78 @@ type User struct {
 	Role     string             `json:"role" bson:"role"`
 }
 
-// UserDetails contains all user information
+// UserDetails contains all user information. Deleted users stay in the
+// trash until they are restored or purged.
 type UserDetails struct {
-	User    `bson:",inline"`
-	Gender  string `json:"gender,omitempty" bson:"gender"`
-	Age     int    `json:"age,omitempty" bson:"age" validate:"omitempty,gte=0,lte=150"`
-	Address string `json:"address,omitempty" bson:"address"`
-	Phone   string `json:"phone,omitempty" bson:"phone"`
+	User        `bson:",inline"`
+	Gender      string      `json:"gender,omitempty" bson:"gender"`
+	DateOfBirth *time.Time  `json:"date_of_birth,omitempty" bson:"date_of_birth,omitempty"`
+	Phone       string      `json:"phone,omitempty" bson:"phone"`
+	Addresses   []Address   `json:"addresses,omitempty" bson:"addresses,omitempty"`
+	Preferences Preferences `json:"preferences" bson:"preferences"`
+	Avatar      *Avatar     `json:"avatar,omitempty" bson:"avatar,omitempty"`
+	DeletedAt   *time.Time  `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBy   string      `json:"deleted_by,omitempty" bson:"deleted_by,omitempty"`
+}
+
+// Address types
+const (
+	AddressShipping = "shipping"
+	AddressBilling  = "billing"
+)
+
+// Address is a postal address of a user. Each type has at most one default
+// address, the first address of a type becomes its default.
+type Address struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Type       string             `json:"type" bson:"type"`
+	Label      string             `json:"label,omitempty" bson:"label,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Line1      string             `json:"line1" bson:"line1"`
+	Line2      string             `json:"line2,omitempty" bson:"line2,omitempty"`
+	City       string             `json:"city" bson:"city"`
+	Region     string             `json:"region,omitempty" bson:"region,omitempty"`
+	PostalCode string             `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	Country    string             `json:"country" bson:"country"` // ISO 3166-1 alpha-2 code
+	Phone      string             `json:"phone,omitempty" bson:"phone,omitempty"`
+	Default    bool               `json:"default" bson:"default"`
+}
+
+// Preferences are a user's settings for how the store talks to them
+type Preferences struct {
+	Locale        string               `json:"locale" bson:"locale"`
+	Timezone      string               `json:"timezone" bson:"timezone"`
+	Notifications NotificationSettings `json:"notifications" bson:"notifications"`
+}
+
+// NotificationSettings chooses the channels and topics a user is notified
+// about
+type NotificationSettings struct {
+	Email        bool `json:"email" bson:"email"`
+	SMS          bool `json:"sms" bson:"sms"`
+	Push         bool `json:"push" bson:"push"`
+	OrderUpdates bool `json:"order_updates" bson:"order_updates"`
+	Marketing    bool `json:"marketing" bson:"marketing"`
+}
+
+// DefaultPreferences are the preferences of new users
+func DefaultPreferences() Preferences {
+	return Preferences{
+		Locale:   "en",
+		Timezone: "UTC",
+		Notifications: NotificationSettings{
+			Email:        true,
+			OrderUpdates: true,
+		},
+	}
+}
+
+// Avatar is a user's profile picture, stored as square images of a few
+// sizes. URLs are signed when the user is served and never stored.
+type Avatar struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Sizes     []ImageThumbnail   `json:"sizes" bson:"sizes"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
 }
 
 // CreateUserRequest is used for user creation/signup requests
@@ -27,9 +96,9 @@ type CreateUserRequest struct {
 	Password string `json:"password" validate:"required,min=6"`
 	Role     string `json:"role" validate:"required,oneof=master_admin sub_admin user"`
 	Gender   string `json:"gender,omitempty"`
-	Age      int    `json:"age,omitempty" validate:"omitempty,gte=0,lte=150"`
-	Address  string `json:"address,omitempty"`
-	Phone    string `json:"phone,omitempty"`
+	// DateOfBirth is written as YYYY-MM-DD
+	DateOfBirth string `json:"date_of_birth,omitempty"`
+	Phone       string `json:"phone,omitempty"`
 }
 
 // UpdateUserRequest is used for user update requests
@@ -39,9 +108,41 @@ type UpdateUserRequest struct {
 	Password string `json:"password,omitempty" validate:"omitempty,min=6"`
 	Role     string `json:"role,omitempty" validate:"omitempty,oneof=admin user"`
 	Gender   string `json:"gender,omitempty" validate:"omitempty,oneof=male female other"`
-	Age      int    `json:"age,omitempty" validate:"omitempty,gte=0,lte=150"`
-	Address  string `json:"address,omitempty"`
-	Phone    string `json:"phone,omitempty" validate:"omitempty,e164"`
+	// DateOfBirth is written as YYYY-MM-DD
+	DateOfBirth string `json:"date_of_birth,omitempty"`
+	Phone       string `json:"phone,omitempty" validate:"omitempty,e164"`
+}
+
+// UpdateProfileRequest is used by users to update their own profile. Only
+// the fields present are changed, an empty string clears an optional field.
+type UpdateProfileRequest struct {
+	Name        *string                   `json:"name,omitempty" validate:"omitempty,min=2,max=100"`
+	Gender      *string                   `json:"gender,omitempty" validate:"omitempty,oneof=male female other ''"`
+	DateOfBirth *string                   `json:"date_of_birth,omitempty"`
+	Phone       *string                   `json:"phone,omitempty" validate:"omitempty,e164|len=0"`
+	Preferences *UpdatePreferencesRequest `json:"preferences,omitempty"`
+}
+
+// UpdatePreferencesRequest changes some of a user's preferences
+type UpdatePreferencesRequest struct {
+	Locale        *string               `json:"locale,omitempty" validate:"omitempty,bcp47_language_tag"`
+	Timezone      *string               `json:"timezone,omitempty" validate:"omitempty,timezone"`
+	Notifications *NotificationSettings `json:"notifications,omitempty"`
+}
+
+// AddressRequest is used to add or replace an address
+type AddressRequest struct {
+	Type       string `json:"type" validate:"required,oneof=shipping billing"`
+	Label      string `json:"label,omitempty" validate:"max=50"`
+	Name       string `json:"name" validate:"required,min=2,max=100"`
+	Line1      string `json:"line1" validate:"required,max=200"`
+	Line2      string `json:"line2,omitempty" validate:"max=200"`
+	City       string `json:"city" validate:"required,max=100"`
+	Region     string `json:"region,omitempty" validate:"max=100"`
+	PostalCode string `json:"postal_code,omitempty" validate:"max=20"`
+	Country    string `json:"country" validate:"required,iso3166_1_alpha2"`
+	Phone      string `json:"phone,omitempty" validate:"omitempty,e164"`
+	Default    bool   `json:"default"`
 }
 
 // UserResponse is used for sending user data in responses (without password)
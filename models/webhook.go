@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook delivery statuses. A delivery that used up its attempts, or whose
+// subscription is disabled or gone, is dead until it is redelivered by hand.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription sends the events of the listed types to URL, signed
+// with Secret. It is disabled after too many failed attempts in a row.
+type WebhookSubscription struct {
+	ID                  primitive.ObjectID `json:"id" bson:"_id"`
+	URL                 string             `json:"url" bson:"url"`
+	Events              []string           `json:"events" bson:"events"`
+	Secret              string             `json:"-" bson:"secret"`
+	Description         string             `json:"description,omitempty" bson:"description,omitempty"`
+	Active              bool               `json:"active" bson:"active"`
+	ConsecutiveFailures int                `json:"consecutive_failures" bson:"consecutive_failures"`
+	DisabledAt          *time.Time         `json:"disabled_at,omitempty" bson:"disabled_at,omitempty"`
+	DisabledReason      string             `json:"disabled_reason,omitempty" bson:"disabled_reason,omitempty"`
+	CreatedBy           string             `json:"created_by" bson:"created_by"`
+	CreatedAt           time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// WebhookSecretResponse is a subscription with its secret, only returned
+// when the secret is set
+type WebhookSecretResponse struct {
+	WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+// WebhookDelivery is an event on its way to a subscription. Failures counts
+// the failed attempts since it was last queued, Attempts keeps every try.
+type WebhookDelivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id"`
+	SubscriptionID primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	EventID        primitive.ObjectID `json:"event_id" bson:"event_id"`
+	EventType      string             `json:"event_type" bson:"event_type"`
+	Payload        json.RawMessage    `json:"payload" bson:"payload"`
+	Status         string             `json:"status" bson:"status"`
+	Failures       int                `json:"failures" bson:"failures"`
+	NextAttemptAt  *time.Time         `json:"next_attempt_at,omitempty" bson:"next_attempt_at,omitempty"`
+	Attempts       []WebhookAttempt   `json:"attempts" bson:"attempts"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	DeliveredAt    *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// WebhookAttempt is a single try at sending a delivery. StatusCode is 0 when
+// no response came back.
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Response   string    `json:"response,omitempty" bson:"response,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// CreateWebhookRequest is the request body for creating a subscription. A
+// secret is generated when none is given.
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" validate:"required,url,max=2048"`
+	Events      []string `json:"events" validate:"required,min=1,dive,required"`
+	Secret      string   `json:"secret" validate:"omitempty,min=16,max=256"`
+	Description string   `json:"description" validate:"max=500"`
+}
+
+// UpdateWebhookRequest is the request body for changing a subscription.
+// Setting active re-enables a disabled subscription.
+type UpdateWebhookRequest struct {
+	URL         *string  `json:"url" validate:"omitempty,url,max=2048"`
+	Events      []string `json:"events" validate:"omitempty,min=1,dive,required"`
+	Secret      *string  `json:"secret" validate:"omitempty,min=16,max=256"`
+	Description *string  `json:"description" validate:"omitempty,max=500"`
+	Active      *bool    `json:"active"`
+}
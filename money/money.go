@@ -0,0 +1,150 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the base currency used until SetBase is called. Prices
+// stored before the Money type existed were in this currency.
+const DefaultCurrency = "USD"
+
+var (
+	// ErrUnknownCurrency is returned for currency codes not in the table
+	ErrUnknownCurrency = errors.New("unknown currency")
+	// ErrCurrencyMismatch is returned when combining amounts in different
+	// currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	// ErrOutOfRange is returned for amounts too large to hold in minor
+	// units
+	ErrOutOfRange = errors.New("amount out of range")
+)
+
+// exponents maps supported ISO 4217 codes to their number of minor unit digits
+var exponents = map[string]int{
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"AUD": 2,
+	"CAD": 2,
+	"SGD": 2,
+	"CNY": 2,
+	"THB": 2,
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+}
+
+// baseCurrency is the currency products are priced in
+var baseCurrency = DefaultCurrency
+
+// Base returns the store's base currency
+func Base() string {
+	return baseCurrency
+}
+
+// SetBase sets the store's base currency, it must be called before serving
+func SetBase(currency string) error {
+	currency, err := Normalize(currency)
+	if err != nil {
+		return err
+	}
+	baseCurrency = currency
+	return nil
+}
+
+// Normalize upper-cases a currency code and checks it is supported
+func Normalize(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if _, ok := exponents[currency]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	return currency, nil
+}
+
+// Exponent returns the number of minor unit digits of a currency
+func Exponent(currency string) int {
+	return exponents[currency]
+}
+
+// Money is an amount in the minor units of a currency, e.g. cents for USD
+type Money struct {
+	Amount   int64  `json:"amount" bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+// New creates an amount from minor units
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns no money in a currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// FromMajor converts an amount in major units, e.g. 19.99 dollars, rounding
+// half away from zero to the currency's minor unit. Amounts whose minor
+// units don't fit in an int64 are rejected.
+func FromMajor(amount float64, currency string) (Money, error) {
+	currency, err := Normalize(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	scale := math.Pow10(Exponent(currency))
+	minor := math.Round(amount * scale)
+	if math.IsNaN(minor) || minor >= math.MaxInt64 || minor < math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %g", ErrOutOfRange, amount)
+	}
+	return Money{Amount: int64(minor), Currency: currency}, nil
+}
+
+// Parse reads an amount in major units written as a decimal, e.g. "19.99"
+func Parse(s, currency string) (Money, error) {
+	amount, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return Money{}, fmt.Errorf("%q is not an amount", s)
+	}
+	return FromMajor(amount, currency)
+}
+
+// Major returns the amount in major units, for display and external APIs
+// only, never for arithmetic
+func (m Money) Major() float64 {
+	return float64(m.Amount) / math.Pow10(Exponent(m.Currency))
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m + other
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other
+func (m Money) Sub(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m multiplied by a quantity
+func (m Money) Mul(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// String formats the amount with its currency, e.g. "19.99 USD"
+func (m Money) String() string {
+	exp := Exponent(m.Currency)
+	return strconv.FormatFloat(m.Major(), 'f', exp, 64) + " " + m.Currency
+}
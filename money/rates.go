@@ -0,0 +1,144 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoRate is returned when converting to or from a currency without a rate
+var ErrNoRate = errors.New("no exchange rate for currency")
+
+// Rates are exchange rates relative to a base currency. Rates[c] is how
+// many units of c one unit of Base buys.
+type Rates struct {
+	Base      string             `json:"base" bson:"base"`
+	Rates     map[string]float64 `json:"rates" bson:"rates"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// Validate checks every currency is supported and every rate positive
+func (r *Rates) Validate() error {
+	base, err := Normalize(r.Base)
+	if err != nil {
+		return err
+	}
+	r.Base = base
+
+	normalized := make(map[string]float64, len(r.Rates))
+	for code, rate := range r.Rates {
+		c, err := Normalize(code)
+		if err != nil {
+			return err
+		}
+		if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+			return fmt.Errorf("rate for %s must be positive", c)
+		}
+		normalized[c] = rate
+	}
+	normalized[base] = 1
+	r.Rates = normalized
+	return nil
+}
+
+// rate returns how many units of currency one unit of the base buys
+func (r *Rates) rate(currency string) (float64, error) {
+	if currency == r.Base {
+		return 1, nil
+	}
+	rate, ok := r.Rates[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %s", ErrNoRate, currency)
+	}
+	return rate, nil
+}
+
+// Convert converts an amount to another currency, rounding to the target's
+// minor unit
+func (r *Rates) Convert(m Money, to string) (Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+	from, err := r.rate(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	target, err := r.rate(to)
+	if err != nil {
+		return Money{}, err
+	}
+
+	minor := float64(m.Amount) * target / from * math.Pow10(Exponent(to)-Exponent(m.Currency))
+	return Money{Amount: int64(math.Round(minor)), Currency: to}, nil
+}
+
+// RateStore holds the current exchange rates, safe for concurrent use
+type RateStore struct {
+	mu    sync.RWMutex
+	rates Rates
+}
+
+// NewRateStore creates a store knowing only the base currency
+func NewRateStore(base string) *RateStore {
+	return &RateStore{rates: Rates{Base: base, Rates: map[string]float64{base: 1}}}
+}
+
+// LoadFile replaces the rates with the ones in a JSON file shaped like Rates
+func (s *RateStore) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rates Rates
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if rates.UpdatedAt.IsZero() {
+		if info, err := os.Stat(path); err == nil {
+			rates.UpdatedAt = info.ModTime()
+		}
+	}
+	return s.Set(rates)
+}
+
+// Set replaces the rates
+func (s *RateStore) Set(rates Rates) error {
+	if err := rates.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rates = rates
+	return nil
+}
+
+// Get returns a copy of the current rates
+func (s *RateStore) Get() Rates {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rates := s.rates
+	rates.Rates = make(map[string]float64, len(s.rates.Rates))
+	for k, v := range s.rates.Rates {
+		rates.Rates[k] = v
+	}
+	return rates
+}
+
+// Convert converts an amount with the current rates
+func (s *RateStore) Convert(m Money, to string) (Money, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rates.Convert(m, to)
+}
+
+// Has reports whether amounts can be converted to a currency
+func (s *RateStore) Has(currency string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, err := s.rates.rate(currency)
+	return err == nil
+}
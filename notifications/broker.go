@@ -0,0 +1,207 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"go-tutorial/models"
+)
+
+// Broker carries notifications to the hubs of every instance
+type Broker interface {
+	// Publish hands a notification to all subscribers
+	Publish(ctx context.Context, notification models.Notification) error
+	// Subscribe calls deliver with every published notification until ctx
+	// is done
+	Subscribe(ctx context.Context, deliver func(models.Notification))
+}
+
+// Presence tracks the open connections of users across instances. A
+// connection that is not refreshed within its TTL counts as closed, so
+// connections of a crashed instance expire.
+type Presence interface {
+	Join(ctx context.Context, userID, connID string, ttl time.Duration) error
+	Leave(ctx context.Context, userID, connID string) error
+	Online(ctx context.Context, userID string) (bool, error)
+}
+
+// MemoryBroker delivers notifications within the process, for a single
+// instance and for tests
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(models.Notification)
+	nextID      int
+}
+
+// NewMemoryBroker creates an in-process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subscribers: make(map[int]func(models.Notification))}
+}
+
+// Publish calls every subscriber
+func (b *MemoryBroker) Publish(ctx context.Context, notification models.Notification) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, deliver := range b.subscribers {
+		deliver(notification)
+	}
+	return nil
+}
+
+// Subscribe registers deliver until ctx is done
+func (b *MemoryBroker) Subscribe(ctx context.Context, deliver func(models.Notification)) {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = deliver
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}()
+}
+
+// MemoryPresence tracks connections within the process
+type MemoryPresence struct {
+	mu    sync.Mutex
+	conns map[string]map[string]time.Time // user ID to connection expiry
+}
+
+// NewMemoryPresence creates an in-process presence tracker
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{conns: make(map[string]map[string]time.Time)}
+}
+
+// Join records or refreshes a connection of a user
+func (p *MemoryPresence) Join(ctx context.Context, userID, connID string, ttl time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[userID] == nil {
+		p.conns[userID] = make(map[string]time.Time)
+	}
+	p.conns[userID][connID] = time.Now().Add(ttl)
+	return nil
+}
+
+// Leave removes a connection of a user
+func (p *MemoryPresence) Leave(ctx context.Context, userID, connID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns[userID], connID)
+	if len(p.conns[userID]) == 0 {
+		delete(p.conns, userID)
+	}
+	return nil
+}
+
+// Online reports whether a user has a connection that has not expired
+func (p *MemoryPresence) Online(ctx context.Context, userID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, expiry := range p.conns[userID] {
+		if expiry.After(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RedisBroker carries notifications between instances over a Redis
+// channel. Instances that are not subscribed miss them, the stored
+// notification is still listed.
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedisBroker creates a broker on channel
+func NewRedisBroker(client *redis.Client, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+// Publish broadcasts a notification as JSON
+func (b *RedisBroker) Publish(ctx context.Context, notification models.Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	if err := b.client.Publish(ctx, b.channel, payload).Err(); err != nil {
+		return fmt.Errorf("publishing to channel %s: %w", b.channel, err)
+	}
+	return nil
+}
+
+// Subscribe receives notifications until ctx is done. The Redis client
+// resubscribes by itself after a lost connection.
+func (b *RedisBroker) Subscribe(ctx context.Context, deliver func(models.Notification)) {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var notification models.Notification
+				if err := json.Unmarshal([]byte(msg.Payload), &notification); err != nil {
+					log.Printf("Invalid notification on %s: %v", b.channel, err)
+					continue
+				}
+				deliver(notification)
+			}
+		}
+	}()
+}
+
+// RedisPresence tracks connections in a sorted set per user, scored by
+// their expiry
+type RedisPresence struct {
+	client *redis.Client
+}
+
+// NewRedisPresence creates a presence tracker on client
+func NewRedisPresence(client *redis.Client) *RedisPresence {
+	return &RedisPresence{client: client}
+}
+
+// presenceKey is the sorted set of a user's connections
+func presenceKey(userID string) string { return "presence:" + userID }
+
+// Join records or refreshes a connection of a user
+func (p *RedisPresence) Join(ctx context.Context, userID, connID string, ttl time.Duration) error {
+	key := presenceKey(userID)
+	pipe := p.client.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: connID})
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Leave removes a connection of a user
+func (p *RedisPresence) Leave(ctx context.Context, userID, connID string) error {
+	return p.client.ZRem(ctx, presenceKey(userID), connID).Err()
+}
+
+// Online reports whether a user has a connection that has not expired
+func (p *RedisPresence) Online(ctx context.Context, userID string) (bool, error) {
+	count, err := p.client.ZCount(ctx, presenceKey(userID),
+		fmt.Sprint(time.Now().Unix()), "+inf").Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,142 @@
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"go-tutorial/models"
+)
+
+const (
+	// clientBuffer is how many notifications a connection may fall behind
+	// before it is closed
+	clientBuffer = 32
+	// presenceTTL is how long a connection counts as open without being
+	// refreshed, it is refreshed at half that
+	presenceTTL = time.Minute
+)
+
+// Client is an open connection of a user. C is closed when the connection
+// falls too far behind.
+type Client struct {
+	UserID string
+	ID     string
+	C      chan models.Notification
+}
+
+// Hub pushes the notifications from the broker to the open connections of
+// their users on this instance and keeps their presence up to date
+type Hub struct {
+	broker   Broker
+	presence Presence
+
+	mu      sync.Mutex
+	clients map[string]map[*Client]struct{} // by user ID
+}
+
+// NewHub creates a hub for the notifications of broker
+func NewHub(broker Broker, presence Presence) *Hub {
+	return &Hub{
+		broker:   broker,
+		presence: presence,
+		clients:  make(map[string]map[*Client]struct{}),
+	}
+}
+
+// Start subscribes to the broker and refreshes the presence of open
+// connections until the process exits
+func (h *Hub) Start() {
+	h.broker.Subscribe(context.Background(), h.deliver)
+
+	ticker := time.NewTicker(presenceTTL / 2)
+	go func() {
+		for range ticker.C {
+			h.refresh()
+		}
+	}()
+}
+
+// Connect registers a connection of a user
+func (h *Hub) Connect(ctx context.Context, userID string) (*Client, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	client := &Client{UserID: userID, ID: hex.EncodeToString(id), C: make(chan models.Notification, clientBuffer)}
+	if err := h.presence.Join(ctx, userID, client.ID, presenceTTL); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][client] = struct{}{}
+	return client, nil
+}
+
+// Disconnect removes a connection
+func (h *Hub) Disconnect(client *Client) {
+	h.mu.Lock()
+	h.remove(client)
+	h.mu.Unlock()
+
+	if err := h.presence.Leave(context.Background(), client.UserID, client.ID); err != nil {
+		log.Printf("Failed to record that user %s left: %v", client.UserID, err)
+	}
+}
+
+// Online reports whether a user has an open connection on any instance
+func (h *Hub) Online(ctx context.Context, userID string) (bool, error) {
+	return h.presence.Online(ctx, userID)
+}
+
+// deliver pushes a notification to its user's connections. Connections
+// that can't keep up are closed, the notification stays listed.
+func (h *Hub) deliver(notification models.Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[notification.UserID] {
+		select {
+		case client.C <- notification:
+		default:
+			h.remove(client)
+		}
+	}
+}
+
+// remove unregisters a connection and closes its channel. The caller
+// holds mu.
+func (h *Hub) remove(client *Client) {
+	if _, ok := h.clients[client.UserID][client]; !ok {
+		return
+	}
+	delete(h.clients[client.UserID], client)
+	if len(h.clients[client.UserID]) == 0 {
+		delete(h.clients, client.UserID)
+	}
+	close(client.C)
+}
+
+// refresh extends the presence of the open connections
+func (h *Hub) refresh() {
+	h.mu.Lock()
+	var clients []*Client
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		if err := h.presence.Join(context.Background(), client.UserID, client.ID, presenceTTL); err != nil {
+			log.Printf("Failed to refresh presence of user %s: %v", client.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package notifications sends messages to individual users. Notifications
+// are stored, so users can list them and mark them read, and pushed to the
+// users' open connections on any instance through a Broker.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// Collection is where notifications are stored
+const Collection = "notifications"
+
+// ErrNotFound is returned when a user has no notification with that ID
+var ErrNotFound = errors.New("notification not found")
+
+// Service stores notifications and hands them to the broker
+type Service struct {
+	notifications *mongo.Collection
+	broker        Broker
+}
+
+// NewService creates a notification service over db publishing to broker
+func NewService(db *mongo.Database, broker Broker) *Service {
+	return &Service{
+		notifications: db.Collection(Collection),
+		broker:        broker,
+	}
+}
+
+// Send stores a notification for a user and pushes it to their open
+// connections. A failed push is not an error, the notification is listed
+// all the same.
+func (s *Service) Send(ctx context.Context, userID, kind, title, body string, data map[string]interface{}) (*models.Notification, error) {
+	notification := models.Notification{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Type:      kind,
+		Title:     title,
+		Body:      body,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	if _, err := s.notifications.InsertOne(ctx, notification); err != nil {
+		return nil, err
+	}
+	if err := s.broker.Publish(ctx, notification); err != nil {
+		log.Printf("Failed to push notification %s: %v", notification.ID.Hex(), err)
+	}
+	return &notification, nil
+}
+
+// List returns a page of a user's notifications, newest first, and their
+// total number
+func (s *Service) List(ctx context.Context, userID string, unreadOnly bool, skip, limit int64) ([]models.Notification, int64, error) {
+	filter := bson.M{"user_id": userID}
+	if unreadOnly {
+		filter["read_at"] = nil
+	}
+	total, err := s.notifications.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := s.notifications.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	notifications := []models.Notification{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, 0, err
+	}
+	return notifications, total, nil
+}
+
+// UnreadCount returns how many notifications a user has not read
+func (s *Service) UnreadCount(ctx context.Context, userID string) (int64, error) {
+	return s.notifications.CountDocuments(ctx, bson.M{"user_id": userID, "read_at": nil})
+}
+
+// MarkRead marks a notification of a user read. Marking it again keeps the
+// time it was first read.
+func (s *Service) MarkRead(ctx context.Context, userID string, id primitive.ObjectID) (*models.Notification, error) {
+	filter := bson.M{"_id": id, "user_id": userID}
+	_, err := s.notifications.UpdateOne(ctx, bson.M{"_id": id, "user_id": userID, "read_at": nil},
+		bson.M{"$set": bson.M{"read_at": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+
+	var notification models.Notification
+	err = s.notifications.FindOne(ctx, filter).Decode(&notification)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+// MarkAllRead marks every unread notification of a user read and returns
+// how many there were
+func (s *Service) MarkAllRead(ctx context.Context, userID string) (int64, error) {
+	result, err := s.notifications.UpdateMany(ctx, bson.M{"user_id": userID, "read_at": nil},
+		bson.M{"$set": bson.M{"read_at": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
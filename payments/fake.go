@@ -0,0 +1,260 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-tutorial/money"
+)
+
+// Payment methods understood by the fake provider, each producing a fixed
+// outcome
+const (
+	FakeMethodSuccess           = "pm_card_success"
+	FakeMethodDeclined          = "pm_card_declined"
+	FakeMethodInsufficientFunds = "pm_card_insufficient_funds"
+	FakeMethod3DS               = "pm_card_3ds"
+)
+
+// FakeSignatureHeader carries the signature of fake provider webhooks
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// webhookTolerance is how old a signed webhook may be
+const webhookTolerance = 5 * time.Minute
+
+// FakeProvider is an in-process payment provider for development and tests.
+// Outcomes depend only on the payment method, see the FakeMethod constants.
+// When WebhookURL is set, events are delivered to it signed with the secret.
+type FakeProvider struct {
+	secret     []byte
+	webhookURL string
+	client     *http.Client
+
+	mu      sync.Mutex
+	intents map[string]*fakeIntent
+	byKey   map[string]string
+	nextID  int
+}
+
+type fakeIntent struct {
+	Intent
+	captured int64
+	refunded int64
+}
+
+// NewFakeProvider creates a fake provider signing webhooks with secret
+func NewFakeProvider(secret, webhookURL string) *FakeProvider {
+	return &FakeProvider{
+		secret:     []byte(secret),
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		intents:    make(map[string]*fakeIntent),
+		byKey:      make(map[string]string),
+	}
+}
+
+func (f *FakeProvider) Name() string { return "fake" }
+
+// CreateIntent authorizes the payment according to the payment method
+func (f *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ref, ok := f.byKey[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		intent := f.intents[ref].Intent
+		return &intent, nil
+	}
+
+	f.nextID++
+	intent := &fakeIntent{Intent: Intent{
+		Reference: fmt.Sprintf("fake_pi_%d_%d", time.Now().UnixNano(), f.nextID),
+		Amount:    req.Amount,
+	}}
+
+	switch req.PaymentMethod {
+	case FakeMethodSuccess:
+		intent.Status = IntentRequiresCapture
+	case FakeMethodDeclined:
+		intent.Status = IntentFailed
+		intent.FailureReason = "card_declined"
+	case FakeMethodInsufficientFunds:
+		intent.Status = IntentFailed
+		intent.FailureReason = "insufficient_funds"
+	case FakeMethod3DS:
+		intent.Status = IntentRequiresAction
+		intent.NextActionURL = "/payments/fake/authenticate/" + intent.Reference
+	default:
+		return nil, ErrInvalidPaymentMethod
+	}
+
+	f.intents[intent.Reference] = intent
+	if req.IdempotencyKey != "" {
+		f.byKey[req.IdempotencyKey] = intent.Reference
+	}
+	result := intent.Intent
+	return &result, nil
+}
+
+// Authenticate completes the 3-D Secure step of an intent, as the payer's
+// bank would, and notifies the webhook of the outcome
+func (f *FakeProvider) Authenticate(reference string, approve bool) error {
+	f.mu.Lock()
+	intent, ok := f.intents[reference]
+	if !ok {
+		f.mu.Unlock()
+		return ErrUnknownIntent
+	}
+	if intent.Status != IntentRequiresAction {
+		f.mu.Unlock()
+		return ErrInvalidState
+	}
+
+	event := Event{Reference: reference, Amount: intent.Amount}
+	if approve {
+		intent.Status = IntentRequiresCapture
+		event.Type = EventAuthorized
+	} else {
+		intent.Status = IntentFailed
+		intent.FailureReason = "authentication_failed"
+		event.Type = EventFailed
+		event.Reason = intent.FailureReason
+	}
+	intent.NextActionURL = ""
+	f.mu.Unlock()
+
+	f.send(event)
+	return nil
+}
+
+// Capture takes the authorized amount. Capturing twice is a no-op.
+func (f *FakeProvider) Capture(ctx context.Context, reference string) (*Intent, error) {
+	f.mu.Lock()
+	intent, ok := f.intents[reference]
+	if !ok {
+		f.mu.Unlock()
+		return nil, ErrUnknownIntent
+	}
+	switch intent.Status {
+	case IntentSucceeded:
+		result := intent.Intent
+		f.mu.Unlock()
+		return &result, nil
+	case IntentRequiresCapture:
+	default:
+		f.mu.Unlock()
+		return nil, ErrInvalidState
+	}
+	intent.Status = IntentSucceeded
+	intent.captured = intent.Amount.Amount
+	result := intent.Intent
+	f.mu.Unlock()
+
+	f.send(Event{Type: EventCaptured, Reference: reference, Amount: result.Amount})
+	return &result, nil
+}
+
+// Refund returns part or all of a captured amount
+func (f *FakeProvider) Refund(ctx context.Context, reference string, amount money.Money) (*Refund, error) {
+	f.mu.Lock()
+	intent, ok := f.intents[reference]
+	if !ok {
+		f.mu.Unlock()
+		return nil, ErrUnknownIntent
+	}
+	if intent.Status != IntentSucceeded || amount.Currency != intent.Amount.Currency ||
+		amount.Amount <= 0 || intent.refunded+amount.Amount > intent.captured {
+		f.mu.Unlock()
+		return nil, ErrInvalidState
+	}
+	intent.refunded += amount.Amount
+	f.nextID++
+	refund := Refund{Reference: fmt.Sprintf("fake_re_%d_%d", time.Now().UnixNano(), f.nextID), Amount: amount}
+	f.mu.Unlock()
+
+	f.send(Event{Type: EventRefunded, Reference: reference, Amount: amount, RefundRef: refund.Reference})
+	return &refund, nil
+}
+
+// Sign returns the signature header value for a webhook payload sent at t
+func (f *FakeProvider) Sign(payload []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the HMAC signature and timestamp of a webhook
+func (f *FakeProvider) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	var timestamp, signature string
+	for _, part := range strings.Split(header.Get(FakeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); math.Abs(age.Seconds()) > webhookTolerance.Seconds() {
+		return nil, ErrInvalidSignature
+	}
+	expected := f.Sign(payload, time.Unix(unix, 0))
+	if !hmac.Equal([]byte(expected), []byte("t="+timestamp+",v1="+signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil || event.ID == "" {
+		return nil, ErrInvalidSignature
+	}
+	return &event, nil
+}
+
+// send delivers an event to the webhook URL in the background, the way a
+// real provider notifies asynchronously
+func (f *FakeProvider) send(event Event) {
+	if f.webhookURL == "" {
+		return
+	}
+
+	f.mu.Lock()
+	f.nextID++
+	event.ID = fmt.Sprintf("fake_evt_%d_%d", time.Now().UnixNano(), f.nextID)
+	f.mu.Unlock()
+
+	go func() {
+		payload, _ := json.Marshal(event)
+		req, err := http.NewRequest(http.MethodPost, f.webhookURL, bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("Failed to build fake webhook: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(FakeSignatureHeader, f.Sign(payload, time.Now()))
+
+		resp, err := f.client.Do(req)
+		if err != nil {
+			log.Printf("Failed to deliver fake webhook %s: %v", event.ID, err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
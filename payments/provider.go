@@ -0,0 +1,86 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go-tutorial/money"
+)
+
+// Intent statuses reported by providers
+const (
+	IntentRequiresAction  = "requires_action"
+	IntentRequiresCapture = "requires_capture"
+	IntentSucceeded       = "succeeded"
+	IntentFailed          = "failed"
+)
+
+// Webhook event types
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventFailed     = "payment.failed"
+	EventRefunded   = "payment.refunded"
+)
+
+var (
+	// ErrInvalidSignature is returned when a webhook is not signed by the provider
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrUnknownIntent is returned when the provider has no intent with the reference
+	ErrUnknownIntent = errors.New("unknown payment intent")
+	// ErrInvalidPaymentMethod is returned when the provider does not accept the
+	// payment method
+	ErrInvalidPaymentMethod = errors.New("invalid payment method")
+	// ErrInvalidState is returned when the intent cannot be captured or refunded
+	// in its current state
+	ErrInvalidState = errors.New("payment intent is not in a valid state for this operation")
+)
+
+// IntentRequest asks a provider to start collecting a payment
+type IntentRequest struct {
+	Amount         money.Money
+	PaymentMethod  string
+	Description    string
+	IdempotencyKey string // Retrying with the same key returns the same intent
+}
+
+// Intent is the provider's view of a payment
+type Intent struct {
+	Reference     string      // Provider's ID of the intent
+	Status        string      // One of the Intent* statuses
+	Amount        money.Money // Amount authorized
+	NextActionURL string      // Where the payer completes authentication
+	FailureReason string
+}
+
+// Refund is the outcome of a refund request
+type Refund struct {
+	Reference string
+	Amount    money.Money
+}
+
+// Event is a verified webhook notification
+type Event struct {
+	ID        string      `json:"id"`   // Unique per event, used to process it once
+	Type      string      `json:"type"` // One of the Event* types
+	Reference string      `json:"reference"`
+	Amount    money.Money `json:"amount"`
+	Reason    string      `json:"reason,omitempty"`
+	RefundRef string      `json:"refund_ref,omitempty"` // Set on refund events
+}
+
+// Provider is a payment gateway
+type Provider interface {
+	// Name identifies the provider in payment records
+	Name() string
+	// CreateIntent starts a payment, the money is authorized but not taken
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	// Capture takes the authorized money
+	Capture(ctx context.Context, reference string) (*Intent, error)
+	// Refund returns amount of a captured payment to the payer
+	Refund(ctx context.Context, reference string, amount money.Money) (*Refund, error)
+	// VerifyWebhook checks the signature of a webhook request and parses its
+	// event
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+}
@@ -0,0 +1,4 @@
+// Package storev1 holds the gRPC services of the store and their messages,
+// generated from the .proto files next to it. Regenerate them by running
+// buf generate in the proto directory.
+package storev1
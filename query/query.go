@@ -0,0 +1,148 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Op is a comparison operator usable in a filter condition
+type Op string
+
+const (
+	OpEq    Op = "="
+	OpNe    Op = "!="
+	OpGt    Op = ">"
+	OpGte   Op = ">="
+	OpLt    Op = "<"
+	OpLte   Op = "<="
+	OpIn    Op = "in"
+	OpNotIn Op = "not in"
+)
+
+// mongoOps maps filter operators to their MongoDB query operator
+var mongoOps = map[Op]string{
+	OpEq:    "$eq",
+	OpNe:    "$ne",
+	OpGt:    "$gt",
+	OpGte:   "$gte",
+	OpLt:    "$lt",
+	OpLte:   "$lte",
+	OpIn:    "$in",
+	OpNotIn: "$nin",
+}
+
+// Condition is a single typed filter condition, e.g. price >= 10
+type Condition struct {
+	Field  string
+	Path   string
+	Op     Op
+	Values []interface{}
+}
+
+// SortField is one key of a multi-field sort
+type SortField struct {
+	Field string
+	Path  string
+	Desc  bool
+}
+
+// Query is the parsed and validated form of the filter, sort and fields
+// parameters of a list request
+type Query struct {
+	Filters []Condition
+	Sort    []SortField
+	Fields  []string
+}
+
+// Error reports an invalid query parameter
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Filter compiles the filter conditions into a MongoDB filter document
+func (q *Query) Filter() bson.M {
+	if len(q.Filters) == 0 {
+		return bson.M{}
+	}
+
+	conditions := make([]bson.M, 0, len(q.Filters))
+	for _, c := range q.Filters {
+		var value interface{} = c.Values
+		if c.Op != OpIn && c.Op != OpNotIn {
+			value = c.Values[0]
+		}
+		conditions = append(conditions, bson.M{c.Path: bson.M{mongoOps[c.Op]: value}})
+	}
+
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return bson.M{"$and": conditions}
+}
+
+// SortDoc compiles the sort fields into a MongoDB sort document with _id as
+// the final tie-breaker. When reverse is set every direction is flipped.
+func (q *Query) SortDoc(reverse bool) bson.D {
+	direction := func(desc bool) int {
+		if desc != reverse {
+			return -1
+		}
+		return 1
+	}
+
+	sortDoc := bson.D{}
+	for _, s := range q.Sort {
+		sortDoc = append(sortDoc, bson.E{Key: s.Path, Value: direction(s.Desc)})
+	}
+	last := len(q.Sort) > 0 && q.Sort[len(q.Sort)-1].Desc
+	return append(sortDoc, bson.E{Key: "_id", Value: direction(last)})
+}
+
+// SortKey returns the canonical sort expression, e.g. "-price,name"
+func (q *Query) SortKey() string {
+	parts := make([]string, len(q.Sort))
+	for i, s := range q.Sort {
+		parts[i] = s.Field
+		if s.Desc {
+			parts[i] = "-" + s.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Canonical returns a normalized representation of the query. Equivalent
+// queries written differently produce the same string, which makes it
+// suitable for cache keys.
+func (q *Query) Canonical() string {
+	conditions := make([]string, len(q.Filters))
+	for i, c := range q.Filters {
+		values := make([]string, len(c.Values))
+		for j, v := range c.Values {
+			values[j] = fmt.Sprintf("%q", fmt.Sprint(v))
+		}
+		if c.Op == OpIn || c.Op == OpNotIn {
+			sort.Strings(values)
+			conditions[i] = fmt.Sprintf("%s %s (%s)", c.Field, c.Op, strings.Join(values, ","))
+		} else {
+			conditions[i] = c.Field + string(c.Op) + values[0]
+		}
+	}
+	sort.Strings(conditions)
+
+	fields := append([]string(nil), q.Fields...)
+	sort.Strings(fields)
+
+	return fmt.Sprintf("filter=%s;sort=%s;fields=%s",
+		strings.Join(conditions, ","), q.SortKey(), strings.Join(fields, ","))
+}
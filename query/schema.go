@@ -0,0 +1,409 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldType is the value type of a queryable field
+type FieldType int
+
+const (
+	String FieldType = iota
+	Number
+	Integer
+)
+
+// MaxConditions caps the number of conditions in a single filter
+const MaxConditions = 20
+
+// Field describes how a resource field may be queried
+type Field[T any] struct {
+	Name     string              // Name used in query parameters and JSON
+	Path     string              // Document path in MongoDB
+	Type     FieldType           // Type filter values are parsed as
+	Filter   bool                // Usable in filter
+	Sort     bool                // Usable in sort
+	Value    func(T) interface{} // Reads the field from an in-memory item
+	Selected bool                // Always included in sparse fieldsets
+
+	// Parse converts a filter value when the stored form differs from the
+	// one clients write, e.g. prices written in major units but stored in
+	// minor units. Type still decides which operators are allowed.
+	Parse func(raw string) (interface{}, error)
+
+	// Output reads the field for sparse fieldsets when Value, which filters
+	// and sorts compare, is only part of it. Projections then fetch the
+	// whole top-level field of Path.
+	Output func(T) interface{}
+}
+
+// Schema whitelists the fields of a resource that can be filtered, sorted
+// and selected
+type Schema[T any] struct {
+	fields      map[string]Field[T]
+	order       []string
+	defaultSort []SortField
+	sortAliases map[string]string
+}
+
+// NewSchema creates a schema from its fields. defaultSort is a sort
+// expression such as "name" used when the request does not specify one.
+func NewSchema[T any](defaultSort string, fields ...Field[T]) *Schema[T] {
+	s := &Schema[T]{
+		fields:      make(map[string]Field[T]),
+		sortAliases: make(map[string]string),
+	}
+	for _, f := range fields {
+		s.fields[f.Name] = f
+		s.order = append(s.order, f.Name)
+	}
+
+	sortFields, err := s.parseSort(defaultSort)
+	if err != nil {
+		panic(fmt.Sprintf("query: invalid default sort %q: %v", defaultSort, err))
+	}
+	s.defaultSort = sortFields
+	return s
+}
+
+// WithSortAlias registers a legacy sort value, e.g. "price_desc" for "-price"
+func (s *Schema[T]) WithSortAlias(alias, expr string) *Schema[T] {
+	s.sortAliases[alias] = expr
+	return s
+}
+
+// Parse reads the filter, sort and fields parameters into a validated query
+func (s *Schema[T]) Parse(values url.Values) (*Query, error) {
+	q := &Query{}
+
+	filters, err := s.parseFilter(values.Get("filter"))
+	if err != nil {
+		return nil, err
+	}
+	q.Filters = filters
+
+	sortExpr := values.Get("sort")
+	if alias, ok := s.sortAliases[sortExpr]; ok {
+		sortExpr = alias
+	}
+	if q.Sort, err = s.parseSort(sortExpr); err != nil {
+		return nil, err
+	}
+	if len(q.Sort) == 0 {
+		q.Sort = s.defaultSort
+	}
+
+	if q.Fields, err = s.parseFields(values.Get("fields")); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Where adds a condition on a field, used to map legacy query parameters
+// such as category onto the query
+func (s *Schema[T]) Where(q *Query, field string, op Op, values ...string) error {
+	condition, err := s.condition(field, op, values)
+	if err != nil {
+		return err
+	}
+	q.Filters = append(q.Filters, condition)
+	return nil
+}
+
+// conditionPattern matches "field op value" and "field [not] in (values)"
+var conditionPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_.]*)\s*(?:(>=|<=|!=|=|>|<)\s*(.*?)|\s(not\s+in|in)\s*\((.*)\))\s*$`)
+
+func (s *Schema[T]) parseFilter(expr string) ([]Condition, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	terms, err := splitTopLevel(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(terms) > MaxConditions {
+		return nil, &Error{Message: fmt.Sprintf("filter has more than %d conditions", MaxConditions)}
+	}
+
+	conditions := make([]Condition, 0, len(terms))
+	for _, term := range terms {
+		m := conditionPattern.FindStringSubmatch(term)
+		if m == nil {
+			return nil, &Error{Message: fmt.Sprintf("invalid filter condition %q", term)}
+		}
+
+		var c Condition
+		if m[2] != "" {
+			c, err = s.condition(m[1], Op(m[2]), []string{m[3]})
+		} else {
+			var values []string
+			if values, err = splitTopLevel(m[5]); err == nil {
+				op := OpIn
+				if m[4] != "in" {
+					op = OpNotIn
+				}
+				c, err = s.condition(m[1], op, values)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+func (s *Schema[T]) condition(name string, op Op, raw []string) (Condition, error) {
+	field, ok := s.fields[name]
+	if !ok || !field.Filter {
+		return Condition{}, &Error{Field: name, Message: "field cannot be filtered"}
+	}
+	if field.Type == String && op != OpEq && op != OpNe && op != OpIn && op != OpNotIn {
+		return Condition{}, &Error{Field: name, Message: fmt.Sprintf("operator %s is not supported on text fields", op)}
+	}
+
+	values := make([]interface{}, 0, len(raw))
+	for _, r := range raw {
+		parse := func(raw string) (interface{}, error) { return parseValue(field.Type, raw) }
+		if field.Parse != nil {
+			parse = field.Parse
+		}
+		v, err := parse(unquote(strings.TrimSpace(r)))
+		if err != nil {
+			return Condition{}, &Error{Field: name, Message: err.Error()}
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return Condition{}, &Error{Field: name, Message: "at least one value is required"}
+	}
+
+	return Condition{Field: name, Path: field.Path, Op: op, Values: values}, nil
+}
+
+func (s *Schema[T]) parseSort(expr string) ([]SortField, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	var sortFields []SortField
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimLeft(part, "+-")
+
+		field, ok := s.fields[name]
+		if !ok || !field.Sort {
+			return nil, &Error{Field: name, Message: "field cannot be sorted"}
+		}
+		if seen[name] {
+			return nil, &Error{Field: name, Message: "field is sorted more than once"}
+		}
+		seen[name] = true
+		sortFields = append(sortFields, SortField{Field: name, Path: field.Path, Desc: desc})
+	}
+	return sortFields, nil
+}
+
+func (s *Schema[T]) parseFields(expr string) ([]string, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	fields := []string{}
+	for _, name := range strings.Split(expr, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := s.fields[name]; !ok {
+			return nil, &Error{Field: name, Message: "unknown field"}
+		}
+		if !slices.Contains(fields, name) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// Projection returns the MongoDB projection for the requested fields, or nil
+// when all fields were requested
+func (s *Schema[T]) Projection(q *Query) map[string]int {
+	if len(q.Fields) == 0 {
+		return nil
+	}
+	projection := map[string]int{"_id": 1}
+	for _, name := range q.Fields {
+		field := s.fields[name]
+		path := field.Path
+		if field.Output != nil {
+			path, _, _ = strings.Cut(path, ".")
+		}
+		projection[path] = 1
+	}
+	return projection
+}
+
+// Select returns the requested fields of an item keyed by field name. Fields
+// marked as Selected are always included.
+func (s *Schema[T]) Select(q *Query, item T) map[string]interface{} {
+	selected := make(map[string]interface{})
+	for _, name := range s.order {
+		field := s.fields[name]
+		if field.Selected || slices.Contains(q.Fields, name) {
+			if field.Output != nil {
+				selected[name] = field.Output(item)
+			} else {
+				selected[name] = field.Value(item)
+			}
+		}
+	}
+	return selected
+}
+
+// Match reports whether an in-memory item satisfies the query's filter
+func (s *Schema[T]) Match(q *Query, item T) bool {
+	for _, c := range q.Filters {
+		value := s.fields[c.Field].Value(item)
+		switch c.Op {
+		case OpIn, OpNotIn:
+			found := slices.ContainsFunc(c.Values, func(v interface{}) bool {
+				return compareValues(value, v) == 0
+			})
+			if found != (c.Op == OpIn) {
+				return false
+			}
+		default:
+			result := compareValues(value, c.Values[0])
+			ok := map[Op]bool{
+				OpEq:  result == 0,
+				OpNe:  result != 0,
+				OpGt:  result > 0,
+				OpGte: result >= 0,
+				OpLt:  result < 0,
+				OpLte: result <= 0,
+			}[c.Op]
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SortValues returns the values of the sort fields of an item, in sort order
+func (s *Schema[T]) SortValues(q *Query, item T) []interface{} {
+	values := make([]interface{}, len(q.Sort))
+	for i, sf := range q.Sort {
+		values[i] = s.fields[sf.Field].Value(item)
+	}
+	return values
+}
+
+// CompareKeys orders two sort keys (as returned by SortValues, followed by
+// the item ID) the same way SortDoc orders documents in MongoDB
+func CompareKeys(q *Query, a, b []interface{}) int {
+	for i := range a {
+		desc := false
+		if i < len(q.Sort) {
+			desc = q.Sort[i].Desc
+		} else if len(q.Sort) > 0 {
+			desc = q.Sort[len(q.Sort)-1].Desc
+		}
+
+		if result := compareValues(a[i], b[i]); result != 0 {
+			if desc {
+				return -result
+			}
+			return result
+		}
+	}
+	return 0
+}
+
+// compareValues compares two scalar values, treating all numbers alike
+func compareValues(a, b interface{}) int {
+	fa, aNum := toFloat(a)
+	fb, bNum := toFloat(b)
+	if aNum && bNum {
+		return cmp.Compare(fa, fb)
+	}
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func parseValue(t FieldType, raw string) (interface{}, error) {
+	switch t {
+	case Number:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return v, nil
+	case Integer:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+// splitTopLevel splits on commas that are not inside quotes or parentheses
+func splitTopLevel(expr string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	depth, quoted := 0, false
+
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return nil, &Error{Message: "unbalanced parentheses in filter"}
+			}
+		case r == ',' && depth == 0:
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if quoted || depth != 0 {
+		return nil, &Error{Message: "unterminated quote or parenthesis in filter"}
+	}
+	return append(parts, current.String()), nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
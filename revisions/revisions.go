@@ -0,0 +1,205 @@
+// Package revisions keeps the version history of products. Every tracked
+// change stores a full snapshot of the product, so any version can be
+// compared with another or restored.
+package revisions
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/audit"
+	"go-tutorial/models"
+)
+
+// Collection is where revisions are stored
+const Collection = "product_revisions"
+
+// maxAttempts bounds the retries when concurrent changes race for the same
+// revision number
+const maxAttempts = 5
+
+var (
+	// ErrNotFound is returned when a product has no revision of that number
+	ErrNotFound = errors.New("revision not found")
+	// ErrConflict is returned when a revision number could not be claimed
+	ErrConflict = errors.New("too many concurrent revisions")
+)
+
+// Policy decides which revisions are pruned. The latest revision of a
+// product is always kept.
+type Policy struct {
+	Keep   int           // Most recent revisions kept per product, 0 keeps all
+	MaxAge time.Duration // Age after which revisions are pruned, 0 keeps them forever
+}
+
+// Service records and reads product revisions
+type Service struct {
+	revisions *mongo.Collection
+	policy    Policy
+}
+
+// NewService creates a revision service over db, pruning by policy
+func NewService(db *mongo.Database, policy Policy) *Service {
+	return &Service{
+		revisions: db.Collection(Collection),
+		policy:    policy,
+	}
+}
+
+// Record stores product as its next revision and prunes the revisions the
+// policy no longer keeps
+func (s *Service) Record(ctx context.Context, product models.Product, action, actor string, revertedFrom int) (*models.ProductRevision, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		latest, err := s.latest(ctx, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		revision := newRevision(product, latest+1, action, actor)
+		revision.RevertedFrom = revertedFrom
+
+		// The unique index on product and number rejects a number that a
+		// concurrent change claimed first
+		_, err = s.revisions.InsertOne(ctx, revision)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.prune(ctx, product.ID, revision.Number)
+		return &revision, nil
+	}
+	return nil, ErrConflict
+}
+
+// EnsureBaseline stores product as revision 1 when it has no revisions yet,
+// so a product created before revisions were kept can be restored to its
+// state before the first tracked change
+func (s *Service) EnsureBaseline(ctx context.Context, product models.Product, actor string) error {
+	_, err := s.revisions.InsertOne(ctx, newRevision(product, 1, models.RevisionBaseline, actor))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns a page of a product's revisions without their snapshots,
+// newest first, and the total number of revisions
+func (s *Service) List(ctx context.Context, productID primitive.ObjectID, skip, limit int64) ([]models.ProductRevision, int64, error) {
+	filter := bson.M{"product_id": productID}
+	total, err := s.revisions.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "number", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit).
+		SetProjection(bson.M{"snapshot": 0})
+	cursor, err := s.revisions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	revisions := []models.ProductRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, 0, err
+	}
+	return revisions, total, nil
+}
+
+// Get returns a revision of a product with its snapshot
+func (s *Service) Get(ctx context.Context, productID primitive.ObjectID, number int) (*models.ProductRevision, error) {
+	var revision models.ProductRevision
+	err := s.revisions.FindOne(ctx, bson.M{"product_id": productID, "number": number}).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// Diff compares two revisions of a product field by field
+func (s *Service) Diff(ctx context.Context, productID primitive.ObjectID, from, to int) (*models.RevisionDiff, error) {
+	before, err := s.Get(ctx, productID, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.Get(ctx, productID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := audit.Diff(before.Snapshot, after.Snapshot)
+	if changes == nil {
+		changes = map[string]models.AuditChange{}
+	}
+	return &models.RevisionDiff{From: from, To: to, Changes: changes}, nil
+}
+
+// latest returns the number of the newest revision of a product, 0 when it
+// has none
+func (s *Service) latest(ctx context.Context, productID primitive.ObjectID) (int, error) {
+	var revision models.ProductRevision
+	err := s.revisions.FindOne(ctx,
+		bson.M{"product_id": productID},
+		options.FindOne().
+			SetSort(bson.D{{Key: "number", Value: -1}}).
+			SetProjection(bson.M{"number": 1}),
+	).Decode(&revision)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return revision.Number, nil
+}
+
+// prune deletes the revisions of a product older than latest that the
+// policy no longer keeps. Failures are logged, they only leave extra
+// history behind.
+func (s *Service) prune(ctx context.Context, productID primitive.ObjectID, latest int) {
+	var expired bson.A
+	if s.policy.Keep > 0 {
+		expired = append(expired, bson.M{"number": bson.M{"$lte": latest - s.policy.Keep}})
+	}
+	if s.policy.MaxAge > 0 {
+		expired = append(expired, bson.M{"created_at": bson.M{"$lt": time.Now().Add(-s.policy.MaxAge)}})
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	_, err := s.revisions.DeleteMany(ctx, bson.M{
+		"product_id": productID,
+		"number":     bson.M{"$lt": latest},
+		"$or":        expired,
+	})
+	if err != nil {
+		log.Printf("Failed to prune revisions of product %s: %v", productID.Hex(), err)
+	}
+}
+
+// newRevision snapshots product
+func newRevision(product models.Product, number int, action, actor string) models.ProductRevision {
+	return models.ProductRevision{
+		ID:        primitive.NewObjectID(),
+		ProductID: product.ID,
+		Number:    number,
+		Action:    action,
+		Actor:     actor,
+		Snapshot:  &product,
+		CreatedAt: time.Now(),
+	}
+}
@@ -10,11 +10,19 @@ import (
 
 func SetupRoutes(h *handlers.Handler) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(middleware.RequestID())
 
 	// Public routes (no authentication required)
 	router.HandleFunc("/signup", h.SignUp).Methods("POST")
 	router.HandleFunc("/login", h.Login).Methods("POST")
 
+	// Payment provider callbacks, authenticated by their signature
+	router.HandleFunc("/payments/webhook", h.PaymentWebhook).Methods("POST")
+	router.HandleFunc("/payments/fake/authenticate/{reference}", h.FakeAuthenticate).Methods("GET")
+
+	// Product images, authenticated by their signed URL
+	router.HandleFunc("/media/{key:.+}", h.ServeMedia).Methods("GET", "HEAD")
+
 	// Protected routes that require authentication
 	protected := router.PathPrefix("").Subrouter()
 	protected.Use(middleware.AuthMiddleware())
@@ -30,11 +38,45 @@ func SetupRoutes(h *handlers.Handler) *mux.Router {
 	userRoutes.Handle("/{id}",
 		middleware.RequirePermission(middleware.PermissionDeleteUser)(
 			http.HandlerFunc(h.DeleteUser))).Methods("DELETE")
+	userRoutes.Handle("/{id}/presence",
+		middleware.RequirePermission(middleware.PermissionListUsers)(
+			http.HandlerFunc(h.GetUserPresence))).Methods("GET")
+
+	// Own profile routes
+	meRoutes := protected.PathPrefix("/me").Subrouter()
+	meRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.GetMe))).Methods("GET")
+	meRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.UpdateMe))).Methods("PATCH")
+	meRoutes.Handle("/avatar",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.UploadAvatar))).Methods("PUT")
+	meRoutes.Handle("/avatar",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.DeleteAvatar))).Methods("DELETE")
+	meRoutes.Handle("/addresses",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.ListAddresses))).Methods("GET")
+	meRoutes.Handle("/addresses",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.CreateAddress))).Methods("POST")
+	meRoutes.Handle("/addresses/{addressId}",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.UpdateAddress))).Methods("PUT")
+	meRoutes.Handle("/addresses/{addressId}",
+		middleware.RequirePermission(middleware.PermissionManageProfile)(
+			http.HandlerFunc(h.DeleteAddress))).Methods("DELETE")
 
 	// Users list route (sub-admin and above)
 	protected.Handle("/users",
 		middleware.RequirePermission(middleware.PermissionListUsers)(
 			http.HandlerFunc(h.GetUsers))).Methods("GET")
+	// Each operation of a bulk write checks its own permission
+	protected.Handle("/users/bulk",
+		middleware.RequirePermission(middleware.PermissionListUsers)(
+			http.HandlerFunc(h.BulkUsers))).Methods("POST")
 
 	// Role management routes (master-admin only)
 	roleRoutes := protected.PathPrefix("/roles").Subrouter()
@@ -50,6 +92,30 @@ func SetupRoutes(h *handlers.Handler) *mux.Router {
 	productRoutes.Handle("",
 		middleware.RequirePermission(middleware.PermissionListProducts)(
 			http.HandlerFunc(h.GetProducts))).Methods("GET")
+	productRoutes.Handle("/search",
+		middleware.RequirePermission(middleware.PermissionListProducts)(
+			http.HandlerFunc(h.SearchProducts))).Methods("GET")
+	productRoutes.Handle("/events",
+		middleware.RequirePermission(middleware.PermissionListProducts)(
+			http.HandlerFunc(h.StreamProductEvents))).Methods("GET")
+	productRoutes.Handle("/export",
+		middleware.RequirePermission(middleware.PermissionExportProducts)(
+			http.HandlerFunc(h.ExportProducts))).Methods("GET")
+	productRoutes.Handle("/import",
+		middleware.RequirePermission(middleware.PermissionImportProducts)(
+			http.HandlerFunc(h.ImportProducts))).Methods("POST")
+	productRoutes.Handle("/imports/{id}",
+		middleware.RequirePermission(middleware.PermissionImportProducts)(
+			http.HandlerFunc(h.GetProductImport))).Methods("GET")
+	productRoutes.Handle("/imports/{id}/cancel",
+		middleware.RequirePermission(middleware.PermissionImportProducts)(
+			http.HandlerFunc(h.CancelProductImport))).Methods("POST")
+	productRoutes.Handle("/reindex",
+		middleware.RequirePermission(middleware.PermissionManageJobs)(
+			http.HandlerFunc(h.ReindexProducts))).Methods("POST")
+	productRoutes.Handle("/bulk",
+		middleware.RequirePermission(middleware.PermissionListProducts)(
+			http.HandlerFunc(h.BulkProducts))).Methods("POST")
 	productRoutes.Handle("/{id}",
 		middleware.RequirePermission(middleware.PermissionReadProduct)(
 			http.HandlerFunc(h.GetProductDetails))).Methods("GET")
@@ -58,10 +124,278 @@ func SetupRoutes(h *handlers.Handler) *mux.Router {
 			http.HandlerFunc(h.CreateProduct))).Methods("POST")
 	productRoutes.Handle("/{id}",
 		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
-			http.HandlerFunc(h.UpdateProduct))).Methods("PUT")
+			http.HandlerFunc(h.UpdateProduct))).Methods("PUT", "PATCH")
 	productRoutes.Handle("/{id}",
 		middleware.RequirePermission(middleware.PermissionDeleteProduct)(
 			http.HandlerFunc(h.DeleteProduct))).Methods("DELETE")
 
+	// Product variant routes
+	productRoutes.Handle("/{id}/variants",
+		middleware.RequirePermission(middleware.PermissionReadProduct)(
+			http.HandlerFunc(h.ListVariants))).Methods("GET")
+	productRoutes.Handle("/{id}/variants",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.CreateVariant))).Methods("POST")
+	productRoutes.Handle("/{id}/variants/generate",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.GenerateVariants))).Methods("POST")
+	productRoutes.Handle("/{id}/variants/{variantId}",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.UpdateVariant))).Methods("PUT")
+	productRoutes.Handle("/{id}/variants/{variantId}",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.DeleteVariant))).Methods("DELETE")
+
+	// Product image routes
+	productRoutes.Handle("/{id}/images",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.UploadProductImage))).Methods("POST")
+	productRoutes.Handle("/{id}/images/order",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.ReorderProductImages))).Methods("PUT")
+	productRoutes.Handle("/{id}/images/{imageId}",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.DeleteProductImage))).Methods("DELETE")
+
+	// Product revision routes
+	productRoutes.Handle("/{id}/revisions",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.ListProductRevisions))).Methods("GET")
+	productRoutes.Handle("/{id}/revisions/diff",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.DiffProductRevisions))).Methods("GET")
+	productRoutes.Handle("/{id}/revisions/{number:[0-9]+}",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.GetProductRevision))).Methods("GET")
+	productRoutes.Handle("/{id}/revisions/{number:[0-9]+}/revert",
+		middleware.RequirePermission(middleware.PermissionUpdateProduct)(
+			http.HandlerFunc(h.RevertProduct))).Methods("POST")
+
+	// Product review routes
+	productRoutes.Handle("/{id}/reviews",
+		middleware.RequirePermission(middleware.PermissionReadProduct)(
+			http.HandlerFunc(h.ListProductReviews))).Methods("GET")
+	productRoutes.Handle("/{id}/reviews",
+		middleware.RequirePermission(middleware.PermissionWriteReview)(
+			http.HandlerFunc(h.CreateReview))).Methods("POST")
+
+	reviewRoutes := protected.PathPrefix("/reviews").Subrouter()
+	reviewRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionModerateReviews)(
+			http.HandlerFunc(h.ListReviews))).Methods("GET")
+	reviewRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionWriteReview)(
+			http.HandlerFunc(h.UpdateReview))).Methods("PUT")
+	reviewRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionWriteReview)(
+			http.HandlerFunc(h.DeleteReview))).Methods("DELETE")
+	reviewRoutes.Handle("/{id}/moderation",
+		middleware.RequirePermission(middleware.PermissionModerateReviews)(
+			http.HandlerFunc(h.ModerateReview))).Methods("PUT")
+
+	// Inventory routes
+	productRoutes.Handle("/{id}/stock/adjust",
+		middleware.RequirePermission(middleware.PermissionAdjustStock)(
+			http.HandlerFunc(h.AdjustStock))).Methods("POST")
+	productRoutes.Handle("/{id}/stock/history",
+		middleware.RequirePermission(middleware.PermissionReadStock)(
+			http.HandlerFunc(h.GetStockHistory))).Methods("GET")
+	productRoutes.Handle("/{id}/stock/reconcile",
+		middleware.RequirePermission(middleware.PermissionReadStock)(
+			http.HandlerFunc(h.ReconcileStock))).Methods("GET")
+	productRoutes.Handle("/{id}/quote",
+		middleware.RequirePermission(middleware.PermissionReadProduct)(
+			http.HandlerFunc(h.QuoteProduct))).Methods("GET")
+	productRoutes.Handle("/{id}/reservations",
+		middleware.RequirePermission(middleware.PermissionReserveStock)(
+			http.HandlerFunc(h.CreateReservation))).Methods("POST")
+
+	reservationRoutes := protected.PathPrefix("/reservations").Subrouter()
+	reservationRoutes.Handle("/{id}/commit",
+		middleware.RequirePermission(middleware.PermissionReserveStock)(
+			http.HandlerFunc(h.CommitReservation))).Methods("POST")
+	reservationRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionReserveStock)(
+			http.HandlerFunc(h.ReleaseReservation))).Methods("DELETE")
+
+	// Cart routes, each user has their own cart
+	cartRoutes := protected.PathPrefix("/cart").Subrouter()
+	cartRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.GetCart))).Methods("GET")
+	cartRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.ClearCart))).Methods("DELETE")
+	cartRoutes.Handle("/items",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.AddCartItem))).Methods("POST")
+	cartRoutes.Handle("/items/{productId}",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.UpdateCartItem))).Methods("PUT")
+	cartRoutes.Handle("/items/{productId}",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.RemoveCartItem))).Methods("DELETE")
+	cartRoutes.Handle("/coupons",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.ApplyCoupon))).Methods("POST")
+	cartRoutes.Handle("/coupons/{code}",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.RemoveCoupon))).Methods("DELETE")
+	cartRoutes.Handle("/quote",
+		middleware.RequirePermission(middleware.PermissionManageCart)(
+			http.HandlerFunc(h.QuoteCart))).Methods("GET")
+	cartRoutes.Handle("/checkout",
+		middleware.RequirePermission(middleware.PermissionCreateOrder)(
+			http.HandlerFunc(h.Checkout))).Methods("POST")
+
+	// Order routes, users only see their own orders
+	orderRoutes := protected.PathPrefix("/orders").Subrouter()
+	orderRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionReadOrder)(
+			http.HandlerFunc(h.GetOrders))).Methods("GET")
+	orderRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionReadOrder)(
+			http.HandlerFunc(h.GetOrderDetails))).Methods("GET")
+	orderRoutes.Handle("/{id}/status",
+		middleware.RequirePermission(middleware.PermissionUpdateOrderStatus)(
+			http.HandlerFunc(h.UpdateOrderStatus))).Methods("PUT")
+	orderRoutes.Handle("/{id}/cancel",
+		middleware.RequirePermission(middleware.PermissionCancelOrder)(
+			http.HandlerFunc(h.CancelOrder))).Methods("POST")
+
+	// Payment routes
+	orderRoutes.Handle("/{id}/payments",
+		middleware.RequirePermission(middleware.PermissionCreatePayment)(
+			http.HandlerFunc(h.CreatePayment))).Methods("POST")
+	orderRoutes.Handle("/{id}/payments",
+		middleware.RequirePermission(middleware.PermissionReadPayment)(
+			http.HandlerFunc(h.GetOrderPayments))).Methods("GET")
+
+	paymentRoutes := protected.PathPrefix("/payments").Subrouter()
+	paymentRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionReadPayment)(
+			http.HandlerFunc(h.GetPayment))).Methods("GET")
+	paymentRoutes.Handle("/{id}/refund",
+		middleware.RequirePermission(middleware.PermissionRefundPayment)(
+			http.HandlerFunc(h.RefundPayment))).Methods("POST")
+
+	// Exchange rate routes
+	protected.Handle("/exchange-rates",
+		middleware.RequirePermission(middleware.PermissionListProducts)(
+			http.HandlerFunc(h.GetExchangeRates))).Methods("GET")
+	protected.Handle("/exchange-rates",
+		middleware.RequirePermission(middleware.PermissionManageRates)(
+			http.HandlerFunc(h.UpdateExchangeRates))).Methods("PUT")
+
+	// Price rule routes
+	priceRuleRoutes := protected.PathPrefix("/price-rules").Subrouter()
+	priceRuleRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManagePricing)(
+			http.HandlerFunc(h.ListPriceRules))).Methods("GET")
+	priceRuleRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManagePricing)(
+			http.HandlerFunc(h.CreatePriceRule))).Methods("POST")
+	priceRuleRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManagePricing)(
+			http.HandlerFunc(h.GetPriceRule))).Methods("GET")
+	priceRuleRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManagePricing)(
+			http.HandlerFunc(h.UpdatePriceRule))).Methods("PUT")
+	priceRuleRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManagePricing)(
+			http.HandlerFunc(h.DeletePriceRule))).Methods("DELETE")
+
+	// Category routes
+	categoryRoutes := protected.PathPrefix("/categories").Subrouter()
+	categoryRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionListCategories)(
+			http.HandlerFunc(h.ListCategories))).Methods("GET")
+	categoryRoutes.Handle("/{slug}",
+		middleware.RequirePermission(middleware.PermissionListCategories)(
+			http.HandlerFunc(h.GetCategory))).Methods("GET")
+	categoryRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionCreateCategory)(
+			http.HandlerFunc(h.CreateCategory))).Methods("POST")
+	categoryRoutes.Handle("/{slug}",
+		middleware.RequirePermission(middleware.PermissionUpdateCategory)(
+			http.HandlerFunc(h.UpdateCategory))).Methods("PUT")
+	categoryRoutes.Handle("/{slug}",
+		middleware.RequirePermission(middleware.PermissionDeleteCategory)(
+			http.HandlerFunc(h.DeleteCategory))).Methods("DELETE")
+
+	// Trash routes
+	trashRoutes := protected.PathPrefix("/trash").Subrouter()
+	trashRoutes.Handle("/products",
+		middleware.RequirePermission(middleware.PermissionManageTrash)(
+			http.HandlerFunc(h.ListDeletedProducts))).Methods("GET")
+	trashRoutes.Handle("/products/{id}/restore",
+		middleware.RequirePermission(middleware.PermissionManageTrash)(
+			http.HandlerFunc(h.RestoreProduct))).Methods("POST")
+	trashRoutes.Handle("/users",
+		middleware.RequirePermission(middleware.PermissionManageTrash)(
+			http.HandlerFunc(h.ListDeletedUsers))).Methods("GET")
+	trashRoutes.Handle("/users/{id}/restore",
+		middleware.RequirePermission(middleware.PermissionManageTrash)(
+			http.HandlerFunc(h.RestoreUser))).Methods("POST")
+
+	// Audit log routes
+	protected.Handle("/audit-log",
+		middleware.RequirePermission(middleware.PermissionReadAuditLog)(
+			http.HandlerFunc(h.GetAuditLog))).Methods("GET")
+
+	// Webhook routes
+	webhookRoutes := protected.PathPrefix("/webhooks").Subrouter()
+	webhookRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.ListWebhooks))).Methods("GET")
+	webhookRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.CreateWebhook))).Methods("POST")
+	webhookRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.GetWebhook))).Methods("GET")
+	webhookRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.UpdateWebhook))).Methods("PATCH")
+	webhookRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.DeleteWebhook))).Methods("DELETE")
+	webhookRoutes.Handle("/{id}/deliveries",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.ListWebhookDeliveries))).Methods("GET")
+	webhookRoutes.Handle("/{id}/deliveries/{deliveryId}/redeliver",
+		middleware.RequirePermission(middleware.PermissionManageWebhooks)(
+			http.HandlerFunc(h.RedeliverWebhook))).Methods("POST")
+
+	// Notification routes, the socket authenticates at the upgrade
+	protected.Handle(middleware.SocketPath,
+		middleware.RequirePermission(middleware.PermissionReadNotifications)(
+			http.HandlerFunc(h.ServeNotifications))).Methods("GET")
+	notificationRoutes := protected.PathPrefix("/notifications").Subrouter()
+	notificationRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionReadNotifications)(
+			http.HandlerFunc(h.ListNotifications))).Methods("GET")
+	notificationRoutes.Handle("/read-all",
+		middleware.RequirePermission(middleware.PermissionReadNotifications)(
+			http.HandlerFunc(h.MarkAllNotificationsRead))).Methods("POST")
+	notificationRoutes.Handle("/{id}/read",
+		middleware.RequirePermission(middleware.PermissionReadNotifications)(
+			http.HandlerFunc(h.MarkNotificationRead))).Methods("POST")
+
+	// GraphQL endpoint, each resolver checks its own permission
+	protected.HandleFunc("/graphql", h.GraphQL).Methods("POST")
+
+	// Background job routes
+	jobRoutes := protected.PathPrefix("/jobs").Subrouter()
+	jobRoutes.Handle("",
+		middleware.RequirePermission(middleware.PermissionManageJobs)(
+			http.HandlerFunc(h.ListJobs))).Methods("GET")
+	jobRoutes.Handle("/{id}",
+		middleware.RequirePermission(middleware.PermissionManageJobs)(
+			http.HandlerFunc(h.GetJob))).Methods("GET")
+	jobRoutes.Handle("/{id}/cancel",
+		middleware.RequirePermission(middleware.PermissionManageJobs)(
+			http.HandlerFunc(h.CancelJob))).Methods("POST")
+
 	return router
 }
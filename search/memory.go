@@ -0,0 +1,155 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	"go-tutorial/models"
+)
+
+// MemoryIndex is an embedded index holding products in memory. It ranks and
+// facets the same way as MongoIndex, so it can stand in for it in tests.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	products map[string]models.Product
+}
+
+// NewMemoryIndex creates an empty in-memory index
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{products: make(map[string]models.Product)}
+}
+
+// Index adds or replaces a product
+func (m *MemoryIndex) Index(ctx context.Context, product models.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.products[product.ID.Hex()] = product
+	return nil
+}
+
+// Remove deletes a product
+func (m *MemoryIndex) Remove(ctx context.Context, productID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.products, productID)
+	return nil
+}
+
+// Search scores every product against the expanded query terms
+func (m *MemoryIndex) Search(ctx context.Context, req Request) (*Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	vocabulary := make(map[string]struct{})
+	for _, p := range m.products {
+		for _, word := range Tokenize(p.Name + " " + p.Description) {
+			vocabulary[word] = struct{}{}
+		}
+	}
+	terms := Expand(Tokenize(req.Query), vocabulary)
+
+	var hits []Hit
+	for _, p := range m.products {
+		if !matchesFilters(p, req) {
+			continue
+		}
+		score := fieldScore(p.Name, terms, NameWeight) + fieldScore(p.Description, terms, DescriptionWeight)
+		if score > 0 {
+			hits = append(hits, Hit{Product: p, Score: score})
+		}
+	}
+
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Product.ID.Hex(), b.Product.ID.Hex())
+	})
+
+	result := &Result{Total: int64(len(hits)), Facets: facetsOf(hits)}
+	start := min((req.Page-1)*req.Limit, len(hits))
+	end := min(start+req.Limit, len(hits))
+	for _, hit := range hits[start:end] {
+		hit.Highlights = highlights(hit.Product, terms)
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+// fieldScore weighs the words of text matching the terms. Words reached by
+// prefix or typo expansion count half as much as exact ones.
+func fieldScore(text string, terms map[string]bool, weight float64) float64 {
+	score := 0.0
+	for _, word := range Tokenize(text) {
+		if exact, ok := terms[word]; ok {
+			if exact {
+				score += weight
+			} else {
+				score += weight / 2
+			}
+		}
+	}
+	return score
+}
+
+// matchesFilters applies the non-text filters of a request
+func matchesFilters(p models.Product, req Request) bool {
+	if req.Category != "" && p.Category != req.Category {
+		return false
+	}
+	if req.MinPrice != nil && p.Price.Amount < *req.MinPrice {
+		return false
+	}
+	if req.MaxPrice != nil && p.Price.Amount > *req.MaxPrice {
+		return false
+	}
+	if req.InStock != nil && (p.Stock > 0) != *req.InStock {
+		return false
+	}
+	return true
+}
+
+// facetsOf counts categories, price buckets and stock state over all hits
+func facetsOf(hits []Hit) Facets {
+	categories := make(map[string]int64)
+	prices := make(map[string]int64)
+	stock := make(map[string]int64)
+	for _, hit := range hits {
+		categories[hit.Product.Category]++
+		prices[priceBucket(hit.Product.Price.Amount)]++
+		if hit.Product.Stock > 0 {
+			stock[InStock]++
+		} else {
+			stock[OutOfStock]++
+		}
+	}
+
+	facets := Facets{
+		Categories:  sortedCounts(categories),
+		PriceRanges: []FacetCount{},
+		Stock:       sortedCounts(stock),
+	}
+	for i := range PriceBoundaries {
+		if count := prices[bucketLabel(i)]; count > 0 {
+			facets.PriceRanges = append(facets.PriceRanges, FacetCount{Value: bucketLabel(i), Count: count})
+		}
+	}
+	return facets
+}
+
+// sortedCounts orders facet counts by count, then value
+func sortedCounts(counts map[string]int64) []FacetCount {
+	result := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, FacetCount{Value: value, Count: count})
+	}
+	slices.SortFunc(result, func(a, b FacetCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return result
+}
@@ -0,0 +1,223 @@
+package search
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+)
+
+func product(name, description, category string, cents int64, stock int) models.Product {
+	return models.Product{
+		ID:          primitive.NewObjectID(),
+		Name:        name,
+		Description: description,
+		Category:    category,
+		Price:       money.New(cents, money.Base()),
+		Stock:       stock,
+	}
+}
+
+func newTestIndex(t *testing.T, products ...models.Product) *MemoryIndex {
+	t.Helper()
+	index := NewMemoryIndex()
+	for _, p := range products {
+		if err := index.Index(context.Background(), p); err != nil {
+			t.Fatalf("Index(%s) error = %v", p.Name, err)
+		}
+	}
+	return index
+}
+
+func search(t *testing.T, index *MemoryIndex, req Request) *Result {
+	t.Helper()
+	if req.Page == 0 {
+		req.Page, req.Limit = 1, 10
+	}
+	result, err := index.Search(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Search(%q) error = %v", req.Query, err)
+	}
+	return result
+}
+
+func names(result *Result) []string {
+	var names []string
+	for _, hit := range result.Hits {
+		names = append(names, hit.Product.Name)
+	}
+	return names
+}
+
+func TestMemoryIndexRanksNameOverDescription(t *testing.T) {
+	index := newTestIndex(t,
+		product("Mouse Pad", "A pad for any wireless mouse", "accessories", 999, 3),
+		product("Wireless Mouse", "Ergonomic mouse with a quiet mouse wheel", "electronics", 2999, 5),
+		product("USB Cable", "Braided cable", "accessories", 499, 0),
+	)
+
+	result := search(t, index, Request{Query: "wireless"})
+	if got, want := names(result), []string{"Wireless Mouse", "Mouse Pad"}; !slices.Equal(got, want) {
+		t.Fatalf("hits = %v, want %v", got, want)
+	}
+	if got := result.Hits[0].Score; got != NameWeight {
+		t.Errorf("name match scored %v, want %v", got, float64(NameWeight))
+	}
+	if got := result.Hits[1].Score; got != DescriptionWeight {
+		t.Errorf("description match scored %v, want %v", got, float64(DescriptionWeight))
+	}
+
+	// Every occurrence counts, the wireless mouse has "mouse" twice in its
+	// description
+	result = search(t, index, Request{Query: "mouse"})
+	if got, want := names(result), []string{"Wireless Mouse", "Mouse Pad"}; !slices.Equal(got, want) {
+		t.Errorf("hits = %v, want %v", got, want)
+	}
+
+	if err := index.Remove(context.Background(), result.Hits[0].Product.ID.Hex()); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if got := names(search(t, index, Request{Query: "wireless"})); !slices.Equal(got, []string{"Mouse Pad"}) {
+		t.Errorf("hits after removal = %v, want [Mouse Pad]", got)
+	}
+}
+
+func TestMemoryIndexExpandsPrefixesAndTypos(t *testing.T) {
+	index := newTestIndex(t,
+		product("Mechanical Keyboard", "Ergonomic layout", "electronics", 8999, 2),
+		product("Mouse Pad", "Soft pad", "accessories", 999, 3),
+	)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"ergo", []string{"Mechanical Keyboard"}},       // prefix of the last term
+		{"keybaord", []string{"Mechanical Keyboard"}},   // two edits in a long word
+		{"mose", []string{"Mouse Pad"}},                 // one edit in a short word
+		{"pda", nil},                                    // too short for typos
+		{"ergo pad", []string{"Mouse Pad"}},             // only the last term is a prefix
+		{"mechanical", []string{"Mechanical Keyboard"}}, // exact
+	}
+	for _, tt := range tests {
+		if got := names(search(t, index, Request{Query: tt.query})); !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Expanded words count half as much as exact ones
+	exact := search(t, index, Request{Query: "keyboard"}).Hits[0].Score
+	typo := search(t, index, Request{Query: "keybaord"}).Hits[0].Score
+	if typo != exact/2 {
+		t.Errorf("typo scored %v, want half of the exact %v", typo, exact)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vocabulary := map[string]struct{}{"ergonomic": {}, "mouse": {}, "house": {}, "keyboard": {}}
+
+	terms := Expand([]string{"mouse", "ergo"}, vocabulary)
+	if exact, ok := terms["mouse"]; !ok || !exact {
+		t.Errorf("mouse = %v, %v, want an exact match", exact, ok)
+	}
+	if exact, ok := terms["ergonomic"]; !ok || exact {
+		t.Errorf("ergonomic = %v, %v, want an expanded match", exact, ok)
+	}
+	if exact, ok := terms["house"]; !ok || exact {
+		t.Errorf("house = %v, %v, want an expanded match of mouse", exact, ok)
+	}
+
+	terms = Expand([]string{"ergo", "mouse"}, vocabulary)
+	if _, ok := terms["ergonomic"]; ok {
+		t.Errorf("ergo expanded to ergonomic although it is not the last term")
+	}
+}
+
+func TestMemoryIndexHighlights(t *testing.T) {
+	index := newTestIndex(t,
+		product("Wireless Mouse", "Ergonomic mouse", "electronics", 2999, 5),
+		product("Mouse Pad", "A pad for any <wireless> mouse & keyboard", "accessories", 999, 3),
+	)
+
+	result := search(t, index, Request{Query: "wireless"})
+	if got, want := result.Hits[0].Highlights, map[string]string{"name": "<em>Wireless</em> Mouse"}; !maps.Equal(got, want) {
+		t.Errorf("highlights = %v, want %v", got, want)
+	}
+	want := map[string]string{"description": "A pad for any &lt;<em>wireless</em>&gt; mouse &amp; keyboard"}
+	if got := result.Hits[1].Highlights; !maps.Equal(got, want) {
+		t.Errorf("highlights = %v, want %v", got, want)
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	text := strings.Repeat("filler words here ", 20) + "the waterproof case" + strings.Repeat(" more filler text", 20)
+	terms := map[string]bool{"waterproof": true}
+
+	snippet := Highlight(text, terms, true)
+	if !strings.HasPrefix(snippet, "…") || !strings.HasSuffix(snippet, "…") {
+		t.Errorf("snippet %q is not cut on both sides", snippet)
+	}
+	if !strings.Contains(snippet, "<em>waterproof</em>") {
+		t.Errorf("snippet %q does not highlight the match", snippet)
+	}
+	// The window is snippetLength long, plus the ellipses and tags
+	if n := len(snippet) - len("……<em></em>"); n > snippetLength {
+		t.Errorf("snippet is %d bytes, want at most %d", n, snippetLength)
+	}
+	words := Tokenize(text)
+	for _, word := range Tokenize(strings.NewReplacer("<em>", "", "</em>", "").Replace(snippet)) {
+		if !slices.Contains(words, word) {
+			t.Errorf("snippet %q cuts the word %q", snippet, word)
+		}
+	}
+
+	if got := Highlight(text, terms, false); strings.Contains(got, "…") || len(got) != len(text)+len("<em></em>") {
+		t.Errorf("Highlight without snippet shortened the text to %q", got)
+	}
+	if got := Highlight(text, map[string]bool{"missing": true}, true); got != "" {
+		t.Errorf("Highlight without a match = %q, want empty", got)
+	}
+}
+
+func TestMemoryIndexFacets(t *testing.T) {
+	index := newTestIndex(t,
+		product("Wireless Mouse", "", "electronics", 2999, 5),
+		product("Gaming Mouse", "", "electronics", 7999, 0),
+		product("Mouse Pad", "", "accessories", 999, 3),
+		product("Mouse Bungee", "", "accessories", 1499, 1),
+		product("Mouse Jiggler", "", "gadgets", 120000, 2),
+		product("Keyboard", "", "electronics", 4999, 4),
+	)
+
+	// Facets count every match, not only the page
+	result := search(t, index, Request{Query: "mouse", Page: 1, Limit: 2})
+	if result.Total != 5 || len(result.Hits) != 2 {
+		t.Fatalf("got %d hits of %d, want 2 of 5", len(result.Hits), result.Total)
+	}
+
+	wantCategories := []FacetCount{{"accessories", 2}, {"electronics", 2}, {"gadgets", 1}}
+	if !slices.Equal(result.Facets.Categories, wantCategories) {
+		t.Errorf("categories = %v, want %v", result.Facets.Categories, wantCategories)
+	}
+	wantPrices := []FacetCount{{"0-10", 1}, {"10-50", 2}, {"50-100", 1}, {"1000+", 1}}
+	if !slices.Equal(result.Facets.PriceRanges, wantPrices) {
+		t.Errorf("price ranges = %v, want %v", result.Facets.PriceRanges, wantPrices)
+	}
+	wantStock := []FacetCount{{InStock, 4}, {OutOfStock, 1}}
+	if !slices.Equal(result.Facets.Stock, wantStock) {
+		t.Errorf("stock = %v, want %v", result.Facets.Stock, wantStock)
+	}
+
+	// Filters narrow the facets too
+	inStock := true
+	result = search(t, index, Request{Query: "mouse", Category: "electronics", InStock: &inStock})
+	if result.Total != 1 || !slices.Equal(result.Facets.Stock, []FacetCount{{InStock, 1}}) {
+		t.Errorf("filtered search = %d hits with stock facets %v, want 1 in stock", result.Total, result.Facets.Stock)
+	}
+}
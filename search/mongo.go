@@ -0,0 +1,227 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// vocabularyTTL is how long the loaded vocabulary is used before reloading
+const vocabularyTTL = 5 * time.Minute
+
+// MongoIndex searches the products collection through its text index (see
+// database.EnsureIndexes). Prefix and typo tolerance come from expanding the
+// query against a vocabulary of the words used in product names and
+// descriptions before handing it to $text.
+type MongoIndex struct {
+	collection *mongo.Collection
+
+	mu         sync.Mutex
+	vocabulary map[string]struct{}
+	loadedAt   time.Time
+}
+
+// NewMongoIndex creates an index over the products collection of db
+func NewMongoIndex(db *mongo.Database) *MongoIndex {
+	return &MongoIndex{collection: db.Collection("products")}
+}
+
+// Index adds the product's words to the vocabulary. MongoDB maintains the
+// text index itself.
+func (m *MongoIndex) Index(ctx context.Context, product models.Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.vocabulary != nil {
+		for _, word := range Tokenize(product.Name + " " + product.Description) {
+			m.vocabulary[word] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// Remove is a no-op, removed words leave the vocabulary on the next reload
+func (m *MongoIndex) Remove(ctx context.Context, productID string) error {
+	return nil
+}
+
+// loadVocabulary returns the vocabulary, reloading it once it is stale
+func (m *MongoIndex) loadVocabulary(ctx context.Context) (map[string]struct{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.vocabulary != nil && time.Since(m.loadedAt) < vocabularyTTL {
+		return m.vocabulary, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"name": 1, "description": 1})
+	cursor, err := m.collection.Find(ctx, bson.M{"deleted_at": nil}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	vocabulary := make(map[string]struct{})
+	for cursor.Next(ctx) {
+		var p models.Product
+		if err := cursor.Decode(&p); err != nil {
+			return nil, err
+		}
+		for _, word := range Tokenize(p.Name + " " + p.Description) {
+			vocabulary[word] = struct{}{}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	m.vocabulary = vocabulary
+	m.loadedAt = time.Now()
+	return vocabulary, nil
+}
+
+// Search runs a $text query ranked by textScore and computes facets in the
+// same aggregation
+func (m *MongoIndex) Search(ctx context.Context, req Request) (*Result, error) {
+	vocabulary, err := m.loadVocabulary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	terms := Expand(Tokenize(req.Query), vocabulary)
+	words := make([]string, 0, len(terms))
+	for word := range terms {
+		words = append(words, word)
+	}
+
+	match := bson.M{
+		"$text":      bson.M{"$search": strings.Join(words, " ")},
+		"deleted_at": nil,
+	}
+	if req.Category != "" {
+		match["category"] = req.Category
+	}
+	price := bson.M{}
+	if req.MinPrice != nil {
+		price["$gte"] = *req.MinPrice
+	}
+	if req.MaxPrice != nil {
+		price["$lte"] = *req.MaxPrice
+	}
+	if len(price) > 0 {
+		match["price.amount"] = price
+	}
+	if req.InStock != nil {
+		if *req.InStock {
+			match["stock"] = bson.M{"$gt": 0}
+		} else {
+			match["stock"] = bson.M{"$lte": 0}
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+		{{Key: "$facet", Value: bson.M{
+			"hits": bson.A{
+				bson.M{"$sort": bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$skip": (req.Page - 1) * req.Limit},
+				bson.M{"$limit": req.Limit},
+			},
+			"total": bson.A{
+				bson.M{"$count": "count"},
+			},
+			"categories": bson.A{
+				bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"prices": bson.A{
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price.amount",
+					"boundaries": minorBoundaries(),
+					"default":    bucketLabel(len(PriceBoundaries) - 1),
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+			"stock": bson.A{
+				bson.M{"$group": bson.M{"_id": bson.M{"$gt": bson.A{"$stock", 0}}, "count": bson.M{"$sum": 1}}},
+			},
+		}}},
+	}
+
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var facetResults []struct {
+		Hits []struct {
+			models.Product `bson:",inline"`
+			Score          float64 `bson:"score"`
+		} `bson:"hits"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+		Categories []struct {
+			Value string `bson:"_id"`
+			Count int64  `bson:"count"`
+		} `bson:"categories"`
+		Prices []struct {
+			Value interface{} `bson:"_id"`
+			Count int64       `bson:"count"`
+		} `bson:"prices"`
+		Stock []struct {
+			Value bool  `bson:"_id"`
+			Count int64 `bson:"count"`
+		} `bson:"stock"`
+	}
+	if err := cursor.All(ctx, &facetResults); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Hits: []Hit{}, Facets: Facets{
+		Categories:  []FacetCount{},
+		PriceRanges: []FacetCount{},
+		Stock:       []FacetCount{},
+	}}
+	if len(facetResults) == 0 {
+		return result, nil
+	}
+	facets := facetResults[0]
+
+	for _, hit := range facets.Hits {
+		result.Hits = append(result.Hits, Hit{
+			Product:    hit.Product,
+			Score:      hit.Score,
+			Highlights: highlights(hit.Product, terms),
+		})
+	}
+	if len(facets.Total) > 0 {
+		result.Total = facets.Total[0].Count
+	}
+	for _, c := range facets.Categories {
+		result.Facets.Categories = append(result.Facets.Categories, FacetCount{Value: c.Value, Count: c.Count})
+	}
+	for _, p := range facets.Prices {
+		label, ok := p.Value.(string)
+		if !ok {
+			// Buckets are keyed by their lower boundary
+			lower, _ := p.Value.(int64)
+			label = priceBucket(lower)
+		}
+		result.Facets.PriceRanges = append(result.Facets.PriceRanges, FacetCount{Value: label, Count: p.Count})
+	}
+	for _, s := range facets.Stock {
+		value := OutOfStock
+		if s.Value {
+			value = InStock
+		}
+		result.Facets.Stock = append(result.Facets.Stock, FacetCount{Value: value, Count: s.Count})
+	}
+	return result, nil
+}
@@ -0,0 +1,263 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go-tutorial/models"
+	"go-tutorial/money"
+)
+
+// Index is a full-text index over products. The MongoDB implementation is
+// used in production, MemoryIndex can be swapped in where no database is
+// available.
+type Index interface {
+	// Search runs a query and returns ranked hits and facet counts
+	Search(ctx context.Context, req Request) (*Result, error)
+	// Index adds or replaces a product in the index
+	Index(ctx context.Context, product models.Product) error
+	// Remove deletes a product from the index
+	Remove(ctx context.Context, productID string) error
+}
+
+// Request holds the parameters of a search
+type Request struct {
+	Query    string
+	Category string
+	MinPrice *int64
+	MaxPrice *int64
+	InStock  *bool
+	Page     int
+	Limit    int
+}
+
+// Hit is a product matching a search with its relevance score
+type Hit struct {
+	Product    models.Product    `json:"product"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// FacetCount is the number of matching products sharing a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Facets summarize all products matching a search
+type Facets struct {
+	Categories  []FacetCount `json:"categories"`
+	PriceRanges []FacetCount `json:"price_ranges"`
+	Stock       []FacetCount `json:"stock"`
+}
+
+// Result is one page of search hits plus facets over every match
+type Result struct {
+	Hits   []Hit  `json:"hits"`
+	Total  int64  `json:"total"`
+	Facets Facets `json:"facets"`
+}
+
+// Field weights used when ranking, a match in the name counts more than one
+// in the description
+const (
+	NameWeight        = 10
+	DescriptionWeight = 2
+)
+
+// PriceBoundaries are the lower bounds of the price facet buckets, in major
+// units of the base currency
+var PriceBoundaries = []float64{0, 10, 50, 100, 500, 1000}
+
+// Stock facet values
+const (
+	InStock    = "in_stock"
+	OutOfStock = "out_of_stock"
+)
+
+// minorBoundaries returns PriceBoundaries in minor units of the base currency
+func minorBoundaries() []int64 {
+	scale := math.Pow10(money.Exponent(money.Base()))
+	bounds := make([]int64, len(PriceBoundaries))
+	for i, b := range PriceBoundaries {
+		bounds[i] = int64(math.Round(b * scale))
+	}
+	return bounds
+}
+
+// priceBucket returns the label of the price bucket a price in minor units
+// of the base currency falls into
+func priceBucket(price int64) string {
+	bounds := minorBoundaries()
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if price >= bounds[i] {
+			return bucketLabel(i)
+		}
+	}
+	return bucketLabel(0)
+}
+
+// bucketLabel names the bucket starting at PriceBoundaries[i]
+func bucketLabel(i int) string {
+	if i == len(PriceBoundaries)-1 {
+		return fmt.Sprintf("%g+", PriceBoundaries[i])
+	}
+	return fmt.Sprintf("%g-%g", PriceBoundaries[i], PriceBoundaries[i+1])
+}
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Tokenize splits text into lowercase words
+func Tokenize(text string) []string {
+	return wordPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+// maxExpansions caps how many vocabulary words a single query term expands to
+const maxExpansions = 10
+
+// Expand matches each query term against the vocabulary. Terms expand to
+// words within a small edit distance, and the last term, which the user may
+// still be typing, also expands to words it is a prefix of.
+func Expand(terms []string, vocabulary map[string]struct{}) map[string]bool {
+	expanded := make(map[string]bool) // word -> exact match
+	for i, term := range terms {
+		expanded[term] = true
+		found := 0
+		for word := range vocabulary {
+			if found >= maxExpansions {
+				break
+			}
+			if word == term {
+				continue
+			}
+			prefix := i == len(terms)-1 && utf8.RuneCountInString(term) >= 2 && strings.HasPrefix(word, term)
+			if prefix || withinTypoDistance(term, word) {
+				if _, ok := expanded[word]; !ok {
+					expanded[word] = false
+				}
+				found++
+			}
+		}
+	}
+	return expanded
+}
+
+// withinTypoDistance reports whether word is a plausible misspelling of term.
+// Short terms must match exactly, longer ones tolerate one or two edits.
+func withinTypoDistance(term, word string) bool {
+	maxEdits := 0
+	switch n := utf8.RuneCountInString(term); {
+	case n >= 8:
+		maxEdits = 2
+	case n >= 4:
+		maxEdits = 1
+	}
+	if maxEdits == 0 {
+		return false
+	}
+	return levenshtein([]rune(term), []rune(word), maxEdits) <= maxEdits
+}
+
+// levenshtein returns the edit distance between a and b, or limit+1 once it
+// is known to exceed limit
+func levenshtein(a, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// snippetLength is the approximate length of description snippets
+const snippetLength = 160
+
+// Highlight wraps words of text found in terms in <em> tags, escaping the
+// rest as HTML. When snippet is set only a window around the first match is
+// returned. It returns an empty string if nothing matched.
+func Highlight(text string, terms map[string]bool, snippet bool) string {
+	matches := wordPattern.FindAllStringIndex(text, -1)
+
+	var b strings.Builder
+	start, end, first := 0, len(text), -1
+	for _, m := range matches {
+		if _, ok := terms[strings.ToLower(text[m[0]:m[1]])]; ok {
+			first = m[0]
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	if snippet && len(text) > snippetLength {
+		start = max(0, first-snippetLength/4)
+		end = min(len(text), start+snippetLength)
+		// Move the window to word boundaries
+		for _, m := range matches {
+			if m[0] <= start && start < m[1] {
+				start = m[0]
+			}
+			if m[0] < end && end < m[1] {
+				end = m[0]
+			}
+		}
+		if start > 0 {
+			b.WriteString("…")
+		}
+	}
+
+	last := start
+	for _, m := range matches {
+		if m[0] < start || m[1] > end {
+			continue
+		}
+		if _, ok := terms[strings.ToLower(text[m[0]:m[1]])]; ok {
+			b.WriteString(html.EscapeString(text[last:m[0]]))
+			b.WriteString("<em>" + html.EscapeString(text[m[0]:m[1]]) + "</em>")
+			last = m[1]
+		}
+	}
+	b.WriteString(html.EscapeString(text[last:end]))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// highlights builds the highlight snippets of a hit
+func highlights(product models.Product, terms map[string]bool) map[string]string {
+	result := make(map[string]string)
+	if h := Highlight(product.Name, terms, false); h != "" {
+		result["name"] = h
+	}
+	if h := Highlight(product.Description, terms, true); h != "" {
+		result["description"] = h
+	}
+	return result
+}
@@ -0,0 +1,134 @@
+package trash
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/cache"
+	"go-tutorial/media"
+	"go-tutorial/models"
+)
+
+// Purger periodically hard-deletes users and products that have been in
+// the trash for longer than the retention period, together with their
+// stored images and the reviews and revisions of purged products
+type Purger struct {
+	db        *mongo.Database
+	images    *media.Images
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurger(db *mongo.Database, images *media.Images, retention, interval time.Duration) *Purger {
+	return &Purger{
+		db:        db,
+		images:    images,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+func (p *Purger) Start() {
+	ticker := time.NewTicker(p.interval)
+	go func() {
+		for range ticker.C {
+			p.purge()
+		}
+	}()
+}
+
+func (p *Purger) purge() {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-p.retention)
+
+	products, err := p.purgeProducts(ctx, cutoff)
+	if err != nil {
+		log.Printf("Error purging deleted products: %v", err)
+	}
+	users, err := p.purgeUsers(ctx, cutoff)
+	if err != nil {
+		log.Printf("Error purging deleted users: %v", err)
+	}
+
+	if products > 0 || users > 0 {
+		log.Printf("Purged %d products and %d users from the trash", products, users)
+	}
+}
+
+// purgeProducts removes the products deleted before cutoff
+func (p *Purger) purgeProducts(ctx context.Context, cutoff time.Time) (int, error) {
+	collection := p.db.Collection("products")
+	cursor, err := collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, product := range products {
+		// Only delete if it wasn't restored meanwhile
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": product.ID, "deleted_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return purged, err
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		purged++
+
+		for i := range product.Images {
+			p.images.Delete(ctx, &product.Images[i])
+		}
+		if _, err := p.db.Collection("reviews").DeleteMany(ctx, bson.M{"product_id": product.ID}); err != nil {
+			log.Printf("Failed to delete reviews of product %s: %v", product.ID.Hex(), err)
+		}
+		if _, err := p.db.Collection("product_revisions").DeleteMany(ctx, bson.M{"product_id": product.ID}); err != nil {
+			log.Printf("Failed to delete revisions of product %s: %v", product.ID.Hex(), err)
+		}
+		if err := cache.DeleteCache(ctx, fmt.Sprintf(cache.ProductDetailPattern, product.ID.Hex())); err != nil {
+			log.Printf("Failed to invalidate product detail cache: %v", err)
+		}
+	}
+	return purged, nil
+}
+
+// purgeUsers removes the users deleted before cutoff
+func (p *Purger) purgeUsers(ctx context.Context, cutoff time.Time) (int, error) {
+	collection := p.db.Collection("users")
+	cursor, err := collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	var users []models.UserDetails
+	if err := cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		result, err := collection.DeleteOne(ctx, bson.M{"_id": user.ID, "deleted_at": bson.M{"$lt": cutoff}})
+		if err != nil {
+			return purged, err
+		}
+		if result.DeletedCount == 0 {
+			continue
+		}
+		purged++
+
+		if user.Avatar != nil {
+			p.images.DeleteAvatar(ctx, user.Avatar)
+		}
+		if err := cache.DeleteCache(ctx, fmt.Sprintf(cache.UserDetailPattern, user.ID.Hex())); err != nil {
+			log.Printf("Failed to invalidate user detail cache: %v", err)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,64 @@
+// Package trash implements soft deletion. Deleted users and products keep
+// their documents with deleted_at and deleted_by set, are left out of every
+// query through NotDeleted and can be restored until the purger removes
+// them for good.
+package trash
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NotDeleted returns a copy of filter restricted to documents outside the
+// trash
+func NotDeleted(filter bson.M) bson.M {
+	live := bson.M{"deleted_at": nil}
+	for key, value := range filter {
+		live[key] = value
+	}
+	return live
+}
+
+// Deleted returns a copy of filter restricted to documents in the trash
+func Deleted(filter bson.M) bson.M {
+	trashed := bson.M{"deleted_at": bson.M{"$ne": nil}}
+	for key, value := range filter {
+		trashed[key] = value
+	}
+	return trashed
+}
+
+// SoftDelete moves a document to the trash, recording who deleted it. It
+// returns mongo.ErrNoDocuments when there is no such document outside the
+// trash.
+func SoftDelete(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, actor string) error {
+	result, err := collection.UpdateOne(ctx, NotDeleted(bson.M{"_id": id}), bson.M{
+		"$set": bson.M{"deleted_at": time.Now(), "deleted_by": actor},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Restore takes a document out of the trash. It returns
+// mongo.ErrNoDocuments when the document is not in the trash.
+func Restore(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) error {
+	result, err := collection.UpdateOne(ctx, Deleted(bson.M{"_id": id}), bson.M{
+		"$unset": bson.M{"deleted_at": "", "deleted_by": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -39,8 +39,8 @@ func (j *RedisUpdateJob) updateProductsCache() {
 	ctx := context.Background()
 	productsCollection := j.db.Database(j.database).Collection("products")
 
-	// Get all products
-	cursor, err := productsCollection.Find(ctx, bson.M{})
+	// Get all products that are not in the trash
+	cursor, err := productsCollection.Find(ctx, bson.M{"deleted_at": nil})
 	if err != nil {
 		log.Printf("Error fetching products for cache update: %v", err)
 		return
@@ -62,7 +62,7 @@ func (j *RedisUpdateJob) updateProductsCache() {
 		Total:    int64(len(products)),
 	}
 
-	if err := cache.SetCache(ctx, "products:all", dataToCache, 15*time.Minute); err != nil {
+	if err := cache.SetCache(ctx, cache.ProductSnapshotKey, dataToCache, 15*time.Minute); err != nil {
 		log.Printf("Failed to update products cache: %v", err)
 		return
 	}
@@ -82,30 +82,41 @@ func (j *RedisUpdateJob) updateUsersCache() {
 	ctx := context.Background()
 	usersCollection := j.db.Database(j.database).Collection("users")
 
-	// Get all users
-	cursor, err := usersCollection.Find(ctx, bson.M{})
+	// Get all users that are not in the trash
+	cursor, err := usersCollection.Find(ctx, bson.M{"deleted_at": nil})
 	if err != nil {
 		log.Printf("Error fetching users for cache update: %v", err)
 		return
 	}
 	defer cursor.Close(ctx)
 
-	var users []models.UserResponse
+	var users []models.UserDetails
 	if err := cursor.All(ctx, &users); err != nil {
 		log.Printf("Error decoding users for cache update: %v", err)
 		return
 	}
 
+	// The list holds the public fields, the detail keys the whole profile
+	responses := make([]models.UserResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, models.UserResponse{
+			ID:    user.ID,
+			Name:  user.Name,
+			Email: user.Email,
+			Role:  user.Role,
+		})
+	}
+
 	// Update users list cache
 	dataToCache := struct {
 		Users []models.UserResponse `json:"users"`
 		Total int64                 `json:"total"`
 	}{
-		Users: users,
-		Total: int64(len(users)),
+		Users: responses,
+		Total: int64(len(responses)),
 	}
 
-	if err := cache.SetCache(ctx, "users:all", dataToCache, 15*time.Minute); err != nil {
+	if err := cache.SetCache(ctx, cache.UserSnapshotKey, dataToCache, 15*time.Minute); err != nil {
 		log.Printf("Failed to update users cache: %v", err)
 		return
 	}
@@ -71,6 +71,11 @@ func (h *ErrorHandler) HandleNotFound(w http.ResponseWriter, message string) {
 	h.HandleError(w, http.StatusNotFound, message)
 }
 
+// HandleConflict sends a 409 Conflict response
+func (h *ErrorHandler) HandleConflict(w http.ResponseWriter, message string) {
+	h.HandleError(w, http.StatusConflict, message)
+}
+
 // HandleInternalError sends a 500 Internal Server Error response
 func (h *ErrorHandler) HandleInternalError(w http.ResponseWriter, message string) {
 	h.HandleError(w, http.StatusInternalServerError, message)
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DefaultPageSize is used when the request does not specify a limit
+	DefaultPageSize = 10
+	// MaxPageSize caps the limit a client can request
+	MaxPageSize = 100
+)
+
+// cursorSecret signs cursor tokens so clients cannot forge them
+var cursorSecret = []byte("your-cursor-secret")
+
+// ErrInvalidCursor is returned when a cursor token is malformed, tampered with
+// or was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks a position in a sorted result set. It holds the values of the
+// sort fields and the _id of the item at that position.
+type Cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+	ID     string        `json:"id"`
+}
+
+// EncodeCursor turns a cursor into an opaque signed token
+func EncodeCursor(c Cursor) string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies a token created by EncodeCursor and returns its cursor
+func DecodeCursor(token string) (*Cursor, error) {
+	payloadPart, sigPart, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, cursorSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// PageParams holds the pagination parameters of a list request
+type PageParams struct {
+	Page         int
+	Limit        int
+	After        *Cursor
+	Before       *Cursor
+	IncludeTotal bool
+}
+
+// Keyset reports whether the request pages by cursor instead of by offset
+func (p PageParams) Keyset() bool {
+	return p.After != nil || p.Before != nil
+}
+
+// Skip returns the number of documents to skip in offset mode
+func (p PageParams) Skip() int64 {
+	if p.Keyset() {
+		return 0
+	}
+	return int64((p.Page - 1) * p.Limit)
+}
+
+// ParsePageParams reads page, limit, after, before and include_total from the
+// query string. Cursors must have been issued for the given sort key.
+func ParsePageParams(r *http.Request, sortKey string) (PageParams, error) {
+	query := r.URL.Query()
+	params := PageParams{
+		Page:  1,
+		Limit: DefaultPageSize,
+	}
+
+	if p, err := strconv.Atoi(query.Get("page")); err == nil && p > 0 {
+		params.Page = p
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		params.Limit = min(l, MaxPageSize)
+	}
+
+	after, before := query.Get("after"), query.Get("before")
+	if after != "" && before != "" {
+		return params, errors.New("after and before cannot be used together")
+	}
+	var err error
+	if after != "" {
+		if params.After, err = decodeCursorFor(after, sortKey); err != nil {
+			return params, err
+		}
+	}
+	if before != "" {
+		if params.Before, err = decodeCursorFor(before, sortKey); err != nil {
+			return params, err
+		}
+	}
+
+	// Offset pagination keeps reporting totals for backward compatibility,
+	// cursor pagination only counts when asked to
+	params.IncludeTotal = !params.Keyset()
+	if v := query.Get("include_total"); v != "" {
+		params.IncludeTotal, _ = strconv.ParseBool(v)
+	}
+
+	return params, nil
+}
+
+// decodeCursorFor decodes a cursor token and checks it was issued for sortKey
+func decodeCursorFor(token, sortKey string) (*Cursor, error) {
+	c, err := DecodeCursor(token)
+	if err != nil || c.Sort != sortKey {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := primitive.ObjectIDFromHex(c.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// KeysetFilter returns the filter selecting documents after (or before) the
+// cursor. sortDoc is the sort the cursor was issued for, ending with _id.
+func KeysetFilter(c *Cursor, sortDoc bson.D, before bool) bson.M {
+	id, _ := primitive.ObjectIDFromHex(c.ID)
+	keys := append(append([]interface{}{}, c.Values...), id)
+	if len(keys) != len(sortDoc) {
+		// Cursors are signed, so this only happens if the sort changed shape
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+
+	// (a > x) OR (a = x AND b > y) OR (a = x AND b = y AND _id > z)
+	branches := make([]bson.M, 0, len(sortDoc))
+	for i, e := range sortDoc {
+		branch := bson.M{}
+		for j := 0; j < i; j++ {
+			branch[sortDoc[j].Key] = keys[j]
+		}
+
+		op := "$gt"
+		if (e.Value.(int) < 0) != before {
+			op = "$lt"
+		}
+		branch[e.Key] = bson.M{op: keys[i]}
+		branches = append(branches, branch)
+	}
+	return bson.M{"$or": branches}
+}
+
+// PageLinks works out whether a fetched page has neighbours. fetched is the
+// number of documents returned when querying for Limit+1.
+func (p PageParams) PageLinks(fetched int) (hasNext, hasPrev bool) {
+	more := fetched > p.Limit
+	switch {
+	case p.Before != nil:
+		return true, more
+	case p.After != nil:
+		return more, true
+	default:
+		return more, p.Page > 1
+	}
+}
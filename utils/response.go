@@ -2,7 +2,9 @@ package utils
 
 import (
     "encoding/json"
+    "fmt"
     "net/http"
+    "strings"
 )
 
 // ResponseHandler handles all successful responses
@@ -23,10 +25,21 @@ type PaginatedResponse struct {
 
 // PaginationInfo contains pagination metadata
 type PaginationInfo struct {
-    CurrentPage  int `json:"current_page"`
-    TotalPages   int `json:"total_pages"`
-    ItemsPerPage int `json:"items_per_page"`
-    TotalItems   int `json:"total_items"`
+    CurrentPage  int    `json:"current_page,omitempty"`
+    TotalPages   *int   `json:"total_pages,omitempty"`
+    ItemsPerPage int    `json:"items_per_page"`
+    TotalItems   *int   `json:"total_items,omitempty"`
+    NextCursor   string `json:"next_cursor,omitempty"`
+    PrevCursor   string `json:"prev_cursor,omitempty"`
+}
+
+// Page describes the page of results passed to Paginated
+type Page struct {
+    Number     int    // 1-based page number, 0 when paging by cursor
+    Limit      int    // Items per page
+    Total      *int64 // Total matching items, nil when not counted
+    NextCursor string // Cursor for the following page, empty on the last page
+    PrevCursor string // Cursor for the preceding page, empty on the first page
 }
 
 // NewResponseHandler creates a new response handler
@@ -65,9 +78,25 @@ func (h *ResponseHandler) Created(w http.ResponseWriter, message string, data in
     })
 }
 
-// Paginated sends a paginated response
-func (h *ResponseHandler) Paginated(w http.ResponseWriter, message string, data interface{}, page, limit, total int) {
-    totalPages := (total + limit - 1) / limit // Ceiling division
+// Paginated sends a paginated response. Cursors are also advertised through
+// an RFC 8288 Link header built from the request URL.
+func (h *ResponseHandler) Paginated(w http.ResponseWriter, r *http.Request, message string, data interface{}, page Page) {
+    info := &PaginationInfo{
+        CurrentPage:  page.Number,
+        ItemsPerPage: page.Limit,
+        NextCursor:   page.NextCursor,
+        PrevCursor:   page.PrevCursor,
+    }
+    if page.Total != nil {
+        total := int(*page.Total)
+        totalPages := (total + page.Limit - 1) / page.Limit // Ceiling division
+        info.TotalItems = &total
+        info.TotalPages = &totalPages
+    }
+
+    if links := pageLinks(r, page); links != "" {
+        w.Header().Set("Link", links)
+    }
 
     h.JSON(w, http.StatusOK, PaginatedResponse{
         Response: Response{
@@ -75,11 +104,33 @@ func (h *ResponseHandler) Paginated(w http.ResponseWriter, message string, data
             Message: message,
             Data:    data,
         },
-        Pagination: &PaginationInfo{
-            CurrentPage:  page,
-            TotalPages:   totalPages,
-            ItemsPerPage: limit,
-            TotalItems:   total,
-        },
+        Pagination: info,
     })
-}
\ No newline at end of file
+}
+
+// pageLinks builds the Link header value with first, next and prev relations
+func pageLinks(r *http.Request, page Page) string {
+    link := func(rel, param, cursor string) string {
+        query := r.URL.Query()
+        query.Del("page")
+        query.Del("after")
+        query.Del("before")
+        if param != "" {
+            query.Set(param, cursor)
+        }
+        target := r.URL.Path
+        if encoded := query.Encode(); encoded != "" {
+            target += "?" + encoded
+        }
+        return fmt.Sprintf("<%s>; rel=\"%s\"", target, rel)
+    }
+
+    links := []string{link("first", "", "")}
+    if page.NextCursor != "" {
+        links = append(links, link("next", "after", page.NextCursor))
+    }
+    if page.PrevCursor != "" {
+        links = append(links, link("prev", "before", page.PrevCursor))
+    }
+    return strings.Join(links, ", ")
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify converts a name into a lowercase, hyphen separated ASCII
+// identifier, e.g. "Home & Garden" becomes "home-garden" and "Điện thoại"
+// becomes "dien-thoai"
+func Slugify(name string) string {
+	// Split accented letters into base letter and mark, then drop the marks
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == 'đ':
+			r = 'd'
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(nonSlugChars.ReplaceAllString(b.String(), "-"), "-")
+}
@@ -2,23 +2,45 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
 
-// FormatValidationError formats validation errors into user-friendly messages
+// FormatValidationError formats validation errors into user-friendly messages.
+// Tags with alternatives, e.g. "e164|len=0", are described by the first one.
 func FormatValidationError(err validator.FieldError) string {
-	switch err.Tag() {
+	tag, _, _ := strings.Cut(err.Tag(), "|")
+	switch tag {
 	case "required":
 		return "This field is required"
+	case "required_if":
+		field, value, _ := strings.Cut(err.Param(), " ")
+		return fmt.Sprintf("This field is required when %s is %s", strings.ToLower(field), value)
 	case "email":
 		return "Invalid email format"
 	case "min":
 		return fmt.Sprintf("Minimum length is %s", err.Param())
 	case "max":
 		return fmt.Sprintf("Maximum length is %s", err.Param())
+	case "gt":
+		return fmt.Sprintf("Must be greater than %s", err.Param())
+	case "gte":
+		return fmt.Sprintf("Must be at least %s", err.Param())
+	case "lte":
+		return fmt.Sprintf("Must be at most %s", err.Param())
 	case "oneof":
 		return fmt.Sprintf("Must be one of: %s", err.Param())
+	case "url":
+		return "Must be an absolute URL"
+	case "e164":
+		return "Must be a phone number in international format, e.g. +14155552671"
+	case "timezone":
+		return "Must be a time zone name, e.g. Europe/Berlin"
+	case "bcp47_language_tag":
+		return "Must be a language tag, e.g. en-US"
+	case "iso3166_1_alpha2":
+		return "Must be a two letter country code, e.g. US"
 	default:
 		return fmt.Sprintf("Validation failed on %s", err.Tag())
 	}
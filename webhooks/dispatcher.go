@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"go-tutorial/events"
+	"go-tutorial/models"
+)
+
+// Dispatcher is the events.Publisher that queues deliveries. An event
+// published again, e.g. after a relay retry, is queued only once per
+// subscription.
+type Dispatcher struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+// NewDispatcher creates a dispatcher over db
+func NewDispatcher(db *mongo.Database) *Dispatcher {
+	return &Dispatcher{
+		subscriptions: db.Collection(SubscriptionsCollection),
+		deliveries:    db.Collection(DeliveriesCollection),
+	}
+}
+
+// Publish queues a delivery of event for every active subscription to its
+// type
+func (d *Dispatcher) Publish(ctx context.Context, event events.Event) error {
+	cursor, err := d.subscriptions.Find(ctx, bson.M{"active": true, "events": event.Type})
+	if err != nil {
+		return err
+	}
+	var subscriptions []models.WebhookSubscription
+	if err := cursor.All(ctx, &subscriptions); err != nil {
+		return err
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		_, err := d.deliveries.InsertOne(ctx, models.WebhookDelivery{
+			ID:             primitive.NewObjectID(),
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        payload,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  &now,
+			Attempts:       []models.WebhookAttempt{},
+			CreatedAt:      now,
+		})
+		// The unique index on subscription and event makes this idempotent
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
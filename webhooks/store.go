@@ -0,0 +1,140 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"go-tutorial/models"
+)
+
+// Store keeps the subscriptions and deliveries the Worker reads and
+// updates. The MongoDB implementation is used in production.
+type Store interface {
+	// Claim takes the oldest delivery due at now and holds it until until,
+	// returning nil when none is due
+	Claim(ctx context.Context, now, until time.Time) (*models.WebhookDelivery, error)
+	// Subscription returns a subscription, nil when it is gone
+	Subscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error)
+	// Record saves the status, failures, next attempt and delivery time of
+	// delivery along with a new attempt
+	Record(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt) error
+	// CountFailure adds a failure to the streak of a subscription and
+	// returns the subscription as updated
+	CountFailure(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error)
+	// ResetFailures clears the failure streak of a subscription
+	ResetFailures(ctx context.Context, id primitive.ObjectID) error
+	// Disable turns off an active subscription for reason
+	Disable(ctx context.Context, id primitive.ObjectID, reason string, at time.Time) error
+	// Redeliver queues a delivery of a subscription to be sent at at with a
+	// fresh set of attempts, returning nil when there is no such delivery
+	Redeliver(ctx context.Context, subscriptionID, deliveryID primitive.ObjectID, at time.Time) (*models.WebhookDelivery, error)
+}
+
+// MongoStore keeps subscriptions and deliveries in their collections
+type MongoStore struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+// NewMongoStore creates a store over the webhook collections of db
+func NewMongoStore(db *mongo.Database) *MongoStore {
+	return &MongoStore{
+		subscriptions: db.Collection(SubscriptionsCollection),
+		deliveries:    db.Collection(DeliveriesCollection),
+	}
+}
+
+func (s *MongoStore) Claim(ctx context.Context, now, until time.Time) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := s.deliveries.FindOneAndUpdate(ctx,
+		bson.M{"status": models.DeliveryPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"next_attempt_at": until}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (s *MongoStore) Subscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	err := s.subscriptions.FindOne(ctx, bson.M{"_id": id}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *MongoStore) Record(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	set := bson.M{"status": delivery.Status, "failures": delivery.Failures}
+	update := bson.M{"$set": set, "$push": bson.M{"attempts": attempt}}
+	if delivery.NextAttemptAt != nil {
+		set["next_attempt_at"] = *delivery.NextAttemptAt
+	} else {
+		update["$unset"] = bson.M{"next_attempt_at": ""}
+	}
+	if delivery.DeliveredAt != nil {
+		set["delivered_at"] = *delivery.DeliveredAt
+	}
+	_, err := s.deliveries.UpdateOne(ctx, bson.M{"_id": delivery.ID}, update)
+	return err
+}
+
+func (s *MongoStore) CountFailure(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	var subscription models.WebhookSubscription
+	err := s.subscriptions.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"consecutive_failures": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&subscription)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func (s *MongoStore) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.subscriptions.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"consecutive_failures": 0}})
+	return err
+}
+
+func (s *MongoStore) Disable(ctx context.Context, id primitive.ObjectID, reason string, at time.Time) error {
+	_, err := s.subscriptions.UpdateOne(ctx, bson.M{"_id": id, "active": true}, bson.M{
+		"$set": bson.M{"active": false, "disabled_at": at, "disabled_reason": reason},
+	})
+	return err
+}
+
+func (s *MongoStore) Redeliver(ctx context.Context, subscriptionID, deliveryID primitive.ObjectID, at time.Time) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := s.deliveries.FindOneAndUpdate(ctx,
+		bson.M{"_id": deliveryID, "subscription_id": subscriptionID},
+		bson.M{"$set": bson.M{
+			"status":          models.DeliveryPending,
+			"failures":        0,
+			"next_attempt_at": at,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
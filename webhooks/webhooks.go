@@ -0,0 +1,48 @@
+// Package webhooks notifies partner endpoints of domain events. The
+// Dispatcher turns every published event into a delivery for each active
+// subscription to its type, and the Worker sends the deliveries, retrying
+// failures with exponential backoff.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// Collections of subscriptions and their deliveries
+const (
+	SubscriptionsCollection = "webhook_subscriptions"
+	DeliveriesCollection    = "webhook_deliveries"
+)
+
+// Headers of a webhook request. The signature is "t=<unix time>,v1=<hex>",
+// the HMAC-SHA256 of the timestamp, a dot and the body keyed with the
+// subscription's secret. Receivers should reject old timestamps to stop
+// replays.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Sign returns the signature header value for a payload sent at t
+func Sign(secret string, payload []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// GenerateSecret returns a random signing secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
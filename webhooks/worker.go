@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"go-tutorial/models"
+)
+
+const (
+	// batchSize bounds the deliveries sent per tick
+	batchSize = 50
+	// maxResponseSize caps the response body kept with an attempt
+	maxResponseSize = 1 << 10
+	// baseBackoff is the wait after the first failure, doubled after each
+	// further one
+	baseBackoff = 30 * time.Second
+	// maxBackoff caps the wait between retries
+	maxBackoff = 6 * time.Hour
+)
+
+// Policy decides how long deliveries are retried
+type Policy struct {
+	MaxAttempts  int           // Attempts before a delivery is dead
+	DisableAfter int           // Failed attempts in a row before a subscription is disabled, 0 never disables
+	Timeout      time.Duration // Time allowed for the receiver to respond
+}
+
+// Worker periodically sends due deliveries. A delivery is claimed for
+// longer than the timeout before it is sent, so several workers can run at
+// once without sending it twice.
+type Worker struct {
+	store    Store
+	client   *http.Client
+	interval time.Duration
+	policy   Policy
+}
+
+func NewWorker(store Store, interval time.Duration, policy Policy) *Worker {
+	return &Worker{
+		store: store,
+		client: &http.Client{
+			Timeout: policy.Timeout,
+			// A redirect counts as a failure, receivers must give the final URL
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval: interval,
+		policy:   policy,
+	}
+}
+
+func (w *Worker) Start() {
+	ticker := time.NewTicker(w.interval)
+	go func() {
+		for range ticker.C {
+			w.work()
+		}
+	}()
+}
+
+func (w *Worker) work() {
+	ctx := context.Background()
+	for i := 0; i < batchSize; i++ {
+		now := time.Now()
+		delivery, err := w.store.Claim(ctx, now, now.Add(2*w.policy.Timeout))
+		if err != nil {
+			log.Printf("Error claiming webhook delivery: %v", err)
+			return
+		}
+		if delivery == nil {
+			return
+		}
+		w.deliver(ctx, delivery)
+	}
+}
+
+// deliver sends a claimed delivery and records the attempt
+func (w *Worker) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	subscription, err := w.store.Subscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		log.Printf("Error fetching webhook subscription %s: %v", delivery.SubscriptionID.Hex(), err)
+		return
+	}
+	if subscription == nil || !subscription.Active {
+		w.bury(ctx, delivery, "subscription is disabled or deleted")
+		return
+	}
+
+	attempt := w.send(ctx, *subscription, delivery)
+	if attempt.StatusCode >= 200 && attempt.StatusCode < 300 {
+		w.succeed(ctx, delivery, subscription, attempt)
+		return
+	}
+	w.fail(ctx, delivery, subscription, attempt)
+}
+
+// send posts a delivery to the subscription's URL once
+func (w *Worker) send(ctx context.Context, subscription models.WebhookSubscription, delivery *models.WebhookDelivery) models.WebhookAttempt {
+	start := time.Now()
+	attempt := models.WebhookAttempt{At: start}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-tutorial-webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, delivery.Payload, start))
+
+	resp, err := w.client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	attempt.Response = string(body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("receiver responded %d", resp.StatusCode)
+	}
+	return attempt
+}
+
+// succeed records a successful attempt, which also clears the failure
+// streak of the subscription
+func (w *Worker) succeed(ctx context.Context, delivery *models.WebhookDelivery, subscription *models.WebhookSubscription, attempt models.WebhookAttempt) {
+	delivery.Status = models.DeliverySucceeded
+	delivery.NextAttemptAt = nil
+	delivery.DeliveredAt = &attempt.At
+	if err := w.store.Record(ctx, delivery, attempt); err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+	if subscription.ConsecutiveFailures > 0 {
+		if err := w.store.ResetFailures(ctx, subscription.ID); err != nil {
+			log.Printf("Error resetting failures of webhook %s: %v", subscription.ID.Hex(), err)
+		}
+	}
+}
+
+// fail records a failed attempt, scheduling a retry or burying the delivery
+// when it has used up its attempts, and disables the subscription once it
+// has failed too often in a row
+func (w *Worker) fail(ctx context.Context, delivery *models.WebhookDelivery, subscription *models.WebhookSubscription, attempt models.WebhookAttempt) {
+	delivery.Failures++
+	if delivery.Failures >= w.policy.MaxAttempts {
+		delivery.Status = models.DeliveryDead
+		delivery.NextAttemptAt = nil
+		log.Printf("Giving up on webhook delivery %s after %d attempts: %s", delivery.ID.Hex(), delivery.Failures, attempt.Error)
+	} else {
+		next := time.Now().Add(backoff(delivery.Failures))
+		delivery.NextAttemptAt = &next
+	}
+	if err := w.store.Record(ctx, delivery, attempt); err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+
+	updated, err := w.store.CountFailure(ctx, subscription.ID)
+	if err != nil {
+		log.Printf("Error counting failures of webhook %s: %v", subscription.ID.Hex(), err)
+		return
+	}
+	if w.policy.DisableAfter > 0 && updated.Active && updated.ConsecutiveFailures >= w.policy.DisableAfter {
+		reason := fmt.Sprintf("%d failed attempts in a row, last: %s", updated.ConsecutiveFailures, attempt.Error)
+		if err := w.store.Disable(ctx, subscription.ID, reason, time.Now()); err != nil {
+			log.Printf("Error disabling webhook %s: %v", subscription.ID.Hex(), err)
+			return
+		}
+		log.Printf("Disabled webhook %s: %s", subscription.ID.Hex(), reason)
+	}
+}
+
+// bury marks a delivery dead without sending it
+func (w *Worker) bury(ctx context.Context, delivery *models.WebhookDelivery, reason string) {
+	delivery.Status = models.DeliveryDead
+	delivery.NextAttemptAt = nil
+	if err := w.store.Record(ctx, delivery, models.WebhookAttempt{At: time.Now(), Error: reason}); err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", delivery.ID.Hex(), err)
+	}
+}
+
+// backoff returns the wait before the retry following the given number of
+// failures: 30s, 1m, 2m and so on up to maxBackoff
+func backoff(failures int) time.Duration {
+	if failures < 1 {
+		return baseBackoff
+	}
+	if failures > 16 {
+		return maxBackoff
+	}
+	wait := baseBackoff << (failures - 1)
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,444 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"go-tutorial/models"
+)
+
+// memoryStore keeps subscriptions and deliveries in maps
+type memoryStore struct {
+	mu            sync.Mutex
+	subscriptions map[primitive.ObjectID]*models.WebhookSubscription
+	deliveries    map[primitive.ObjectID]*models.WebhookDelivery
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		subscriptions: make(map[primitive.ObjectID]*models.WebhookSubscription),
+		deliveries:    make(map[primitive.ObjectID]*models.WebhookDelivery),
+	}
+}
+
+func (s *memoryStore) Claim(ctx context.Context, now, until time.Time) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due *models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.Status != models.DeliveryPending || d.NextAttemptAt == nil || d.NextAttemptAt.After(now) {
+			continue
+		}
+		if due == nil || d.NextAttemptAt.Before(*due.NextAttemptAt) {
+			due = d
+		}
+	}
+	if due == nil {
+		return nil, nil
+	}
+	due.NextAttemptAt = &until
+	claimed := *due
+	return &claimed, nil
+}
+
+func (s *memoryStore) Subscription(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (s *memoryStore) Record(ctx context.Context, delivery *models.WebhookDelivery, attempt models.WebhookAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deliveries[delivery.ID]
+	d.Status = delivery.Status
+	d.Failures = delivery.Failures
+	d.NextAttemptAt = delivery.NextAttemptAt
+	d.DeliveredAt = delivery.DeliveredAt
+	d.Attempts = append(d.Attempts, attempt)
+	return nil
+}
+
+func (s *memoryStore) CountFailure(ctx context.Context, id primitive.ObjectID) (*models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription := s.subscriptions[id]
+	subscription.ConsecutiveFailures++
+	copied := *subscription
+	return &copied, nil
+}
+
+func (s *memoryStore) ResetFailures(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[id].ConsecutiveFailures = 0
+	return nil
+}
+
+func (s *memoryStore) Disable(ctx context.Context, id primitive.ObjectID, reason string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription := s.subscriptions[id]
+	if subscription.Active {
+		subscription.Active = false
+		subscription.DisabledAt = &at
+		subscription.DisabledReason = reason
+	}
+	return nil
+}
+
+func (s *memoryStore) Redeliver(ctx context.Context, subscriptionID, deliveryID primitive.ObjectID, at time.Time) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[deliveryID]
+	if !ok || d.SubscriptionID != subscriptionID {
+		return nil, nil
+	}
+	d.Status = models.DeliveryPending
+	d.Failures = 0
+	d.NextAttemptAt = &at
+	copied := *d
+	return &copied, nil
+}
+
+func (s *memoryStore) subscribe(url, secret string) *models.WebhookSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription := &models.WebhookSubscription{
+		ID:     primitive.NewObjectID(),
+		URL:    url,
+		Events: []string{"product.created"},
+		Secret: secret,
+		Active: true,
+	}
+	s.subscriptions[subscription.ID] = subscription
+	return subscription
+}
+
+func (s *memoryStore) queue(subscription *models.WebhookSubscription, payload string) *models.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	delivery := &models.WebhookDelivery{
+		ID:             primitive.NewObjectID(),
+		SubscriptionID: subscription.ID,
+		EventID:        primitive.NewObjectID(),
+		EventType:      "product.created",
+		Payload:        []byte(payload),
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  &now,
+		Attempts:       []models.WebhookAttempt{},
+		CreatedAt:      now,
+	}
+	s.deliveries[delivery.ID] = delivery
+	return delivery
+}
+
+// makeDue moves the next attempt of a delivery to now, as if its backoff
+// had passed
+func (s *memoryStore) makeDue(id primitive.ObjectID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d := s.deliveries[id]; d.NextAttemptAt != nil {
+		now := time.Now()
+		d.NextAttemptAt = &now
+	}
+}
+
+func (s *memoryStore) delivery(id primitive.ObjectID) models.WebhookDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := *s.deliveries[id]
+	d.Attempts = append([]models.WebhookAttempt(nil), d.Attempts...)
+	return d
+}
+
+// receiver is a webhook endpoint answering with a settable status code
+type receiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []receivedRequest
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rec := &receiver{status: http.StatusOK}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, receivedRequest{header: r.Header.Clone(), body: body})
+		status := rec.status
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.server.Close)
+	return rec
+}
+
+func (rec *receiver) respond(status int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.status = status
+}
+
+func (rec *receiver) received() []receivedRequest {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]receivedRequest(nil), rec.requests...)
+}
+
+func newTestWorker(store Store, policy Policy) *Worker {
+	if policy.Timeout == 0 {
+		policy.Timeout = 5 * time.Second
+	}
+	return NewWorker(store, time.Minute, policy)
+}
+
+func TestWorkerSignsRequests(t *testing.T) {
+	rec := newReceiver(t)
+	store := newMemoryStore()
+	subscription := store.subscribe(rec.server.URL, "whsec_test")
+	payload := `{"type":"product.created"}`
+	delivery := store.queue(subscription, payload)
+
+	before := time.Now()
+	newTestWorker(store, Policy{MaxAttempts: 3}).work()
+
+	requests := rec.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if string(req.body) != payload {
+		t.Errorf("body = %s, want %s", req.body, payload)
+	}
+	if got := req.header.Get(EventHeader); got != "product.created" {
+		t.Errorf("%s = %q, want product.created", EventHeader, got)
+	}
+	if got := req.header.Get(DeliveryHeader); got != delivery.ID.Hex() {
+		t.Errorf("%s = %q, want %s", DeliveryHeader, got, delivery.ID.Hex())
+	}
+
+	// t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	signature := req.header.Get(SignatureHeader)
+	parts := strings.Split(signature, ",")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "t=") || !strings.HasPrefix(parts[1], "v1=") {
+		t.Fatalf("%s = %q, want t=<timestamp>,v1=<signature>", SignatureHeader, signature)
+	}
+	timestamp := strings.TrimPrefix(parts[0], "t=")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp %q is not a unix time: %v", timestamp, err)
+	}
+	if sent := time.Unix(unix, 0); sent.Before(before.Truncate(time.Second)) || sent.After(time.Now()) {
+		t.Errorf("timestamp %v is not the time of sending", sent)
+	}
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(req.body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if got := strings.TrimPrefix(parts[1], "v1="); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("v1 = %s, want %s", got, want)
+	}
+
+	d := store.delivery(delivery.ID)
+	if d.Status != models.DeliverySucceeded || d.DeliveredAt == nil || d.NextAttemptAt != nil {
+		t.Errorf("delivery is %s, delivered at %v, next attempt %v; want succeeded with no next attempt",
+			d.Status, d.DeliveredAt, d.NextAttemptAt)
+	}
+	if len(d.Attempts) != 1 || d.Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("attempts = %+v, want one with status 200", d.Attempts)
+	}
+}
+
+func TestWorkerRetriesWithGrowingBackoff(t *testing.T) {
+	rec := newReceiver(t)
+	rec.respond(http.StatusServiceUnavailable)
+	store := newMemoryStore()
+	subscription := store.subscribe(rec.server.URL, "whsec_test")
+	delivery := store.queue(subscription, `{}`)
+	worker := newTestWorker(store, Policy{MaxAttempts: 5})
+
+	for failures := 1; failures <= 3; failures++ {
+		worker.work()
+
+		d := store.delivery(delivery.ID)
+		if d.Status != models.DeliveryPending || d.Failures != failures {
+			t.Fatalf("after attempt %d delivery is %s with %d failures, want pending with %d",
+				failures, d.Status, d.Failures, failures)
+		}
+		last := d.Attempts[len(d.Attempts)-1]
+		if last.StatusCode != http.StatusServiceUnavailable || last.Error == "" {
+			t.Errorf("attempt %d = %+v, want a failed 503", failures, last)
+		}
+		// The retry waits 30s, then 1m, then 2m
+		want := baseBackoff << (failures - 1)
+		wait := d.NextAttemptAt.Sub(last.At)
+		if wait < want || wait > want+time.Second {
+			t.Errorf("after attempt %d the retry is in %v, want %v", failures, wait, want)
+		}
+
+		// Not sent again before the backoff has passed
+		worker.work()
+		if got := len(rec.received()); got != failures {
+			t.Fatalf("receiver got %d requests before the retry was due, want %d", got, failures)
+		}
+		store.makeDue(delivery.ID)
+	}
+
+	rec.respond(http.StatusNoContent)
+	worker.work()
+	if d := store.delivery(delivery.ID); d.Status != models.DeliverySucceeded || len(d.Attempts) != 4 {
+		t.Errorf("delivery is %s after %d attempts, want succeeded after 4", d.Status, len(d.Attempts))
+	}
+	if got := store.subscriptions[subscription.ID].ConsecutiveFailures; got != 0 {
+		t.Errorf("consecutive failures = %d after a success, want 0", got)
+	}
+}
+
+func TestWorkerGivesUpAfterMaxAttempts(t *testing.T) {
+	rec := newReceiver(t)
+	rec.respond(http.StatusInternalServerError)
+	store := newMemoryStore()
+	subscription := store.subscribe(rec.server.URL, "whsec_test")
+	delivery := store.queue(subscription, `{}`)
+	worker := newTestWorker(store, Policy{MaxAttempts: 3})
+
+	for i := 0; i < 5; i++ {
+		worker.work()
+		store.makeDue(delivery.ID)
+	}
+
+	d := store.delivery(delivery.ID)
+	if d.Status != models.DeliveryDead {
+		t.Errorf("status = %s, want %s", d.Status, models.DeliveryDead)
+	}
+	if d.Failures != 3 || len(d.Attempts) != 3 || d.NextAttemptAt != nil {
+		t.Errorf("delivery has %d failures, %d attempts, next attempt %v; want 3, 3 and none",
+			d.Failures, len(d.Attempts), d.NextAttemptAt)
+	}
+	if got := len(rec.received()); got != 3 {
+		t.Errorf("receiver got %d requests, want 3", got)
+	}
+}
+
+func TestWorkerDisablesFailingSubscription(t *testing.T) {
+	rec := newReceiver(t)
+	rec.respond(http.StatusBadGateway)
+	store := newMemoryStore()
+	subscription := store.subscribe(rec.server.URL, "whsec_test")
+	worker := newTestWorker(store, Policy{MaxAttempts: 10, DisableAfter: 3})
+
+	// Each delivery fails once, the third failure in a row disables
+	for i := 0; i < 3; i++ {
+		store.queue(subscription, `{}`)
+		worker.work()
+	}
+
+	disabled := store.subscriptions[subscription.ID]
+	if disabled.Active {
+		t.Fatalf("subscription still active after %d failures in a row", disabled.ConsecutiveFailures)
+	}
+	if disabled.DisabledAt == nil || !strings.Contains(disabled.DisabledReason, "3 failed attempts in a row") {
+		t.Errorf("disabled at %v for %q, want a time and the failure count", disabled.DisabledAt, disabled.DisabledReason)
+	}
+
+	// Deliveries of a disabled subscription are buried without being sent
+	pending := store.queue(subscription, `{}`)
+	worker.work()
+	if got := len(rec.received()); got != 3 {
+		t.Errorf("receiver got %d requests, want 3", got)
+	}
+	if d := store.delivery(pending.ID); d.Status != models.DeliveryDead {
+		t.Errorf("delivery to a disabled subscription is %s, want %s", d.Status, models.DeliveryDead)
+	}
+}
+
+func TestWorkerSendsRedelivery(t *testing.T) {
+	rec := newReceiver(t)
+	rec.respond(http.StatusInternalServerError)
+	store := newMemoryStore()
+	subscription := store.subscribe(rec.server.URL, "whsec_test")
+	delivery := store.queue(subscription, `{}`)
+	worker := newTestWorker(store, Policy{MaxAttempts: 2})
+
+	for i := 0; i < 2; i++ {
+		worker.work()
+		store.makeDue(delivery.ID)
+	}
+	if d := store.delivery(delivery.ID); d.Status != models.DeliveryDead {
+		t.Fatalf("status = %s, want %s", d.Status, models.DeliveryDead)
+	}
+
+	if d, _ := store.Redeliver(context.Background(), primitive.NewObjectID(), delivery.ID, time.Now()); d != nil {
+		t.Errorf("redelivered through another subscription")
+	}
+	queued, err := store.Redeliver(context.Background(), subscription.ID, delivery.ID, time.Now())
+	if err != nil || queued == nil {
+		t.Fatalf("Redeliver() = %v, %v", queued, err)
+	}
+
+	// A redelivery gets a fresh set of attempts
+	worker.work()
+	d := store.delivery(delivery.ID)
+	if d.Status != models.DeliveryPending || d.Failures != 1 {
+		t.Fatalf("after a failed redelivery the delivery is %s with %d failures, want pending with 1", d.Status, d.Failures)
+	}
+
+	rec.respond(http.StatusOK)
+	store.makeDue(delivery.ID)
+	worker.work()
+	d = store.delivery(delivery.ID)
+	if d.Status != models.DeliverySucceeded {
+		t.Errorf("status = %s, want %s", d.Status, models.DeliverySucceeded)
+	}
+	if len(d.Attempts) != 4 {
+		t.Errorf("%d attempts kept, want all 4", len(d.Attempts))
+	}
+	if got := len(rec.received()); got != 4 {
+		t.Errorf("receiver got %d requests, want 4", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}